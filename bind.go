@@ -0,0 +1,230 @@
+package xhttp
+
+// This file defines helpers decoding the input of a request into a struct and
+// validating it.
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxJSONSize is the default maximum size of a JSON request body read by
+// ReadJSON.
+const MaxJSONSize = 1 << 20
+
+// ReadJSON decodes the JSON body of a request into v and validates the result
+// with Validate. Bodies larger than MaxJSONSize are rejected.
+//
+// The returned errors are Errors carrying the appropriate status code (400,
+// 413 or 415) so that they can be fed to an ErrorMapper as is.
+func ReadJSON(r *http.Request, v interface{}) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) {
+			return NewError(http.StatusUnsupportedMediaType, errors.New("expected a JSON request body"))
+		}
+	}
+	if r.Body == nil {
+		return NewError(http.StatusBadRequest, errors.New("missing request body"))
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, MaxJSONSize+1))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var mbe *http.MaxBytesError
+		var se *json.SyntaxError
+		var te *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &mbe):
+			return NewError(http.StatusRequestEntityTooLarge, err)
+		case errors.Is(err, io.EOF):
+			return NewError(http.StatusBadRequest, errors.New("missing request body"))
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			if dec.InputOffset() > MaxJSONSize {
+				return NewError(http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+			}
+			return NewError(http.StatusBadRequest, errors.New("malformed JSON"))
+		case errors.As(err, &se):
+			return NewError(http.StatusBadRequest, fmt.Errorf("malformed JSON at offset %d", se.Offset))
+		case errors.As(err, &te):
+			return NewError(http.StatusBadRequest, ValidationError{{te.Field, "type", "must be of type " + te.Type.String()}})
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return NewError(http.StatusBadRequest, ValidationError{{strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), "unknown", "is not allowed"}})
+		default:
+			return NewError(http.StatusBadRequest, err)
+		}
+	}
+	if dec.More() {
+		return NewError(http.StatusBadRequest, errors.New("request body must contain a single JSON value"))
+	}
+	return Validate(v)
+}
+
+// BindQuery decodes the query parameters of a request into the fields of the
+// struct pointed to by v, and validates the result with Validate.
+// Fields are matched by their "query" tag, or their name otherwise. Strings,
+// booleans, numbers, time.Duration, encoding.TextUnmarshaler implementations
+// and slices of these are supported.
+func BindQuery(r *http.Request, v interface{}) error {
+	if err := BindValues(r.URL.Query(), v, "query"); err != nil {
+		return err
+	}
+	return Validate(v)
+}
+
+// DefaultMaxMemory is the maximum number of bytes of a multipart form kept in
+// memory by BindForm, the remainder being stored in temporary files.
+const DefaultMaxMemory = 32 << 20
+
+// BindForm decodes the fields of a urlencoded or multipart form into the
+// struct pointed to by v, and validates the result with Validate.
+// Fields are matched by their "form" tag, or their name otherwise, and may
+// have the same types as with BindQuery. File parts are ignored: see the
+// upload package to bind them as well.
+func BindForm(r *http.Request, v interface{}) error {
+	if err := r.ParseMultipartForm(DefaultMaxMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return NewError(http.StatusRequestEntityTooLarge, err)
+		}
+		return NewError(http.StatusBadRequest, err)
+	}
+	if err := BindValues(r.PostForm, v, "form"); err != nil {
+		return err
+	}
+	return Validate(v)
+}
+
+// BindValues sets the fields of the struct pointed to by v from url values,
+// matching fields by the given struct tag. The result is not validated.
+// Conversion failures are reported as an Error with a 400 status wrapping a
+// ValidationError.
+func BindValues(values url.Values, v interface{}, tag string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic("xhttp: binding target must be a pointer to a struct")
+	}
+	var errs ValidationError
+	bindStruct(values, rv.Elem(), tag, &errs)
+	if len(errs) > 0 {
+		return NewError(http.StatusBadRequest, errs)
+	}
+	return nil
+}
+
+func bindStruct(values url.Values, v reflect.Value, tag string, errs *ValidationError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && fv.Kind() == reflect.Struct {
+			bindStruct(values, fv, tag, errs)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		vals, ok := values[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		if err := setField(fv, vals); err != nil {
+			*errs = append(*errs, Violation{name, "type", err.Error()})
+		}
+	}
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func setField(v reflect.Value, vals []string) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 && !reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		s := reflect.MakeSlice(v.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setValue(s.Index(i), val); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	return setValue(v, vals[len(vals)-1])
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return errors.New("is invalid")
+		}
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("must be a duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		if s == "on" { // value sent for checked HTML checkboxes
+			v.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a positive integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(n)
+	default:
+		panic("xhttp: cannot bind a value of type " + v.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package xhttp
+
+// This file defines the error mapping facility used to translate the errors
+// occurring during request handling into http responses in a consistent
+// manner.
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorMapper is the type of the functions in charge of turning an error
+// which occurred while servicing a request into a http response.
+type ErrorMapper func(w http.ResponseWriter, r *http.Request, err error)
+
+// Error is an error associated with a http status code.
+type Error struct {
+	Status int
+	Err    error
+}
+
+// NewError returns an error which, when fed to an ErrorMapper, should result in
+// a response with the given http status code.
+func NewError(status int, err error) Error {
+	return Error{status, err}
+}
+
+func (e Error) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e Error) Unwrap() error { return e.Err }
+
+// StatusCode returns the http status code associated with an error.
+// If the error does not wrap an Error or a Problem, the status code is 500.
+func StatusCode(err error) int {
+	var e Error
+	if errors.As(err, &e) && e.Status >= 400 && e.Status < 600 {
+		return e.Status
+	}
+	var p Problem
+	if errors.As(err, &p) && p.Status >= 400 && p.Status < 600 {
+		return p.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// DefaultErrorMapper responds with the status code associated with the error.
+// For client errors (4xx), the error message is sent in the response body.
+// For server errors (5xx), only the status text is sent so as not to leak
+// any internal detail.
+func DefaultErrorMapper(w http.ResponseWriter, r *http.Request, err error) {
+	status := StatusCode(err)
+	if status < 500 {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
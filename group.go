@@ -0,0 +1,33 @@
+package xhttp
+
+import "strings"
+
+// Group returns a route group: a child ServeMux whose routes are registered
+// on sm with the given path prefix and are handled by the group handlers
+// before their own handler. The group handlers are called after the handlers
+// registered with USE and only apply to the routes of the group.
+//
+// For instance, the following registers /api/v1/users with an authentication
+// handler which does not apply to the other routes:
+//
+//	api := sm.Group("/api/v1", authenticator)
+//	api.GET("/users", usersHandler)
+//
+// Groups can be nested. USE cannot be called on a group.
+func (sm *ServeMux) Group(prefix string, handlers ...HandlerLinker) *ServeMux {
+	root := sm
+	if sm.root != nil {
+		root = sm.root
+	}
+	g := &ServeMux{
+		root:   root,
+		prefix: sm.prefix + strings.TrimSuffix(prefix, "/"),
+		group:  append(append([]HandlerLinker(nil), sm.group...), handlers...),
+	}
+	return g
+}
+
+// wrap links the group handlers to a route handler.
+func (sm *ServeMux) wrap(h Handler) Handler {
+	return linkRoute(h, sm.group)
+}
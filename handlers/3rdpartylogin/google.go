@@ -64,6 +64,7 @@ func (g GoogleProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	userinfo["picture"] = picture
 
 	// Let's generate an authenticated session
+	r = g.Session.Attach(r)
 	err= g.Session.Generate(w,r)
 	if err!= nil{
 		http.Error(w,"Unable to create authenticated session", http.StatusInternalServerError)
@@ -0,0 +1,235 @@
+// Package accesslog defines a request handler which logs every serviced http
+// request along with the response status code, the number of bytes written
+// and the time it took to respond.
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/requestid"
+)
+
+// Entry holds the information recorded for a serviced request.
+type Entry struct {
+	Time       time.Time         `json:"time"`
+	RemoteAddr string            `json:"remoteaddr"`
+	User       string            `json:"user,omitempty"`
+	Method     string            `json:"method"`
+	URI        string            `json:"uri"`
+	Proto      string            `json:"proto"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	Duration   time.Duration     `json:"duration"`
+	Referer    string            `json:"referer,omitempty"`
+	UserAgent  string            `json:"useragent,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+// Format defines how an Entry is rendered into a log line.
+type Format func(e Entry) string
+
+// CommonLog renders an Entry using the NCSA Common Log Format. Additional
+// fields are appended as key="value" pairs.
+func CommonLog(e Entry) string {
+	user := e.User
+	if user == "" {
+		user = "-"
+	}
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var b strings.Builder
+	b.WriteString(host)
+	b.WriteString(" - ")
+	b.WriteString(user)
+	b.WriteString(" [")
+	b.WriteString(e.Time.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString("] \"")
+	b.WriteString(e.Method + " " + e.URI + " " + e.Proto)
+	b.WriteString("\" ")
+	b.WriteString(strconv.Itoa(e.Status))
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(e.Bytes, 10))
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(" " + k + "=" + strconv.Quote(e.Fields[k]))
+	}
+	return b.String()
+}
+
+// JSON renders an Entry as a JSON object. The duration is expressed in
+// nanoseconds.
+func JSON(e Entry) string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return CommonLog(e)
+	}
+	return string(b)
+}
+
+// Handler is the access logging request handler.
+type Handler struct {
+	Log    *log.Logger
+	Format Format
+	Fields func(r *http.Request) map[string]string
+	next   xhttp.Handler
+}
+
+// New returns an access logging request handler writing to the provided
+// logger. If the logger is nil, entries are written to the standard output.
+// The default format is the Common Log Format.
+func New(l *log.Logger, options ...func(Handler) Handler) Handler {
+	if l == nil {
+		l = log.New(os.Stdout, "", 0)
+	}
+	h := Handler{
+		Log:    l,
+		Format: CommonLog,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// WithFormat sets the format of the log entries.
+func WithFormat(f Format) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Format = f
+		return h
+	}
+}
+
+// WithFields registers a function returning additional fields to be logged
+// for a request, typically a request ID or a session ID.
+// It is called once the request has been serviced.
+// The request identifier set by the requestid handler, if any, is logged
+// under the "requestid" key without requiring such a function.
+func WithFields(fn func(r *http.Request) map[string]string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Fields = fn
+		return h
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rw := &recorder{ResponseWriter: w}
+	if h.next != nil {
+		h.next.ServeHTTP(rw, r)
+	}
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	e := Entry{
+		Time:       start,
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		Status:     rw.status,
+		Bytes:      rw.bytes,
+		Duration:   time.Since(start),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+	}
+	if e.URI == "" {
+		e.URI = r.URL.RequestURI()
+	}
+	if u, _, ok := r.BasicAuth(); ok {
+		e.User = u
+	}
+	if h.Fields != nil {
+		e.Fields = h.Fields(r)
+	}
+	if id := requestid.FromContext(r.Context()); id != "" {
+		if e.Fields == nil {
+			e.Fields = make(map[string]string)
+		}
+		if _, ok := e.Fields["requestid"]; !ok {
+			e.Fields["requestid"] = id
+		}
+	}
+	format := h.Format
+	if format == nil {
+		format = CommonLog
+	}
+	h.Log.Print(format(e))
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// recorder wraps a http.ResponseWriter in order to record the status code and
+// the number of bytes of the response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rw *recorder) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+func (rw *recorder) ReadFrom(r io.Reader) (int64, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := xhttp.ReadFrom(rw.ResponseWriter, r)
+	rw.bytes += n
+	return n, err
+}
+
+func (rw *recorder) FlushError() error {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return xhttp.Flush(rw.ResponseWriter)
+}
+
+func (rw *recorder) Flush() { rw.FlushError() }
+
+func (rw *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return xhttp.Hijack(rw.ResponseWriter)
+}
+
+func (rw *recorder) Wrappee() http.ResponseWriter { return rw.ResponseWriter }
+
+func (rw *recorder) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
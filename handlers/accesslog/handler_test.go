@@ -0,0 +1,59 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestCommonLog(t *testing.T) {
+	var buf bytes.Buffer
+	mux := xhttp.NewServeMux()
+	mux.USE(New(log.New(&buf, "", 0), WithFields(func(r *http.Request) map[string]string {
+		return map[string]string{"requestid": "abc"}
+	})))
+	mux.GET("/teapot", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/teapot?x=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := strings.TrimSpace(buf.String())
+	if !strings.HasPrefix(line, "192.0.2.1 - - [") {
+		t.Fatalf("Unexpected log line %q", line)
+	}
+	if !strings.HasSuffix(line, `"GET /teapot?x=1 HTTP/1.1" 418 15 requestid="abc"`) {
+		t.Fatalf("Unexpected log line %q", line)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	mux := xhttp.NewServeMux()
+	mux.USE(New(log.New(&buf, "", 0), WithFormat(JSON)))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+
+	var e Entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != http.StatusOK || e.Bytes != 5 || e.Method != "GET" {
+		t.Fatalf("Unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,367 @@
+// Package assets defines a request handler serving static assets under
+// content-hashed paths, so that they can be cached indefinitely by clients.
+//
+// Every file of an fs.FS is fingerprinted when the Handler is created: a file
+// named "css/app.css" is served as "css/app.<hash>.css". The manifest maps
+// the logical names to the fingerprinted URLs and is available to templates
+// via the "asset" function.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+// ImmutableCacheControl is the Cache-Control header value of the responses to
+// requests for fingerprinted assets.
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// encodings lists the precompressed variants looked up next to an asset, in
+// order of preference.
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// ErrUnknownAsset is returned when a logical name is not found in the manifest.
+var ErrUnknownAsset = errors.New("assets: unknown asset")
+
+type asset struct {
+	name     string // logical name
+	hash     string
+	modtime  time.Time
+	variants map[string]string // encoding -> file name
+}
+
+type manifest struct {
+	byName map[string]*asset // logical name -> asset
+	byPath map[string]*asset // fingerprinted path -> asset
+}
+
+// Handler serves the fingerprinted assets of a file system.
+type Handler struct {
+	fsys     fs.FS
+	prefix   string
+	hashLen  int
+	unhashed bool
+	precomp  bool
+	manifest *manifest
+	next     xhttp.Handler
+}
+
+// New returns a Handler serving the files of fsys under the URL path prefix.
+// The files are read and hashed once, so New returns an error if fsys cannot
+// be walked.
+func New(fsys fs.FS, prefix string, options ...func(Handler) Handler) (Handler, error) {
+	h := Handler{
+		fsys:    fsys,
+		prefix:  "/" + strings.Trim(prefix, "/") + "/",
+		hashLen: 10,
+		precomp: true,
+		next:    nil,
+	}
+	if h.prefix == "//" {
+		h.prefix = "/"
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	if h.hashLen < 4 || h.hashLen > 64 {
+		panic("assets: hash length must be between 4 and 64")
+	}
+	m, err := h.build()
+	if err != nil {
+		return h, err
+	}
+	h.manifest = m
+	return h, nil
+}
+
+// Must is a helper that wraps a call to New and panics if the error is non-nil.
+func Must(h Handler, err error) Handler {
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// HashLength is a configuration option which sets the number of hexadecimal
+// characters of the content hash inserted in the file names. It defaults to 10.
+func HashLength(n int) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.hashLen = n
+		return h
+	}
+}
+
+// ServeUnhashed is a configuration option which allows the assets to be
+// requested under their logical name too. Such responses must be revalidated
+// by clients.
+func ServeUnhashed() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.unhashed = true
+		return h
+	}
+}
+
+// NoPrecompressed is a configuration option which disables the serving of
+// precompressed variants of the assets (files with a ".br" or ".gz" suffix).
+// These are otherwise served to the clients accepting the encoding, instead of
+// compressing the response on the fly.
+func NoPrecompressed() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.precomp = false
+		return h
+	}
+}
+
+func (h Handler) build() (*manifest, error) {
+	m := &manifest{
+		byName: make(map[string]*asset),
+		byPath: make(map[string]*asset),
+	}
+	files := make(map[string]bool)
+	err := fs.WalkDir(h.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range files {
+		if h.precomp && isVariant(name, files) {
+			continue
+		}
+		a, err := h.hash(name)
+		if err != nil {
+			return nil, err
+		}
+		if h.precomp {
+			for _, enc := range encodings {
+				if files[name+enc.ext] {
+					if a.variants == nil {
+						a.variants = make(map[string]string)
+					}
+					a.variants[enc.name] = name + enc.ext
+				}
+			}
+		}
+		m.byName[name] = a
+		m.byPath[fingerprint(name, a.hash)] = a
+	}
+	return m, nil
+}
+
+// isVariant reports whether a file is a precompressed variant of another one.
+func isVariant(name string, files map[string]bool) bool {
+	for _, enc := range encodings {
+		if strings.HasSuffix(name, enc.ext) && files[strings.TrimSuffix(name, enc.ext)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Handler) hash(name string) (*asset, error) {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s := sha256.New()
+	if _, err := io.Copy(s, f); err != nil {
+		return nil, err
+	}
+	return &asset{
+		name:    name,
+		hash:    hex.EncodeToString(s.Sum(nil))[:h.hashLen],
+		modtime: fi.ModTime(),
+	}, nil
+}
+
+// fingerprint inserts the hash before the extension of a file name.
+func fingerprint(name string, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// URL returns the fingerprinted URL path of an asset given its logical name,
+// e.g. "css/app.css".
+func (h Handler) URL(name string) (string, error) {
+	a, ok := h.manifest.byName[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return "", ErrUnknownAsset
+	}
+	return h.prefix + fingerprint(a.name, a.hash), nil
+}
+
+// Manifest returns the mapping of the logical names of the assets to their
+// fingerprinted URL paths.
+func (h Handler) Manifest() map[string]string {
+	m := make(map[string]string, len(h.manifest.byName))
+	for name, a := range h.manifest.byName {
+		m[name] = h.prefix + fingerprint(name, a.hash)
+	}
+	return m
+}
+
+// WriteManifest writes the manifest as a JSON object, e.g. for consumption by
+// a build tool or a CDN upload script.
+func (h Handler) WriteManifest(w io.Writer) error {
+	m := h.Manifest()
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, name := range names {
+		k, _ := json.Marshal(name)
+		v, _ := json.Marshal(m[name])
+		buf.WriteString("  ")
+		buf.Write(k)
+		buf.WriteString(": ")
+		buf.Write(v)
+		if i < len(names)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// FuncMap returns the template functions giving access to the manifest.
+// The "asset" function returns the fingerprinted URL of an asset:
+//
+//	<link rel="stylesheet" href="{{ asset "css/app.css" }}">
+func (h Handler) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"asset": h.URL,
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r)
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+func (h Handler) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := strings.CutPrefix(r.URL.Path, h.prefix)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if a, ok := h.manifest.byPath[p]; ok {
+		w.Header().Set("Cache-Control", ImmutableCacheControl)
+		h.serveAsset(w, r, a)
+		return
+	}
+	if a, ok := h.manifest.byName[p]; ok && h.unhashed {
+		w.Header().Set("Cache-Control", "no-cache")
+		h.serveAsset(w, r, a)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (h Handler) serveAsset(w http.ResponseWriter, r *http.Request, a *asset) {
+	name := a.name
+	// A response already being compressed, e.g. by a compression.Gzipper,
+	// must not receive a precompressed variant.
+	if len(a.variants) > 0 && w.Header().Get("Content-Encoding") == "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, enc := range encodings {
+			if v, ok := a.variants[enc.name]; ok && accepts(r, enc.name) {
+				name = v
+				w.Header().Set("Content-Encoding", enc.name)
+				ct := mime.TypeByExtension(path.Ext(a.name))
+				if ct == "" {
+					ct = "application/octet-stream"
+				}
+				w.Header().Set("Content-Type", ct)
+				break
+			}
+		}
+	}
+
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		rs = bytes.NewReader(b)
+	}
+	etag := a.hash
+	if name != a.name {
+		etag = etag + "-" + w.Header().Get("Content-Encoding")
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, a.name, a.modtime, rs)
+}
+
+// accepts reports whether the client accepts a content encoding.
+func accepts(r *http.Request, encoding string) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		v, q, _ := strings.Cut(strings.TrimSpace(v), ";")
+		if strings.EqualFold(strings.TrimSpace(v), encoding) {
+			return strings.ReplaceAll(strings.TrimSpace(q), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
@@ -0,0 +1,85 @@
+package assets
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/atdiar/xhttp"
+)
+
+var files = fstest.MapFS{
+	"css/app.css":    {Data: []byte("body{color:red}")},
+	"css/app.css.gz": {Data: []byte("gzipped")},
+	"js/app.js":      {Data: []byte("alert(1)")},
+}
+
+func TestHandler(t *testing.T) {
+	h := Must(New(files, "/static"))
+	mux := xhttp.NewServeMux()
+	mux.GET("/static/", h)
+
+	u, err := h.URL("css/app.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, "/static/css/app.") || !strings.HasSuffix(u, ".css") || len(u) != len("/static/css/app.css")+11 {
+		t.Fatalf("Unexpected fingerprinted URL %q", u)
+	}
+	if _, ok := h.Manifest()["css/app.css.gz"]; ok {
+		t.Fatal("Precompressed variants should not appear in the manifest.")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com"+u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "body{color:red}" {
+		t.Fatalf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") != ImmutableCacheControl {
+		t.Fatalf("Expected immutable caching but got %q", w.Header().Get("Cache-Control"))
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Body.String() != "gzipped" || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the precompressed variant but got %q", w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Fatalf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+
+	req, err = http.NewRequest("GET", "http://example.com/static/js/app.js", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected unhashed paths not to be served. Got %d", w.Code)
+	}
+}
+
+func TestFuncMap(t *testing.T) {
+	h := Must(New(files, "/static"))
+	tmpl := template.Must(template.New("page").Funcs(h.FuncMap()).Parse(`<script src="{{ asset "js/app.js" }}"></script>`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := h.URL("js/app.js")
+	if buf.String() != `<script src="`+u+`"></script>` {
+		t.Fatalf("Unexpected template output %q", buf.String())
+	}
+	if _, err := h.URL("missing.js"); err != ErrUnknownAsset {
+		t.Fatalf("Expected ErrUnknownAsset but got %v", err)
+	}
+}
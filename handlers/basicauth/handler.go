@@ -0,0 +1,98 @@
+// Package basicauth defines a request handler implementing the Basic HTTP
+// authentication scheme as specified by RFC 7617.
+//
+// It is meant to quickly protect internal or administration routes. As
+// credentials are sent in clear with every request, it should only be used
+// over TLS.
+package basicauth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/atdiar/xhttp"
+)
+
+type contextKey struct{}
+
+// User returns the name of the user authenticated by the handler, if any.
+func User(ctx context.Context) (string, bool) {
+	u, ok := ctx.Value(contextKey{}).(string)
+	return u, ok
+}
+
+// Handler is the Basic authentication request handler.
+type Handler struct {
+	Realm     string
+	Validator Validator
+	Log       *log.Logger
+
+	next xhttp.Handler
+}
+
+// New returns a request handler which requires the client to authenticate
+// with credentials accepted by the Validator.
+func New(realm string, v Validator, options ...func(Handler) Handler) Handler {
+	if v == nil {
+		panic("basicauth: nil Validator")
+	}
+	h := Handler{
+		Realm:     realm,
+		Validator: v,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// validation failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Log = l
+		return h
+	}
+}
+
+// challenge returns the value of the WWW-Authenticate header.
+func (h Handler) challenge() string {
+	realm := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(h.Realm)
+	return `Basic realm="` + realm + `", charset="UTF-8"`
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if ok {
+		valid, err := h.Validator.Validate(r.Context(), username, password)
+		if err != nil {
+			if h.Log != nil {
+				h.Log.Print(err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		ok = valid
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", h.challenge())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), contextKey{}, username))
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
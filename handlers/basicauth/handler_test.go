@@ -0,0 +1,75 @@
+package basicauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+type users map[string][]byte
+
+func (u users) PasswordHash(ctx context.Context, username string) ([]byte, error) {
+	h, ok := u[username]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+	return h, nil
+}
+
+func TestBasicAuth(t *testing.T) {
+	plain := func(hash []byte, password []byte) error {
+		if !bytes.Equal(hash, password) {
+			return errors.New("mismatch")
+		}
+		return nil
+	}
+	validators := map[string]Validator{
+		"static":    Static(map[string]string{"admin": "s3cret"}),
+		"userstore": FromUserStore(users{"admin": []byte("s3cret")}, plain),
+	}
+
+	for name, v := range validators {
+		var user string
+		mux := xhttp.NewServeMux()
+		mux.USE(New(`Admin "area"`, v))
+		mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ = User(r.Context())
+		}))
+
+		tcs := []struct {
+			user, password string
+			set            bool
+			code           int
+		}{
+			{"", "", false, http.StatusUnauthorized},
+			{"admin", "wrong", true, http.StatusUnauthorized},
+			{"nobody", "s3cret", true, http.StatusUnauthorized},
+			{"admin", "s3cret", true, http.StatusOK},
+		}
+		for _, tc := range tcs {
+			req, err := http.NewRequest("GET", "http://example.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.set {
+				req.SetBasicAuth(tc.user, tc.password)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tc.code {
+				t.Fatalf("%s %s:%s: expected %d but got %d", name, tc.user, tc.password, tc.code, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="Admin \"area\"", charset="UTF-8"` {
+				t.Fatalf("%s: unexpected challenge %q", name, w.Header().Get("WWW-Authenticate"))
+			}
+		}
+		if user != "admin" {
+			t.Fatalf("%s: expected the user to be stored in the context. Got %q", name, user)
+		}
+	}
+}
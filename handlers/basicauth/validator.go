@@ -0,0 +1,67 @@
+package basicauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrUnknownUser should be returned by a UserStore when no user matches a
+// username.
+var ErrUnknownUser = errors.New("basicauth: unknown user")
+
+// Validator is the interface implemented by objects checking user
+// credentials.
+type Validator interface {
+	Validate(ctx context.Context, username string, password string) (bool, error)
+}
+
+// ValidatorFunc allows the use of an ordinary function as a Validator.
+type ValidatorFunc func(ctx context.Context, username string, password string) (bool, error)
+
+// Validate calls v(ctx, username, password).
+func (v ValidatorFunc) Validate(ctx context.Context, username string, password string) (bool, error) {
+	return v(ctx, username, password)
+}
+
+// Static returns a Validator checking credentials against a map of passwords
+// indexed by username.
+// Comparisons are performed in constant time, whether the username exists or
+// not, so that neither passwords nor usernames can be guessed by measuring
+// response times.
+func Static(credentials map[string]string) Validator {
+	digests := make(map[string][32]byte, len(credentials))
+	for u, p := range credentials {
+		digests[u] = sha256.Sum256([]byte(p))
+	}
+	return ValidatorFunc(func(ctx context.Context, username string, password string) (bool, error) {
+		expected, ok := digests[username]
+		given := sha256.Sum256([]byte(password))
+		match := subtle.ConstantTimeCompare(expected[:], given[:]) == 1
+		return ok && match, nil
+	})
+}
+
+// UserStore is the interface implemented by user databases from which the
+// hash of a user password can be retrieved.
+type UserStore interface {
+	// PasswordHash returns the password hash of the user, or ErrUnknownUser.
+	PasswordHash(ctx context.Context, username string) ([]byte, error)
+}
+
+// FromUserStore returns a Validator which checks passwords against the hashes
+// held in a UserStore. compare should return a nil error if the password
+// matches the hash, e.g. bcrypt.CompareHashAndPassword.
+func FromUserStore(s UserStore, compare func(hash []byte, password []byte) error) Validator {
+	return ValidatorFunc(func(ctx context.Context, username string, password string) (bool, error) {
+		hash, err := s.PasswordHash(ctx, username)
+		if err != nil {
+			if errors.Is(err, ErrUnknownUser) {
+				return false, nil
+			}
+			return false, err
+		}
+		return compare(hash, []byte(password)) == nil, nil
+	})
+}
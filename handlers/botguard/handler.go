@@ -0,0 +1,228 @@
+// Package botguard defines a request handler which turns away obvious bots
+// before they reach sensitive endpoints such as signup or upload forms.
+//
+// Three heuristics are available:
+//   - honeypot fields: form fields hidden from humans via CSS, which bots
+//     tend to fill in,
+//   - a minimum form-fill time: the time the form was served is recorded in
+//     the session and forms submitted faster than a human could are rejected,
+//   - per-session strike counters: sessions which tripped the heuristics too
+//     many times are blocked altogether.
+//
+// Detected bots receive a 403 Forbidden response, or are tarpitted: the
+// response is delayed and looks successful, so as to waste their time.
+package botguard
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+var (
+	// ErrHoneypot is reported when a honeypot field has been filled in.
+	ErrHoneypot = errors.New("botguard: honeypot field filled in")
+	// ErrTooFast is reported when a form was submitted too quickly.
+	ErrTooFast = errors.New("botguard: form submitted too quickly")
+	// ErrBlocked is reported for sessions which exceeded the maximum number of
+	// strikes.
+	ErrBlocked = errors.New("botguard: session blocked")
+)
+
+// Handler is the bot mitigation request handler.
+type Handler struct {
+	honeypots []string
+
+	session    *session.Handler
+	key        string
+	minFill    time.Duration
+	maxStrikes int
+
+	tarpit time.Duration
+	log    *log.Logger
+	next   xhttp.Handler
+}
+
+// New returns a bot mitigation request handler. Without options, it lets
+// every request through.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		key:  "botguard",
+		next: nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	if (h.minFill > 0 || h.maxStrikes > 0) && h.session == nil {
+		panic("botguard: form-fill time and strikes require a session")
+	}
+	return h
+}
+
+// Honeypot is a configuration option which rejects form submissions where any
+// of the named fields is not empty.
+// Only urlencoded forms are checked: multipart bodies are left untouched so
+// that they can still be streamed by an upload handler.
+func Honeypot(fields ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.honeypots = append(h.honeypots, fields...)
+		return h
+	}
+}
+
+// WithSession is a configuration option which sets the session in which the
+// form serving time and the strikes are recorded, under the given key prefix.
+// The session handler should be registered ahead.
+func WithSession(s session.Handler, key string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.session = &s
+		if key != "" {
+			h.key = key
+		}
+		return h
+	}
+}
+
+// MinFillTime is a configuration option which rejects forms submitted less
+// than d after they were served. The serving time is recorded whenever a GET
+// request goes through the handler, so it should be registered on the route
+// serving the form as well as on the one receiving it.
+func MinFillTime(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.minFill = d
+		return h
+	}
+}
+
+// MaxStrikes is a configuration option which blocks every request of a
+// session once it has been detected as a bot n times.
+func MaxStrikes(n int) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.maxStrikes = n
+		return h
+	}
+}
+
+// Tarpit is a configuration option which makes the handler answer detected
+// bots with an empty 200 OK response after a delay, instead of a 403
+// Forbidden response.
+func Tarpit(delay time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.tarpit = delay
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// detected bots.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.log = l
+		return h
+	}
+}
+
+// Stamp records the time a form is served in the session. It is called by the
+// handler for GET requests, but can be used directly when the form is served
+// by a route the handler is not registered on.
+func (h Handler) Stamp(ctx context.Context) error {
+	if h.session == nil {
+		return nil
+	}
+	return h.session.Put(ctx, h.key+"/served", []byte(strconv.FormatInt(time.Now().UnixNano(), 10)), 0)
+}
+
+// Check returns the reason why a request is deemed to come from a bot, or nil.
+func (h Handler) Check(r *http.Request) error {
+	if h.session != nil && h.maxStrikes > 0 && h.strikes(r.Context()) >= h.maxStrikes {
+		return ErrBlocked
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return nil
+	}
+	if len(h.honeypots) > 0 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err == nil {
+			for _, f := range h.honeypots {
+				if r.PostForm.Get(f) != "" {
+					return ErrHoneypot
+				}
+			}
+		}
+	}
+	if h.session != nil && h.minFill > 0 {
+		b, err := h.session.Get(r.Context(), h.key+"/served")
+		if err != nil {
+			return ErrTooFast // the form was never served to this session
+		}
+		served, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil || time.Since(time.Unix(0, served)) < h.minFill {
+			return ErrTooFast
+		}
+	}
+	return nil
+}
+
+func (h Handler) strikes(ctx context.Context) int {
+	b, err := h.session.Get(ctx, h.key+"/strikes")
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(b))
+	return n
+}
+
+func (h Handler) strike(ctx context.Context) {
+	if h.session == nil {
+		return
+	}
+	err := h.session.Put(ctx, h.key+"/strikes", []byte(strconv.Itoa(h.strikes(ctx)+1)), 0)
+	if err != nil && h.log != nil {
+		h.log.Print(err)
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.Check(r); err != nil {
+		if h.log != nil {
+			h.log.Printf("%v: %s %s from %s", err, r.Method, r.URL.Path, r.RemoteAddr)
+		}
+		if err != ErrBlocked {
+			h.strike(r.Context())
+		}
+		if h.tarpit > 0 {
+			select {
+			case <-time.After(h.tarpit):
+			case <-r.Context().Done():
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodGet && h.session != nil && h.minFill > 0 {
+		if err := h.Stamp(r.Context()); err != nil && h.log != nil {
+			h.log.Print(err)
+		}
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
@@ -0,0 +1,46 @@
+package botguard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestHoneypot(t *testing.T) {
+	reached := 0
+	next := xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+	})
+
+	post := func(h xhttp.Handler, form string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://example.com/signup", strings.NewReader(form))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	h := New(Honeypot("website")).Link(next)
+	if w := post(h, "email=a%40example.com&website="); w.Code != http.StatusOK || reached != 1 {
+		t.Fatalf("Expected a human submission to go through. Got %d", w.Code)
+	}
+	if w := post(h, "email=a%40example.com&website=spam.example.com"); w.Code != http.StatusForbidden || reached != 1 {
+		t.Fatalf("Expected the bot to be rejected. Got %d", w.Code)
+	}
+
+	h = New(Honeypot("website"), Tarpit(10*time.Millisecond)).Link(next)
+	start := time.Now()
+	if w := post(h, "website=spam.example.com"); w.Code != http.StatusOK || reached != 1 {
+		t.Fatalf("Expected the bot to be tarpitted. Got %d", w.Code)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("Expected the tarpit to delay the response.")
+	}
+}
@@ -0,0 +1,235 @@
+// Package buffer defines a request handler which buffers the downstream
+// response so that registered hooks can modify its headers or body before it
+// is written, e.g. to inject CSP nonces in HTML, rewrite links or minify.
+//
+// Responses larger than the size limit, of a content type that is not
+// buffered, or flushed by the downstream handlers bypass the hooks and are
+// streamed as is.
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/atdiar/xhttp"
+)
+
+// DefaultMaxSize is the default maximum size of a buffered response body.
+const DefaultMaxSize = 1 << 20
+
+// Response is a buffered response which hooks may modify.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Hook is a post-processing function. If it returns an error, the client
+// receives a 500 Internal Server Error response instead.
+type Hook func(r *http.Request, res *Response) error
+
+// Handler buffers the responses and runs the hooks.
+type Handler struct {
+	hooks        []Hook
+	maxSize      int
+	contentTypes map[string]bool
+	log          *log.Logger
+	next         xhttp.Handler
+}
+
+// New returns a response buffering request handler.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		maxSize: DefaultMaxSize,
+		next:    nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// WithHook is a configuration option which registers a hook. Hooks run in the
+// order of registration.
+func WithHook(fn Hook) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.hooks = append(h.hooks[:len(h.hooks):len(h.hooks)], fn)
+		return h
+	}
+}
+
+// MaxSize is a configuration option which sets the maximum size of the
+// buffered response bodies. Larger responses are streamed without running the
+// hooks.
+func MaxSize(n int) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.maxSize = n
+		return h
+	}
+}
+
+// ContentTypes is a configuration option which restricts the buffering to the
+// responses of the given media types, e.g. "text/html". By default, every
+// response is buffered.
+func ContentTypes(types ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		m := make(map[string]bool, len(h.contentTypes)+len(types))
+		for k := range h.contentTypes {
+			m[k] = true
+		}
+		for _, t := range types {
+			m[t] = true
+		}
+		h.contentTypes = m
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// hook failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.log = l
+		return h
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.next == nil {
+		return
+	}
+	if len(h.hooks) == 0 {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	bw := &bufferingWriter{ResponseWriter: w, h: h}
+	h.next.ServeHTTP(bw, r)
+	if bw.bypass {
+		return
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+
+	res := &Response{
+		Status: bw.status,
+		Header: w.Header().Clone(),
+		Body:   bw.buf.Bytes(),
+	}
+	for _, hook := range h.hooks {
+		if err := hook(r, res); err != nil {
+			if h.log != nil {
+				h.log.Print(err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	hdr := w.Header()
+	for k := range hdr {
+		if _, ok := res.Header[k]; !ok {
+			delete(hdr, k)
+		}
+	}
+	for k, v := range res.Header {
+		hdr[k] = v
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(res.Body)))
+	w.WriteHeader(res.Status)
+	w.Write(res.Body)
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// bufferingWriter buffers the response until it has to switch to streaming.
+type bufferingWriter struct {
+	http.ResponseWriter
+	h      Handler
+	status int
+	buf    bytes.Buffer
+	bypass bool
+}
+
+func (bw *bufferingWriter) WriteHeader(code int) {
+	if bw.status != 0 || bw.bypass {
+		return
+	}
+	bw.status = code
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified || !bw.buffered() {
+		bw.startStreaming()
+	}
+}
+
+// buffered reports whether the content type of the response is buffered.
+func (bw *bufferingWriter) buffered() bool {
+	if len(bw.h.contentTypes) == 0 {
+		return true
+	}
+	ct := bw.Header().Get("Content-Type")
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && bw.h.contentTypes[mt]
+}
+
+func (bw *bufferingWriter) startStreaming() {
+	if bw.bypass {
+		return
+	}
+	bw.bypass = true
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	bw.ResponseWriter.WriteHeader(bw.status)
+	if bw.buf.Len() > 0 {
+		bw.ResponseWriter.Write(bw.buf.Bytes())
+		bw.buf = bytes.Buffer{}
+	}
+}
+
+func (bw *bufferingWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		if bw.Header().Get("Content-Type") == "" {
+			bw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		bw.WriteHeader(http.StatusOK)
+	}
+	if !bw.bypass && bw.buf.Len()+len(b) > bw.h.maxSize {
+		bw.startStreaming()
+	}
+	if bw.bypass {
+		return bw.ResponseWriter.Write(b)
+	}
+	return bw.buf.Write(b)
+}
+
+// FlushError switches the response to streaming, as the downstream handler
+// expects the data to reach the client right away.
+func (bw *bufferingWriter) FlushError() error {
+	bw.startStreaming()
+	return xhttp.Flush(bw.ResponseWriter)
+}
+
+func (bw *bufferingWriter) Flush() { bw.FlushError() }
+
+// Hijack gives up buffering: the downstream handler takes over the
+// connection.
+func (bw *bufferingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	bw.bypass = true
+	return xhttp.Hijack(bw.ResponseWriter)
+}
+
+func (bw *bufferingWriter) Wrappee() http.ResponseWriter { return bw.ResponseWriter }
+
+func (bw *bufferingWriter) Unwrap() http.ResponseWriter { return bw.ResponseWriter }
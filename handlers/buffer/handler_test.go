@@ -0,0 +1,84 @@
+package buffer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/secureheaders"
+)
+
+func TestBuffer(t *testing.T) {
+	page := xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><script>run()</script><script nonce="x">ok()</script>`))
+		w.Write([]byte(`<a href="http://old.example.com/a">a</a></html>`))
+	})
+	h := xhttp.Chain(
+		secureheaders.New(secureheaders.CSP(secureheaders.NewPolicy().ScriptSrc(secureheaders.NonceSource))),
+		New(WithHook(InjectNonce), WithHook(Replace("http://old.example.com", "https://cdn.example.com")), ContentTypes("text/html")),
+	).Link(page)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	csp := w.Header().Get("Content-Security-Policy")
+	i := strings.Index(csp, "'nonce-")
+	if i < 0 {
+		t.Fatalf("Expected a nonce in the policy. Got %q", csp)
+	}
+	nonce := csp[i+len("'nonce-"):]
+	nonce = nonce[:strings.Index(nonce, "'")]
+	if !strings.Contains(body, `<script nonce="`+nonce+`">run()`) || !strings.Contains(body, `<script nonce="x">`) {
+		t.Fatalf("Expected the nonce to be injected. Got %s", body)
+	}
+	if !strings.Contains(body, "https://cdn.example.com/a") {
+		t.Fatalf("Expected the links to be rewritten. Got %s", body)
+	}
+	if w.Header().Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Fatalf("Expected the Content-Length to match the body. Got %s", w.Header().Get("Content-Length"))
+	}
+}
+
+func TestBypass(t *testing.T) {
+	hook := WithHook(Replace("a", "b"))
+	tcs := []struct {
+		name string
+		h    xhttp.HandlerFunc
+	}{
+		{"too large", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(strings.Repeat("a", 8)))
+			w.Write([]byte(strings.Repeat("a", 8)))
+		}},
+		{"content type", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("aa"))
+		}},
+		{"flushed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("aa"))
+			w.(http.Flusher).Flush()
+		}},
+	}
+	for _, tc := range tcs {
+		h := New(hook, MaxSize(10), ContentTypes("text/html")).Link(tc.h)
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if strings.Contains(w.Body.String(), "b") {
+			t.Errorf("%s: expected the hooks to be bypassed. Got %q", tc.name, w.Body.String())
+		}
+		if w.Body.Len() == 0 {
+			t.Errorf("%s: expected the response to be written", tc.name)
+		}
+	}
+}
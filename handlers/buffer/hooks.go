@@ -0,0 +1,49 @@
+package buffer
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/atdiar/xhttp/handlers/secureheaders"
+)
+
+var scriptTag = regexp.MustCompile(`(?i)<(script|style)\b([^>]*)>`)
+
+// InjectNonce is a Hook which adds the Content-Security-Policy nonce generated
+// by a secureheaders.Handler to the script and style tags of HTML responses
+// that lack one. The secureheaders handler should be registered ahead.
+func InjectNonce(r *http.Request, res *Response) error {
+	nonce := secureheaders.Nonce(r)
+	if nonce == "" || !isHTML(res) {
+		return nil
+	}
+	attr := []byte(` nonce="` + nonce + `"`)
+	res.Body = scriptTag.ReplaceAllFunc(res.Body, func(tag []byte) []byte {
+		m := scriptTag.FindSubmatchIndex(tag)
+		if bytes.Contains(bytes.ToLower(tag[m[4]:m[5]]), []byte("nonce=")) {
+			return tag
+		}
+		out := make([]byte, 0, len(tag)+len(attr))
+		out = append(out, tag[:m[3]]...)
+		out = append(out, attr...)
+		return append(out, tag[m[3]:]...)
+	})
+	return nil
+}
+
+// Replace returns a Hook which replaces every occurrence of old by new in the
+// body of the responses, e.g. to rewrite links to a CDN.
+func Replace(old string, new string) Hook {
+	o, n := []byte(old), []byte(new)
+	return func(r *http.Request, res *Response) error {
+		res.Body = bytes.ReplaceAll(res.Body, o, n)
+		return nil
+	}
+}
+
+func isHTML(res *Response) bool {
+	ct := strings.ToLower(res.Header.Get("Content-Type"))
+	return strings.HasPrefix(ct, "text/html")
+}
@@ -0,0 +1,158 @@
+// Package canonical defines a request handler which redirects requests to
+// the canonical URL of a resource: www and apex domain names are unified and
+// legacy paths are redirected to their current location.
+package canonical
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/atdiar/xhttp"
+)
+
+// Handler is the URL canonicalization request handler.
+type Handler struct {
+	www    bool
+	apex   bool
+	code   int
+	scheme string
+	paths  map[string]string
+	next   xhttp.Handler
+}
+
+// New returns a request handler redirecting requests to canonical URLs.
+// Redirects are permanent (308) by default.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		code: http.StatusPermanentRedirect,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	if h.www && h.apex {
+		panic("canonical: WWW and Apex options are mutually exclusive")
+	}
+	switch h.code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		panic("canonical: invalid redirect status code " + strconv.Itoa(h.code))
+	}
+	return h
+}
+
+// WWW is a configuration option which redirects requests for the apex domain
+// (example.com) to the www subdomain (www.example.com).
+func WWW() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.www = true
+		return h
+	}
+}
+
+// Apex is a configuration option which redirects requests for the www
+// subdomain (www.example.com) to the apex domain (example.com).
+func Apex() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.apex = true
+		return h
+	}
+}
+
+// Code is a configuration option which sets the redirect status code, e.g.
+// http.StatusMovedPermanently.
+func Code(code int) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.code = code
+		return h
+	}
+}
+
+// Scheme is a configuration option which sets the scheme of the redirect
+// URLs. By default, it is https for requests received over TLS and http
+// otherwise.
+func Scheme(s string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.scheme = s
+		return h
+	}
+}
+
+// Paths is a configuration option which registers a table of legacy paths
+// and the paths they should be redirected to. The query string of the
+// requests is preserved.
+func Paths(table map[string]string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		if h.paths == nil {
+			h.paths = make(map[string]string, len(table))
+		}
+		for k, v := range table {
+			h.paths[k] = v
+		}
+		return h
+	}
+}
+
+// Canonical returns the canonical host and path of a request and whether they
+// differ from the requested ones.
+func (h Handler) Canonical(r *http.Request) (host string, path string, redirect bool) {
+	host, path = r.Host, r.URL.Path
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, ""
+	}
+	if net.ParseIP(name) == nil && name != "localhost" {
+		lname := strings.ToLower(name)
+		switch {
+		case h.www && !strings.HasPrefix(lname, "www."):
+			name = "www." + name
+			redirect = true
+		case h.apex && strings.HasPrefix(lname, "www."):
+			name = name[len("www."):]
+			redirect = true
+		}
+	}
+	if redirect {
+		host = name
+		if port != "" {
+			host = net.JoinHostPort(name, port)
+		}
+	}
+	if p, ok := h.paths[path]; ok && p != path {
+		path = p
+		redirect = true
+	}
+	return host, path, redirect
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, path, redirect := h.Canonical(r)
+	if !redirect {
+		if h.next != nil {
+			h.next.ServeHTTP(w, r)
+		}
+		return
+	}
+	scheme := h.scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	u := scheme + "://" + host + path
+	if r.URL.RawQuery != "" {
+		u = u + "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, u, h.code)
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
@@ -0,0 +1,38 @@
+package canonical
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestCanonical(t *testing.T) {
+	tcs := []struct {
+		name     string
+		h        Handler
+		url      string
+		code     int
+		location string
+	}{
+		{"apex", New(Apex()), "http://www.example.com/a?b=c", http.StatusPermanentRedirect, "http://example.com/a?b=c"},
+		{"apex noop", New(Apex()), "http://example.com/a", http.StatusOK, ""},
+		{"www", New(WWW(), Code(http.StatusMovedPermanently)), "http://example.com:8080/a", http.StatusMovedPermanently, "http://www.example.com:8080/a"},
+		{"www ip", New(WWW()), "http://192.0.2.1/a", http.StatusOK, ""},
+		{"legacy path", New(Paths(map[string]string{"/old": "/new"}), Scheme("https")), "http://example.com/old?x=1", http.StatusPermanentRedirect, "https://example.com/new?x=1"},
+		{"both", New(Apex(), Paths(map[string]string{"/old": "/new"})), "http://www.example.com/old", http.StatusPermanentRedirect, "http://example.com/new"},
+	}
+	for _, tc := range tcs {
+		h := tc.h.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.code || w.Header().Get("Location") != tc.location {
+			t.Fatalf("%s: unexpected response %d %q", tc.name, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package upload
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/atdiar/xhttp"
+)
+
+var objectType = reflect.TypeOf(Object{})
+
+// Bind decodes an ordinary form, urlencoded or multipart, into the struct
+// pointed to by v and validates the result with xhttp.Validate.
+// Unlike a Handler, it does not stream the files to their storage: it is meant
+// for forms with small attachments that fit within maxMemory (or temporary
+// files beyond that).
+//
+// Fields are matched by their "form" tag, or their name otherwise. Fields of
+// type Object, *Object or []Object receive the file parts of the same name,
+// whose content is readable via the Binary field until the request handling
+// ends. The other fields receive the values of the form as with xhttp.BindForm.
+func Bind(r *http.Request, v interface{}, maxMemory int64) error {
+	if maxMemory <= 0 {
+		maxMemory = xhttp.DefaultMaxMemory
+	}
+	if err := r.ParseMultipartForm(maxMemory); err != nil && err != http.ErrNotMultipart {
+		return xhttp.NewError(http.StatusBadRequest, ErrParsingFailed.Wraps(err))
+	}
+	if err := xhttp.BindValues(r.PostForm, v, "form"); err != nil {
+		return err
+	}
+	if r.MultipartForm != nil {
+		if err := bindFiles(r, reflect.ValueOf(v).Elem()); err != nil {
+			return err
+		}
+	}
+	return xhttp.Validate(v)
+}
+
+func bindFiles(r *http.Request, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("form"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fv := v.Field(i)
+		switch {
+		case f.Type == objectType:
+			objs, err := files(r, name, 1)
+			if err != nil {
+				return err
+			}
+			if len(objs) > 0 {
+				fv.Set(reflect.ValueOf(objs[0]))
+			}
+		case f.Type == reflect.PointerTo(objectType):
+			objs, err := files(r, name, 1)
+			if err != nil {
+				return err
+			}
+			if len(objs) > 0 {
+				fv.Set(reflect.ValueOf(&objs[0]))
+			}
+		case f.Type == reflect.SliceOf(objectType) || f.Type == reflect.TypeOf(FileList{}):
+			objs, err := files(r, name, -1)
+			if err != nil {
+				return err
+			}
+			if len(objs) > 0 {
+				fv.Set(reflect.ValueOf(objs).Convert(f.Type))
+			}
+		case f.Anonymous && f.Type.Kind() == reflect.Struct:
+			if err := bindFiles(r, fv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// files opens at most n (all if n < 0) files submitted under a form field name.
+func files(r *http.Request, name string, n int) ([]Object, error) {
+	headers := r.MultipartForm.File[name]
+	if n >= 0 && len(headers) > n {
+		headers = headers[:n]
+	}
+	objs := make([]Object, 0, len(headers))
+	for _, fh := range headers {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, xhttp.NewError(http.StatusInternalServerError, ErrParsingFailed.Wraps(err))
+		}
+		o := NewFile(f, fh.Filename, fh.Header.Get("Content-Type"), "", "")
+		o.Size = fh.Size
+		o.Filesize = fh.Size
+		objs = append(objs, o)
+	}
+	return objs, nil
+}
@@ -0,0 +1,46 @@
+package upload
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	type profile struct {
+		Name   string   `form:"name" validate:"required"`
+		Avatar Object   `form:"avatar" validate:"required"`
+		Docs   []Object `form:"docs" validate:"max=2"`
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "Ana")
+	fw, _ := mw.CreateFormFile("avatar", "me.png")
+	fw.Write([]byte("png data"))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, _ = mw.CreateFormFile("docs", name)
+		fw.Write([]byte(name))
+	}
+	mw.Close()
+
+	req, err := http.NewRequest("POST", "http://example.com/", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var p profile
+	if err := Bind(req, &p, 0); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Ana" || p.Avatar.Filename != "me.png" || p.Avatar.Size != 8 || len(p.Docs) != 2 {
+		t.Fatalf("Unexpected binding %+v", p)
+	}
+	b, err := io.ReadAll(p.Docs[1].Binary)
+	if err != nil || string(b) != "b.txt" {
+		t.Fatalf("Unexpected file content %q (%v)", b, err)
+	}
+}
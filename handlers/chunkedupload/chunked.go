@@ -1,7 +1,6 @@
 package upload
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"io"
@@ -45,7 +44,7 @@ func (h ChunkHandler) ParseUpload( w http.ResponseWriter, r *http.Request) (Pars
 	if !h.Handler.Session.Loaded(r.Context()) {
 		return ParseResult{nil, onerror}, ErrParsingFailed.Wraps(errors.New("uploader session is not loaded"))
 	}
-	uploaderid, err := h.Handler.Session.ID()
+	uploaderid, err := h.Handler.Session.ID(r.Context())
 	if err != nil {
 		return ParseResult{nil, onerror}, ErrParsingFailed.Wraps(errors.New("No session ID found. Unable to retrieve uploader session id").Wraps(err))
 	}
@@ -102,7 +101,8 @@ func (h ChunkHandler) ParseUpload( w http.ResponseWriter, r *http.Request) (Pars
 
 
 // Let's try to load the upload session
-	err = session.LoadServerOnly(r, uploadid, &h.Session)
+	r = h.Session.Attach(r)
+	err = session.LoadServerOnly(r, uploadid, h.Session)
 	if err != nil {
 		return ParseResult{nil, onerror}, ErrParsingFailed.Wraps(err)
 	}
@@ -154,21 +154,11 @@ func (h ChunkHandler) ParseUpload( w http.ResponseWriter, r *http.Request) (Pars
 			fieldIndex = i
 
 			// Let's check the data content type
-			contentType, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			contentType, body, err := h.ContentTypes.Detect(p, p.Header.Get("Content-Type"), filename, f[fieldIndex].SizeLimit)
 			if err != nil {
-				buf := bufio.NewReader(p)
-				peeksize := 512
-				if f[fieldIndex].SizeLimit < int64(peeksize) {
-					peeksize = int(f[fieldIndex].SizeLimit)
-				}
-				sniff, _ := buf.Peek(peeksize)
-				contentType = http.DetectContentType(sniff)
-				if !f[fieldIndex].AllowedContentTypes.Contains(contentType, false) {
-					return ParseResult{f, onerror}, errors.New("Unsupported Content-Type")
-				}
-				f[fieldIndex].ContentType = contentType
+				return ParseResult{nil, onerror}, ErrClientFormInvalid.Wraps(err)
 			}
-			if !f[fieldIndex].AllowedContentTypes.Contains(contentType, false) {
+			if !h.ContentTypes.Allowed(f[fieldIndex].AllowedContentTypes, contentType) {
 				return ParseResult{nil, onerror}, ErrClientFormInvalid.Wraps(ErrBadContentType)
 			}
 			f[fieldIndex].ContentType = contentType
@@ -180,7 +170,7 @@ func (h ChunkHandler) ParseUpload( w http.ResponseWriter, r *http.Request) (Pars
 				return ParseResult{nil, onerror}, ErrServerFormInvalid.Wraps(errors.New("Chunked upload does not support multiple file upload"))
 			}
 
-			pr := io.LimitReader(p, f[fieldIndex].SizeLimit)
+			pr := io.LimitReader(body, f[fieldIndex].SizeLimit)
 			if f[fieldIndex].Files != nil {
 				if uploadFileCreated {
 					return ParseResult{nil, onerror}, ErrServerFormInvalid.Wraps(errors.New("Form is malformed server side. Only one file upload field is allowed for chunk uploads"))
@@ -235,7 +225,7 @@ func (h ChunkHandler) ParseUpload( w http.ResponseWriter, r *http.Request) (Pars
 				uploadFileCreated = true
 				if n == f[fieldIndex].SizeLimit {
 					s := make([]byte, 1)
-					c, _ := p.Read(s)
+					c, _ := body.Read(s)
 					if c != 0 {
 						return ParseResult{nil, onerror}, ErrUploadTooLarge.Wraps(errors.New("Total upload size limited to: " + strconv.Itoa(int(f[fieldIndex].SizeLimit))))
 					}
@@ -250,7 +240,7 @@ func (h ChunkHandler) ParseUpload( w http.ResponseWriter, r *http.Request) (Pars
 				}
 				if n == f[fieldIndex].SizeLimit {
 					s := make([]byte, 1)
-					c, _ := p.Read(s)
+					c, _ := body.Read(s)
 					if c != 0 {
 						return ParseResult{nil, onerror}, ErrUploadTooLarge.Wraps(errors.New("Total upload size limited to: " + strconv.Itoa(int(f[fieldIndex].SizeLimit)))) // todo perhaps convey the limits back to the client
 					}
@@ -280,6 +270,8 @@ type ChunkHandler struct {
 	maxage         int
 	maxConcurrency int
 	bottleneck     *bottleneck.Client
+	presigner      Presigner
+	maxChunks      int
 }
 
 // New returns a handler for a chunked upload request.
@@ -288,7 +280,7 @@ type ChunkHandler struct {
 func Chunked(h Handler) ChunkHandler {
 	uploadSessionHandler := h.Session.Spawn("uploads", session.SetMaxage(7*24*60*60), session.SetUUIDgenerator(h.FileIDgenerator), session.ServerOnly())
 	// By default, the upload id generator is the the file uuid generator.
-	return ChunkHandler{h, uploadSessionHandler, 7 * 24 * 60 * 60, 1, nil}
+	return ChunkHandler{h, uploadSessionHandler, 7 * 24 * 60 * 60, 1, nil, nil, DefaultMaxChunks}
 }
 
 func (c ChunkHandler) Configure(functions ...func(ChunkHandler) ChunkHandler) ChunkHandler {
@@ -314,6 +306,17 @@ func SetMaxConcurrency(n int, limiter *bottleneck.Client) func(ChunkHandler) Chu
 	}
 }
 
+// SetMaxChunks sets the maximum number of chunks an upload may be split into.
+// Initialization requests announcing more chunks are rejected with a 400
+// status code, so that a client cannot have an arbitrary number of URLs
+// presigned.
+func SetMaxChunks(n int) func(ChunkHandler) ChunkHandler {
+	return func(c ChunkHandler) ChunkHandler {
+		c.maxChunks = n
+		return c
+	}
+}
+
 func SetUploadIDgenerator(uuidFn func() (string, error)) func(ChunkHandler) ChunkHandler {
 	return func(c ChunkHandler) ChunkHandler {
 		c.Session = c.Session.Configure(session.SetUUIDgenerator(uuidFn))
@@ -336,20 +339,8 @@ func (c ChunkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			c.Log.Print(err2)
 		}
 
-		switch err {
-		case ErrNoBoundary, ErrBadContentType, ErrClientFormInvalid:
-			http.Error(w, "Expecting correct form-data", http.StatusBadRequest)
-			return
-		case ErrParsingFailed, ErrUploadingFailed, ErrServerFormInvalid:
-			http.Error(w, "Server was unable to proceed with request processing", http.StatusInternalServerError)
-			return
-		case ErrUploadTooLarge:
-			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
-			return
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		c.Handler.fail(w, r, err)
+		return
 
 	}
 	ctx = context.WithValue(ctx, c.Handler.ctxKey, res)
@@ -380,7 +371,7 @@ ctx:= r.Context()
 		http.Error(w, "User session does not seem to have been loaded", http.StatusUnauthorized)
 		return
 	}
-	id, err := i.c.Handler.Session.ID()
+	id, err := i.c.Handler.Session.ID(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -408,11 +399,13 @@ ctx:= r.Context()
 		}
 
 		// We can create a new upload session
+		r = i.c.Session.Attach(r)
 		err = i.c.Session.Generate(w, r)
 		if err != nil {
 			http.Error(w, "Failed to generate new upload session", http.StatusInternalServerError)
 			return
 		}
+		ctx = r.Context()
 
 		// this ticket needs to be stored as we need to try and return it
 		b, err := t.Marshal()
@@ -430,14 +423,16 @@ ctx:= r.Context()
 	// if session has not been generated before, i.e. no concurrency limiting is
 	// implemented we generate a new session.
 	if !i.c.Session.Loaded(ctx) {
+		r = i.c.Session.Attach(r)
 		err = i.c.Session.Generate(w, r)
 		if err != nil {
 			http.Error(w, "Failed to generate new upload session", http.StatusInternalServerError)
 			return
 		}
+		ctx = r.Context()
 	}
 
-	uploadid, err := i.c.Session.ID()
+	uploadid, err := i.c.Session.ID(r.Context())
 	if err != nil {
 		http.Error(w, "upload session seems to have been ill-instantiated. unable to retrieve upload session id.", http.StatusInternalServerError)
 		if i.c.Handler.Log != nil {
@@ -480,7 +475,28 @@ ctx:= r.Context()
 		return
 	}
 
-	w.Write([]byte(uploadid))
+	if i.c.presigner != nil {
+		preq, err := presignRequest(r, uploadid, fileuuid, i.c.maxage, i.c.maxChunks)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := i.c.presigner.Presign(ctx, preq)
+		if err != nil {
+			http.Error(w, "Failed to issue upload ticket", http.StatusInternalServerError)
+			if i.c.Handler.Log != nil {
+				i.c.Handler.Log.Print(err)
+			}
+			return
+		}
+		t.UploadID = uploadid
+		err = xhttp.WriteJSON(w, t, http.StatusOK)
+		if err != nil && i.c.Handler.Log != nil {
+			i.c.Handler.Log.Print(err)
+		}
+	} else {
+		w.Write([]byte(uploadid))
+	}
 
 	r = r.WithContext(ctx)
 	if i.c.next != nil {
@@ -1,7 +1,6 @@
 package upload
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"io"
@@ -9,7 +8,6 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -80,11 +78,12 @@ func (h Handler) ParseUpload(w http.ResponseWriter, r *http.Request) (ParseResul
 	onerror := newCanceler()
 	f := h.Form
 	// Let's get the uploader id
+	r = h.Session.Attach(r)
 	err := h.Session.Load(w, r)
 	if err != nil {
 		return ParseResult{nil, onerror}, ErrParsingFailed.Wraps(errors.New("Unable to load session").Wraps(err))
 	}
-	uploaderid, err := h.Session.ID()
+	uploaderid, err := h.Session.ID(r.Context())
 	if err != nil {
 		return ParseResult{nil, onerror}, ErrParsingFailed.Wraps(errors.New("No session ID found. Unable to retrieve uploader session id").Wraps(err))
 	}
@@ -137,31 +136,12 @@ func (h Handler) ParseUpload(w http.ResponseWriter, r *http.Request) (ParseResul
 			fieldIndex = i
 
 			// Let's check the data content type
-			contentType, params2, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			_, params2, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			contentType, body, err := h.ContentTypes.Detect(p, p.Header.Get("Content-Type"), filenameIfExists, f[fieldIndex].SizeLimit)
 			if err != nil {
-				buf := bufio.NewReader(p)
-				peeksize := 512
-				if f[fieldIndex].SizeLimit < int64(peeksize) {
-					peeksize = int(f[fieldIndex].SizeLimit)
-				}
-				sniff, _ := buf.Peek(peeksize)
-				contentType = http.DetectContentType(sniff)
-				if !f[fieldIndex].AllowedContentTypes.Contains(contentType, false) {
-					if filenameIfExists != "" {
-						ext := filepath.Ext(filenameIfExists)
-						if ext != "" {
-							contentType = mime.TypeByExtension(ext)
-							if !f[fieldIndex].AllowedContentTypes.Contains(contentType, false) {
-								return ParseResult{f, onerror}, errors.New("Unknown Content-Type")
-							}
-						}
-						return ParseResult{f, onerror}, errors.New("Unsupported Content-Type")
-					}
-					return ParseResult{f, onerror}, errors.New("Unsupported Content-Type")
-				}
-				f[fieldIndex].ContentType = contentType
+				return ParseResult{f, onerror}, ErrClientFormInvalid.Wraps(err)
 			}
-			if !f[fieldIndex].AllowedContentTypes.Contains(contentType, false) {
+			if !h.ContentTypes.Allowed(f[fieldIndex].AllowedContentTypes, contentType) {
 				return ParseResult{f, onerror}, ErrClientFormInvalid.Wraps(ErrBadContentType)
 			}
 			f[fieldIndex].ContentType = contentType
@@ -186,23 +166,17 @@ func (h Handler) ParseUpload(w http.ResponseWriter, r *http.Request) (ParseResul
 						return ParseResult{nil, onerror}, ErrParsingFailed.Wraps(err)
 					}
 					// Get file content-type
-					ct, _, err := mime.ParseMediaType(q.Header.Get("Content-Type"))
+					ct, qbody, err := h.ContentTypes.Detect(q, q.Header.Get("Content-Type"), q.FileName(), remainingSize)
 					if err != nil {
-						buf := bufio.NewReader(p)
-						peeksize := 512
-						if remainingSize < int64(peeksize) {
-							peeksize = int(remainingSize)
-						}
-						sniff, _ := buf.Peek(peeksize)
-						ct = http.DetectContentType(sniff)
+						return ParseResult{nil, onerror}, ErrClientFormInvalid.Wraps(err)
 					}
 					// See if the content-type is supported
-					if !f[fieldIndex].AllowedContentTypes.Contains(contentType, false) || ct == "multipart/mixed" {
+					if !h.ContentTypes.Allowed(f[fieldIndex].AllowedContentTypes, ct) || ct == "multipart/mixed" {
 						return ParseResult{nil, onerror}, ErrBadContentType
 					}
 					// create a new file , populate it, and add it to the filelist
 
-					obj := NewFile(io.LimitReader(q, remainingSize), q.FileName(), ct, uploaderid, f[fieldIndex].Path)
+					obj := NewFile(io.LimitReader(qbody, remainingSize), q.FileName(), ct, uploaderid, f[fieldIndex].Path)
 					id, err := h.FileIDgenerator()
 					if err != nil {
 						return ParseResult{nil, onerror}, ErrUploadingFailed.Wraps(errors.New("Unable to generate unique id for the upload file. Operation aborted")) // todo see if we could just skip the failing parts and retry perhaps
@@ -225,13 +199,13 @@ func (h Handler) ParseUpload(w http.ResponseWriter, r *http.Request) (ParseResul
 						return ParseResult{nil, onerror}, ErrUploadTooLarge.Wraps(errors.New("Total upload size limited to: " + strconv.Itoa(int(f[fieldIndex].SizeLimit))))
 					}
 					s := make([]byte, 1)
-					c, _ := q.Read(s)
+					c, _ := qbody.Read(s)
 					if c != 0 {
 						return ParseResult{nil, onerror}, ErrUploadTooLarge.Wraps(errors.New("Total upload size limited to: " + strconv.Itoa(int(f[fieldIndex].SizeLimit))))
 					}
 				}
 			} else {
-				pr := io.LimitReader(p, f[fieldIndex].SizeLimit)
+				pr := io.LimitReader(body, f[fieldIndex].SizeLimit)
 				if f[fieldIndex].Files != nil {
 					obj := NewFile(pr, filenameIfExists, contentType, uploaderid, f[fieldIndex].Path)
 					id, err := h.FileIDgenerator()
@@ -251,7 +225,7 @@ func (h Handler) ParseUpload(w http.ResponseWriter, r *http.Request) (ParseResul
 					f[fieldIndex].Files = []Object{obj}
 					if n == f[fieldIndex].SizeLimit {
 						s := make([]byte, 1)
-						c, _ := p.Read(s)
+						c, _ := body.Read(s)
 						if c != 0 {
 							return ParseResult{nil, onerror}, ErrUploadTooLarge.Wraps(errors.New("Total upload size limited to: " + strconv.Itoa(int(f[fieldIndex].SizeLimit))))
 						}
@@ -266,7 +240,7 @@ func (h Handler) ParseUpload(w http.ResponseWriter, r *http.Request) (ParseResul
 					}
 					if n == f[fieldIndex].SizeLimit {
 						s := make([]byte, 1)
-						c, _ := p.Read(s)
+						c, _ := body.Read(s)
 						if c != 0 {
 							return ParseResult{nil, onerror}, ErrUploadTooLarge.Wraps(errors.New("Total upload size limited to: " + strconv.Itoa(int(f[fieldIndex].SizeLimit)))) // todo perhaps convey the limits back to the client
 						}
@@ -450,6 +424,12 @@ type Handler struct {
 
 	Log *log.Logger
 
+	// ErrorMapper, if set, writes the error responses instead of http.Error.
+	ErrorMapper xhttp.ErrorMapper
+
+	// ContentTypes detects the content type of the submitted data.
+	ContentTypes ContentTypeDetector
+
 	ctxKey contextKey
 
 	next xhttp.Handler
@@ -459,7 +439,7 @@ type Handler struct {
 // try and retrieve values if the structure of the request fits the expected
 // model defined in an upload Form.
 func New(f Form, s session.Handler, uploadpath string, fileUUIDgenerator func() (string, error)) Handler {
-	return Handler{f, s, uploadpath, fileUUIDgenerator, nil, contextKey{}, nil}
+	return Handler{f, s, uploadpath, fileUUIDgenerator, nil, nil, NewContentTypeDetector(), contextKey{}, nil}
 }
 
 // WithLogger enables logging capabilities. Typically for logging errors. such as
@@ -470,6 +450,43 @@ func (h Handler) WithLogger(l *log.Logger) Handler {
 	return h
 }
 
+// WithErrorMapper sets the ErrorMapper writing the error responses, e.g.
+// xhttp.ProblemErrorMapper. It receives an xhttp.Error wrapping the parsing
+// error.
+func (h Handler) WithErrorMapper(m xhttp.ErrorMapper) Handler {
+	h.ErrorMapper = m
+	return h
+}
+
+// WithContentTypeDetector sets the ContentTypeDetector used to determine the
+// content type of the submitted data, e.g. to change the sniffing size or the
+// policy applied when the declared and detected content types differ.
+func (h Handler) WithContentTypeDetector(d ContentTypeDetector) Handler {
+	h.ContentTypes = d
+	return h
+}
+
+// fail responds to a request whose upload form could not be parsed.
+func (h Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	var status int
+	var msg string
+	switch err {
+	case ErrNoBoundary, ErrBadContentType, ErrClientFormInvalid:
+		status, msg = http.StatusBadRequest, "Expecting correct form-data"
+	case ErrParsingFailed, ErrUploadingFailed, ErrServerFormInvalid:
+		status, msg = http.StatusInternalServerError, "Server was unable to proceed with request processing"
+	case ErrUploadTooLarge:
+		status, msg = http.StatusRequestEntityTooLarge, err.Error()
+	default:
+		status, msg = http.StatusInternalServerError, err.Error()
+	}
+	if h.ErrorMapper != nil {
+		h.ErrorMapper(w, r, xhttp.NewError(status, err))
+		return
+	}
+	http.Error(w, msg, status)
+}
+
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	// Limit size of the request
@@ -481,21 +498,8 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if h.Log != nil {
 			h.Log.Print(err)
 		}
-		// todo switch on error value
-		switch err {
-		case ErrNoBoundary, ErrBadContentType, ErrClientFormInvalid:
-			http.Error(w, "Expecting correct form-data", http.StatusBadRequest)
-			return
-		case ErrParsingFailed, ErrUploadingFailed, ErrServerFormInvalid:
-			http.Error(w, "Server was unable to proceed with request processing", http.StatusInternalServerError)
-			return
-		case ErrUploadTooLarge:
-			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
-			return
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		h.fail(w, r, err)
+		return
 	}
 	ctx = context.WithValue(ctx, h.ctxKey, res)
 	r = r.WithContext(ctx)
@@ -0,0 +1,207 @@
+package upload
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/atdiar/errors"
+)
+
+// DefaultSniffSize is the number of bytes of an upload which are read in order
+// to detect its content type, as per http.DetectContentType.
+const DefaultSniffSize = 512
+
+var ErrContentTypeMismatch = errors.New("Declared and detected content types do not match.")
+
+// MismatchPolicy defines which content type is retained when the declared,
+// sniffed and extension-derived content types of an upload disagree.
+// Generic types such as application/octet-stream or text/plain are considered
+// unknown and never cause a mismatch.
+type MismatchPolicy int
+
+const (
+	// TrustDeclared retains the declared content type, then the sniffed one,
+	// then the one derived from the file extension.
+	TrustDeclared MismatchPolicy = iota
+	// PreferSniffed retains the sniffed content type, then the declared one,
+	// then the one derived from the file extension.
+	PreferSniffed
+	// RejectMismatch rejects the upload if the known content types differ.
+	RejectMismatch
+)
+
+// defaultAliases maps the non-standard MIME types commonly sent by clients
+// to their canonical form.
+var defaultAliases = map[string]string{
+	"audio/x-wav":       "audio/wav",
+	"audio/wave":        "audio/wav",
+	"audio/vnd.wave":    "audio/wav",
+	"audio/x-aiff":      "audio/aiff",
+	"audio/mp3":         "audio/mpeg",
+	"audio/x-mpeg":      "audio/mpeg",
+	"audio/x-midi":      "audio/midi",
+	"video/x-msvideo":   "video/avi",
+	"video/msvideo":     "video/avi",
+	"image/jpg":         "image/jpeg",
+	"image/pjpeg":       "image/jpeg",
+	"image/x-png":       "image/png",
+	"application/x-pdf": "application/pdf",
+	"text/xml":          "application/xml",
+}
+
+// ContentTypeDetector determines the content type of uploaded data from the
+// declared Content-Type, the data itself and the file extension.
+// The zero value is ready to use.
+type ContentTypeDetector struct {
+	// SniffSize is the number of bytes read to detect the content type.
+	// DefaultSniffSize is used if it is not positive.
+	SniffSize int
+	Policy    MismatchPolicy
+
+	// Aliases maps MIME types to their canonical form, in addition to the
+	// default aliases (e.g. audio/x-wav to audio/wav).
+	Aliases map[string]string
+}
+
+// NewContentTypeDetector returns a ContentTypeDetector.
+func NewContentTypeDetector(options ...func(ContentTypeDetector) ContentTypeDetector) ContentTypeDetector {
+	d := ContentTypeDetector{SniffSize: DefaultSniffSize}
+	for _, opt := range options {
+		if opt != nil {
+			d = opt(d)
+		}
+	}
+	return d
+}
+
+// SniffSize is a configuration option which sets the number of bytes read to
+// detect the content type.
+func SniffSize(n int) func(ContentTypeDetector) ContentTypeDetector {
+	return func(d ContentTypeDetector) ContentTypeDetector {
+		d.SniffSize = n
+		return d
+	}
+}
+
+// OnMismatch is a configuration option which sets the MismatchPolicy.
+func OnMismatch(p MismatchPolicy) func(ContentTypeDetector) ContentTypeDetector {
+	return func(d ContentTypeDetector) ContentTypeDetector {
+		d.Policy = p
+		return d
+	}
+}
+
+// Alias is a configuration option which registers the canonical form of a
+// MIME type.
+func Alias(alias string, canonical string) func(ContentTypeDetector) ContentTypeDetector {
+	return func(d ContentTypeDetector) ContentTypeDetector {
+		m := make(map[string]string, len(d.Aliases)+1)
+		for k, v := range d.Aliases {
+			m[k] = v
+		}
+		m[strings.ToLower(alias)] = strings.ToLower(canonical)
+		d.Aliases = m
+		return d
+	}
+}
+
+// Canonical returns the canonical form of a MIME type: lower-cased, without
+// parameters and with aliases resolved.
+func (d ContentTypeDetector) Canonical(mimetype string) string {
+	mt, _, err := mime.ParseMediaType(mimetype)
+	if err != nil {
+		return ""
+	}
+	if c, ok := d.Aliases[mt]; ok {
+		return c
+	}
+	if c, ok := defaultAliases[mt]; ok {
+		return c
+	}
+	return mt
+}
+
+// Allowed reports whether a content type belongs to a list of accepted
+// content types, both being compared in their canonical form.
+func (d ContentTypeDetector) Allowed(accepted set, contenttype string) bool {
+	ct := d.Canonical(contenttype)
+	for k := range accepted {
+		if d.Canonical(k) == ct {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect returns the canonical content type of the data read from r, given
+// its declared Content-Type and its file name, both of which may be empty.
+// The data is sniffed up to the SniffSize, or limit if lower and positive.
+// The returned reader yields the whole data, sniffed bytes included, and
+// should be used in place of r.
+func (d ContentTypeDetector) Detect(r io.Reader, declared string, filename string, limit int64) (string, io.Reader, error) {
+	dt := d.Canonical(declared)
+	if strings.HasPrefix(dt, "multipart/") {
+		return dt, r, nil
+	}
+
+	n := d.SniffSize
+	if n <= 0 {
+		n = DefaultSniffSize
+	}
+	if limit > 0 && limit < int64(n) {
+		n = int(limit)
+	}
+	buf := bufio.NewReaderSize(r, n)
+	sniff, _ := buf.Peek(n)
+	st := d.Canonical(http.DetectContentType(sniff))
+
+	var et string
+	if ext := filepath.Ext(filename); ext != "" {
+		et = d.Canonical(mime.TypeByExtension(ext))
+	}
+
+	ct, err := d.resolve(dt, st, et)
+	return ct, buf, err
+}
+
+// generic reports whether a canonical MIME type is too generic to be
+// informative.
+func generic(mimetype string) bool {
+	return mimetype == "" || mimetype == "application/octet-stream" || mimetype == "text/plain"
+}
+
+// resolve applies the MismatchPolicy to canonical content types. Generic
+// types are only retained when no other type is known.
+func (d ContentTypeDetector) resolve(declared, sniffed, ext string) (string, error) {
+	order := []string{declared, sniffed, ext}
+	if d.Policy == PreferSniffed {
+		order = []string{sniffed, declared, ext}
+	}
+	var ct, fallback string
+	for _, t := range order {
+		if generic(t) {
+			if fallback == "" {
+				fallback = t
+			}
+			continue
+		}
+		if ct == "" {
+			ct = t
+			continue
+		}
+		if d.Policy == RejectMismatch && t != ct {
+			return "", ErrContentTypeMismatch
+		}
+	}
+	if ct != "" {
+		return ct, nil
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "application/octet-stream", nil
+}
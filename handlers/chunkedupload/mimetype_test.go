@@ -0,0 +1,49 @@
+package upload
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestContentTypeDetector(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 600)
+	tcs := []struct {
+		name     string
+		policy   MismatchPolicy
+		data     string
+		declared string
+		filename string
+		want     string
+		err      error
+	}{
+		{"declared alias", TrustDeclared, "RIFF....WAVEfmt ", "audio/x-wav", "", "audio/wav", nil},
+		{"sniffed", TrustDeclared, png, "", "", "image/png", nil},
+		{"generic declared", TrustDeclared, png, "application/octet-stream", "a.jpg", "image/png", nil},
+		{"extension", TrustDeclared, "a,b\n1,2\n", "", "data.csv", "text/csv", nil},
+		{"plain text", TrustDeclared, "hello", "text/plain; charset=utf-8", "", "text/plain", nil},
+		{"trust declared", TrustDeclared, png, "image/gif", "", "image/gif", nil},
+		{"prefer sniffed", PreferSniffed, png, "image/gif", "", "image/png", nil},
+		{"reject mismatch", RejectMismatch, png, "image/png", "a.gif", "", ErrContentTypeMismatch},
+		{"consistent", RejectMismatch, png, "image/x-png", "a.png", "image/png", nil},
+	}
+	for _, tc := range tcs {
+		d := NewContentTypeDetector(OnMismatch(tc.policy))
+		ct, r, err := d.Detect(strings.NewReader(tc.data), tc.declared, tc.filename, 0)
+		if err != tc.err || ct != tc.want {
+			t.Errorf("%s: got %q, %v want %q, %v", tc.name, ct, err, tc.want, tc.err)
+			continue
+		}
+		if b, _ := io.ReadAll(r); string(b) != tc.data {
+			t.Errorf("%s: the sniffed bytes were not preserved", tc.name)
+		}
+	}
+
+	d := NewContentTypeDetector(SniffSize(4), Alias("audio/x-custom", "audio/wav"))
+	if !d.Allowed(AudioMIMETypes, "audio/x-custom") || d.Allowed(VideoMIMETypes, "audio/wav") {
+		t.Error("expected content types to be compared in their canonical form")
+	}
+	if ct, _, _ := d.Detect(strings.NewReader(png), "", "", 0); ct == "image/png" {
+		t.Error("expected the sniffing to be limited to SniffSize")
+	}
+}
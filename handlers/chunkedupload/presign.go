@@ -0,0 +1,118 @@
+package upload
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/atdiar/errors"
+	"github.com/atdiar/xhttp"
+)
+
+// Ticket is sent to the client by the Initializer when a Presigner is
+// configured. Besides the upload id, it holds either presigned URLs to which
+// the chunks can be uploaded directly, in chunk order, or a storage specific
+// upload token.
+type Ticket struct {
+	UploadID string    `json:"uploadid"`
+	URLs     []string  `json:"urls,omitempty"`
+	Token    string    `json:"token,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+// PresignRequest describes the upload for which a Ticket is requested. The
+// filename, size and number of chunks are read from the headers of the
+// initialization request, if provided.
+type PresignRequest struct {
+	UploadID string
+	FileID   string
+	Filename string
+	Size     int64
+	Chunks   int
+	// MaxAge is the duration of the upload session.
+	MaxAge time.Duration
+}
+
+// Presigner issues upload Tickets, typically by presigning object storage
+// URLs, so that clients can upload chunks straight to the storage backend
+// while the server keeps track of the upload session.
+type Presigner interface {
+	Presign(ctx context.Context, req PresignRequest) (Ticket, error)
+}
+
+// PresignerFunc is an adapter allowing the use of a function as a Presigner.
+type PresignerFunc func(ctx context.Context, req PresignRequest) (Ticket, error)
+
+// Presign calls f.
+func (f PresignerFunc) Presign(ctx context.Context, req PresignRequest) (Ticket, error) {
+	return f(ctx, req)
+}
+
+// SetPresigner configures the Initializer to respond with a JSON encoded
+// Ticket issued by p instead of the bare upload id.
+func SetPresigner(p Presigner) func(ChunkHandler) ChunkHandler {
+	return func(c ChunkHandler) ChunkHandler {
+		c.presigner = p
+		return c
+	}
+}
+
+// DefaultMaxChunks is the default maximum number of chunks an upload may be
+// split into. It matches the maximum number of parts of an S3 multipart upload.
+const DefaultMaxChunks = 10000
+
+// SignedURLs returns a Presigner issuing one URL per chunk, of the form
+// base/uploadid/chunkindex, signed with xhttp.SignURL so that the endpoint
+// receiving the chunks can be protected with xhttp.VerifySignedURL. The URLs
+// expire with the upload session.
+func SignedURLs(base string, secret []byte) Presigner {
+	return PresignerFunc(func(ctx context.Context, req PresignRequest) (Ticket, error) {
+		if req.Chunks <= 0 {
+			return Ticket{}, ErrMissingChunksTotal
+		}
+		t := Ticket{
+			UploadID: req.UploadID,
+			Expires:  time.Now().Add(req.MaxAge).UTC().Truncate(time.Second),
+		}
+		prefix := base + "/" + url.PathEscape(req.UploadID) + "/"
+		for i := 0; i < req.Chunks; i++ {
+			u, err := xhttp.SignURL(prefix+strconv.Itoa(i), secret, t.Expires, "")
+			if err != nil {
+				return Ticket{}, err
+			}
+			t.URLs = append(t.URLs, u)
+		}
+		return t, nil
+	})
+}
+
+// presignRequest reads the description of the upload from the headers of the
+// initialization request. The number of chunks may not exceed maxchunks.
+func presignRequest(r *http.Request, uploadid string, fileid string, maxage int, maxchunks int) (PresignRequest, error) {
+	p := PresignRequest{
+		UploadID: uploadid,
+		FileID:   fileid,
+		Filename: r.Header.Get(FileNameHeader),
+		MaxAge:   time.Duration(maxage) * time.Second,
+	}
+	if v := r.Header.Get(FileSizeHeader); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return p, errors.New("invalid filesize header")
+		}
+		p.Size = n
+	}
+	if v := r.Header.Get(ChunksTotalHeader); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, errors.New("invalid chunkstotal header")
+		}
+		if n > maxchunks {
+			return p, errors.New("chunkstotal header exceeds the maximum number of chunks")
+		}
+		p.Chunks = n
+	}
+	return p, nil
+}
@@ -0,0 +1,54 @@
+package upload
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestSignedURLs(t *testing.T) {
+	secret := []byte("secret")
+	r, _ := http.NewRequest("POST", "http://example.com/uploads", nil)
+	r.Header.Set(FileNameHeader, "video.mp4")
+	r.Header.Set(FileSizeHeader, "30000000")
+	r.Header.Set(ChunksTotalHeader, "3")
+
+	preq, err := presignRequest(r, "up1", "file1", 3600, DefaultMaxChunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preq.Filename != "video.mp4" || preq.Size != 30000000 || preq.Chunks != 3 {
+		t.Fatalf("Unexpected presign request %+v", preq)
+	}
+
+	ticket, err := SignedURLs("https://uploads.example.com/chunks", secret).Presign(context.Background(), preq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.UploadID != "up1" || len(ticket.URLs) != 3 {
+		t.Fatalf("Expected a ticket with 3 chunk URLs. Got %+v", ticket)
+	}
+	u, err := url.Parse(ticket.URLs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/chunks/up1/2" {
+		t.Fatalf("Unexpected chunk URL %s", u)
+	}
+	if err := xhttp.VerifyURL(u, secret, ""); err != nil {
+		t.Fatalf("Expected a valid signed URL. Got %v", err)
+	}
+
+	r.Header.Set(ChunksTotalHeader, "many")
+	if _, err := presignRequest(r, "up1", "file1", 3600, DefaultMaxChunks); err == nil {
+		t.Fatal("Expected an invalid chunkstotal header to be rejected")
+	}
+
+	r.Header.Set(ChunksTotalHeader, "10001")
+	if _, err := presignRequest(r, "up1", "file1", 3600, DefaultMaxChunks); err == nil {
+		t.Fatal("Expected a chunkstotal header above the maximum to be rejected")
+	}
+}
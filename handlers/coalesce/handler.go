@@ -0,0 +1,267 @@
+// Package coalesce defines a request handler which merges concurrent identical
+// GET requests: the downstream handler is executed once and its response is
+// sent to every waiting client.
+//
+// This protects expensive endpoints from thundering herds, e.g. when a popular
+// cache entry expires. Requests carrying credentials (Cookie or Authorization
+// headers) are not coalesced by default, as their responses may be private.
+package coalesce
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/atdiar/xhttp"
+)
+
+// DefaultMaxSize is the default maximum size of a response body broadcast to
+// the waiting clients.
+const DefaultMaxSize = 1 << 20
+
+// KeyFunc returns the key identifying identical requests. If it returns
+// false, the request is not coalesced.
+type KeyFunc func(r *http.Request) (key string, ok bool)
+
+// call is an in-flight execution of the downstream handler.
+type call struct {
+	done    chan struct{}
+	waiters int  // number of requests waiting for the response
+	ok      bool // whether the response can be replayed
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+type group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Handler coalesces identical GET requests.
+type Handler struct {
+	key     KeyFunc
+	vary    []string
+	private bool
+	maxSize int64
+	group   *group
+	next    xhttp.Handler
+}
+
+// New returns a request coalescing handler.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		vary:    []string{"Accept", "Accept-Encoding", "Accept-Language"},
+		maxSize: DefaultMaxSize,
+		group:   &group{calls: make(map[string]*call)},
+		next:    nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// WithKey is a configuration option which sets the function identifying
+// identical requests, instead of the default based on the request URI and the
+// content negotiation headers.
+func WithKey(kf KeyFunc) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.key = kf
+		return h
+	}
+}
+
+// VaryBy is a configuration option which adds request headers to the default
+// key.
+func VaryBy(headers ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		for _, hdr := range headers {
+			h.vary = append(h.vary, http.CanonicalHeaderKey(hdr))
+		}
+		return h
+	}
+}
+
+// IncludeCredentialed is a configuration option which allows requests carrying
+// a Cookie or Authorization header to be coalesced. It should only be used
+// for endpoints whose response does not depend on the client.
+func IncludeCredentialed() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.private = true
+		return h
+	}
+}
+
+// MaxSize is a configuration option which sets the maximum size of a response
+// body broadcast to the waiting clients. The waiting clients of a larger
+// response are served by the downstream handler on their own.
+func MaxSize(n int64) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.maxSize = n
+		return h
+	}
+}
+
+// Key returns the default key of a request.
+func (h Handler) Key(r *http.Request) (string, bool) {
+	if !h.private && (r.Header.Get("Cookie") != "" || r.Header.Get("Authorization") != "") {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.RequestURI())
+	for _, hdr := range h.vary {
+		b.WriteString("\n")
+		b.WriteString(hdr)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(hdr), ","))
+	}
+	return b.String(), true
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.next == nil {
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	kf := h.key
+	if kf == nil {
+		kf = h.Key
+	}
+	key, ok := kf(r)
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	h.group.mu.Lock()
+	if c, ok := h.group.calls[key]; ok {
+		c.waiters++
+		h.group.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-r.Context().Done():
+			return
+		}
+		if !c.ok {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		replay(w, c)
+		return
+	}
+	c := &call{done: make(chan struct{})}
+	h.group.calls[key] = c
+	h.group.mu.Unlock()
+
+	defer func() {
+		h.group.mu.Lock()
+		delete(h.group.calls, key)
+		h.group.mu.Unlock()
+		close(c.done)
+	}()
+
+	tw := &teeWriter{ResponseWriter: w, max: h.maxSize, ok: true}
+	h.next.ServeHTTP(tw, r)
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	c.status = tw.status
+	c.header = tw.header
+	c.body = tw.buf.Bytes()
+	// Responses that were interrupted or are meant for a single client are
+	// not replayed.
+	c.ok = tw.ok && r.Context().Err() == nil && c.header.Get("Set-Cookie") == ""
+}
+
+// waiting returns the number of requests waiting for the in-flight execution
+// of the downstream handler for a key.
+func (g *group) waiting(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c.waiters
+	}
+	return 0
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+func replay(w http.ResponseWriter, c *call) {
+	hdr := w.Header()
+	for k, v := range c.header {
+		hdr[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(c.status)
+	w.Write(c.body)
+}
+
+// teeWriter writes the response to the client while recording it for the
+// waiting clients.
+type teeWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	buf    bytes.Buffer
+	max    int64
+	ok     bool
+}
+
+func (tw *teeWriter) WriteHeader(code int) {
+	if tw.status != 0 {
+		return
+	}
+	tw.status = code
+	tw.header = tw.ResponseWriter.Header().Clone()
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *teeWriter) Write(b []byte) (int, error) {
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.ok {
+		if int64(tw.buf.Len()+len(b)) > tw.max {
+			tw.ok = false
+			tw.buf = bytes.Buffer{}
+		} else {
+			tw.buf.Write(b)
+		}
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *teeWriter) FlushError() error {
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return xhttp.Flush(tw.ResponseWriter)
+}
+
+func (tw *teeWriter) Flush() { tw.FlushError() }
+
+// Hijack prevents the response from being shared: it is not recorded past
+// that point.
+func (tw *teeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.ok = false
+	tw.buf = bytes.Buffer{}
+	return xhttp.Hijack(tw.ResponseWriter)
+}
+
+func (tw *teeWriter) Wrappee() http.ResponseWriter { return tw.ResponseWriter }
+
+func (tw *teeWriter) Unwrap() http.ResponseWriter { return tw.ResponseWriter }
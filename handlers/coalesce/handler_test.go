@@ -0,0 +1,78 @@
+package coalesce
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestCoalesce(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	c := New()
+	h := c.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("expensive"))
+	}))
+
+	serve := func(cookie bool) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://example.com/report", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if cookie {
+			req.Header.Set("Cookie", "session=1")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		responses[0] = serve(false)
+	}()
+	<-entered
+	for i := 1; i < len(responses); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = serve(false)
+		}(i)
+	}
+	// The response is released once every request waits for it.
+	key, _ := c.Key(httptest.NewRequest("GET", "http://example.com/report", nil))
+	for c.group.waiting(key) < len(responses)-1 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("Expected the downstream handler to be called once but got %d calls", n)
+	}
+	for i, w := range responses {
+		if w.Code != http.StatusOK || w.Body.String() != "expensive" || w.Header().Get("Content-Type") != "text/plain" {
+			t.Fatalf("Unexpected response %d: %d %q", i, w.Code, w.Body.String())
+		}
+	}
+
+	serve(true)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("Expected credentialed requests to be served on their own. Got %d calls", n)
+	}
+}
@@ -3,8 +3,9 @@
 package compression
 
 import (
+	"bufio"
 	"compress/gzip"
-	"io"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -44,40 +45,130 @@ func (g Gzipper) Skip(method string) Gzipper {
 	return g
 }
 
-// This is a type of wrapper around a http.ResponseWriter which buffers data
-// before compressing the whole and writing.
+// compressingWriter is a wrapper around a http.ResponseWriter which decides
+// whether to compress the response once its headers are known, i.e. when the
+// status code is written, and then compresses the written data if needed.
 type compressingWriter struct {
-	io.WriteCloser
 	http.ResponseWriter
-	p *sync.Pool
+	p          *sync.Pool
+	acceptGzip bool
+
+	decided bool
+	z       *gzip.Writer
+}
+
+// decide determines whether the response is compressed. Responses marked with
+// the no-transform Cache-Control directive, already encoded, or without body
+// are left untouched. For the others, the Vary header is set since the
+// representation depends on the Accept-Encoding request header, whether the
+// current client accepts gzip or not.
+func (cw *compressingWriter) decide(code int) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+	if !compressible(h, code) {
+		return
+	}
+	if !varies(h, "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if !cw.acceptGzip {
+		return
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	cw.z = cw.p.Get().(*gzip.Writer)
+	cw.z.Reset(cw.ResponseWriter)
+}
+
+// compressible reports whether a response with the given headers and status
+// code may be compressed.
+func compressible(h http.Header, code int) bool {
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), "no-transform") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// varies reports whether the Vary header lists the given request header.
+func varies(h http.Header, name string) bool {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, name) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func newcompressingWriter(w http.ResponseWriter, p *sync.Pool) compressingWriter {
-	w1 := p.Get()
-	w2 := w1.(*gzip.Writer)
-	w2.Reset(w)
-	return compressingWriter{w2, w, p}
+// WriteHeader decides whether the response is compressed before writing the
+// status code.
+func (cw *compressingWriter) WriteHeader(code int) {
+	cw.decide(code)
+	cw.ResponseWriter.WriteHeader(code)
 }
 
-// Write is using the gzip writer Write method.
-func (cw compressingWriter) Write(b []byte) (int, error) {
-	if cw.ResponseWriter.Header().Get("Content-Type") == "" {
-		cw.ResponseWriter.Header().Set("Content-Type", http.DetectContentType(b))
-		cw.ResponseWriter.Header().Del("Content-Length")
+// Write is using the gzip writer Write method when the response is
+// compressed.
+func (cw *compressingWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if cw.ResponseWriter.Header().Get("Content-Type") == "" {
+			cw.ResponseWriter.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.decide(http.StatusOK)
 	}
-	return cw.WriteCloser.Write(b)
+	if cw.z == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.z.Write(b)
 }
 
 // Close flushes the compressed bytestring to the underlying ResponseWriter.
 // Then it releases the gzip.Writer, putting it back into the Pool.
-func (cw compressingWriter) Close() error {
-	z := cw.WriteCloser.(*gzip.Writer)
+func (cw *compressingWriter) Close() error {
+	if cw.z == nil {
+		return nil
+	}
+	z := cw.z
+	cw.z = nil
 	err := z.Flush()
 	cw.p.Put(z)
 	return err
 }
 
-func (cw compressingWriter) Wrappee() http.ResponseWriter { return cw.ResponseWriter }
+// FlushError sends the data compressed so far to the client.
+func (cw *compressingWriter) FlushError() error {
+	cw.decide(http.StatusOK)
+	if cw.z != nil {
+		if err := cw.z.Flush(); err != nil {
+			return err
+		}
+	}
+	return xhttp.Flush(cw.ResponseWriter)
+}
+
+func (cw *compressingWriter) Flush() { cw.FlushError() }
+
+func (cw *compressingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return xhttp.Hijack(cw.ResponseWriter)
+}
+
+func (cw *compressingWriter) Wrappee() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *compressingWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
 
 // ServeHTTP handles a http.Request by gzipping the http response body and
 // setting the right http Headers.
@@ -89,19 +180,12 @@ func (g Gzipper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	// We create a compressingWriter that will enable
-	//the response writing w/ Compression.
-	wc := newcompressingWriter(w, g.pool)
-
-	w.Header().Add("Vary", "Accept-Encoding")
-	if !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
-		if g.next != nil {
-			g.next.ServeHTTP(w, req)
-		}
-		return
+	// the response writing w/ Compression, if the response allows it.
+	wc := &compressingWriter{
+		ResponseWriter: w,
+		p:              g.pool,
+		acceptGzip:     strings.Contains(req.Header.Get("Accept-Encoding"), "gzip"),
 	}
-	wc.Header().Set("Content-Encoding", "gzip")
-	// All the conditions are present : we shall compress the data before writing
-	// it out.
 	if g.next != nil {
 		g.next.ServeHTTP(wc, req)
 	}
@@ -102,3 +102,71 @@ func TestCompressHandler(t *testing.T) {
 		t.Errorf("wrong content-length. got %q expected %d", l, 1024*LenPayload)
 	}
 }
+
+func TestNoTransform(t *testing.T) {
+	tcs := []struct {
+		name     string
+		header   string
+		value    string
+		status   int
+		encoding string
+		vary     string
+	}{
+		{"compressed", "", "", http.StatusOK, "gzip", "Accept-Encoding"},
+		{"no-transform", "Cache-Control", "public, No-Transform", http.StatusOK, "", ""},
+		{"already encoded", "Content-Encoding", "br", http.StatusOK, "br", ""},
+		{"no content", "", "", http.StatusNoContent, "", ""},
+	}
+	for _, tc := range tcs {
+		h := NewHandler().Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.header != "" {
+				w.Header().Set(tc.header, tc.value)
+			}
+			w.WriteHeader(tc.status)
+			if tc.status != http.StatusNoContent {
+				w.Write([]byte(Payload))
+			}
+		}))
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if enc := w.Header().Get("Content-Encoding"); enc != tc.encoding {
+			t.Errorf("%s: wrong content encoding, got %q want %q", tc.name, enc, tc.encoding)
+		}
+		if v := w.Header().Get("Vary"); v != tc.vary {
+			t.Errorf("%s: wrong Vary header, got %q want %q", tc.name, v, tc.vary)
+		}
+	}
+
+	// The representation depends on Accept-Encoding even for clients which do
+	// not accept gzip.
+	h := NewHandler().Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Payload))
+	}))
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" || w.Body.String() != Payload {
+		t.Errorf("Expected an uncompressed response varying on Accept-Encoding. Got %v %q", w.Header(), w.Body.String())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var flushed int
+	h := NewHandler().Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(Payload))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Fatalf("flushing through the compressing writer failed: %v", err)
+		}
+		flushed = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder).Body.Len()
+	}))
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !w.Flushed || flushed == 0 {
+		t.Error("expected the compressed data to be flushed to the client")
+	}
+}
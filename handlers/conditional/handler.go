@@ -0,0 +1,244 @@
+// Package conditional defines a request handler which adds conditional GET
+// support to the routes it is registered for.
+//
+// Responses to GET and HEAD requests are buffered so that an ETag can be
+// derived from their content. Requests bearing a matching If-None-Match
+// header, or an If-Modified-Since header not older than the Last-Modified
+// header of the response, receive a 304 Not Modified response instead.
+package conditional
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+// Handler is the conditional request handler.
+type Handler struct {
+	maxSize      int64
+	weak         bool
+	lastModified func(r *http.Request) time.Time
+
+	next xhttp.Handler
+}
+
+// New returns a request handler which answers conditional requests.
+// Responses larger than 1MB are not buffered: they are streamed to the client
+// without validator.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		maxSize: 1 << 20,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// MaxSize is a configuration option which sets the maximum size in bytes of a
+// response body for which an ETag is computed.
+func MaxSize(n int64) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.maxSize = n
+		return h
+	}
+}
+
+// Weak is a configuration option which makes the generated ETags weak. It
+// should be used when the response body may differ in ways that are not
+// semantically significant, or when a downstream handler compresses it.
+func Weak() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.weak = true
+		return h
+	}
+}
+
+// LastModified is a configuration option which registers a function returning
+// the last modification time of the requested resource. It is used when the
+// downstream handlers do not set a Last-Modified header themselves.
+func LastModified(fn func(r *http.Request) time.Time) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.lastModified = fn
+		return h
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.next == nil {
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	bw := &bufferedWriter{ResponseWriter: w, limit: h.maxSize}
+	h.next.ServeHTTP(bw, r)
+	if bw.streaming {
+		// Either the status code is not 200 or the body is too large.
+		return
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+
+	hdr := w.Header()
+	if hdr.Get("ETag") == "" {
+		hdr.Set("ETag", etag(bw.buf.Bytes(), h.weak))
+	}
+	if hdr.Get("Last-Modified") == "" && h.lastModified != nil {
+		if t := h.lastModified(r); !t.IsZero() {
+			hdr.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	if notModified(r, hdr) {
+		for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+			hdr.Del(k)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	bw.flush()
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+func etag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// notModified evaluates the If-None-Match and If-Modified-Since preconditions
+// as specified by RFC 9110, section 13.2.2.
+func notModified(r *http.Request, hdr http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return matchETag(inm, hdr.Get("ETag"))
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	lm := hdr.Get("Last-Modified")
+	if ims == "" || lm == "" {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	m, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+	return !m.Truncate(time.Second).After(t)
+}
+
+// matchETag performs a weak comparison of the entity tag against the
+// If-None-Match list.
+func matchETag(list string, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds back the response until it is complete, unless its
+// body exceeds the size limit, in which case it is streamed.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	limit     int64
+	streaming bool
+}
+
+func (bw *bufferedWriter) WriteHeader(code int) {
+	if bw.status != 0 {
+		return
+	}
+	bw.status = code
+	if code != http.StatusOK {
+		bw.stream()
+	}
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	if bw.streaming {
+		return bw.ResponseWriter.Write(b)
+	}
+	if bw.limit > 0 && int64(bw.buf.Len()+len(b)) > bw.limit {
+		if err := bw.stream(); err != nil {
+			return 0, err
+		}
+		return bw.ResponseWriter.Write(b)
+	}
+	return bw.buf.Write(b)
+}
+
+// stream sends the status code and buffered content to the client and
+// switches to unbuffered writes.
+func (bw *bufferedWriter) stream() error {
+	bw.streaming = true
+	bw.ResponseWriter.WriteHeader(bw.status)
+	_, err := bw.buf.WriteTo(bw.ResponseWriter)
+	return err
+}
+
+func (bw *bufferedWriter) flush() {
+	if bw.Header().Get("Content-Length") == "" {
+		bw.Header().Set("Content-Length", strconv.Itoa(bw.buf.Len()))
+	}
+	bw.stream()
+}
+
+// FlushError switches the response to streaming, the conditional request
+// headers being then ignored.
+func (bw *bufferedWriter) FlushError() error {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	if !bw.streaming {
+		if err := bw.stream(); err != nil {
+			return err
+		}
+	}
+	return xhttp.Flush(bw.ResponseWriter)
+}
+
+func (bw *bufferedWriter) Flush() { bw.FlushError() }
+
+func (bw *bufferedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	bw.streaming = true
+	return xhttp.Hijack(bw.ResponseWriter)
+}
+
+func (bw *bufferedWriter) Wrappee() http.ResponseWriter { return bw.ResponseWriter }
+
+func (bw *bufferedWriter) Unwrap() http.ResponseWriter { return bw.ResponseWriter }
@@ -0,0 +1,82 @@
+package conditional
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+var modtime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newMux(options ...func(Handler) Handler) xhttp.ServeMux {
+	mux := xhttp.NewServeMux()
+	mux.USE(New(options...))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hello":"world"}`))
+	}))
+	mux.GET("/large", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	return mux
+}
+
+func serve(t *testing.T, mux xhttp.ServeMux, path string, hdr map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestETag(t *testing.T) {
+	mux := newMux()
+	w := serve(t, mux, "/", nil)
+	tag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || tag == "" || w.Body.String() != `{"hello":"world"}` {
+		t.Fatalf("Unexpected response %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = serve(t, mux, "/", map[string]string{"If-None-Match": `"other", ` + tag})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Fatalf("Expected 304 but got %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("ETag") != tag {
+		t.Fatal("The ETag should be sent along with the 304 response")
+	}
+
+	w = serve(t, mux, "/", map[string]string{"If-None-Match": `"other"`})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 but got %d", w.Code)
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	mux := newMux(LastModified(func(r *http.Request) time.Time { return modtime }))
+
+	w := serve(t, mux, "/", map[string]string{"If-Modified-Since": modtime.Format(http.TimeFormat)})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("Expected 304 but got %d", w.Code)
+	}
+	w = serve(t, mux, "/", map[string]string{"If-Modified-Since": modtime.Add(-time.Hour).Format(http.TimeFormat)})
+	if w.Code != http.StatusOK || w.Header().Get("Last-Modified") != modtime.Format(http.TimeFormat) {
+		t.Fatalf("Expected 200 but got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	mux := newMux(MaxSize(10))
+	w := serve(t, mux, "/large", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 100 || w.Header().Get("ETag") != "" {
+		t.Fatalf("Expected a streamed response without ETag. Got %d %v", w.Code, w.Header())
+	}
+}
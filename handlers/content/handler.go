@@ -2,11 +2,16 @@
 // It is useful for media that are susceptible to a range request such as audio
 // or video files.
 //
+// Content may either be provided as an in-memory io.ReadSeeker or pulled on
+// demand from a remote source implementing the Getter interface.
+//
 // Example: https://stackoverflow.com/questions/8293687/sample-http-range-request-session
 package content
 
 import (
+	"errors"
 	"io"
+	"io/fs"
 	"net/http"
 	"time"
 
@@ -19,6 +24,7 @@ type Server struct {
 	name    string
 	modtime time.Time
 	content io.ReadSeeker
+	getter  Getter
 	next    xhttp.Handler
 }
 
@@ -32,13 +38,46 @@ func NewServer(name string, modtime time.Time, content io.ReadSeeker) Server {
 	}
 }
 
+// NewRemoteServer returns a http request handler in charge of serving the
+// content of the named object retrieved via a Getter.
+// Only the byte ranges that are requested by the client are fetched from the
+// content source, so that large media files do not need to be loaded into
+// memory.
+func NewRemoteServer(name string, g Getter) Server {
+	return Server{
+		name:   name,
+		getter: g,
+		next:   nil,
+	}
+}
+
 func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	http.ServeContent(w, r, s.name, s.modtime, s.content)
+	if s.getter != nil {
+		s.serveRemote(w, r)
+	} else {
+		http.ServeContent(w, r, s.name, s.modtime, s.content)
+	}
 	if s.next != nil {
 		s.next.ServeHTTP(w, r)
 	}
 }
 
+func (s Server) serveRemote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	size, modtime, err := s.getter.Stat(ctx, s.name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Unable to retrieve content", http.StatusBadGateway)
+		return
+	}
+	rc := newRemoteContent(ctx, s.getter, s.name, size, r.Header.Get("Range"))
+	defer rc.Close()
+	http.ServeContent(w, r, s.name, modtime, rc)
+}
+
 // Link registers a next request Handler to be called by ServeHTTP method.
 // It returns the result of the linking.
 func (s Server) Link(h xhttp.Handler) xhttp.HandlerLinker {
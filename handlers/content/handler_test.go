@@ -0,0 +1,126 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+var media = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+// memGetter is a mock remote content source which records the offsets of the
+// range reads it is asked to perform.
+type memGetter struct {
+	data    map[string][]byte
+	offsets []int64
+	lengths []int64
+}
+
+func (m *memGetter) Stat(ctx context.Context, name string) (int64, time.Time, error) {
+	b, ok := m.data[name]
+	if !ok {
+		return 0, time.Time{}, fs.ErrNotExist
+	}
+	return int64(len(b)), time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+func (m *memGetter) GetRange(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error) {
+	m.offsets = append(m.offsets, offset)
+	m.lengths = append(m.lengths, length)
+	b := m.data[name][offset:]
+	if length >= 0 && length < int64(len(b)) {
+		b = b[:length]
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func TestRemoteRange(t *testing.T) {
+	g := &memGetter{data: map[string][]byte{"video.mp4": media}}
+	mux := xhttp.NewServeMux()
+	mux.GET("/video", NewRemoteServer("video.mp4", g))
+
+	req, err := http.NewRequest("GET", "http://example.com/video", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=10-15")
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status %d but got %d", http.StatusPartialContent, w.Code)
+	}
+	if body := w.Body.String(); body != "abcdef" {
+		t.Fatalf("Expected: %v but got: %v \n", "abcdef", body)
+	}
+	if len(g.offsets) != 1 || g.offsets[0] != 10 || g.lengths[0] != 6 {
+		t.Fatalf("Expected a single range read of 6 bytes at offset 10 but got %v %v", g.offsets, g.lengths)
+	}
+
+	// Adjacent ranges are read separately, each bounded.
+	g.offsets, g.lengths = nil, nil
+	req.Header.Set("Range", "bytes=0-1,2-3")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || !strings.Contains(w.Body.String(), "01") || !strings.Contains(w.Body.String(), "23") {
+		t.Fatalf("Unexpected multipart response %d %q", w.Code, w.Body.String())
+	}
+	for _, l := range g.lengths {
+		if l != 2 || len(g.lengths) != 2 {
+			t.Fatalf("Expected bounded range reads but got lengths %v", g.lengths)
+		}
+	}
+}
+
+func TestRemoteSniff(t *testing.T) {
+	blob := bytes.Repeat([]byte("x"), 4096)
+	g := &memGetter{data: map[string][]byte{"blob": blob}}
+	mux := xhttp.NewServeMux()
+	mux.GET("/blob", NewRemoteServer("blob", g))
+
+	req, err := http.NewRequest("GET", "http://example.com/blob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=1000-1009")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "xxxxxxxxxx" {
+		t.Fatalf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") == "" {
+		t.Fatal("Expected the content type to be sniffed")
+	}
+	// The content type is sniffed from a bounded read of the first bytes.
+	for _, l := range g.lengths {
+		if l < 0 || l > sniffLen {
+			t.Fatalf("Expected bounded range reads but got lengths %v", g.lengths)
+		}
+	}
+}
+
+func TestRemoteNotFound(t *testing.T) {
+	g := &memGetter{data: map[string][]byte{}}
+	mux := xhttp.NewServeMux()
+	mux.GET("/video", NewRemoteServer("video.mp4", g))
+
+	req, err := http.NewRequest("GET", "http://example.com/video", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d but got %d", http.StatusNotFound, w.Code)
+	}
+}
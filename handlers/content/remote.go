@@ -0,0 +1,196 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Getter is the interface implemented by content sources that are able to
+// serve byte ranges of a named object without the object having to be held in
+// memory. Typical implementations issue signed range reads against an object
+// storage service such as S3 or GCS.
+type Getter interface {
+	// Stat returns the size in bytes and the last modification time of the
+	// named object.
+	Stat(ctx context.Context, name string) (size int64, modtime time.Time, err error)
+
+	// GetRange returns a reader over the content of the named object, starting
+	// at offset. If length is negative, the reader should extend up to the end
+	// of the object.
+	GetRange(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// remoteContent adapts a Getter into the io.ReadSeeker expected by
+// http.ServeContent.
+// Seeking is free: no request is made to the content source until data is
+// actually read. A new range read is issued whenever a Read follows a Seek
+// that changed the current offset.
+// The range reads are bounded by the end of the byte range requested by the
+// client which holds the current offset, if any. When byte ranges are
+// requested, the reads outside of them, i.e. the sniffing of the content type
+// by http.ServeContent, are bounded to sniffLen bytes.
+type remoteContent struct {
+	ctx    context.Context
+	getter Getter
+	name   string
+	size   int64
+	ranges []byteRange
+
+	offset int64
+	end    int64 // end of the current range read
+	body   io.ReadCloser
+}
+
+// sniffLen is the number of bytes http.ServeContent reads to detect the
+// content type.
+const sniffLen = 512
+
+// byteRange is a byte range requested by a client, end excluded.
+type byteRange struct {
+	start, end int64
+}
+
+func newRemoteContent(ctx context.Context, g Getter, name string, size int64, rangeHeader string) *remoteContent {
+	return &remoteContent{
+		ctx:    ctx,
+		getter: g,
+		name:   name,
+		size:   size,
+		ranges: parseRanges(rangeHeader, size),
+	}
+}
+
+func (rc *remoteContent) Read(p []byte) (int, error) {
+	if rc.offset >= rc.size {
+		return 0, io.EOF
+	}
+	if rc.body != nil && rc.offset >= rc.end {
+		rc.Close()
+	}
+	if rc.body == nil {
+		length := rc.length()
+		body, err := rc.getter.GetRange(rc.ctx, rc.name, rc.offset, length)
+		if err != nil {
+			return 0, err
+		}
+		rc.body = body
+		rc.end = rc.size
+		if length >= 0 {
+			rc.end = rc.offset + length
+		}
+	}
+	n, err := rc.body.Read(p)
+	rc.offset += int64(n)
+	if err == io.EOF && n > 0 && rc.offset >= rc.end && rc.offset < rc.size {
+		// The end of a bounded range read: the next Read issues a new one.
+		err = nil
+	}
+	return n, err
+}
+
+// length returns the length of the range read starting at the current offset:
+// up to the end of the requested byte range holding it, sniffLen bytes if it
+// is outside of the requested byte ranges, or -1 if there are none.
+func (rc *remoteContent) length() int64 {
+	if rc.ranges == nil {
+		return -1
+	}
+	for _, r := range rc.ranges {
+		if r.start <= rc.offset && rc.offset < r.end {
+			return r.end - rc.offset
+		}
+	}
+	if n := rc.size - rc.offset; n < sniffLen {
+		return n
+	}
+	return sniffLen
+}
+
+// parseRanges parses the value of a Range header for content of the given
+// size, as http.ServeContent does. It returns nil if the header is absent or
+// invalid, in which case the range reads are not bounded.
+func parseRanges(s string, size int64) []byteRange {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil
+	}
+	var ranges []byteRange
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		var r byteRange
+		if first == "" {
+			// A suffix range: the last bytes of the content.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{size - n, size}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil
+			}
+			r = byteRange{start, size}
+			if last != "" {
+				end, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil
+				}
+				if end < size-1 {
+					r.end = end + 1
+				}
+			}
+		}
+		if r.start < r.end {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+func (rc *remoteContent) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = rc.offset + offset
+	case io.SeekEnd:
+		abs = rc.size + offset
+	default:
+		return 0, errors.New("content: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("content: negative position")
+	}
+	if abs != rc.offset {
+		rc.Close()
+		rc.offset = abs
+	}
+	return abs, nil
+}
+
+// Close releases the range reader currently in use, if any.
+func (rc *remoteContent) Close() error {
+	if rc.body == nil {
+		return nil
+	}
+	err := rc.body.Close()
+	rc.body = nil
+	return err
+}
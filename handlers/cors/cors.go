@@ -63,20 +63,21 @@ type Handler struct {
 
 // Parameters defines the set of actionable components that are used to define a
 // response to a Cross-Origin request.
-// "*" is used to denote that anything is accepted (resp. Headers, Methods,
-// Content-Types).
-// The fields AllowedOrigins, AllowedHeaders, AllowedMethods, ExposeHeaders and
-// AllowedContentTypes are sets of strings. A string may be inserted by using
+// "*" is used to denote that anything is accepted (resp. Headers, Methods).
+// The fields AllowedOrigins, AllowedHeaders, AllowedMethods and ExposeHeaders
+// are sets of strings. A string may be inserted by using
 // the `Add(str string, caseSensitive bool)` method.
 // It is also possible to lookup for the existence of a string within a set
 // thanks to the `Contains(str string, caseSensitive bool)` method.
 type Parameters struct {
-	AllowedOrigins      set
-	AllowedHeaders      set
-	AllowedContentTypes set
-	ExposeHeaders       set
-	AllowedMethods      set
-	AllowCredentials    bool
+	AllowedOrigins   set
+	AllowedHeaders   set
+	ExposeHeaders    set
+	AllowedMethods   set
+	AllowCredentials bool
+
+	// originPatterns are the allowed origin patterns. See AllowOrigins.
+	originPatterns []originPattern
 }
 
 // PreflightHandler holds the elements required to build and register
@@ -105,7 +106,6 @@ func NewHandler() Handler {
 	h.Parameters = new(Parameters)
 	h.Parameters.AllowedOrigins = newSet()
 	h.Parameters.AllowedHeaders = newSet().Add("Accept", "Accept-Language", "Content-Language", "Content-Type", "Origin")
-	h.Parameters.AllowedContentTypes = newSet().Add("application/x-www-form-urlencoded", "multipart/form-data", "text/plain")
 	h.Parameters.ExposeHeaders = newSet()
 	h.Parameters.AllowedMethods = newSet()
 	return h
@@ -124,10 +124,15 @@ func (h Handler) EnablePreflight(mux *xhttp.ServeMux, endpoint string) Handler {
 	return h
 }
 
+// ServeHTTP answers the preflight requests, as defined by the Fetch
+// specification. An accepted preflight request gets a 204 No Content response
+// holding the CORS headers. A rejected one gets a 403 Forbidden response
+// without them, so that the user-agent does not send the actual request.
+// The OPTIONS requests which are not preflight requests are passed to the next
+// handler, if any.
 func (p *PreflightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-
-	// Check Headers: Origin, Access-Control-Request-Method, Access-Control-Request-Headers
-	if !originIsPresent(r) {
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !originIsPresent(r) || method == "" {
 		if p.next != nil {
 			p.next.ServeHTTP(w, r)
 		}
@@ -136,89 +141,26 @@ func (p *PreflightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// The preflight request is a preparation step that verifies that the request
 	// observes the requirement from the server in terms of origin, method, headers
-
-	// Checking origin
 	w.Header().Add("Vary", "Origin")
-
-	origin, ok := (textproto.MIMEHeader(r.Header))["Origin"]
-	if !ok {
-		if p.next != nil {
-			p.next.ServeHTTP( w, r)
-		}
-		return
-	}
-	originallowed := p.Parameters.AllowedOrigins.Contains(origin[0], true)
-	if p.Parameters.AllowedOrigins.Contains("*", false) {
-		originallowed = true
-	}
-	if !originallowed {
-		if p.next != nil {
-			p.next.ServeHTTP(w, r)
-		}
-		return
-	}
-
-	// Checking method
 	w.Header().Add("Vary", "Access-Control-Request-Method")
-
-	method, ok := (textproto.MIMEHeader(r.Header))["Access-Control-Request-Method"]
-	if !ok {
-		if p.next != nil {
-			p.next.ServeHTTP(w, r)
-		}
-		return
-	}
-	methodallowed := p.Parameters.AllowedMethods.Contains(method[0], true)
-	if p.Parameters.AllowedMethods.Contains("*", true) {
-		methodallowed = true
-	}
-	if !methodallowed {
-		if p.next != nil {
-			p.next.ServeHTTP(w, r)
-		}
-		return
-	}
-
-	// Checking headers
 	w.Header().Add("Vary", "Access-Control-Request-Headers")
 
-	headers, ok := (textproto.MIMEHeader(r.Header))["Access-Control-Request-Headers"]
-	if !ok {
-		if p.next != nil {
-			p.next.ServeHTTP(w, r)
-		}
-		return
-	}
-
-	headersallowed := p.Parameters.AllowedHeaders.Contains(headers[0], false)
-	for _, header := range headers {
-		headersallowed = headersallowed && p.Parameters.AllowedHeaders.Contains(header, false)
-	}
-	if p.Parameters.AllowedHeaders.Contains("*", false) {
-		headersallowed = true
-	}
-	if !headersallowed {
-		if p.next != nil {
-			p.next.ServeHTTP(w, r)
-		}
+	headers := requestedHeaders(r)
+	if !p.Parameters.OriginAllowed(r.Header.Get("Origin")) || !p.Parameters.methodAllowed(method) || !p.Parameters.headersAllowed(headers) {
+		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
 	// Setting the appropriate Headers on the HTTP response
-	setAllowCredentials(w, p.Parameters.AllowCredentials)
-
+	p.Parameters.setAllowOrigin(w, r.Header.Get("Origin"))
 	if p.MxAge != 0 {
 		setMaxAge(w, int(p.MxAge.Seconds()))
 	}
-
-	w.Header().Set("Access-Control-Allow-Methods", method[0])
-	for _, header := range headers {
-		w.Header().Add("Access-Control-Allow-Headers", header)
-	}
-
-	if p.next != nil {
-		p.next.ServeHTTP(w, r)
+	w.Header().Set("Access-Control-Allow-Methods", method)
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // Link enables the linking of a xhttp.Handler to the preflight request handler.
@@ -234,30 +176,16 @@ func (h Handler) WithCredentials() Handler {
 	return h
 }
 
+// ServeHTTP handles the actual, non-preflight, requests. The CORS headers
+// are set on the responses to the requests from an allowed origin only, the
+// user-agent preventing the others from reading the response.
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Vary", "Origin")
 
-	if !originIsPresent(r) {
-		if h.next != nil {
-			h.next.ServeHTTP(w, r)
-		}
-		return
-	}
-
-	// if the request is a simple one, we do not need to do much.
-	if methodIsAllowed(r, SimpleRequestMethods) {
-		if headersAreAllowed(r, SimpleRequestHeaders) {
-			if contentTypeIsAllowed(r, SimpleRequestContentTypes) {
-				if h.next != nil {
-					h.next.ServeHTTP(w, r)
-				}
-				return
-			}
-		}
+	if originIsPresent(r) && h.Parameters.OriginAllowed(r.Header.Get("Origin")) {
+		h.Parameters.setAllowOrigin(w, r.Header.Get("Origin"))
+		setExposeHeaders(w, h.Parameters.ExposeHeaders)
 	}
-	setAllowOrigin(w, r, h.Parameters.AllowedOrigins)
-	setAllowCredentials(w, h.Parameters.AllowCredentials)
-	setExposeHeaders(w, h.Parameters.ExposeHeaders)
 
 	if h.next != nil {
 		h.next.ServeHTTP(w, r)
@@ -270,53 +198,71 @@ func (h Handler) Link(hn xhttp.Handler) xhttp.HandlerLinker {
 	return h
 }
 
-// setAllowOrigin will write the Access-Control-Allow-Origin header assigning to
-// it the correct value.
-func setAllowOrigin(w http.ResponseWriter, r *http.Request, AllowedOrigins set) {
-	header := textproto.MIMEHeader(r.Header)
-	origin, ok := header["Origin"]
-	if !ok {
-		return
-	}
-
-	if len(origin) != 1 {
+// setAllowOrigin writes the Access-Control-Allow-Origin and
+// Access-Control-Allow-Credentials headers for an allowed origin. A listed
+// origin is echoed. An origin only allowed by "*" gets the literal "*" without
+// credentials, so that no site can read the credentialed responses.
+func (p *Parameters) setAllowOrigin(w http.ResponseWriter, origin string) {
+	if !p.originListed(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
 		return
 	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	setAllowCredentials(w, p.AllowCredentials)
+}
 
-	ori := origin[0]
+// OriginAllowed reports whether the requests from an origin are allowed, by
+// name, by pattern or by "*".
+func (p *Parameters) OriginAllowed(origin string) bool {
+	return p.originListed(origin) || p.AllowedOrigins.Contains("*", true)
+}
 
-	if !AllowedOrigins.Contains(ori, true) {
-		if AllowedOrigins.Contains("*", true) {
-			w.Header().Set("Access-Control-Allow-Origin", ori)
-			return
+// originListed reports whether an origin is allowed by name or by pattern,
+// rather than by "*".
+func (p *Parameters) originListed(origin string) bool {
+	if p.AllowedOrigins.Contains(origin, true) {
+		return true
+	}
+	for _, pattern := range p.originPatterns {
+		if pattern.match(origin) {
+			return true
 		}
-
-		w.Header().Set("Access-Control-Allow-Origin", "null")
-		return
 	}
+	return false
+}
 
-	w.Header().Set("Access-Control-Allow-Origin", ori)
-
+// methodAllowed reports whether a method is allowed for the actual request.
+// Methods are case-sensitive.
+func (p *Parameters) methodAllowed(method string) bool {
+	return SimpleRequestMethods.Contains(method, true) || p.AllowedMethods.Contains(method, true) || p.AllowedMethods.Contains("*", true)
 }
 
-// setAllowMethods will write the Access-Control-Allow-Methods header assigning to
-// it the correct value. It is written in response to a preflight request to
-// provide the user-agent with the list of methods that can be used in the actual
-// request.
-func setAllowMethods(w http.ResponseWriter, s set) {
-	for method := range s {
-		w.Header().Add("Access-Control-Allow-Methods", method)
+// headersAllowed reports whether the headers of the actual request, listed by
+// the preflight request, are allowed. The CORS-safelisted headers always are.
+func (p *Parameters) headersAllowed(headers []string) bool {
+	if p.AllowedHeaders.Contains("*", false) {
+		return true
 	}
+	for _, header := range headers {
+		if !SimpleRequestHeaders.Contains(header, false) && !p.AllowedHeaders.Contains(header, false) {
+			return false
+		}
+	}
+	return true
 }
 
-// setAllowHeaders will write the Access-Control-Allow-Headers header assigning to
-// it the correct value. It is written in response to a preflight request to
-// provide the user-agent with the list of headers that can be used in the actual
-// request.
-func setAllowHeaders(w http.ResponseWriter, s set) {
-	for header := range s {
-		w.Header().Add("Access-Control-Allow-Headers", header)
+// requestedHeaders returns the headers of the actual request, listed by the
+// Access-Control-Request-Headers header of a preflight request.
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(v, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, header)
+			}
+		}
 	}
+	return headers
 }
 
 // setExposeHeaders writes out the Access-Control-Expose-Headers header.
@@ -336,11 +282,10 @@ func setExposeHeaders(w http.ResponseWriter, s set) {
 // NOTE: Note sure it will be that useful since the Basic Authenitcation scheme
 // of the http protocol is not very practical.
 func setAllowCredentials(w http.ResponseWriter, b bool) {
+	// Only "true" is a valid value: the header is omitted otherwise.
 	if b {
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		return
 	}
-	w.Header().Set("Access-Control-Allow-Credentials", "false")
 }
 
 // setMaxAge writes out the Access-Control-Max-Age header which indicates for
@@ -350,29 +295,6 @@ func setMaxAge(w http.ResponseWriter, seconds int) {
 	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(seconds))
 }
 
-func headersAreAllowed(r *http.Request, s set) bool {
-	for k := range r.Header {
-		if !s.Contains(k, false) {
-			return false
-		}
-	}
-	return true
-}
-
-func methodIsAllowed(r *http.Request, s set) bool {
-	return s.Contains(r.Method, true)
-}
-
-func contentTypeIsAllowed(r *http.Request, s set) bool {
-	h := textproto.MIMEHeader(r.Header)
-	ct := h["Content-Type"]
-	var res bool
-	for _, val := range ct {
-		res = res && s.Contains(val, false)
-	}
-	return res
-}
-
 func originIsPresent(req *http.Request) bool {
 	ori := textproto.MIMEHeader(req.Header).Get("Origin")
 	if ori != "" {
@@ -401,18 +323,16 @@ func (s set) Add(strls ...string) set {
 }
 
 func (s set) Remove(str string, caseSensitive bool) {
-	if !caseSensitive {
-		str = strings.ToLower(str)
+	for k := range s {
+		if k == str || !caseSensitive && strings.EqualFold(k, str) {
+			delete(s, k)
+		}
 	}
-	delete(s, str)
 }
 
 func (s set) Contains(str string, caseSensitive bool) bool {
-	if !caseSensitive {
-		str = strings.ToLower(str)
-	}
 	for k := range s {
-		if k == str {
+		if k == str || !caseSensitive && strings.EqualFold(k, str) {
 			return true
 		}
 	}
@@ -32,7 +32,7 @@ func TestCORS(t *testing.T) {
 	}
 	req.Header.Set("Origin", URL)
 	req.Header.Set("Access-Control-Request-Method", "GET")
-	req.Header.Set("Access-Control-Request-Headers", "")
+	req.Header.Set("Access-Control-Request-Headers", "X-Test")
 
 	req2, err := http.NewRequest("GET", "http://example.com/", nil)
 	if err != nil {
@@ -101,3 +101,115 @@ func TestCORS(t *testing.T) {
 		t.Errorf("Did not expect the header to be set since origin is not authorized.\n")
 	}
 }
+
+func TestPreflight(t *testing.T) {
+	mux := xhttp.NewServeMux()
+	cs := NewHandler().EnablePreflight(&mux, "/")
+	cs.AllowedOrigins.Add(URL)
+	cs.AllowedMethods.Add("PUT")
+	cs.AllowedHeaders.Add("X-Token")
+	mux.GET("/", cs)
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "http://example.com/", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight(URL, "PUT", "x-token, content-type")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected an accepted preflight to get a 204 response but got %d", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != URL || w.Header().Get("Access-Control-Allow-Methods") != "PUT" || w.Header().Get("Access-Control-Allow-Headers") != "x-token, content-type" {
+		t.Fatalf("Unexpected preflight response headers %v", w.Header())
+	}
+	if _, ok := w.Header()["Access-Control-Allow-Credentials"]; ok {
+		t.Fatal("Expected the credentials header to be omitted")
+	}
+	w = preflight(URL, "PUT", "")
+	if _, ok := w.Header()["Access-Control-Allow-Headers"]; w.Code != http.StatusNoContent || ok {
+		t.Fatalf("Expected no Allow-Headers header when no header is requested but got %d %v", w.Code, w.Header())
+	}
+
+	for _, w := range []*httptest.ResponseRecorder{
+		preflight(ShortURL, "PUT", ""),
+		preflight(URL, "DELETE", ""),
+		preflight(URL, "PUT", "X-Other"),
+	} {
+		if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Allow-Methods") != "" {
+			t.Fatalf("Expected the preflight to be rejected but got %d %v", w.Code, w.Header())
+		}
+	}
+
+	// Simple requests get the Allow-Origin header when their origin is allowed.
+	for origin, want := range map[string]string{URL: URL, ShortURL: ""} {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%s: expected Allow-Origin %q but got %q", origin, want, got)
+		}
+	}
+}
+
+func TestOriginPatterns(t *testing.T) {
+	cs := NewHandler().AllowOrigins("https://app.example.org", "https://*.example.com", "http://localhost:*", "https://api.example.net:*")
+
+	for origin, want := range map[string]bool{
+		"https://app.example.org":        true,
+		"https://a.example.com":          true,
+		"https://A.B.Example.com":        true,
+		"https://example.com":            false,
+		"https://evilexample.com":        false,
+		"http://a.example.com":           false,
+		"https://a.example.com:8443":     false,
+		"https://a.example.com.evil.org": false,
+		"http://localhost:3000":          true,
+		"http://localhost":               true,
+		"https://localhost:3000":         false,
+		"https://api.example.net:8443":   true,
+		"null":                           false,
+	} {
+		if got := cs.Parameters.OriginAllowed(origin); got != want {
+			t.Errorf("%s: expected %v but got %v", origin, want, got)
+		}
+	}
+
+	for _, pattern := range []string{"https://*", "https://a.*.com", "*.example.com", "https://*.example.com/path", "http://localhost:8*"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected an invalid pattern to panic", pattern)
+				}
+			}()
+			NewHandler().AllowOrigins(pattern)
+		}()
+	}
+}
+
+func TestWildcardOrigin(t *testing.T) {
+	mux := xhttp.NewServeMux()
+	cs := NewHandler().EnablePreflight(&mux, "/").AllowOrigins("*", URL).WithCredentials()
+	mux.GET("/", cs)
+
+	for origin, want := range map[string]string{URL: URL, ShortURL: "*"} {
+		for _, method := range []string{"OPTIONS", "GET"} {
+			req := httptest.NewRequest(method, "http://example.com/", nil)
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Access-Control-Request-Method", "GET")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != want {
+				t.Errorf("%s %s: expected Allow-Origin %q but got %q", method, origin, want, got)
+			}
+			if cred := w.Header().Get("Access-Control-Allow-Credentials"); (cred == "true") != (want == URL) {
+				t.Errorf("%s %s: unexpected Allow-Credentials %q", method, origin, cred)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cors
+
+import (
+	"net"
+	"strings"
+)
+
+// originPattern is a compiled allowed origin pattern, such as
+// https://*.example.com or http://localhost:*.
+type originPattern struct {
+	scheme string
+	// host is the host, or the domain whose subdomains are allowed if
+	// subdomains is set.
+	host       string
+	subdomains bool
+	// port is empty when the origin has no port, "*" when any port, or none,
+	// is allowed.
+	port string
+}
+
+// AllowOrigins adds origins to the allowed origins. An origin may be a pattern
+// whose first host label is "*", allowing any subdomain, e.g.
+// https://*.example.com, or whose port is "*", allowing any port, e.g.
+// http://localhost:*. The patterns are compiled once: it panics if one of them
+// is invalid.
+func (h Handler) AllowOrigins(origins ...string) Handler {
+	for _, o := range origins {
+		if o == "*" || !strings.Contains(o, "*") {
+			h.Parameters.AllowedOrigins.Add(o)
+			continue
+		}
+		p, ok := compileOrigin(o)
+		if !ok {
+			panic("cors: invalid origin pattern " + o)
+		}
+		h.Parameters.originPatterns = append(h.Parameters.originPatterns, p)
+	}
+	return h
+}
+
+// compileOrigin parses an origin pattern.
+func compileOrigin(o string) (originPattern, bool) {
+	var p originPattern
+	scheme, hostport, ok := splitOrigin(o)
+	if !ok {
+		return p, false
+	}
+	p.scheme, p.host = scheme, hostport
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		p.host, p.port = host, port
+	}
+	if strings.HasPrefix(p.host, "*.") {
+		p.host, p.subdomains = p.host[1:], true
+	}
+	p.host = strings.ToLower(p.host)
+	if p.host == "" || p.host == "." || strings.Contains(p.host, "*") || p.port != "*" && strings.Contains(p.port, "*") {
+		return p, false
+	}
+	return p, true
+}
+
+// splitOrigin returns the scheme and the host, with its port if any, of an
+// origin.
+func splitOrigin(o string) (scheme string, hostport string, ok bool) {
+	i := strings.Index(o, "://")
+	if i <= 0 || i+3 == len(o) || strings.ContainsAny(o[i+3:], "/?#") {
+		return "", "", false
+	}
+	return strings.ToLower(o[:i]), o[i+3:], true
+}
+
+// match reports whether an origin matches the pattern.
+func (p originPattern) match(origin string) bool {
+	scheme, hostport, ok := splitOrigin(origin)
+	if !ok || scheme != p.scheme {
+		return false
+	}
+	host, port := hostport, ""
+	if h, pt, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, pt
+	}
+	if port != p.port && p.port != "*" {
+		return false
+	}
+	host = strings.ToLower(host)
+	if p.subdomains {
+		return len(host) > len(p.host) && strings.HasSuffix(host, p.host)
+	}
+	return host == p.host
+}
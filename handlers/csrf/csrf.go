@@ -4,6 +4,7 @@ package csrf
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"net/http"
@@ -14,8 +15,6 @@ import (
 	"github.com/atdiar/xhttp/handlers/session"
 )
 
-// TODO use subtle compare... it mitigates timing attacks apparently
-
 const (
 	methodGET     = "GET"
 	methodHEAD    = "HEAD"
@@ -28,12 +27,51 @@ var (
 	ErrInvalidSession = errors.New("Session does not exist ?")
 )
 
+// Pattern is the way the anti-CSRF token sent by the client is checked.
+type Pattern int
+
+const (
+	// DoubleSubmit checks that the anti-CSRF header holds the value of the
+	// anti-CSRF cookie. It is the default.
+	DoubleSubmit Pattern = iota
+	// SynchronizerToken checks that the anti-CSRF header holds the token kept
+	// server-side in the session of the client.
+	SynchronizerToken
+)
+
 // Handler is a special type of request handler that creates a token value used
 // to protect against Cross-Site Request Forgery vulnerabilities.
 type Handler struct {
 	Header  string // Name of the anti-csrf request header to check
 	Session session.Handler
-	next    xhttp.Handler
+
+	// Sources are the places of the request where the token is looked for,
+	// in order. See TokenSources.
+	Sources []Extractor
+
+	// Pattern is the way the tokens are checked. With the SynchronizerToken
+	// pattern, the tokens are held by the Tokens session. See Synchronized.
+	Pattern Pattern
+	Tokens  session.Handler
+
+	// PerRequest renews the token after each request it protected.
+	PerRequest bool
+
+	// Strict marks the tokens consumed once used, the replays being reported
+	// to OnReplay. See Strict.
+	Strict   bool
+	OnReplay func(r *http.Request)
+	consumed *locks
+
+	// ErrorMapper, if set, writes the error responses instead of http.Error.
+	ErrorMapper xhttp.ErrorMapper
+
+	// exempt and skip select the requests which bypass the protection. See
+	// Except and Skip.
+	exempt []string
+	skip   []SkipFunc
+
+	next xhttp.Handler
 }
 
 // NewHandler builds a new anti-CSRF request handler, creating a full session
@@ -53,6 +91,52 @@ func NewHandler(name string, secret string, options ...func(Handler) Handler) Ha
 	return h
 }
 
+// WithErrorMapper is a configuration option which sets the ErrorMapper writing
+// the error responses, e.g. xhttp.ProblemErrorMapper.
+func WithErrorMapper(m xhttp.ErrorMapper) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.ErrorMapper = m
+		return h
+	}
+}
+
+// Synchronized is a configuration option which makes the handler use the
+// synchronizer token pattern: the expected token is held by the server-side
+// session s, typically the session of the user, rather than only by the
+// anti-CSRF cookie.
+// The session is loaded, or generated, by the anti-CSRF handler if it has not
+// been already.
+func Synchronized(s session.Handler) func(Handler) Handler {
+	if s.Store == nil {
+		panic("csrf: the synchronizer token pattern requires a server-side session")
+	}
+	return func(h Handler) Handler {
+		h.Pattern = SynchronizerToken
+		h.Tokens = s
+		return h
+	}
+}
+
+// PerRequestTokens is a configuration option which renews the anti-CSRF token
+// after each request it protected, so that a token can only be used once. It
+// only applies to the synchronizer token pattern.
+// The pages holding a token must be rendered again after each request, which
+// prevents the use of several tabs or concurrent requests.
+func PerRequestTokens() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.PerRequest = true
+		return h
+	}
+}
+
+func (h Handler) fail(res http.ResponseWriter, req *http.Request, msg string, status int) {
+	if h.ErrorMapper != nil {
+		h.ErrorMapper(res, req, xhttp.NewError(status, errors.New(msg)))
+		return
+	}
+	http.Error(res, msg, status)
+}
+
 // Link enables the linking of a xhttp.Handler to the anti-CSRF request Handler.
 func (h Handler) Link(hn xhttp.Handler) xhttp.HandlerLinker {
 	h.next = hn
@@ -62,40 +146,69 @@ func (h Handler) Link(hn xhttp.Handler) xhttp.HandlerLinker {
 func (h Handler) generateToken(res http.ResponseWriter, req *http.Request) error {
 	tok, err := generateToken(32)
 	if err != nil {
-		http.Error(res, "Generating anti-CSRF Token failed", 503)
+		h.fail(res, req, "Generating anti-CSRF Token failed", 503)
 		return err
 	}
 	// First we replace the session cookie by the anti-CSRF cookie
 	// That will ensure that on Session Save, the anti-CSRF is registered in the
 	// http response header.
+	err = h.Session.Generate(res, req)
+	if err != nil {
+		h.fail(res, req, "Generating anti-CSRF session failed", 503)
+		return err
+	}
 	err = h.Session.Put(req.Context(), h.Session.Name, []byte(tok), 0)
 
 	if err != nil {
-		http.Error(res, "Storing new CSRF Token in session failed", 503)
+		h.fail(res, req, "Storing new CSRF Token in session failed", 503)
 		return err
 	}
 	return h.Session.Save(res, req)
 }
 
-// CtxToken returns the encoded session value of a csrf token.
+// CtxToken returns the encoded session value of a csrf token, or the token
+// itself with the synchronizer token pattern.
 func (h Handler) CtxToken(ctx context.Context) (string, error) {
-	c, ok := ctx.Value(h.Session.ContextKey).(http.Cookie)
-	if !ok {
+	if h.Pattern == SynchronizerToken {
+		tok, err := h.Tokens.Get(ctx, h.Session.Name)
+		if err != nil {
+			return "", errors.New("CSRF: could not retrieve anticsrf token. Absent")
+		}
+		return string(tok), nil
+	}
+	s, err := h.Session.From(ctx)
+	if err != nil {
 		return "", errors.New("CSRF: could not retrieve anticsrf token. Absent")
 	}
+	c, err := s.Cookie.Encode()
+	if err != nil {
+		return "", err
+	}
 	return c.Value, nil
 }
 
 // ServeHTTP handles the servicing of incoming http requests.
 func (h Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	ctx := req.Context()
+	if h.skipped(req) {
+		if h.next != nil {
+			h.next.ServeHTTP(res, req)
+		}
+		return
+	}
+
 	// We want any potential caching system to remain aware of changes to the
 	// cookie header. As such, we have to add a Vary header.
 	res.Header().Add("Vary", "Cookie")
 
+	if h.Pattern == SynchronizerToken {
+		h.serveSynchronized(res, req)
+		return
+	}
+
 	// First we have to load the session data.
 	// Indeed, we want to register the CSRF token as a session value.
 	// For this, we need to use the most recently generated session id.
+	req = h.Session.Attach(req)
 	err := h.Session.Load(res, req)
 
 	switch req.Method {
@@ -106,7 +219,7 @@ func (h Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			err = h.generateToken(res, req)
 			if err != nil {
-				http.Error(res, "Internal Server Error", 500)
+				h.fail(res, req, "Internal Server Error", 500)
 				return
 			}
 		}
@@ -118,41 +231,40 @@ func (h Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			err = h.generateToken(res, req)
 			if err != nil {
-				http.Error(res, "Internal Server Error", 500)
+				h.fail(res, req, "Internal Server Error", 500)
 				return
 			}
-			http.Error(res, TokenInvalid, 403)
+			h.fail(res, req, TokenInvalid, 403)
 			return
 		}
 
-		Header, ok := req.Header[h.Header]
-		if !ok {
-			http.Error(res, HeaderMissing, http.StatusBadRequest)
+		headerToken := h.token(req)
+		if headerToken == "" {
+			h.fail(res, req, HeaderMissing, http.StatusBadRequest)
 			return
 		}
 
 		// Validation
 
-		// Header exists. The anti-csrf cookie must be present too.
-		headerToken := Header[0]
-		cookie, ok := ctx.Value(h.Session.ContextKey).(http.Cookie)
-		if !ok {
+		// Token exists. The anti-csrf cookie must be present too.
+		cookie, err := req.Cookie(h.Session.Cookie.HttpCookie.Name)
+		if err != nil {
 			err = h.generateToken(res, req)
 			if err != nil {
-				http.Error(res, "Internal Server Error", 500)
+				h.fail(res, req, "Internal Server Error", 500)
 				return
 			}
-			http.Error(res, "anti csrf session not valid", http.StatusBadRequest)
+			h.fail(res, req, "anti csrf session not valid", http.StatusBadRequest)
 			return
 		}
 		cookieToken := cookie.Value
-		if headerToken != cookieToken {
+		if !equal(headerToken, cookieToken) {
 			err = h.generateToken(res, req)
 			if err != nil {
-				http.Error(res, "Internal Server Error", 500)
+				h.fail(res, req, "Internal Server Error", 500)
 				return
 			}
-			http.Error(res, "anti csrf header not valid", http.StatusBadRequest)
+			h.fail(res, req, "anti csrf header not valid", http.StatusBadRequest)
 			return
 		}
 		if h.next != nil {
@@ -162,6 +274,77 @@ func (h Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// serveSynchronized handles the requests with the synchronizer token pattern.
+func (h Handler) serveSynchronized(res http.ResponseWriter, req *http.Request) {
+	req = h.Tokens.Attach(req)
+	err := h.Tokens.Load(res, req)
+	if err != nil {
+		err = h.Tokens.Generate(res, req)
+		if err != nil {
+			h.fail(res, req, "Generating anti-CSRF session failed", 503)
+			return
+		}
+	}
+	tok, err := h.Tokens.Get(req.Context(), h.Session.Name)
+
+	switch req.Method {
+	case methodGET, methodHEAD, methodOPTIONS:
+		if err != nil {
+			if err = h.renewToken(res, req); err != nil {
+				return
+			}
+		}
+	default:
+		sent := h.token(req)
+		if sent == "" {
+			h.fail(res, req, HeaderMissing, http.StatusBadRequest)
+			return
+		}
+		if h.Strict {
+			if !h.consumeToken(res, req, sent) {
+				return
+			}
+			break
+		}
+		if err != nil || !equal(sent, string(tok)) {
+			h.fail(res, req, TokenInvalid, 403)
+			return
+		}
+		if h.PerRequest {
+			if err = h.renewToken(res, req); err != nil {
+				return
+			}
+		}
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(res, req)
+	}
+}
+
+// renewToken stores a new token in the Tokens session.
+func (h Handler) renewToken(res http.ResponseWriter, req *http.Request) error {
+	tok, err := generateToken(32)
+	if err != nil {
+		h.fail(res, req, "Generating anti-CSRF Token failed", 503)
+		return err
+	}
+	err = h.Tokens.Put(req.Context(), h.Session.Name, []byte(tok), 0)
+	if err == nil {
+		err = h.Tokens.Save(res, req)
+	}
+	if err != nil {
+		h.fail(res, req, "Storing new CSRF Token in session failed", 503)
+		return err
+	}
+	return nil
+}
+
+// equal compares two tokens in constant time, so that the expected token
+// cannot be guessed from the duration of the comparison.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
 // generateToken creates a base64 encoded version of a 32byte Cryptographically
 // secure random number to be used as a protection against CSRF attacks.
 // It uses Go's implementation of devurandom (which has a backup in case
@@ -1,15 +1,25 @@
 package csrf
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"html/template"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/session"
+	"github.com/atdiar/xhttp/handlers/session/cache/memory"
 )
 
 func TestAntiCSRF(t *testing.T) {
@@ -132,8 +142,9 @@ func TestAntiCSRF(t *testing.T) {
 		t.Fatal("Unexpected empty body.")
 	}
 
-	if body == oldToken {
-		t.Fatal("Expected a newly generated token but the previous one was sent.")
+	// The token is not rotated after a successful request.
+	if body != oldToken {
+		t.Fatalf("Expected the token to be kept but got %v", body)
 	}
 
 	// Step 3: third POST request
@@ -432,3 +443,270 @@ var isTokenTable = [127]bool{
 	'|':  true,
 	'~':  true,
 }
+
+func TestSynchronizerToken(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	user := session.New("SID", "secret", session.SetStore(store))
+	anticsrf := NewHandler("nosurf", "secret", Synchronized(user), PerRequestTokens())
+
+	var token string
+	h := anticsrf.Link(xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		tok, err := anticsrf.CtxToken(req.Context())
+		if err != nil {
+			t.Error(err)
+		}
+		token = tok
+	}))
+	serve := func(method string, header string, c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com/", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		if header != "" {
+			req.Header.Set(anticsrf.Header, header)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve("GET", "", nil)
+	c := RetrieveCookie(res.Header(), "SID")
+	if token == "" || c == nil || c.Name != "SID" {
+		t.Fatal("Expected a token to be generated in the user session")
+	}
+	first := token
+
+	if res = serve("POST", "forged", c); res.Code != http.StatusForbidden {
+		t.Fatalf("Expected an invalid token to be rejected but got %d", res.Code)
+	}
+	if res = serve("POST", first, c); res.Code != http.StatusOK {
+		t.Fatalf("Expected the token to be accepted but got %d", res.Code)
+	}
+	if token == first {
+		t.Fatal("Expected the token to be renewed after the request")
+	}
+	if res = serve("POST", first, c); res.Code != http.StatusForbidden {
+		t.Fatalf("Expected a used token to be rejected but got %d", res.Code)
+	}
+}
+
+func TestTokenSources(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	user := session.New("SID", "secret", session.SetStore(store))
+	anticsrf := NewHandler("nosurf", "secret", Synchronized(user))
+
+	var field template.HTML
+	h := anticsrf.Link(xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		f, err := anticsrf.TemplateField(req.Context())
+		if err != nil {
+			t.Error(err)
+		}
+		field = f
+	}))
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "http://example.com/", nil))
+	c := RetrieveCookie(res.Header(), "SID")
+	i := strings.Index(string(field), `value="`)
+	if !strings.HasPrefix(string(field), `<input type="hidden" name="_csrf"`) || i < 0 {
+		t.Fatalf("Unexpected template field %s", field)
+	}
+	token := html.UnescapeString(strings.TrimSuffix(string(field)[i+len(`value="`):], `">`))
+
+	post := func(h xhttp.Handler, req *http.Request) int {
+		req.AddCookie(c)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res.Code
+	}
+	form := func() *http.Request {
+		req := httptest.NewRequest("POST", "http://example.com/", strings.NewReader(url.Values{DefaultFormField: {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	if code := post(h, form()); code != http.StatusOK {
+		t.Fatalf("Expected the token of the form field to be accepted by default but got %d", code)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("token", token)
+	mw.Close()
+	multi := httptest.NewRequest("POST", "http://example.com/", &body)
+	multi.Header.Set("Content-Type", mw.FormDataContentType())
+	query := httptest.NewRequest("POST", "http://example.com/?t="+url.QueryEscape(token), nil)
+
+	custom := TokenSources(FromMultipart("token", 1<<20), FromQuery("t"))(anticsrf).Link(xhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	if code := post(custom, multi); code != http.StatusOK {
+		t.Fatalf("Expected the token of the multipart field to be accepted but got %d", code)
+	}
+	if code := post(custom, query); code != http.StatusOK {
+		t.Fatalf("Expected the token of the query parameter to be accepted but got %d", code)
+	}
+	if code := post(custom, form()); code != http.StatusBadRequest {
+		t.Fatalf("Expected the sources not configured to be ignored but got %d", code)
+	}
+}
+
+func TestExemptions(t *testing.T) {
+	anticsrf := NewHandler("nosurf", "secret").
+		Except("/webhooks/", "/login").
+		Skip(func(r *http.Request) bool { return r.Header.Get("Authorization") != "" })
+	h := anticsrf.Link(xhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for path, want := range map[string]int{
+		"/webhooks/stripe": http.StatusOK,
+		"/login":           http.StatusOK,
+		"/login/reset":     http.StatusForbidden,
+		"/webhooks":        http.StatusForbidden,
+	} {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest("POST", "http://example.com"+path, nil))
+		if res.Code != want {
+			t.Errorf("%s: expected %d but got %d", path, want, res.Code)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "http://example.com/api", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected the skipped request to bypass the protection but got %d", res.Code)
+	}
+}
+
+func TestStrictTokens(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	user := session.New("SID", "secret", session.SetStore(store))
+	replays := 0
+	anticsrf := NewHandler("nosurf", "secret", Synchronized(user), Strict(func(*http.Request) { replays++ }))
+
+	var token string
+	h := anticsrf.Link(xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		token, _ = anticsrf.CtxToken(req.Context())
+	}))
+	serve := func(method string, header string, c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com/", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		if header != "" {
+			req.Header.Set(anticsrf.Header, header)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	c := RetrieveCookie(serve("GET", "", nil).Header(), "SID")
+	used := token
+	if res := serve("POST", used, c); res.Code != http.StatusOK {
+		t.Fatalf("Expected the token to be accepted but got %d", res.Code)
+	}
+	res := serve("POST", used, c)
+	if res.Code != http.StatusForbidden || strings.TrimSpace(res.Body.String()) != TokenReplayed || replays != 1 {
+		t.Fatalf("Expected the replay to be detected but got %d %q", res.Code, res.Body.String())
+	}
+	res = serve("POST", "forged", c)
+	if strings.TrimSpace(res.Body.String()) != TokenInvalid || replays != 1 {
+		t.Fatalf("Expected an unknown token not to be reported as a replay but got %q", res.Body.String())
+	}
+	if res = serve("POST", token, c); res.Code != http.StatusOK {
+		t.Fatalf("Expected the new token to be accepted but got %d", res.Code)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected strict mode without synchronizer token pattern to panic")
+		}
+	}()
+	NewHandler("nosurf", "secret", Strict(nil))
+}
+
+// slowStore delays the reads so that concurrent requests overlap.
+type slowStore struct {
+	*memory.Store
+}
+
+func (s slowStore) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	time.Sleep(time.Millisecond)
+	return s.Store.Get(ctx, id, hkey)
+}
+
+func TestStrictConcurrent(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+	user := session.New("SID", "secret", session.SetStore(slowStore{store}))
+	anticsrf := NewHandler("nosurf", "secret", Synchronized(user), Strict(nil))
+
+	var token string
+	h := anticsrf.Link(xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			token, _ = anticsrf.CtxToken(req.Context())
+		}
+	}))
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "http://example.com/", nil))
+	c := RetrieveCookie(res.Header(), "SID")
+
+	// Concurrent requests sending the same token are accepted only once.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	accepted := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "http://example.com/", nil)
+			req.AddCookie(c)
+			req.Header.Set(anticsrf.Header, token)
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+			if res.Code == http.StatusOK {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Fatalf("Expected the token to be accepted once but it was accepted %d times", accepted)
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	anticsrf := NewHandler("nosurf", "secret")
+	res := httptest.NewRecorder()
+	anticsrf.TokenHandler().ServeHTTP(res, httptest.NewRequest("GET", "http://example.com/csrf", nil))
+
+	var body TokenResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	c := RetrieveCookie(res.Header(), "nosurf")
+	if body.Token == "" || body.Token != c.Value || body.Header != anticsrf.Header {
+		t.Fatalf("Expected the token of the anti-CSRF cookie but got %+v", body)
+	}
+	if res.Header().Get("Cache-Control") != "no-store" {
+		t.Fatal("Expected the token not to be cached")
+	}
+
+	// The token can be read back on the next request.
+	req := httptest.NewRequest("POST", "http://example.com/", nil)
+	req.AddCookie(c)
+	req.Header.Set(body.Header, body.Token)
+	var got string
+	h := anticsrf.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = anticsrf.Token(r)
+	}))
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Code != http.StatusOK || got == "" {
+		t.Fatalf("Expected the bootstrapped token to be accepted but got %d", res.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package csrf
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SkipFunc reports whether a request bypasses the anti-CSRF protection, e.g.
+// because it is authenticated by a bearer token rather than a cookie.
+type SkipFunc func(r *http.Request) bool
+
+// Except returns a copy of the handler which lets the requests for the given
+// paths bypass the anti-CSRF protection, e.g. webhook endpoints. A path ending
+// with a slash exempts the whole subtree, like the patterns of http.ServeMux.
+func (h Handler) Except(paths ...string) Handler {
+	h.exempt = append(h.exempt[:len(h.exempt):len(h.exempt)], paths...)
+	return h
+}
+
+// Skip returns a copy of the handler which lets the requests for which f
+// returns true bypass the anti-CSRF protection.
+func (h Handler) Skip(f SkipFunc) Handler {
+	h.skip = append(h.skip[:len(h.skip):len(h.skip)], f)
+	return h
+}
+
+// skipped reports whether a request bypasses the anti-CSRF protection.
+func (h Handler) skipped(r *http.Request) bool {
+	for _, p := range h.exempt {
+		if r.URL.Path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	for _, f := range h.skip {
+		if f(r) {
+			return true
+		}
+	}
+	return false
+}
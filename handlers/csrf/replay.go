@@ -0,0 +1,136 @@
+package csrf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenReplayed is the error message sent when a token which has already been
+// used is sent again.
+const TokenReplayed = "Forbidden. anti-CSRF Token already used"
+
+// consumedTTL is the duration for which a used token is remembered.
+const consumedTTL = 24 * time.Hour
+
+// Strict is a configuration option which makes every token single-use, with
+// the synchronizer token pattern: once a token has protected a request, it is
+// marked consumed in the session Store and a new token is issued. A consumed
+// token sent again is rejected as a replay, onReplay, if not nil, being called
+// with the request, e.g. to log the attempt.
+// It must be set after Synchronized.
+//
+// The check and the consumption of a token are serialized per session within
+// the process, so that concurrent requests sending the same token cannot both
+// pass. The session Store offering no conditional write, this does not hold
+// across several instances sharing the Store: each of them may accept a
+// token once during the time it takes to consume it.
+func Strict(onReplay func(r *http.Request)) func(Handler) Handler {
+	return func(h Handler) Handler {
+		if h.Pattern != SynchronizerToken {
+			panic("csrf: strict mode requires the synchronizer token pattern")
+		}
+		h.PerRequest = true
+		h.Strict = true
+		h.OnReplay = onReplay
+		h.consumed = newLocks()
+		return h
+	}
+}
+
+// consumeToken checks the token sent with an unsafe request against the
+// session token and, if it matches, consumes it and issues a new one, all
+// while holding the lock of the session. It writes the error response and
+// returns false if the request must not proceed.
+func (h Handler) consumeToken(res http.ResponseWriter, req *http.Request, sent string) bool {
+	ctx := req.Context()
+	id, err := h.Tokens.ID(ctx)
+	if err != nil {
+		h.fail(res, req, TokenInvalid, 403)
+		return false
+	}
+	unlock := h.consumed.lock(id)
+	defer unlock()
+
+	// The token is read again as it may have been consumed since the session
+	// was loaded.
+	tok, err := h.Tokens.Get(ctx, h.Session.Name)
+	if err != nil || !equal(sent, string(tok)) {
+		if h.replayed(req, sent) {
+			if h.OnReplay != nil {
+				h.OnReplay(req)
+			}
+			h.fail(res, req, TokenReplayed, 403)
+			return false
+		}
+		h.fail(res, req, TokenInvalid, 403)
+		return false
+	}
+	if err = h.consume(req, sent); err != nil {
+		h.fail(res, req, "Storing used CSRF Token in session failed", 503)
+		return false
+	}
+	return h.renewToken(res, req) == nil
+}
+
+// consumedKey returns the session key marking a token as consumed. The token
+// itself is not stored.
+func (h Handler) consumedKey(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return h.Session.Name + "/consumed/" + hex.EncodeToString(sum[:])
+}
+
+// consume marks a token as used.
+func (h Handler) consume(r *http.Request, tok string) error {
+	return h.Tokens.Put(r.Context(), h.consumedKey(tok), []byte("1"), consumedTTL)
+}
+
+// replayed reports whether a token has already been used.
+func (h Handler) replayed(r *http.Request, tok string) bool {
+	_, err := h.Tokens.Get(r.Context(), h.consumedKey(tok))
+	return err == nil
+}
+
+// locks holds a mutex per key, removed once no longer in use.
+type locks struct {
+	mu sync.Mutex
+	m  map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	n int
+}
+
+func newLocks() *locks {
+	return &locks{m: make(map[string]*keyLock)}
+}
+
+// lock acquires the mutex of key and returns the function releasing it.
+// A nil *locks does not lock.
+func (l *locks) lock(key string) func() {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	k, ok := l.m[key]
+	if !ok {
+		k = &keyLock{}
+		l.m[key] = k
+	}
+	k.n++
+	l.mu.Unlock()
+
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.mu.Lock()
+		k.n--
+		if k.n == 0 {
+			delete(l.m, key)
+		}
+		l.mu.Unlock()
+	}
+}
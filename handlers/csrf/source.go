@@ -0,0 +1,95 @@
+package csrf
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+)
+
+// DefaultFormField is the name of the form field holding the anti-CSRF token
+// in the forms of server-rendered pages.
+const DefaultFormField = "_csrf"
+
+// Extractor retrieves the anti-CSRF token sent with a request. It returns an
+// empty string if the token is absent.
+type Extractor func(r *http.Request) string
+
+// FromHeader returns an Extractor reading the token from a request header.
+func FromHeader(name string) Extractor {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// FromForm returns an Extractor reading the token from a field of an url
+// encoded form.
+func FromForm(field string) Extractor {
+	return func(r *http.Request) string {
+		if err := r.ParseForm(); err != nil {
+			return ""
+		}
+		return r.PostForm.Get(field)
+	}
+}
+
+// FromMultipart returns an Extractor reading the token from a field of a
+// multipart form. Up to maxMemory bytes of the files it holds are kept in
+// memory, the remainder being stored on disk. See
+// http.Request.ParseMultipartForm.
+func FromMultipart(field string, maxMemory int64) Extractor {
+	return func(r *http.Request) string {
+		if err := r.ParseMultipartForm(maxMemory); err != nil || r.MultipartForm == nil {
+			return ""
+		}
+		if v := r.MultipartForm.Value[field]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+}
+
+// FromQuery returns an Extractor reading the token from a query parameter.
+// The token may leak through the logs or the Referer header: this source
+// should only be used when no other is available.
+func FromQuery(param string) Extractor {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}
+}
+
+// TokenSources is a configuration option which sets the sources of the
+// anti-CSRF token, evaluated in order until a token is found.
+// By default, the token is read from the anti-CSRF header, then from the
+// DefaultFormField field of an url encoded form.
+func TokenSources(extractors ...Extractor) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Sources = extractors
+		return h
+	}
+}
+
+// token returns the anti-CSRF token sent with a request, from the first
+// source which holds one.
+func (h Handler) token(r *http.Request) string {
+	sources := h.Sources
+	if sources == nil {
+		sources = []Extractor{FromHeader(h.Header), FromForm(DefaultFormField)}
+	}
+	for _, extract := range sources {
+		if tok := extract(r); tok != "" {
+			return tok
+		}
+	}
+	return ""
+}
+
+// TemplateField returns the hidden input holding the anti-CSRF token, in the
+// DefaultFormField field, to be inserted in the forms of server-rendered
+// pages.
+func (h Handler) TemplateField(ctx context.Context) (template.HTML, error) {
+	tok, err := h.CtxToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<input type="hidden" name="` + DefaultFormField + `" value="` + template.HTMLEscapeString(tok) + `">`), nil
+}
@@ -0,0 +1,46 @@
+package csrf
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/atdiar/xhttp"
+)
+
+// Token returns the anti-CSRF token of a request handled by the anti-CSRF
+// handler, as it should be sent back by the client. It is the value of the
+// anti-CSRF cookie or, with the synchronizer token pattern, the token held by
+// the session.
+func (h Handler) Token(r *http.Request) (string, error) {
+	return h.CtxToken(r.Context())
+}
+
+// TokenResponse is the body of the responses of the TokenHandler.
+type TokenResponse struct {
+	Token string `json:"token"`
+	// Header is the name of the request header which should hold the token.
+	Header string `json:"header"`
+}
+
+// TokenHandler returns a request handler responding with the anti-CSRF token
+// of the client as JSON, generating it if needed, so that single-page
+// applications can bootstrap it:
+//
+//	mux.GET("/csrf", anticsrf.TokenHandler())
+func (h Handler) TokenHandler() xhttp.Handler {
+	return h.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, err := h.Token(r)
+		if err != nil {
+			h.fail(w, r, "Anti-CSRF token unavailable", http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(TokenResponse{Token: tok, Header: h.Header})
+		if err != nil {
+			h.fail(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(b)
+	}))
+}
@@ -0,0 +1,138 @@
+// Package defaults assembles the request handlers that every service should
+// run, in the recommended order, into a single xhttp.HandlerLinker.
+//
+// The chain is, from outermost to innermost:
+//
+//	request ID -> panic recovery -> HSTS -> security headers -> CORS ->
+//	request size limit -> CSRF protection
+//
+// The request ID comes first so that it is available in panic reports. Panic
+// recovery then wraps every other handler. The response headers are set before
+// any request is rejected, so that error responses carry them too.
+package defaults
+
+import (
+	"log"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/cors"
+	"github.com/atdiar/xhttp/handlers/csrf"
+	"github.com/atdiar/xhttp/handlers/hsts"
+	"github.com/atdiar/xhttp/handlers/maxreqsize"
+	"github.com/atdiar/xhttp/handlers/panic"
+	"github.com/atdiar/xhttp/handlers/requestid"
+	"github.com/atdiar/xhttp/handlers/secureheaders"
+)
+
+const (
+	// DefaultHSTSMaxAge is the default max-age of the Strict-Transport-Security
+	// header.
+	DefaultHSTSMaxAge = 180 * 24 * time.Hour
+	// DefaultMaxBodySize is the default maximum size of a request body.
+	DefaultMaxBodySize = 10 << 20
+	// DefaultCSRFName is the default name of the anti-CSRF cookie.
+	DefaultCSRFName = "CSRF"
+)
+
+// Options configures the chain returned by SecureDefaults. The zero value is a
+// valid configuration which disables CSRF protection and cross-origin
+// requests.
+type Options struct {
+	// Log receives the recovered panics. The standard logger is used if nil.
+	Log *log.Logger
+	// ErrorMapper, if set, writes the error responses of the panic and CSRF
+	// handlers.
+	ErrorMapper xhttp.ErrorMapper
+
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header,
+	// DefaultHSTSMaxAge if zero. A negative value disables HSTS, e.g. for
+	// services that are only reachable behind a TLS terminating proxy setting
+	// it.
+	HSTSMaxAge time.Duration
+
+	// CSP is the Content-Security-Policy of the responses. None is set if
+	// empty.
+	CSP secureheaders.Policy
+
+	// AllowedOrigins lists the origins allowed to issue cross-origin requests.
+	// They may be patterns, as accepted by cors.Handler.AllowOrigins.
+	AllowedOrigins []string
+	// AllowCredentials allows cross-origin requests to carry credentials.
+	AllowCredentials bool
+	// AllowedMethods and AllowedHeaders list the methods and headers that the
+	// preflighted cross-origin requests may use, besides the CORS-safelisted
+	// ones.
+	AllowedMethods []string
+	AllowedHeaders []string
+	// Preflight, if set, is the ServeMux on which the handler of the preflight
+	// requests is registered, for each of the PreflightPaths. Without it, only
+	// the simple cross-origin requests, which are not preflighted, can be
+	// issued.
+	Preflight      *xhttp.ServeMux
+	PreflightPaths []string
+
+	// MaxBodySize is the maximum size of the request bodies in bytes,
+	// DefaultMaxBodySize if zero. A negative value disables the limit.
+	MaxBodySize int
+
+	// CSRFSecret enables CSRF protection when not empty. It signs the
+	// anti-CSRF cookie named CSRFName (DefaultCSRFName if empty).
+	CSRFSecret string
+	CSRFName   string
+}
+
+// SecureDefaults returns the chain of request handlers configured by o.
+func SecureDefaults(o Options) xhttp.HandlerLinker {
+	ph := panic.Default(o.Log)
+	if o.ErrorMapper != nil {
+		ph = panic.NewHandler(panic.ToError(o.ErrorMapper))
+	}
+	chain := []xhttp.HandlerLinker{requestid.New(), ph}
+
+	switch {
+	case o.HSTSMaxAge == 0:
+		chain = append(chain, hsts.New(DefaultHSTSMaxAge))
+	case o.HSTSMaxAge > 0:
+		chain = append(chain, hsts.New(o.HSTSMaxAge))
+	}
+
+	var sh []func(secureheaders.Handler) secureheaders.Handler
+	if len(o.CSP.String("")) > 0 {
+		sh = append(sh, secureheaders.CSP(o.CSP))
+	}
+	chain = append(chain, secureheaders.New(sh...))
+
+	c := cors.NewHandler().AllowOrigins(o.AllowedOrigins...)
+	c.Parameters.AllowedMethods.Add(o.AllowedMethods...)
+	c.Parameters.AllowedHeaders.Add(o.AllowedHeaders...)
+	if o.AllowCredentials {
+		c = c.WithCredentials()
+	}
+	if o.Preflight != nil {
+		for _, path := range o.PreflightPaths {
+			c = c.EnablePreflight(o.Preflight, path)
+		}
+	}
+	chain = append(chain, c)
+
+	switch {
+	case o.MaxBodySize == 0:
+		chain = append(chain, maxreqsize.New(DefaultMaxBodySize))
+	case o.MaxBodySize > 0:
+		chain = append(chain, maxreqsize.New(o.MaxBodySize))
+	}
+
+	if o.CSRFSecret != "" {
+		name := o.CSRFName
+		if name == "" {
+			name = DefaultCSRFName
+		}
+		var opts []func(csrf.Handler) csrf.Handler
+		if o.ErrorMapper != nil {
+			opts = append(opts, csrf.WithErrorMapper(o.ErrorMapper))
+		}
+		chain = append(chain, csrf.NewHandler(name, o.CSRFSecret, opts...))
+	}
+	return xhttp.Chain(chain...)
+}
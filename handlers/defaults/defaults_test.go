@@ -0,0 +1,70 @@
+package defaults
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestSecureDefaults(t *testing.T) {
+	h := SecureDefaults(Options{MaxBodySize: 8}).Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200. Got %d", w.Code)
+	}
+	for _, name := range []string{"X-Request-Id", "Strict-Transport-Security", "X-Content-Type-Options", "X-Frame-Options"} {
+		if w.Header().Get(name) == "" {
+			t.Errorf("Expected the %s header to be set", name)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "https://example.com/panic", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("X-Frame-Options") == "" {
+		t.Fatalf("Expected the panic to be recovered with the security headers set. Got %d %v", w.Code, w.Header())
+	}
+
+	req = httptest.NewRequest("POST", "https://example.com/", strings.NewReader("0123456789"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected the request body to be limited. Got %d", w.Code)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	mux := xhttp.NewServeMux()
+	mux.USE(SecureDefaults(Options{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"PUT"},
+		AllowedHeaders: []string{"X-Token"},
+		Preflight:      &mux,
+		PreflightPaths: []string{"/items"},
+	}))
+	mux.PUT("/items", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("OPTIONS", "https://example.com/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	req.Header.Set("Access-Control-Request-Headers", "x-token")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "PUT" {
+		t.Fatalf("Expected the preflight to be accepted. Got %d %v", w.Code, w.Header())
+	}
+}
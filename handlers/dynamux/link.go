@@ -3,17 +3,15 @@ package dynamux
 
 import (
 	"context"
-	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
-	"net/http/httptest"
-	"net/http/httputil"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/proxy"
 )
 
 type contextKey struct{}
@@ -28,10 +26,13 @@ type contextKey struct{}
 type Link struct {
 	UID string
 
+	// Host restricts the link to the requests for a given host, e.g.
+	// "www.example.com", or for any subdomain of a domain when it starts with
+	// a wildcard label, e.g. "*.example.com". An empty Host matches any host.
+	Host        string
 	Path        string
 	Destination *url.URL
-	Proxy       *httputil.ReverseProxy `json:"-"`
-	Client      *http.Client           `json:"-"`
+	Proxy       http.Handler `json:"-"`
 	Active      bool
 	// Owner string // whom the link was created on behalf of
 	// RessourceID string
@@ -51,11 +52,47 @@ type Link struct {
 // such dynamically generated links.
 // maxage <0 means the link is expired
 // maxage = 0 means the link doesn not expire
-func NewLink(id string, path string, dest *url.URL, maxage time.Duration, proxy bool) Link {
-	if proxy {
-		return Link{id, path, dest, httputil.NewSingleHostReverseProxy(dest), &http.Client{}, true, time.Now().UTC(), maxage, nil, new(contextKey)}
+func NewLink(id string, path string, dest *url.URL, maxage time.Duration, forward bool) Link {
+	if forward {
+		// The destination is fetched as is, whatever the path of the link.
+		p := proxy.New([]string{dest.String()}, proxy.RewriteRequest(func(r *http.Request) {
+			r.URL.Path = ""
+			r.URL.RawPath = ""
+			r.URL.RawQuery = ""
+		}))
+		return Link{id, "", path, dest, p, true, time.Now().UTC(), maxage, nil, new(contextKey)}
 	}
-	return Link{id, path, dest, nil, nil, true, time.Now().UTC(), maxage, nil, new(contextKey)}
+	return Link{id, "", path, dest, nil, true, time.Now().UTC(), maxage, nil, new(contextKey)}
+}
+
+// ForHost scopes the link to a host pattern such as "www.example.com" or
+// "*.example.com". Links sharing a path may then lead to different
+// destinations depending on the requested host, e.g. per tenant subdomain.
+func (l Link) ForHost(pattern string) Link {
+	l.Host = strings.ToLower(pattern)
+	return l
+}
+
+// HostMatch describes how the host of a request matched the host pattern of
+// a Link.
+type HostMatch struct {
+	// Host is the requested host, without port.
+	Host string
+	// Pattern is the host pattern of the Link.
+	Pattern string
+	// Wildcard is the label matched by the wildcard of the pattern, e.g. the
+	// tenant "acme" for the host "acme.example.com" and the pattern
+	// "*.example.com".
+	Wildcard string
+}
+
+type hostKey struct{}
+
+// HostFromContext returns the host match of the Link serving the request. It
+// is available to the Link Handler.
+func HostFromContext(ctx context.Context) (HostMatch, bool) {
+	m, ok := ctx.Value(hostKey{}).(HostMatch)
+	return m, ok
 }
 
 // WithHandler provides the link with a middleware request handling function that
@@ -87,19 +124,7 @@ func (l Link) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	}
 
 	if l.Proxy != nil {
-		// l.Client should have been set
-		forwarder := httptest.NewServer(l.Proxy)
-		res, err := l.Client.Get(forwarder.URL)
-		if err != nil {
-			http.Error(w, "Could not fetch resource", http.StatusInternalServerError)
-			return
-		}
-		b, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			http.Error(w, "Could not read fetched response body", http.StatusInternalServerError)
-			return
-		}
-		w.Write(b)
+		l.Proxy.ServeHTTP(w, r)
 		return
 	}
 	http.Redirect(w, r, l.Destination.String(), http.StatusTemporaryRedirect)
@@ -117,6 +142,9 @@ destination url.
 */
 
 // Multiplexer is used to handle dynamically generated URLs.
+//
+// Links are indexed by host pattern followed by path. Links without host
+// pattern are indexed by path only.
 type Multiplexer struct {
 	mu *sync.RWMutex
 
@@ -136,19 +164,22 @@ func (m *Multiplexer) AddLink(links ...Link) {
 	defer m.mu.Unlock()
 
 	for _, lnk := range links {
-		m.Links[lnk.Path] = lnk
+		m.Links[lnk.Host+lnk.Path] = lnk
 	}
 }
 
-func pathExists(url *url.URL, m *Multiplexer) (bool, string) {
-	path := url.Path
+func pathExists(url *url.URL, m *Multiplexer, host string) (bool, string) {
+	path := host + url.Path
 	_, ok := m.Links[path]
 	if ok {
 		return ok, path
 	}
 
 	var longestpath string
-	for route := range m.Links {
+	for route, lnk := range m.Links {
+		if lnk.Host != host {
+			continue
+		}
 		if strings.HasSuffix(path, "/") {
 			if strings.HasPrefix(route, path) {
 				if len(route) > len(longestpath) {
@@ -161,14 +192,41 @@ func pathExists(url *url.URL, m *Multiplexer) (bool, string) {
 	return ok, longestpath
 }
 
+// hostPatterns returns the host patterns that may match a host, from the most
+// to the least specific.
+func hostPatterns(host string) []HostMatch {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	patterns := []HostMatch{{Host: host, Pattern: host}}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		patterns = append(patterns, HostMatch{Host: host, Pattern: "*" + host[i:], Wildcard: host[:i]})
+	}
+	return append(patterns, HostMatch{Host: host})
+}
+
 func (m *Multiplexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx:= r.Context()
-	ok, dao := pathExists(r.URL, m)
-	v, ok := m.Links[dao]
-	if !ok {
-		log.Print(dao, v)
+	ctx := r.Context()
+	m.mu.RLock()
+	var v Link
+	var match HostMatch
+	found := false
+	for _, hm := range hostPatterns(r.Host) {
+		if ok, dao := pathExists(r.URL, m, hm.Pattern); ok {
+			v, found = m.Links[dao]
+			match = hm
+			break
+		}
+	}
+	m.mu.RUnlock()
+	if !found {
 		http.NotFound(w, r)
 		return
 	}
+	if v.Host != "" {
+		ctx = context.WithValue(ctx, hostKey{}, match)
+		r = r.WithContext(ctx)
+	}
 	v.ServeHTTP(ctx, w, r)
 }
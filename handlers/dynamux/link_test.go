@@ -116,3 +116,46 @@ func TestLinkServerWithRedirect(t *testing.T) {
 		t.Errorf("Expected %v but got %v", test3+test2, test1)
 	}
 }
+
+func TestHostLinks(t *testing.T) {
+	dynamux := NewMultiplexer()
+	dest, _ := url.Parse("http://cdn.example.com/default")
+	acme, _ := url.Parse("http://cdn.example.com/acme")
+
+	var tenant string
+	dynamux.AddLink(
+		NewLink("default", "/report", dest, 0, false),
+		NewLink("tenant", "/report", acme, 0, false).ForHost("*.example.com").WithHandler(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m, ok := HostFromContext(r.Context())
+			if !ok {
+				t.Error("Expected the host match to be in the context")
+			}
+			tenant = m.Wildcard
+		})),
+	)
+
+	tcs := []struct {
+		host     string
+		location string
+		tenant   string
+	}{
+		{"acme.example.com:8080", "http://cdn.example.com/acme", "acme"},
+		{"example.org", "http://cdn.example.com/default", ""},
+		{"a.b.example.com", "http://cdn.example.com/default", ""},
+	}
+	for _, tc := range tcs {
+		tenant = ""
+		req, err := http.NewRequest("GET", "http://"+tc.host+"/report", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		dynamux.ServeHTTP(w, req)
+		if loc := w.Header().Get("Location"); loc != tc.location {
+			t.Errorf("%s: expected a redirection to %s. Got %q", tc.host, tc.location, loc)
+		}
+		if tenant != tc.tenant {
+			t.Errorf("%s: expected the tenant %q. Got %q", tc.host, tc.tenant, tenant)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Package experiment defines a request handler assigning sessions to the
+// variants of A/B test experiments.
+//
+// Assignments are deterministic (the same session always lands in the same
+// variant) and are persisted in the session, so that changing the weights of
+// an experiment does not move the sessions already enrolled.
+// They are exposed in the request context and in a response header, and each
+// new assignment is sent as an Exposure to the registered Trackers.
+package experiment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// DefaultHeader is the name of the response header listing the assignments.
+const DefaultHeader = "X-Experiments"
+
+// Variant is a branch of an experiment. Sessions are assigned to variants in
+// proportion to their weight.
+type Variant struct {
+	Name   string
+	Weight int
+}
+
+// Experiment is a named set of variants.
+type Experiment struct {
+	Name     string
+	Variants []Variant
+}
+
+// Exposure records the enrollment of a session in a variant.
+type Exposure struct {
+	Experiment string    `json:"experiment"`
+	Variant    string    `json:"variant"`
+	SessionID  string    `json:"sessionid"`
+	Path       string    `json:"path"`
+	Time       time.Time `json:"time"`
+}
+
+// Tracker is the interface implemented by analytics pipelines receiving the
+// exposures. The context passed to Track is the request context.
+type Tracker interface {
+	Track(ctx context.Context, e Exposure) error
+}
+
+// TrackerFunc allows the use of ordinary functions as Trackers.
+type TrackerFunc func(ctx context.Context, e Exposure) error
+
+// Track calls f(ctx, e).
+func (f TrackerFunc) Track(ctx context.Context, e Exposure) error {
+	return f(ctx, e)
+}
+
+type contextKey struct{}
+
+// VariantOf returns the variant a request was assigned to for an experiment, or
+// the empty string if the request is not enrolled (e.g. it has no session).
+func VariantOf(ctx context.Context, experiment string) string {
+	a, _ := ctx.Value(contextKey{}).(map[string]string)
+	return a[experiment]
+}
+
+// Assignments returns the variants a request was assigned to, by experiment.
+func Assignments(ctx context.Context) map[string]string {
+	a, _ := ctx.Value(contextKey{}).(map[string]string)
+	res := make(map[string]string, len(a))
+	for k, v := range a {
+		res[k] = v
+	}
+	return res
+}
+
+// Handler assigns the sessions to experiment variants.
+type Handler struct {
+	Session     session.Handler
+	Experiments []Experiment
+
+	header   string
+	trackers []Tracker
+	log      *log.Logger
+
+	next xhttp.Handler
+}
+
+// New returns a request handler enrolling the sessions in the experiments.
+// The session handler should be registered ahead.
+// It panics if an experiment has no variant or a variant has no weight.
+func New(s session.Handler, experiments []Experiment, options ...func(Handler) Handler) Handler {
+	for _, e := range experiments {
+		if e.Name == "" || len(e.Variants) == 0 {
+			panic("experiment: experiments must be named and have variants")
+		}
+		for _, v := range e.Variants {
+			if v.Weight <= 0 || v.Name == "" {
+				panic("experiment: variants of " + e.Name + " must be named and have a positive weight")
+			}
+		}
+	}
+	h := Handler{
+		Session:     s,
+		Experiments: experiments,
+		header:      DefaultHeader,
+		next:        nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// Header is a configuration option which sets the name of the response header
+// listing the assignments. An empty name disables the header.
+func Header(name string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.header = name
+		return h
+	}
+}
+
+// WithTracker is a configuration option which registers a Tracker receiving
+// the exposures.
+func WithTracker(t Tracker) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.trackers = append(h.trackers[:len(h.trackers):len(h.trackers)], t)
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// session and tracking failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.log = l
+		return h
+	}
+}
+
+// Assign returns the variant of an experiment a session id deterministically
+// maps to, regardless of any persisted assignment.
+func Assign(e Experiment, sessionID string) string {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	sum := sha256.Sum256([]byte(e.Name + ":" + sessionID))
+	n := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, v := range e.Variants {
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1].Name
+}
+
+func (e Experiment) has(variant string) bool {
+	for _, v := range e.Variants {
+		if v.Name == variant {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := h.Session.ID(r.Context())
+	if err == nil {
+		assignments := make(map[string]string, len(h.Experiments))
+		for _, e := range h.Experiments {
+			assignments[e.Name] = h.assign(r, e, id)
+		}
+		if h.header != "" {
+			names := make([]string, 0, len(assignments))
+			for name := range assignments {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			values := make([]string, len(names))
+			for i, name := range names {
+				values[i] = name + "=" + assignments[name]
+			}
+			w.Header().Set(h.header, strings.Join(values, ", "))
+			w.Header().Add("Vary", "Cookie")
+		}
+		r = r.WithContext(context.WithValue(ctx, contextKey{}, assignments))
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// assign returns the persisted variant of a session, or assigns one.
+func (h Handler) assign(r *http.Request, e Experiment, id string) string {
+	ctx := r.Context()
+	key := "experiment/" + e.Name
+	if b, err := h.Session.Get(ctx, key); err == nil && e.has(string(b)) {
+		return string(b)
+	}
+	v := Assign(e, id)
+	if err := h.Session.Put(ctx, key, []byte(v), 0); err != nil && h.log != nil {
+		h.log.Print(err)
+	}
+	exp := Exposure{e.Name, v, id, r.URL.Path, time.Now().UTC()}
+	for _, t := range h.trackers {
+		if err := t.Track(ctx, exp); err != nil && h.log != nil {
+			h.log.Print(err)
+		}
+	}
+	return v
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
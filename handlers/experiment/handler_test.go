@@ -0,0 +1,34 @@
+package experiment
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAssign(t *testing.T) {
+	e := Experiment{"checkout", []Variant{{"control", 3}, {"onepage", 1}}}
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		id := "session" + strconv.Itoa(i)
+		v := Assign(e, id)
+		if Assign(e, id) != v {
+			t.Fatal("Expected the assignment to be deterministic.")
+		}
+		counts[v]++
+	}
+	if counts["control"] < 2800 || counts["control"] > 3200 || counts["onepage"] < 800 || counts["onepage"] > 1200 {
+		t.Fatalf("Expected the assignments to follow the weights. Got %v", counts)
+	}
+
+	other := Experiment{"pricing", []Variant{{"a", 1}, {"b", 1}}}
+	same := 0
+	for i := 0; i < 1000; i++ {
+		id := "session" + strconv.Itoa(i)
+		if (Assign(e, id) == "control") == (Assign(other, id) == "a") {
+			same++
+		}
+	}
+	if same < 300 || same > 700 {
+		t.Fatalf("Expected the experiments to be bucketed independently. Got %d/1000 correlated", same)
+	}
+}
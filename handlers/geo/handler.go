@@ -0,0 +1,229 @@
+// Package geo defines a request handler which blocks or routes requests
+// according to the country of the client, as resolved from its IP address by
+// a pluggable Resolver.
+//
+// The resolved country is stored in the request context, where it can be
+// retrieved with Country, e.g. for analytics. A MaxMind database Resolver is
+// provided by the maxmind subpackage.
+package geo
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/ipfilter"
+)
+
+// Unknown is the country code stored in the context when the country of a
+// client cannot be resolved.
+const Unknown = "XX"
+
+// ErrNotFound is returned by a Resolver when an IP address is not found.
+var ErrNotFound = errors.New("geo: address not found")
+
+// Resolver resolves the country of an IP address as an ISO 3166-1 alpha-2
+// code, e.g. "FR".
+type Resolver interface {
+	Country(ctx context.Context, ip net.IP) (string, error)
+}
+
+// ResolverFunc is a function implementing the Resolver interface.
+type ResolverFunc func(ctx context.Context, ip net.IP) (string, error)
+
+// Country implements the Resolver interface.
+func (f ResolverFunc) Country(ctx context.Context, ip net.IP) (string, error) {
+	return f(ctx, ip)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context holding the country of the client.
+func NewContext(ctx context.Context, country string) context.Context {
+	return context.WithValue(ctx, contextKey{}, country)
+}
+
+// Country returns the country resolved for a request, Unknown if it could not
+// be resolved, or the empty string if the request was not handled by a
+// Handler.
+func Country(ctx context.Context) string {
+	c, _ := ctx.Value(contextKey{}).(string)
+	return c
+}
+
+// Handler is the geo-restriction request handler.
+type Handler struct {
+	Resolver Resolver
+
+	allow        map[string]bool
+	deny         map[string]bool
+	blockUnknown bool
+	exempt       *ipfilter.List
+	routes       map[string]xhttp.Handler
+	status       int
+	clientIP     func(r *http.Request) net.IP
+	log          *log.Logger
+
+	next xhttp.Handler
+}
+
+// New returns a geo-restriction request handler. Without options, it only
+// resolves the country of the clients and stores it in the request context.
+func New(r Resolver, options ...func(Handler) Handler) Handler {
+	if r == nil {
+		panic("geo: nil Resolver")
+	}
+	h := Handler{
+		Resolver: r,
+		status:   http.StatusForbidden,
+		clientIP: ipfilter.RemoteIP,
+		next:     nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	if len(h.allow) > 0 && len(h.deny) > 0 {
+		panic("geo: allow and deny lists are mutually exclusive")
+	}
+	return h
+}
+
+func countries(codes []string) map[string]bool {
+	m := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		m[strings.ToUpper(c)] = true
+	}
+	return m
+}
+
+// Allow is a configuration option which only lets through the clients from
+// the given countries.
+func Allow(codes ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.allow = countries(codes)
+		return h
+	}
+}
+
+// Deny is a configuration option which blocks the clients from the given
+// countries.
+func Deny(codes ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.deny = countries(codes)
+		return h
+	}
+}
+
+// BlockUnknown is a configuration option which blocks the clients whose
+// country cannot be resolved. By default, they are only blocked by an
+// allowlist.
+func BlockUnknown() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.blockUnknown = true
+		return h
+	}
+}
+
+// Exempt is a configuration option which lets the clients whose IP address
+// belongs to the list through, whatever their country.
+func Exempt(l *ipfilter.List) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.exempt = l
+		return h
+	}
+}
+
+// Route is a configuration option which sends the requests of the clients
+// from a country to a specific handler, e.g. a localized storefront, instead
+// of the next one.
+func Route(code string, rh xhttp.Handler) func(Handler) Handler {
+	return func(h Handler) Handler {
+		routes := make(map[string]xhttp.Handler, len(h.routes)+1)
+		for k, v := range h.routes {
+			routes[k] = v
+		}
+		routes[strings.ToUpper(code)] = rh
+		h.routes = routes
+		return h
+	}
+}
+
+// StatusCode is a configuration option which sets the status of the responses
+// to blocked clients, e.g. 451 Unavailable For Legal Reasons. It defaults to
+// 403 Forbidden.
+func StatusCode(code int) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.status = code
+		return h
+	}
+}
+
+// WithClientIP is a configuration option which changes the function used to
+// determine the client IP address, for instance when the server is behind a
+// trusted reverse proxy.
+func WithClientIP(fn func(r *http.Request) net.IP) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.clientIP = fn
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// resolution failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.log = l
+		return h
+	}
+}
+
+// Blocked reports whether the clients from a country are blocked.
+func (h Handler) Blocked(country string) bool {
+	if country == Unknown {
+		return h.blockUnknown || len(h.allow) > 0
+	}
+	if len(h.allow) > 0 {
+		return !h.allow[country]
+	}
+	return h.deny[country]
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip := h.clientIP(r)
+	country := Unknown
+	if ip != nil {
+		c, err := h.Resolver.Country(r.Context(), ip)
+		switch {
+		case err == nil && c != "":
+			country = strings.ToUpper(c)
+		case err != nil && !errors.Is(err, ErrNotFound) && h.log != nil:
+			h.log.Print(err)
+		}
+	}
+	r = r.WithContext(NewContext(r.Context(), country))
+
+	if h.Blocked(country) && !(h.exempt != nil && h.exempt.Contains(ip)) {
+		http.Error(w, http.StatusText(h.status), h.status)
+		return
+	}
+	if rh, ok := h.routes[country]; ok {
+		rh.ServeHTTP(w, r)
+		return
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
@@ -0,0 +1,73 @@
+package geo
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/ipfilter"
+)
+
+var resolver = ResolverFunc(func(ctx context.Context, ip net.IP) (string, error) {
+	switch ip.String() {
+	case "192.0.2.1":
+		return "fr", nil
+	case "192.0.2.2":
+		return "KP", nil
+	case "198.51.100.7":
+		return "KP", nil
+	}
+	return "", ErrNotFound
+})
+
+func TestHandler(t *testing.T) {
+	var country string
+	mux := xhttp.NewServeMux()
+	mux.USE(New(resolver,
+		Deny("KP"),
+		Exempt(ipfilter.MustList("198.51.100.0/24")),
+		Route("FR", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			country = Country(r.Context())
+			w.Write([]byte("bonjour"))
+		})),
+	))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country = Country(r.Context())
+		w.Write([]byte("hello"))
+	}))
+
+	tcs := []struct {
+		ip      string
+		status  int
+		body    string
+		country string
+	}{
+		{"192.0.2.1", 200, "bonjour", "FR"},
+		{"192.0.2.2", 403, "", ""},
+		{"198.51.100.7", 200, "hello", "KP"},
+		{"203.0.113.9", 200, "hello", Unknown},
+	}
+	for _, tc := range tcs {
+		country = ""
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = tc.ip + ":1234"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tc.status || (tc.body != "" && w.Body.String() != tc.body) || country != tc.country {
+			t.Errorf("%s: unexpected response %d %q for country %q", tc.ip, w.Code, w.Body.String(), country)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	h := New(resolver, Allow("FR"))
+	if h.Blocked("FR") || !h.Blocked("KP") || !h.Blocked(Unknown) {
+		t.Fatal("Expected only the allowlisted countries to be let through.")
+	}
+}
@@ -0,0 +1,86 @@
+// Package maxmind provides a geo.Resolver backed by a MaxMind GeoIP2 or
+// GeoLite2 country (or city) database.
+package maxmind
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/atdiar/xhttp/handlers/geo"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Resolver resolves countries from a MaxMind database. The database may be
+// swapped at runtime with Reload, e.g. after a weekly update.
+type Resolver struct {
+	mu sync.RWMutex
+	db *maxminddb.Reader
+}
+
+// Open returns a Resolver using the database file at path.
+func Open(path string) (*Resolver, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{db: db}, nil
+}
+
+// FromBytes returns a Resolver using a database loaded in memory.
+func FromBytes(b []byte) (*Resolver, error) {
+	db, err := maxminddb.FromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{db: db}, nil
+}
+
+// Reload replaces the database with the file at path. The previous database
+// is kept if the new one cannot be opened.
+func (r *Resolver) Reload(path string) error {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	old := r.db
+	r.db = db
+	r.mu.Unlock()
+	return old.Close()
+}
+
+// Close releases the database.
+func (r *Resolver) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.db.Close()
+}
+
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Country implements the geo.Resolver interface. The country where the
+// network is registered is used when the location of the address is unknown.
+func (r *Resolver) Country(ctx context.Context, ip net.IP) (string, error) {
+	var rec record
+	r.mu.RLock()
+	err := r.db.Lookup(ip, &rec)
+	r.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	if rec.Country.ISOCode != "" {
+		return rec.Country.ISOCode, nil
+	}
+	if rec.RegisteredCountry.ISOCode != "" {
+		return rec.RegisteredCountry.ISOCode, nil
+	}
+	return "", geo.ErrNotFound
+}
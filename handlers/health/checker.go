@@ -0,0 +1,87 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// Checker is the interface implemented by the dependencies of a service whose
+// availability is required to serve requests.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc allows the use of an ordinary function as a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// probeID is the session id under which probe values are written.
+const probeID = "xhttp-health"
+
+// StoreChecker returns a Checker which writes a short-lived probe value into a
+// session Store and reads it back.
+func StoreChecker(s session.Store) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return probe(ctx, s.Put, s.Get)
+	})
+}
+
+// CacheChecker returns a Checker which writes a short-lived probe value into a
+// session Cache and reads it back.
+func CacheChecker(c session.Cache) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return probe(ctx, c.Put, c.Get)
+	})
+}
+
+// SessionChecker returns a Checker of the Store and Cache of a session
+// handler. Those implementing session.Pinger are pinged, a probe value is
+// written into the others.
+func SessionChecker(h session.Handler) Checker {
+	return CheckerFunc(h.Ping)
+}
+
+func probe(ctx context.Context,
+	put func(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error,
+	get func(ctx context.Context, id string, hkey string) ([]byte, error)) error {
+	v := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	hkey := "probe/" + string(v)
+	if err := put(ctx, probeID, hkey, v, time.Minute); err != nil {
+		return err
+	}
+	res, err := get(ctx, probeID, hkey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(res, v) {
+		return errors.New("health: probe value mismatch")
+	}
+	return nil
+}
+
+// DirChecker returns a Checker verifying that files can be created in a
+// directory, e.g. the storage directory of the disk upload backend.
+func DirChecker(dir string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		err = f.Close()
+		if rerr := os.Remove(name); err == nil {
+			err = rerr
+		}
+		return err
+	})
+}
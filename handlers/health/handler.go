@@ -0,0 +1,186 @@
+// Package health defines liveness and readiness request handlers, suitable
+// for load balancer health checks and Kubernetes probes.
+//
+// The liveness handler only reports that the process is able to service
+// requests. The readiness handler evaluates the registered Checkers
+// concurrently, each within its own timeout, and reports their aggregated
+// status as JSON, with a 503 Service Unavailable status if any of them fails.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+// DefaultTimeout is the time allotted to a check when none is specified.
+const DefaultTimeout = 5 * time.Second
+
+// Status values.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+type check struct {
+	name    string
+	checker Checker
+	timeout time.Duration
+}
+
+// Health holds the registered Checkers. It is safe for concurrent use.
+type Health struct {
+	mu       sync.RWMutex
+	checks   []check
+	draining bool
+}
+
+// New returns an object holding the health checks of a service.
+func New() *Health {
+	return &Health{}
+}
+
+// Register adds a named Checker evaluated by the readiness handler. A
+// non-positive timeout is replaced by DefaultTimeout. Registering a Checker
+// under an existing name replaces it.
+func (h *Health) Register(name string, c Checker, timeout time.Duration) *Health {
+	if c == nil {
+		panic("health: nil Checker for " + name)
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, ch := range h.checks {
+		if ch.name == name {
+			h.checks[i] = check{name, c, timeout}
+			return h
+		}
+	}
+	h.checks = append(h.checks, check{name, c, timeout})
+	return h
+}
+
+// Drain makes the readiness handler fail, regardless of the checks, so that
+// load balancers stop routing traffic to the server before it shuts down.
+func (h *Health) Drain() {
+	h.mu.Lock()
+	h.draining = true
+	h.mu.Unlock()
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Report is the aggregated outcome of the checks.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Run evaluates every registered check concurrently.
+func (h *Health) Run(ctx context.Context) Report {
+	h.mu.RLock()
+	checks := append([]check(nil), h.checks...)
+	draining := h.draining
+	h.mu.RUnlock()
+
+	rep := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	if draining {
+		rep.Status = StatusFail
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c check) {
+			defer wg.Done()
+			res := run(ctx, c)
+			mu.Lock()
+			rep.Checks[c.name] = res
+			if res.Status != StatusOK {
+				rep.Status = StatusFail
+			}
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	return rep
+}
+
+func run(ctx context.Context, c check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- c.checker.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	res := Result{Status: StatusOK, Duration: time.Since(start)}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// Names returns the names of the registered checks, sorted.
+func (h *Health) Names() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := make([]string, 0, len(h.checks))
+	for _, c := range h.checks {
+		res = append(res, c.name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Liveness returns a request handler which always responds with a 200 OK
+// status as long as the server is able to service requests.
+func (h *Health) Liveness() xhttp.Handler {
+	return xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// Readiness returns a request handler which runs the registered checks and
+// responds with 200 OK if they all succeed, 503 Service Unavailable otherwise.
+func (h *Health) Readiness() xhttp.Handler {
+	return xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := h.Run(r.Context())
+		code := http.StatusOK
+		if rep.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		write(w, code, rep)
+	})
+}
+
+func write(w http.ResponseWriter, code int, rep Report) {
+	b, err := json.Marshal(rep)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(b)
+}
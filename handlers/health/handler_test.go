@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+func serve(t *testing.T, mux xhttp.ServeMux, path string) (int, Report) {
+	req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var rep Report
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, rep
+}
+
+func TestReadiness(t *testing.T) {
+	var failing error
+	h := New().
+		Register("db", CheckerFunc(func(ctx context.Context) error { return failing }), time.Second).
+		Register("slow", CheckerFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}), 10*time.Millisecond).
+		Register("dir", DirChecker(t.TempDir()), 0)
+
+	mux := xhttp.NewServeMux()
+	mux.GET("/livez", h.Liveness())
+	mux.GET("/readyz", h.Readiness())
+
+	if code, rep := serve(t, mux, "/livez"); code != http.StatusOK || rep.Status != StatusOK {
+		t.Fatalf("Unexpected liveness %d %+v", code, rep)
+	}
+
+	code, rep := serve(t, mux, "/readyz")
+	if code != http.StatusServiceUnavailable || rep.Checks["slow"].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("Expected the slow check to time out. Got %d %+v", code, rep)
+	}
+	if rep.Checks["db"].Status != StatusOK || rep.Checks["dir"].Status != StatusOK {
+		t.Fatalf("Unexpected report %+v", rep)
+	}
+
+	h.Register("slow", CheckerFunc(func(ctx context.Context) error { return nil }), 0)
+	if code, rep := serve(t, mux, "/readyz"); code != http.StatusOK {
+		t.Fatalf("Expected the service to be ready. Got %d %+v", code, rep)
+	}
+
+	failing = errors.New("connection refused")
+	if code, rep := serve(t, mux, "/readyz"); code != http.StatusServiceUnavailable || rep.Checks["db"].Error != "connection refused" {
+		t.Fatalf("Expected the db check to fail. Got %d %+v", code, rep)
+	}
+
+	failing = nil
+	h.Drain()
+	if code, _ := serve(t, mux, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("A draining service should not be ready. Got %d", code)
+	}
+}
@@ -4,31 +4,102 @@ package hsts
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/atdiar/xhttp"
 )
 
+// MinPreloadMaxAge is the minimum max-age value required by the browser HSTS
+// preload lists (see https://hstspreload.org).
+const MinPreloadMaxAge = 365 * 24 * time.Hour
+
 // Handler is an object that enforces the use of Strict Transport Security.
 type Handler struct {
-	m                 int
+	maxage            time.Duration
 	includeSubDomains bool
+	preload           bool
+	skipInsecure      bool
+	value             string
 	next              xhttp.Handler
 }
 
 // New is a handler that enforces the use of Strict Transport Security.
-func New(maxage int, withsubdomains bool) Handler {
-	return Handler{
-		m:                 maxage,
-		includeSubDomains: withsubdomains,
-		next:              nil,
+// maxage is truncated to the second.
+//
+// It panics if the handler is configured for preloading while the preload
+// list requirements are not met, i.e. when max-age is below MinPreloadMaxAge
+// or subdomains are not included.
+func New(maxage time.Duration, options ...func(Handler) Handler) Handler {
+	h := Handler{
+		maxage: maxage,
+		next:   nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	if h.maxage < 0 {
+		panic("hsts: max-age cannot be negative")
+	}
+	if h.preload {
+		if h.maxage < MinPreloadMaxAge {
+			panic("hsts: preload requires a max-age of at least " + strconv.Itoa(int(MinPreloadMaxAge.Seconds())) + " seconds")
+		}
+		if !h.includeSubDomains {
+			panic("hsts: preload requires the includeSubDomains directive")
+		}
+	}
+	h.value = h.headerValue()
+	return h
+}
+
+// IncludeSubDomains is a configuration option which extends the policy to
+// every subdomain of the host.
+func IncludeSubDomains() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.includeSubDomains = true
+		return h
+	}
+}
+
+// Preload is a configuration option which adds the preload directive,
+// signaling consent for inclusion in the browser preload lists.
+func Preload() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.preload = true
+		return h
+	}
+}
+
+// SkipInsecure is a configuration option which prevents the header from being
+// sent in response to requests that were not received over TLS. User-agents
+// ignore the header on such responses anyway.
+func SkipInsecure() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.skipInsecure = true
+		return h
 	}
 }
 
-func (h Handler) ServeHTTP( w http.ResponseWriter, r *http.Request) {
+func (h Handler) headerValue() string {
+	v := "max-age=" + strconv.FormatInt(int64(h.maxage/time.Second), 10)
 	if h.includeSubDomains {
-		w.Header().Add("Strict-Transport-Security", "max-age="+strconv.Itoa(h.m)+"; includeSubDomains")
-	} else {
-		w.Header().Add("Strict-Transport-Security", "max-age="+strconv.Itoa(h.m))
+		v = v + "; includeSubDomains"
+	}
+	if h.preload {
+		v = v + "; preload"
+	}
+	return v
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.skipInsecure || r.TLS != nil {
+		v := h.value
+		if v == "" {
+			v = h.headerValue()
+		}
+		w.Header().Set("Strict-Transport-Security", v)
 	}
 	if h.next != nil {
 		h.next.ServeHTTP(w, r)
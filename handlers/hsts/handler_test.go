@@ -0,0 +1,55 @@
+package hsts
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+	h := New(MinPreloadMaxAge, IncludeSubDomains(), Preload())
+
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	want := "max-age=31536000; includeSubDomains; preload"
+	if got := w.Header().Get("Strict-Transport-Security"); got != want {
+		t.Fatalf("Expected: %v but got: %v \n", want, got)
+	}
+}
+
+func TestSkipInsecure(t *testing.T) {
+	h := New(time.Hour, SkipInsecure())
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Fatalf("Did not expect the header to be set on a plaintext request. Got %v", got)
+	}
+
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=3600" {
+		t.Fatalf("Expected: %v but got: %v \n", "max-age=3600", got)
+	}
+}
+
+func TestPreloadValidation(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic since max-age is too short for preloading.")
+		}
+	}()
+	New(time.Hour, IncludeSubDomains(), Preload())
+}
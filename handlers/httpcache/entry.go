@@ -0,0 +1,223 @@
+package httpcache
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+// entry is the cached representation of a response.
+type entry struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"`
+	Stale   time.Time   `json:"stale"` // end of the stale-while-revalidate period
+}
+
+// cacheControl holds the Cache-Control directives relevant to a shared cache.
+type cacheControl struct {
+	noStore              bool
+	noCache              bool
+	private              bool
+	maxAge               time.Duration
+	hasMaxAge            bool
+	staleWhileRevalidate time.Duration
+	hasSWR               bool
+}
+
+func parseCacheControl(h http.Header) cacheControl {
+	var cc cacheControl
+	var sharedMaxAge bool
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			name, value, _ := strings.Cut(d, "=")
+			value = strings.Trim(value, `"`)
+			switch name {
+			case "no-store":
+				cc.noStore = true
+			case "no-cache":
+				cc.noCache = true
+			case "private":
+				cc.private = true
+			case "max-age", "s-maxage":
+				if sharedMaxAge && name == "max-age" {
+					continue // s-maxage takes precedence for shared caches
+				}
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					cc.maxAge = time.Duration(n) * time.Second
+					cc.hasMaxAge = true
+					sharedMaxAge = name == "s-maxage"
+				}
+			case "stale-while-revalidate":
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					cc.staleWhileRevalidate = time.Duration(n) * time.Second
+					cc.hasSWR = true
+				}
+			}
+		}
+	}
+	return cc
+}
+
+// cacheableStatus lists the status codes of responses that are stored.
+func cacheableStatus(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusNotFound,
+		http.StatusGone, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// index keeps track of the cache keys stored for every path, so that entries
+// can be invalidated by path or path prefix, as well as of the ongoing
+// background revalidations.
+type index struct {
+	mu           sync.Mutex
+	keys         map[string]map[string]struct{}
+	revalidating map[string]struct{}
+}
+
+func newIndex() *index {
+	return &index{
+		keys:         make(map[string]map[string]struct{}),
+		revalidating: make(map[string]struct{}),
+	}
+}
+
+func (i *index) add(path, key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s, ok := i.keys[path]
+	if !ok {
+		s = make(map[string]struct{})
+		i.keys[path] = s
+	}
+	s[key] = struct{}{}
+}
+
+// remove unregisters and returns the keys of the paths matching the predicate.
+func (i *index) remove(match func(path string) bool) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var res []string
+	for p, s := range i.keys {
+		if !match(p) {
+			continue
+		}
+		for k := range s {
+			res = append(res, k)
+		}
+		delete(i.keys, p)
+	}
+	return res
+}
+
+// startRevalidation returns false if the key is already being revalidated.
+func (i *index) startRevalidation(key string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.revalidating[key]; ok {
+		return false
+	}
+	i.revalidating[key] = struct{}{}
+	return true
+}
+
+func (i *index) endRevalidation(key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.revalidating, key)
+}
+
+// recorder captures a response while it is being written to the client. It
+// may also be used without an underlying writer for background revalidations.
+type recorder struct {
+	w        http.ResponseWriter
+	header   http.Header
+	status   int
+	snapshot http.Header
+	body     []byte
+	limit    int64
+	overflow bool
+}
+
+func newRecorder(w http.ResponseWriter, limit int64) *recorder {
+	rec := &recorder{w: w, limit: limit}
+	if w == nil {
+		rec.header = make(http.Header)
+	}
+	return rec
+}
+
+func (rec *recorder) Header() http.Header {
+	if rec.w != nil {
+		return rec.w.Header()
+	}
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = code
+	rec.snapshot = rec.Header().Clone()
+	if rec.w != nil {
+		rec.w.WriteHeader(code)
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if rec.limit > 0 && int64(len(rec.body)+len(b)) > rec.limit {
+			rec.overflow = true
+			rec.body = nil
+		} else {
+			rec.body = append(rec.body, b...)
+		}
+	}
+	if rec.w != nil {
+		return rec.w.Write(b)
+	}
+	return len(b), nil
+}
+
+func (rec *recorder) FlushError() error {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.w == nil {
+		return http.ErrNotSupported
+	}
+	return xhttp.Flush(rec.w)
+}
+
+func (rec *recorder) Flush() { rec.FlushError() }
+
+// Hijack prevents the response from being cached.
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rec.overflow = true
+	rec.body = nil
+	if rec.w == nil {
+		return nil, nil, http.ErrNotSupported
+	}
+	return xhttp.Hijack(rec.w)
+}
+
+func (rec *recorder) Wrappee() http.ResponseWriter { return rec.w }
+
+func (rec *recorder) Unwrap() http.ResponseWriter { return rec.w }
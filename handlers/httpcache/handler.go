@@ -0,0 +1,311 @@
+// Package httpcache defines a request handler which caches the responses to
+// GET and HEAD requests, using a session.Cache as storage.
+//
+// Responses are cached according to the Cache-Control header set by the
+// downstream handlers: responses marked no-store or private are never stored
+// and the max-age (or s-maxage) and stale-while-revalidate directives
+// override the default durations. Responses setting cookies and requests
+// bearing credentials are never cached.
+//
+// During the stale-while-revalidate period, a stale response is served while
+// the cached entry is refreshed in the background.
+package httpcache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// Handler is the response caching request handler.
+type Handler struct {
+	cache     session.Cache
+	namespace string
+
+	ttl                  time.Duration
+	staleWhileRevalidate time.Duration
+	maxSize              int64
+	vary                 []string
+	index                *index
+	Log                  *log.Logger
+
+	next xhttp.Handler
+}
+
+// New returns a response caching request handler which stores entries in the
+// cache under the namespace id.
+// By default, responses without an explicit max-age are not stored and
+// responses larger than 1MB are not stored.
+func New(c session.Cache, namespace string, options ...func(Handler) Handler) Handler {
+	if c == nil {
+		panic("httpcache: nil cache")
+	}
+	h := Handler{
+		cache:     c,
+		namespace: namespace,
+		maxSize:   1 << 20,
+		index:     newIndex(),
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// TTL is a configuration option which sets the time during which responses
+// without a max-age directive are considered fresh.
+func TTL(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.ttl = d
+		return h
+	}
+}
+
+// StaleWhileRevalidate is a configuration option which sets the duration
+// during which a stale response may still be served while it is refreshed,
+// when the response does not specify it.
+func StaleWhileRevalidate(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.staleWhileRevalidate = d
+		return h
+	}
+}
+
+// MaxSize is a configuration option which sets the maximum size in bytes of a
+// cached response body. A non-positive value removes the limit.
+func MaxSize(n int64) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.maxSize = n
+		return h
+	}
+}
+
+// VaryBy is a configuration option which adds the values of the named request
+// headers to the cache key, e.g. Accept-Encoding or Accept-Language.
+func VaryBy(headers ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		for _, hdr := range headers {
+			h.vary = append(h.vary, http.CanonicalHeaderKey(hdr))
+		}
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// cache failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Log = l
+		return h
+	}
+}
+
+// Key returns the cache key of a request.
+func (h Handler) Key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(http.MethodGet)
+	b.WriteString(" ")
+	b.WriteString(r.URL.RequestURI())
+	for _, hdr := range h.vary {
+		b.WriteString("\n" + hdr + ": " + strings.Join(r.Header.Values(hdr), ","))
+	}
+	return b.String()
+}
+
+// Invalidate removes the cached responses for the given path, whatever their
+// query string and varying headers.
+//
+// N.B. Only the entries stored by this Handler (or a copy of it) are known:
+// when the cache is shared by several servers, invalidation must be performed
+// on each of them.
+func (h Handler) Invalidate(ctx context.Context, path string) error {
+	return h.delete(ctx, h.index.remove(func(p string) bool { return p == path }))
+}
+
+// InvalidatePrefix removes the cached responses for every path starting with
+// the prefix.
+func (h Handler) InvalidatePrefix(ctx context.Context, prefix string) error {
+	return h.delete(ctx, h.index.remove(func(p string) bool { return strings.HasPrefix(p, prefix) }))
+}
+
+func (h Handler) delete(ctx context.Context, keys []string) error {
+	var err error
+	for _, k := range keys {
+		if e := h.cache.Delete(ctx, h.namespace, k); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.next == nil {
+		return
+	}
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Authorization") != "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	key := h.Key(r)
+	reqcc := parseCacheControl(r.Header)
+
+	if !reqcc.noStore && !reqcc.noCache {
+		if e, ok := h.lookup(r.Context(), key); ok {
+			now := time.Now()
+			if now.Before(e.Expires) {
+				h.write(w, r, e, "HIT")
+				return
+			}
+			if now.Before(e.Stale) {
+				h.write(w, r, e, "STALE")
+				if h.index.startRevalidation(key) {
+					go h.revalidate(r, key)
+				}
+				return
+			}
+		}
+	}
+
+	if reqcc.noStore || r.Method == http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	rec := newRecorder(w, h.maxSize)
+	w.Header().Set("X-Cache", "MISS")
+	h.next.ServeHTTP(rec, r)
+	h.store(r.Context(), r, key, rec)
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+func (h Handler) lookup(ctx context.Context, key string) (entry, bool) {
+	var e entry
+	b, err := h.cache.Get(ctx, h.namespace, key)
+	if err != nil {
+		return e, false
+	}
+	if err = json.Unmarshal(b, &e); err != nil {
+		h.log(err)
+		return e, false
+	}
+	return e, true
+}
+
+func (h Handler) write(w http.ResponseWriter, r *http.Request, e entry, status string) {
+	hdr := w.Header()
+	for k, v := range e.Header {
+		hdr[k] = v
+	}
+	hdr.Set("Age", strconv.Itoa(int(time.Since(e.Stored)/time.Second)))
+	hdr.Set("X-Cache", status)
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+}
+
+// revalidate refreshes a cache entry by servicing a copy of the request
+// detached from the client.
+func (h Handler) revalidate(r *http.Request, key string) {
+	defer h.index.endRevalidation(key)
+	ctx := context.Background()
+	req := r.Clone(ctx)
+	req.Method = http.MethodGet
+	rec := newRecorder(nil, h.maxSize)
+	h.next.ServeHTTP(rec, req)
+	h.store(ctx, req, key, rec)
+}
+
+func (h Handler) store(ctx context.Context, r *http.Request, key string, rec *recorder) {
+	if rec.overflow || !cacheableStatus(rec.status) {
+		return
+	}
+	hdr := rec.snapshot
+	if hdr == nil || hdr.Get("Set-Cookie") != "" || !h.keyedBy(hdr) {
+		return
+	}
+	cc := parseCacheControl(hdr)
+	if cc.noStore || cc.private || cc.noCache {
+		return
+	}
+	ttl, swr := h.ttl, h.staleWhileRevalidate
+	if cc.hasMaxAge {
+		ttl = cc.maxAge
+	}
+	if cc.hasSWR {
+		swr = cc.staleWhileRevalidate
+	}
+	if ttl <= 0 && swr <= 0 {
+		return
+	}
+
+	hdr = hdr.Clone()
+	hdr.Del("X-Cache")
+	now := time.Now()
+	e := entry{
+		Status:  rec.status,
+		Header:  hdr,
+		Body:    rec.body,
+		Stored:  now,
+		Expires: now.Add(ttl),
+		Stale:   now.Add(ttl + swr),
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		h.log(err)
+		return
+	}
+	if err = h.cache.Put(ctx, h.namespace, key, b, ttl+swr); err != nil {
+		h.log(err)
+		return
+	}
+	h.index.add(r.URL.Path, key)
+}
+
+// keyedBy reports whether every request header listed by the Vary header of a
+// response is part of the cache key, so that the response is only served to
+// the requests it was produced for. Vary: * is never keyed.
+func (h Handler) keyedBy(hdr http.Header) bool {
+	for _, v := range hdr.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if name == "*" || !h.varies(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// varies reports whether a request header is part of the cache key.
+func (h Handler) varies(name string) bool {
+	for _, hdr := range h.vary {
+		if hdr == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Handler) log(err error) {
+	if h.Log != nil {
+		h.Log.Print(err)
+	}
+}
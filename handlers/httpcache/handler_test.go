@@ -0,0 +1,167 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+type mapCache struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func newMapCache() *mapCache { return &mapCache{m: make(map[string][]byte)} }
+
+func (c *mapCache) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[id+"/"+hkey]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return v, nil
+}
+
+func (c *mapCache) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[id+"/"+hkey] = content
+	return nil
+}
+
+func (c *mapCache) Delete(ctx context.Context, id string, hkey string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, id+"/"+hkey)
+	return nil
+}
+
+func (c *mapCache) Clear() error                     { return nil }
+func (c *mapCache) ClearAfter(t time.Duration) error { return nil }
+
+func get(t *testing.T, h http.Handler, url string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCache(t *testing.T) {
+	var calls int
+	c := New(newMapCache(), "httpcache")
+	mux := xhttp.NewServeMux()
+	mux.USE(c)
+	mux.GET("/articles/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/articles/private" {
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		w.Write([]byte(strconv.Itoa(calls)))
+	}))
+
+	if w := get(t, mux, "http://example.com/articles/1"); w.Body.String() != "1" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("Unexpected first response %q %v", w.Body.String(), w.Header())
+	}
+	if w := get(t, mux, "http://example.com/articles/1"); w.Body.String() != "1" || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("Expected a cached response but got %q %v", w.Body.String(), w.Header())
+	}
+	if w := get(t, mux, "http://example.com/articles/1?page=2"); w.Body.String() != "2" {
+		t.Fatalf("The query string should be part of the key. Got %q", w.Body.String())
+	}
+	get(t, mux, "http://example.com/articles/private")
+	if w := get(t, mux, "http://example.com/articles/private"); w.Body.String() != "4" {
+		t.Fatalf("Private responses should not be cached. Got %q", w.Body.String())
+	}
+
+	if err := c.InvalidatePrefix(context.Background(), "/articles/"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(t, mux, "http://example.com/articles/1"); w.Body.String() != "5" {
+		t.Fatalf("Expected the entry to be invalidated. Got %q", w.Body.String())
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	revalidated := make(chan struct{}, 1)
+
+	mux := xhttp.NewServeMux()
+	mux.USE(New(newMapCache(), "httpcache"))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		w.Write([]byte(strconv.Itoa(n)))
+		if n > 1 {
+			select {
+			case revalidated <- struct{}{}:
+			default:
+			}
+		}
+	}))
+
+	get(t, mux, "http://example.com/")
+	if w := get(t, mux, "http://example.com/"); w.Body.String() != "1" || w.Header().Get("X-Cache") != "STALE" {
+		t.Fatalf("Expected a stale response but got %q %v", w.Body.String(), w.Header())
+	}
+	select {
+	case <-revalidated:
+	case <-time.After(time.Second):
+		t.Fatal("The entry was not revalidated")
+	}
+	// The revalidated entry is stored right after the response is produced.
+	deadline := time.Now().Add(time.Second)
+	for {
+		w := get(t, mux, "http://example.com/")
+		if w.Body.String() == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the revalidated response but got %q", w.Body.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestVary(t *testing.T) {
+	var calls int
+	handler := xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Write([]byte(strconv.Itoa(calls)))
+	})
+
+	// The response varies by a header absent from the key: it is not stored.
+	mux := xhttp.NewServeMux()
+	mux.USE(New(newMapCache(), "httpcache"))
+	mux.GET("/", handler)
+	get(t, mux, "http://example.com/")
+	if w := get(t, mux, "http://example.com/"); w.Body.String() != "2" {
+		t.Fatalf("Expected the response not to be cached. Got %q", w.Body.String())
+	}
+
+	mux = xhttp.NewServeMux()
+	mux.USE(New(newMapCache(), "httpcache", VaryBy("accept-encoding")))
+	mux.GET("/", handler)
+	get(t, mux, "http://example.com/")
+	if w := get(t, mux, "http://example.com/"); w.Body.String() != "3" || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("Expected a cached response but got %q %v", w.Body.String(), w.Header())
+	}
+}
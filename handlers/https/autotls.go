@@ -0,0 +1,94 @@
+package https
+
+// This file defines a helper which serves a ServeMux over TLS with
+// certificates obtained automatically from Let's Encrypt.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/hsts"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultHSTSMaxAge is the max-age of the Strict-Transport-Security header
+// sent by an AutoTLS server unless configured otherwise.
+const DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+// AutoTLS serves a ServeMux over https on port 443, the certificates being
+// obtained and renewed by an ACME client. Port 80 answers the ACME http-01
+// challenges and redirects every other request to https.
+// The https responses include a Strict-Transport-Security header.
+type AutoTLS struct {
+	Manager *autocert.Manager
+	HTTP    *xhttp.Server
+	HTTPS   *xhttp.Server
+
+	mux *xhttp.ServeMux
+}
+
+// NewAutoTLS returns an AutoTLS for the given domains, which are the only
+// ones certificates are requested for. The certificates are cached in the
+// user cache directory.
+// The Manager and the servers can be further configured before use, e.g. to
+// set a contact email or timeouts.
+func NewAutoTLS(mux *xhttp.ServeMux, domains ...string) AutoTLS {
+	if len(domains) == 0 {
+		panic("https: no domain to request certificates for")
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = "."
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(filepath.Join(dir, "xhttp-autocert")),
+	}
+
+	a := AutoTLS{
+		Manager: m,
+		HTTP:    xhttp.NewServer(":80", mux),
+		HTTPS:   xhttp.NewServer(":443", mux),
+		mux:     mux,
+	}
+	a.HTTP.Handler = m.HTTPHandler(NewRedirect(Code(http.StatusMovedPermanently)))
+	a.HTTPS.TLSConfig = m.TLSConfig()
+	return a.WithHSTS(hsts.New(DefaultHSTSMaxAge))
+}
+
+// WithHSTS replaces the handler setting the Strict-Transport-Security header
+// of the https responses.
+func (a AutoTLS) WithHSTS(h hsts.Handler) AutoTLS {
+	a.HTTPS.Handler = h.Link(a.mux)
+	return a
+}
+
+// ListenAndServe starts both servers and blocks until they have shut down,
+// upon a termination signal, or one of them has failed, in which case the
+// other one is shut down.
+func (a AutoTLS) ListenAndServe() error {
+	errc := make(chan error, 2)
+	go func() { errc <- a.HTTP.ListenAndServe() }()
+	go func() { errc <- a.HTTPS.ListenAndServeTLS("", "") }()
+
+	err := <-errc
+	if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), xhttp.DefaultShutdownTimeout)
+		defer cancel()
+		a.HTTP.Shutdown(ctx)
+		a.HTTPS.Shutdown(ctx)
+	}
+	return errors.Join(err, <-errc)
+}
+
+// ListenAndServeAutoTLS serves mux over https for the given domains with
+// automatically managed certificates, as configured by NewAutoTLS.
+func ListenAndServeAutoTLS(mux *xhttp.ServeMux, domains ...string) error {
+	return NewAutoTLS(mux, domains...).ListenAndServe()
+}
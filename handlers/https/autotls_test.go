@@ -0,0 +1,44 @@
+package https
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestAutoTLS(t *testing.T) {
+	mux := xhttp.NewServeMux()
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	a := NewAutoTLS(&mux, "example.com")
+
+	w := httptest.NewRecorder()
+	a.HTTP.Handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/a?b=c", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://example.com/a?b=c" {
+		t.Errorf("expected a redirect to https, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	a.HTTP.Handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/token", nil))
+	if w.Code == http.StatusMovedPermanently {
+		t.Error("ACME challenges should not be redirected")
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	a.HTTPS.Handler.ServeHTTP(w, req)
+	if w.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("expected a Strict-Transport-Security header")
+	}
+
+	if a.HTTPS.TLSConfig == nil || a.HTTPS.TLSConfig.GetCertificate == nil {
+		t.Fatal("expected certificates to be provided by the manager")
+	}
+	_, err := a.HTTPS.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.com"})
+	if err == nil {
+		t.Error("certificates should only be requested for the listed domains")
+	}
+}
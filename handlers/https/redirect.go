@@ -1,28 +1,112 @@
+// Package https defines a request handler which redirects plain http requests
+// to https.
 package https
 
 import (
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/ipfilter"
 )
 
 // Redirect is the handler which redirects all traffic stqrting with the http
 // scheme to https. It just needs to be dropped in the handler chain.
+//
+// Its zero value redirects with a 307 Temporary Redirect status and does not
+// trust any proxy. NewRedirect allows for further configuration.
 type Redirect struct {
-	next xhttp.Handler
+	code    int
+	port    int
+	proxies *ipfilter.List
+	next    xhttp.Handler
+}
+
+// NewRedirect returns a https redirecting request handler.
+func NewRedirect(options ...func(Redirect) Redirect) Redirect {
+	re := Redirect{}
+	for _, opt := range options {
+		if opt != nil {
+			re = opt(re)
+		}
+	}
+	switch re.code {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		panic("https: invalid redirect status code " + strconv.Itoa(re.code))
+	}
+	return re
+}
+
+// Code is a configuration option which sets the redirect status code, e.g.
+// http.StatusMovedPermanently or http.StatusPermanentRedirect.
+// The latter should be preferred as it preserves the request method.
+func Code(code int) func(Redirect) Redirect {
+	return func(re Redirect) Redirect {
+		re.code = code
+		return re
+	}
+}
+
+// Port is a configuration option which sets the port the https server listens
+// on, when it is not the default 443.
+func Port(p int) func(Redirect) Redirect {
+	return func(re Redirect) Redirect {
+		re.port = p
+		return re
+	}
+}
+
+// TrustProxies is a configuration option which makes the handler honor the
+// X-Forwarded-Proto header of the requests sent by the listed proxies, e.g.
+// a load balancer terminating TLS.
+func TrustProxies(l *ipfilter.List) func(Redirect) Redirect {
+	return func(re Redirect) Redirect {
+		re.proxies = l
+		return re
+	}
+}
+
+// secure reports whether the request was received over TLS, either by the
+// server itself or by a trusted proxy.
+func (re Redirect) secure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if re.proxies != nil && re.proxies.Contains(ipfilter.RemoteIP(r)) {
+		proto := r.Header.Get("X-Forwarded-Proto")
+		if i := strings.IndexByte(proto, ','); i >= 0 {
+			proto = proto[:i] // the left-most value was set by the first proxy
+		}
+		return strings.EqualFold(strings.TrimSpace(proto), "https")
+	}
+	return false
 }
 
 func (re Redirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	url := r.URL
-	sch := url.Scheme
-	if sch == "https" {
+	if re.secure(r) {
 		if re.next != nil {
 			re.next.ServeHTTP(w, r)
 		}
 		return
 	}
-	url.Scheme = "https"
-	http.Redirect(w, r, url.String(), http.StatusTemporaryRedirect)
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	if re.port != 0 && re.port != 443 {
+		host = host + ":" + strconv.Itoa(re.port)
+	}
+	code := re.code
+	if code == 0 {
+		code = http.StatusTemporaryRedirect
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
 }
 
 func (re Redirect) Link(h xhttp.Handler) xhttp.HandlerLinker {
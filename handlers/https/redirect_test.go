@@ -0,0 +1,56 @@
+package https
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/ipfilter"
+)
+
+func TestRedirect(t *testing.T) {
+	re := NewRedirect(Code(http.StatusPermanentRedirect), TrustProxies(ipfilter.MustList("10.0.0.1")))
+	h := re.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tcs := []struct {
+		name     string
+		addr     string
+		proto    string
+		tls      bool
+		redirect bool
+	}{
+		{"plain", "192.0.2.1:1234", "", false, true},
+		{"tls", "192.0.2.1:1234", "", true, false},
+		{"trusted proxy", "10.0.0.1:1234", "https", false, false},
+		{"trusted proxy over http", "10.0.0.1:1234", "http", false, true},
+		{"untrusted proxy", "192.0.2.1:1234", "https", false, true},
+	}
+	for _, tc := range tcs {
+		req, err := http.NewRequest("GET", "http://example.com:8080/a?b=c", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = tc.addr
+		if tc.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tc.proto)
+		}
+		if tc.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if !tc.redirect {
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: expected the request to be serviced. Got %d", tc.name, w.Code)
+			}
+			continue
+		}
+		// The port of the plain http server is not carried over.
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://example.com/a?b=c" {
+			t.Fatalf("%s: unexpected redirect %d %q", tc.name, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Catalog defines the interface of a collection of translated messages.
+type Catalog interface {
+	// Translate returns the message identified by key in the given locale,
+	// formatted with the optional arguments. It returns false if no such
+	// message exists.
+	Translate(locale string, key string, args ...interface{}) (string, bool)
+}
+
+// Messages is an in-memory Catalog mapping locales to message keys to
+// translations. Translations are formatted with fmt.Sprintf when arguments are
+// provided.
+//
+// A missing translation in a regional locale ("fr-CA") falls back to the base
+// language ("fr").
+type Messages map[string]map[string]string
+
+// Translate implements the Catalog interface.
+func (m Messages) Translate(locale string, key string, args ...interface{}) (string, bool) {
+	for _, l := range fallbacks(locale) {
+		if msg, ok := m[l][key]; ok {
+			if len(args) > 0 {
+				return fmt.Sprintf(msg, args...), true
+			}
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// fallbacks returns the locale followed by its base language, if different.
+func fallbacks(locale string) []string {
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		return []string{locale, locale[:i]}
+	}
+	return []string{locale}
+}
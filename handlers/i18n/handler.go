@@ -0,0 +1,289 @@
+// Package i18n defines a request handler resolving the preferred locale of a
+// client.
+//
+// The locale is looked up in order in a session value, a cookie and the
+// Accept-Language header, and matched against the locales supported by the
+// application. It is stored in the request context along with the translation
+// Catalog, so that downstream handlers and templates can translate messages
+// consistently.
+package i18n
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+type contextKey struct{}
+
+type localizer struct {
+	locale  string
+	catalog Catalog
+}
+
+// NewContext returns a copy of the context holding the locale and catalog.
+func NewContext(ctx context.Context, locale string, c Catalog) context.Context {
+	return context.WithValue(ctx, contextKey{}, localizer{locale, c})
+}
+
+// Locale returns the locale resolved for a request, or the empty string if the
+// request was not handled by a Handler.
+func Locale(ctx context.Context) string {
+	l, _ := ctx.Value(contextKey{}).(localizer)
+	return l.locale
+}
+
+// T translates the message identified by key into the locale of the request.
+// The key itself is returned if no translation exists.
+func T(ctx context.Context, key string, args ...interface{}) string {
+	l, ok := ctx.Value(contextKey{}).(localizer)
+	if ok && l.catalog != nil {
+		if msg, ok := l.catalog.Translate(l.locale, key, args...); ok {
+			return msg
+		}
+	}
+	return key
+}
+
+// FuncMap returns the template functions bound to the locale of a request:
+// "t" translates a message and "locale" returns the locale.
+//
+//	<html lang="{{ locale }}"><h1>{{ t "welcome" .User.Name }}</h1>
+func FuncMap(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string, args ...interface{}) string {
+			return T(ctx, key, args...)
+		},
+		"locale": func() string {
+			return Locale(ctx)
+		},
+	}
+}
+
+// Handler resolves the locale of the requests.
+type Handler struct {
+	supported []string // canonical forms, the first one being the default
+	catalog   Catalog
+
+	session    *session.Handler
+	sessionKey string
+	cookie     string
+
+	next xhttp.Handler
+}
+
+// New returns a Handler resolving the locale of the requests among the
+// supported locales, given as BCP 47 language tags (e.g. "en", "fr-CA").
+// The first one is used when no preference of the client can be satisfied.
+// It panics if no locale is supported.
+func New(c Catalog, supported []string, options ...func(Handler) Handler) Handler {
+	if len(supported) == 0 {
+		panic("i18n: at least one locale must be supported")
+	}
+	h := Handler{
+		catalog: c,
+		next:    nil,
+	}
+	for _, l := range supported {
+		h.supported = append(h.supported, canonical(l))
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// FromSession is a configuration option which looks the locale up in a session
+// value first. The session should have been loaded by an upstream handler.
+func FromSession(s session.Handler, key string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.session = &s
+		h.sessionKey = key
+		return h
+	}
+}
+
+// FromCookie is a configuration option which looks the locale up in the named
+// cookie, after the session if any.
+func FromCookie(name string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.cookie = name
+		return h
+	}
+}
+
+// Default returns the default locale.
+func (h Handler) Default() string {
+	return h.supported[0]
+}
+
+// Match returns the supported locale matching a language tag, falling back to
+// the base language (a request for "fr-CA" matches "fr" and vice versa).
+// The base language itself is preferred over other regional variants.
+func (h Handler) Match(tag string) (string, bool) {
+	tag = canonical(tag)
+	if tag == "" {
+		return "", false
+	}
+	for _, l := range h.supported {
+		if l == tag {
+			return l, true
+		}
+	}
+	base := language(tag)
+	for _, l := range h.supported {
+		if l == base {
+			return l, true
+		}
+	}
+	for _, l := range h.supported {
+		if language(l) == base {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// Resolve returns the locale of a request.
+func (h Handler) Resolve(r *http.Request) string {
+	if h.session != nil {
+		if v, err := h.session.Get(r.Context(), h.sessionKey); err == nil {
+			if l, ok := h.Match(string(v)); ok {
+				return l
+			}
+		}
+	}
+	if h.cookie != "" {
+		if c, err := r.Cookie(h.cookie); err == nil {
+			if l, ok := h.Match(c.Value); ok {
+				return l
+			}
+		}
+	}
+	for _, tag := range AcceptLanguage(r.Header.Get("Accept-Language")) {
+		if l, ok := h.Match(tag); ok {
+			return l
+		}
+	}
+	return h.Default()
+}
+
+// Set persists the locale chosen by a client in the session and cookie the
+// Handler reads from. It returns false if the locale is not supported.
+func (h Handler) Set(w http.ResponseWriter, r *http.Request, locale string) (bool, error) {
+	l, ok := h.Match(locale)
+	if !ok {
+		return false, nil
+	}
+	if h.session != nil {
+		if err := h.session.Put(r.Context(), h.sessionKey, []byte(l), 0); err != nil {
+			return true, err
+		}
+	}
+	if h.cookie != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     h.cookie,
+			Value:    l,
+			Path:     "/",
+			MaxAge:   365 * 24 * 3600,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return true, nil
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l := h.Resolve(r)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", l)
+	r = r.WithContext(NewContext(r.Context(), l, h.catalog))
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// AcceptLanguage parses the value of an Accept-Language header and returns the
+// language tags by decreasing preference. Tags with a zero weight and the "*"
+// wildcard are omitted.
+func AcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	res := make([]string, len(tags))
+	for i, t := range tags {
+		res[i] = t.tag
+	}
+	return res
+}
+
+// language returns the primary language subtag of a canonical tag.
+func language(tag string) string {
+	l, _, _ := strings.Cut(tag, "-")
+	return l
+}
+
+// canonical returns the canonical form of a simple language tag: lowercase
+// language, uppercase region ("en_us" becomes "en-US").
+func canonical(tag string) string {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	parts := strings.Split(tag, "-")
+	for i, p := range parts {
+		if p == "" || len(p) > 8 {
+			return ""
+		}
+		for _, c := range p {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+				return ""
+			}
+		}
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(p)
+		case len(p) == 2:
+			parts[i] = strings.ToUpper(p)
+		case len(p) == 4:
+			parts[i] = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		default:
+			parts[i] = strings.ToLower(p)
+		}
+	}
+	return strings.Join(parts, "-")
+}
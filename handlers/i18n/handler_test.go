@@ -0,0 +1,70 @@
+package i18n
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+var catalog = Messages{
+	"en":    {"welcome": "Welcome %s", "bye": "Goodbye"},
+	"fr":    {"welcome": "Bienvenue %s", "bye": "Au revoir"},
+	"fr-CA": {"bye": "Bye-bye"},
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	got := AcceptLanguage("fr;q=0.8, en-US, de;q=0, *;q=0.1, es;q=0.9")
+	want := []string{"en-US", "es", "fr"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v but got %v", want, got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var page bytes.Buffer
+	tmpl := template.Must(template.New("page").Funcs(FuncMap(context.Background())).Parse(`{{ locale }}: {{ t "welcome" "Ana" }} / {{ t "bye" }} / {{ t "missing" }}`))
+
+	mux := xhttp.NewServeMux()
+	mux.USE(New(catalog, []string{"en", "fr-CA", "fr"}, FromCookie("lang")))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page.Reset()
+		if err := template.Must(tmpl.Clone()).Funcs(FuncMap(r.Context())).Execute(&page, nil); err != nil {
+			t.Fatal(err)
+		}
+	}))
+
+	tcs := []struct {
+		accept string
+		cookie string
+		want   string
+	}{
+		{"", "", "en: Welcome Ana / Goodbye / missing"},
+		{"de, fr-CA;q=0.5", "", "fr-CA: Bienvenue Ana / Bye-bye / missing"},
+		{"fr-FR", "", "fr: Bienvenue Ana / Au revoir / missing"},
+		{"fr-FR", "en", "en: Welcome Ana / Goodbye / missing"},
+		{"fr-FR", "xx", "fr: Bienvenue Ana / Au revoir / missing"},
+	}
+	for _, tc := range tcs {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept-Language", tc.accept)
+		}
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "lang", Value: tc.cookie})
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if page.String() != tc.want {
+			t.Errorf("Accept-Language %q, cookie %q: expected %q but got %q", tc.accept, tc.cookie, tc.want, page.String())
+		}
+	}
+}
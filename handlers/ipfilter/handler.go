@@ -0,0 +1,105 @@
+// Package ipfilter defines a request handler which filters requests according
+// to the IP address of the client. It is typically used to restrict access to
+// administration routes or to block abusive clients.
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/atdiar/xhttp"
+)
+
+// Handler rejects the requests of clients whose IP address is denylisted or,
+// when an allowlist is set, not allowlisted, with a 403 Forbidden status.
+type Handler struct {
+	allow    *List
+	deny     *List
+	ClientIP func(r *http.Request) net.IP
+
+	next xhttp.Handler
+}
+
+// New returns an IP filtering request handler.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		ClientIP: RemoteIP,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// Allow is a configuration option which restricts access to the clients whose
+// IP address belongs to the list. The list may be reloaded at any time.
+func Allow(l *List) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.allow = l
+		return h
+	}
+}
+
+// Deny is a configuration option which denies access to the clients whose IP
+// address belongs to the list. The denylist takes precedence over the
+// allowlist. The list may be reloaded at any time.
+func Deny(l *List) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.deny = l
+		return h
+	}
+}
+
+// WithClientIP is a configuration option which changes the function used to
+// determine the client IP address, for instance when the server is behind a
+// trusted reverse proxy.
+func WithClientIP(fn func(r *http.Request) net.IP) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.ClientIP = fn
+		return h
+	}
+}
+
+// RemoteIP returns the IP address of the peer that sent the request.
+func RemoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Allowed reports whether a request should be let through.
+func (h Handler) Allowed(r *http.Request) bool {
+	fn := h.ClientIP
+	if fn == nil {
+		fn = RemoteIP
+	}
+	ip := fn(r)
+	if h.deny.Contains(ip) {
+		return false
+	}
+	if h.allow != nil && !h.allow.Contains(ip) {
+		return false
+	}
+	return true
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Allowed(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
@@ -0,0 +1,55 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestFilter(t *testing.T) {
+	allow := MustList("10.0.0.0/8", "2001:db8::/32")
+	deny := MustList("10.0.0.66")
+
+	mux := xhttp.NewServeMux()
+	mux.USE(New(Allow(allow), Deny(deny)))
+	mux.GET("/admin", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	status := func(addr string) int {
+		req, err := http.NewRequest("GET", "http://example.com/admin", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tcs := map[string]int{
+		"10.1.2.3:1234":       http.StatusOK,
+		"[2001:db8::1]:1234":  http.StatusOK,
+		"10.0.0.66:1234":      http.StatusForbidden,
+		"192.0.2.1:1234":      http.StatusForbidden,
+		"not an address:1234": http.StatusForbidden,
+	}
+	for addr, want := range tcs {
+		if got := status(addr); got != want {
+			t.Fatalf("%s: expected %d but got %d", addr, want, got)
+		}
+	}
+
+	if err := allow.Reload("192.0.2.0/24", "bogus"); err == nil {
+		t.Fatal("Expected an error for an invalid network")
+	}
+	if got := status("10.1.2.3:1234"); got != http.StatusOK {
+		t.Fatalf("A failed reload should leave the list unchanged. Got %d", got)
+	}
+	if err := allow.Reload("192.0.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if status("10.1.2.3:1234") != http.StatusForbidden || status("192.0.2.1:1234") != http.StatusOK {
+		t.Fatal("The reloaded allowlist was not applied")
+	}
+}
@@ -0,0 +1,80 @@
+package ipfilter
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// List is a set of IP networks that can be replaced at runtime, for instance
+// when a configuration file changes, without interrupting the servicing of
+// requests. It is safe for concurrent use.
+type List struct {
+	nets atomic.Value // []*net.IPNet
+}
+
+// NewList returns a List made of the provided networks, in CIDR notation.
+// Single IP addresses are also accepted.
+func NewList(cidrs ...string) (*List, error) {
+	l := &List{}
+	if err := l.Reload(cidrs...); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// MustList is like NewList but panics if a network cannot be parsed.
+func MustList(cidrs ...string) *List {
+	l, err := NewList(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Reload atomically replaces the content of the list. If any of the networks
+// cannot be parsed, the list is left unchanged and an error is returned.
+func (l *List) Reload(cidrs ...string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: c}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, n)
+	}
+	l.nets.Store(nets)
+	return nil
+}
+
+// Contains reports whether the IP address belongs to one of the networks of
+// the list.
+func (l *List) Contains(ip net.IP) bool {
+	if l == nil || ip == nil {
+		return false
+	}
+	nets, _ := l.nets.Load().([]*net.IPNet)
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
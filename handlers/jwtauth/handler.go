@@ -0,0 +1,253 @@
+// Package jwtauth defines a request handler which authenticates requests
+// bearing a JSON Web Token in their Authorization header (RFC 6750).
+//
+// Token signatures are verified against a KeySet (HMAC secret, RSA public
+// keys or a remote JWKS) and the standard claims are validated. The verified
+// claims are stored in the request context. A hook allows claims to be
+// mapped to rbac roles.
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/rbac"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims is the default claims type. It holds the registered claims as well
+// as the commonly used scope and roles claims.
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope string   `json:"scope,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+type contextKey struct{}
+
+// FromContext returns the verified claims of the request token.
+// Their concrete type is *Claims unless the WithClaims option is used.
+func FromContext(ctx context.Context) (jwt.Claims, bool) {
+	c, ok := ctx.Value(contextKey{}).(jwt.Claims)
+	return c, ok
+}
+
+// Handler is the bearer token authentication request handler.
+type Handler struct {
+	Keys      KeySet
+	Realm     string
+	Issuer    string
+	Audience  string
+	Leeway    time.Duration
+	NewClaims func() jwt.Claims
+	Roles     func(ctx context.Context, c jwt.Claims) ([]rbac.Role, error)
+	Optional  bool
+	Log       *log.Logger
+
+	next xhttp.Handler
+}
+
+// New returns a request handler which requires a valid bearer token, signed
+// with one of the keys of the KeySet.
+func New(keys KeySet, options ...func(Handler) Handler) Handler {
+	if keys == nil {
+		panic("jwtauth: nil KeySet")
+	}
+	h := Handler{
+		Keys:      keys,
+		NewClaims: func() jwt.Claims { return new(Claims) },
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// Issuer is a configuration option which requires the "iss" claim to match.
+func Issuer(iss string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Issuer = iss
+		return h
+	}
+}
+
+// Audience is a configuration option which requires the "aud" claim to
+// contain the audience.
+func Audience(aud string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Audience = aud
+		return h
+	}
+}
+
+// Leeway is a configuration option which sets the tolerance applied to the
+// validation of time based claims, to account for clock skew.
+func Leeway(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Leeway = d
+		return h
+	}
+}
+
+// Realm is a configuration option which sets the realm of the challenges.
+func Realm(realm string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Realm = realm
+		return h
+	}
+}
+
+// WithClaims is a configuration option which registers the constructor of a
+// custom claims type, into which the token payload is decoded.
+func WithClaims(fn func() jwt.Claims) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.NewClaims = fn
+		return h
+	}
+}
+
+// WithRoles is a configuration option which registers a function mapping the
+// verified claims to rbac roles. The roles are stored in the request context
+// under their ContextKey, where they can be checked by HasRole.
+func WithRoles(fn func(ctx context.Context, c jwt.Claims) ([]rbac.Role, error)) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Roles = fn
+		return h
+	}
+}
+
+// Optional is a configuration option which lets requests without a bearer
+// token through, unauthenticated. Requests with an invalid token are still
+// rejected.
+func Optional() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Optional = true
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// token validation failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Log = l
+		return h
+	}
+}
+
+// HasRole can be used as an rbac.Enforcer authorization checker: it returns an
+// error if the role was not granted by the jwtauth Handler.
+func HasRole(w http.ResponseWriter, r *http.Request, role rbac.Role) error {
+	if _, ok := r.Context().Value(role.ContextKey).(rbac.Role); !ok {
+		return ErrMissingRole
+	}
+	return nil
+}
+
+// ErrMissingRole is returned by HasRole.
+var ErrMissingRole = errors.New("jwtauth: role missing")
+
+func (h Handler) challenge(w http.ResponseWriter, code int, errcode string, desc string) {
+	v := "Bearer"
+	params := []string{}
+	if h.Realm != "" {
+		params = append(params, `realm="`+h.Realm+`"`)
+	}
+	if errcode != "" {
+		params = append(params, `error="`+errcode+`"`)
+	}
+	if desc != "" {
+		params = append(params, `error_description="`+strings.ReplaceAll(desc, `"`, `'`)+`"`)
+	}
+	if len(params) > 0 {
+		v = v + " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", v)
+	http.Error(w, http.StatusText(code), code)
+}
+
+func (h Handler) parser() *jwt.Parser {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(h.Keys.Algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(h.Leeway),
+	}
+	if h.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(h.Issuer))
+	}
+	if h.Audience != "" {
+		opts = append(opts, jwt.WithAudience(h.Audience))
+	}
+	return jwt.NewParser(opts...)
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		if h.Optional {
+			if h.next != nil {
+				h.next.ServeHTTP(w, r)
+			}
+			return
+		}
+		h.challenge(w, http.StatusUnauthorized, "", "")
+		return
+	}
+	scheme, raw, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || raw == "" {
+		h.challenge(w, http.StatusBadRequest, "invalid_request", "malformed Authorization header")
+		return
+	}
+
+	ctx := r.Context()
+	claims := h.NewClaims()
+	_, err := h.parser().ParseWithClaims(strings.TrimSpace(raw), claims, func(t *jwt.Token) (interface{}, error) {
+		return h.Keys.Key(ctx, t)
+	})
+	if err != nil {
+		if h.Log != nil {
+			h.Log.Print(err)
+		}
+		h.challenge(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid")
+		return
+	}
+	ctx = context.WithValue(ctx, contextKey{}, claims)
+
+	if h.Roles != nil {
+		roles, err := h.Roles(ctx, claims)
+		if err != nil {
+			if h.Log != nil {
+				h.Log.Print(err)
+			}
+			h.challenge(w, http.StatusForbidden, "insufficient_scope", "")
+			return
+		}
+		now := time.Now().UTC()
+		for _, role := range roles {
+			if role.ContextKey == nil {
+				continue // not created with rbac.NewRole
+			}
+			role.AssignedOn = now
+			ctx = context.WithValue(ctx, role.ContextKey, role)
+		}
+	}
+
+	r = r.WithContext(ctx)
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
@@ -0,0 +1,141 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/rbac"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var secret = []byte("secret")
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, c Claims) string {
+	tok := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func claims(exp time.Duration, roles ...string) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user42",
+			Issuer:    "https://issuer.example.com",
+			Audience:  jwt.ClaimStrings{"api"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(exp)),
+		},
+		Roles: roles,
+	}
+}
+
+func serve(t *testing.T, h http.Handler, token string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHMAC(t *testing.T) {
+	admin := rbac.NewRole("1", "admin", 0)
+	var subject string
+	mux := xhttp.NewServeMux()
+	mux.USE(New(HMAC(secret), Issuer("https://issuer.example.com"), Audience("api"),
+		WithRoles(func(ctx context.Context, c jwt.Claims) ([]rbac.Role, error) {
+			var roles []rbac.Role
+			for _, r := range c.(*Claims).Roles {
+				if r == "admin" {
+					roles = append(roles, admin)
+				}
+			}
+			return roles, nil
+		})))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := FromContext(r.Context())
+		subject, _ = c.GetSubject()
+		if err := HasRole(w, r, admin); err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+
+	if w := serve(t, mux, ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("Expected a challenge but got %d %v", w.Code, w.Header())
+	}
+	if w := serve(t, mux, sign(t, jwt.SigningMethodHS256, secret, "", claims(-time.Minute))); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expired tokens should be rejected. Got %d", w.Code)
+	}
+	if w := serve(t, mux, sign(t, jwt.SigningMethodHS256, []byte("other"), "", claims(time.Minute))); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Tokens with an invalid signature should be rejected. Got %d", w.Code)
+	}
+	c := claims(time.Minute)
+	c.Audience = jwt.ClaimStrings{"other"}
+	if w := serve(t, mux, sign(t, jwt.SigningMethodHS256, secret, "", c)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Tokens for another audience should be rejected. Got %d", w.Code)
+	}
+	if w := serve(t, mux, sign(t, jwt.SigningMethodHS256, secret, "", claims(time.Minute))); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected the admin role to be missing. Got %d", w.Code)
+	}
+	if w := serve(t, mux, sign(t, jwt.SigningMethodHS256, secret, "", claims(time.Minute, "admin"))); w.Code != http.StatusOK {
+		t.Fatalf("Expected a valid token. Got %d %v", w.Code, w.Header())
+	}
+	if subject != "user42" {
+		t.Fatalf("Expected the claims to be stored in the context. Got subject %q", subject)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer srv.Close()
+
+	h := New(NewJWKS(srv.URL)).Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if w := serve(t, h, sign(t, jwt.SigningMethodRS256, key, "k1", claims(time.Minute))); w.Code != http.StatusOK {
+		t.Fatalf("Expected a valid token. Got %d", w.Code)
+	}
+	if w := serve(t, h, sign(t, jwt.SigningMethodRS256, key, "k2", claims(time.Minute))); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected an unknown key. Got %d", w.Code)
+	}
+	// HMAC tokens must not be accepted by an asymmetric key set.
+	if w := serve(t, h, sign(t, jwt.SigningMethodHS256, secret, "k1", claims(time.Minute))); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the algorithm to be rejected. Got %d", w.Code)
+	}
+	if fetches != 1 {
+		t.Fatalf("Expected the key set to be fetched once but got %d fetches", fetches)
+	}
+}
@@ -0,0 +1,205 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrUnknownKey is returned when no key matches the key ID of a token.
+var ErrUnknownKey = errors.New("jwtauth: unknown signing key")
+
+// KeySet is the interface implemented by the sets of keys used to verify the
+// signature of tokens.
+type KeySet interface {
+	// Key returns the key used to verify the token signature, typically
+	// selected by the "kid" header of the token.
+	Key(ctx context.Context, t *jwt.Token) (interface{}, error)
+
+	// Algorithms lists the signing algorithms accepted for this key set.
+	// Tokens signed with any other algorithm are rejected.
+	Algorithms() []string
+}
+
+type hmacKeySet []byte
+
+// HMAC returns a KeySet made of a single HMAC shared secret.
+func HMAC(secret []byte) KeySet {
+	return hmacKeySet(secret)
+}
+
+func (k hmacKeySet) Key(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	return []byte(k), nil
+}
+
+func (k hmacKeySet) Algorithms() []string {
+	return []string{"HS256", "HS384", "HS512"}
+}
+
+type rsaKeySet map[string]*rsa.PublicKey
+
+// RSA returns a KeySet made of RSA public keys indexed by key ID. If a key is
+// registered for the empty key ID, it is used for tokens without "kid" header.
+func RSA(keys map[string]*rsa.PublicKey) KeySet {
+	return rsaKeySet(keys)
+}
+
+func (k rsaKeySet) Key(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (k rsaKeySet) Algorithms() []string {
+	return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+}
+
+// JWKS is a KeySet retrieved from a JSON Web Key Set URL, as published by
+// most identity providers. RSA and ECDSA keys are supported.
+//
+// The key set is fetched lazily and refreshed whenever a token references an
+// unknown key ID, at most once per MinRefresh interval.
+type JWKS struct {
+	URL        string
+	Client     *http.Client
+	MinRefresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+// NewJWKS returns a KeySet fetched from the URL.
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL:        url,
+		Client:     http.DefaultClient,
+		MinRefresh: 5 * time.Minute,
+	}
+}
+
+// Algorithms returns the asymmetric signing algorithms.
+func (j *JWKS) Algorithms() []string {
+	return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+}
+
+// Key returns the key matching the key ID of the token.
+func (j *JWKS) Key(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if !j.fetched.IsZero() && time.Since(j.fetched) < j.MinRefresh {
+		return nil, ErrUnknownKey
+	}
+	if err := j.refresh(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.fetched = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return err
+	}
+	client := j.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("jwtauth: unable to fetch key set: " + res.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue // unsupported keys are ignored
+		}
+		keys[k.Kid] = key
+	}
+	j.keys = keys
+	return nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("jwtauth: unsupported curve " + k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, errors.New("jwtauth: unsupported key type " + k.Kty)
+}
@@ -0,0 +1,155 @@
+// Package maintenance defines a request handler which can be switched at
+// runtime to answer all traffic with a 503 Service Unavailable response, for
+// instance during deploy windows or incidents.
+//
+// Requests from allowlisted IP addresses, for allowlisted paths or from
+// allowlisted sessions are still serviced, so that operators can check the
+// application before reopening it.
+package maintenance
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/ipfilter"
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// DefaultPage is the body of the responses sent during maintenance when no
+// custom page is provided.
+const DefaultPage = `<!DOCTYPE html><html><head><title>Maintenance</title></head><body><h1>We'll be back soon.</h1><p>This service is undergoing maintenance.</p></body></html>`
+
+// Handler is the maintenance mode request handler.
+type Handler struct {
+	Switch     Switch
+	RetryAfter time.Duration
+
+	page        []byte
+	contentType string
+	ips         *ipfilter.List
+	paths       []string
+	allow       []func(r *http.Request) bool
+
+	next xhttp.Handler
+}
+
+// New returns a maintenance mode request handler controlled by the Switch.
+func New(s Switch, options ...func(Handler) Handler) Handler {
+	if s == nil {
+		panic("maintenance: nil Switch")
+	}
+	h := Handler{
+		Switch:      s,
+		RetryAfter:  5 * time.Minute,
+		page:        []byte(DefaultPage),
+		contentType: "text/html; charset=utf-8",
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// RetryAfter is a configuration option which sets the value of the
+// Retry-After header. A non-positive duration omits the header.
+func RetryAfter(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.RetryAfter = d
+		return h
+	}
+}
+
+// Page is a configuration option which sets the body of the responses sent
+// during maintenance.
+func Page(contentType string, body []byte) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.contentType = contentType
+		h.page = body
+		return h
+	}
+}
+
+// AllowIPs is a configuration option which lets the requests of the clients
+// whose IP address belongs to the list through.
+func AllowIPs(l *ipfilter.List) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.ips = l
+		return h
+	}
+}
+
+// AllowPaths is a configuration option which lets the requests for the paths
+// starting with one of the prefixes through, e.g. health check endpoints.
+func AllowPaths(prefixes ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.paths = append(h.paths, prefixes...)
+		return h
+	}
+}
+
+// AllowSession is a configuration option which lets the requests through if
+// the session holds a value for the key, e.g. a session flagged for
+// administrators. The session handler should be registered ahead.
+func AllowSession(s session.Handler, key string) func(Handler) Handler {
+	return AllowFunc(func(r *http.Request) bool {
+		_, err := s.Get(r.Context(), key)
+		return err == nil
+	})
+}
+
+// AllowFunc is a configuration option which lets the requests for which the
+// function returns true through.
+func AllowFunc(fn func(r *http.Request) bool) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.allow = append(h.allow, fn)
+		return h
+	}
+}
+
+func (h Handler) allowed(r *http.Request) bool {
+	for _, p := range h.paths {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	if h.ips != nil && h.ips.Contains(ipfilter.RemoteIP(r)) {
+		return true
+	}
+	for _, fn := range h.allow {
+		if fn(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	on, err := h.Switch.Enabled(r.Context())
+	if err == nil && on && !h.allowed(r) {
+		if h.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(h.RetryAfter/time.Second), 10))
+		}
+		w.Header().Set("Content-Type", h.contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if r.Method != http.MethodHead {
+			w.Write(h.page)
+		}
+		return
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
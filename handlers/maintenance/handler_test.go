@@ -0,0 +1,109 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/ipfilter"
+)
+
+type mapCache struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (c *mapCache) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[id+"/"+hkey]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return v, nil
+}
+
+func (c *mapCache) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[id+"/"+hkey] = content
+	return nil
+}
+
+func (c *mapCache) Delete(ctx context.Context, id string, hkey string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, id+"/"+hkey)
+	return nil
+}
+
+func (c *mapCache) Clear() error                     { return nil }
+func (c *mapCache) ClearAfter(t time.Duration) error { return nil }
+
+func TestMaintenance(t *testing.T) {
+	flag := new(Flag)
+	mux := xhttp.NewServeMux()
+	mux.USE(New(flag, AllowIPs(ipfilter.MustList("10.0.0.0/8")), AllowPaths("/healthz"), RetryAfter(time.Minute)))
+	ok := xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux.GET("/", ok)
+	mux.GET("/healthz", ok)
+
+	serve := func(path string, ip string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("/", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 but got %d", w.Code)
+	}
+	flag.Enable()
+	w := serve("/", "192.0.2.1")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "60" || w.Body.String() != DefaultPage {
+		t.Fatalf("Expected the maintenance page but got %d %v", w.Code, w.Header())
+	}
+	if w := serve("/healthz", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("Allowlisted paths should be serviced. Got %d", w.Code)
+	}
+	if w := serve("/", "10.1.2.3"); w.Code != http.StatusOK {
+		t.Fatalf("Allowlisted IPs should be serviced. Got %d", w.Code)
+	}
+	flag.Disable()
+	if w := serve("/", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 but got %d", w.Code)
+	}
+}
+
+func TestCacheSwitch(t *testing.T) {
+	ctx := context.Background()
+	c := &mapCache{m: make(map[string][]byte)}
+	a := NewCacheSwitch(c, "maintenance", "on")
+	b := NewCacheSwitch(c, "maintenance", "on")
+	b.Refresh = 0
+
+	if on, _ := b.Enabled(ctx); on {
+		t.Fatal("Expected maintenance mode to be off")
+	}
+	if err := a.Enable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if on, _ := b.Enabled(ctx); !on {
+		t.Fatal("Expected maintenance mode to be switched on through the cache")
+	}
+	if err := a.Disable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if on, _ := b.Enabled(ctx); on {
+		t.Fatal("Expected maintenance mode to be switched off through the cache")
+	}
+}
@@ -0,0 +1,96 @@
+package maintenance
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// Switch is the interface implemented by objects holding the maintenance
+// state.
+type Switch interface {
+	Enabled(ctx context.Context) (bool, error)
+}
+
+// Flag is an in-process Switch. Its zero value is a disabled switch.
+type Flag struct {
+	on atomic.Bool
+}
+
+// Enable turns maintenance mode on.
+func (f *Flag) Enable() { f.on.Store(true) }
+
+// Disable turns maintenance mode off.
+func (f *Flag) Disable() { f.on.Store(false) }
+
+// Enabled reports whether maintenance mode is on.
+func (f *Flag) Enabled(ctx context.Context) (bool, error) {
+	return f.on.Load(), nil
+}
+
+// CacheSwitch is a Switch whose state is stored in a session.Cache, so that
+// every server sharing the cache can be switched at once.
+// The state is read from the cache at most once per Refresh interval.
+type CacheSwitch struct {
+	Cache   session.Cache
+	ID      string
+	Key     string
+	Refresh time.Duration
+
+	mu      sync.Mutex
+	on      bool
+	fetched time.Time
+}
+
+// NewCacheSwitch returns a Switch whose state is stored in the cache under
+// the provided id and key.
+func NewCacheSwitch(c session.Cache, id string, key string) *CacheSwitch {
+	return &CacheSwitch{
+		Cache:   c,
+		ID:      id,
+		Key:     key,
+		Refresh: time.Second,
+	}
+}
+
+// Enable turns maintenance mode on for every server sharing the cache.
+func (s *CacheSwitch) Enable(ctx context.Context) error {
+	if err := s.Cache.Put(ctx, s.ID, s.Key, []byte("1"), 0); err != nil {
+		return err
+	}
+	s.set(true)
+	return nil
+}
+
+// Disable turns maintenance mode off for every server sharing the cache.
+func (s *CacheSwitch) Disable(ctx context.Context) error {
+	if err := s.Cache.Delete(ctx, s.ID, s.Key); err != nil {
+		return err
+	}
+	s.set(false)
+	return nil
+}
+
+func (s *CacheSwitch) set(on bool) {
+	s.mu.Lock()
+	s.on = on
+	s.fetched = time.Now()
+	s.mu.Unlock()
+}
+
+// Enabled reports whether maintenance mode is on. A missing key means that
+// it is off.
+func (s *CacheSwitch) Enabled(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.fetched.IsZero() && time.Since(s.fetched) < s.Refresh {
+		return s.on, nil
+	}
+	v, err := s.Cache.Get(ctx, s.ID, s.Key)
+	s.on = err == nil && string(v) == "1"
+	s.fetched = time.Now()
+	return s.on, nil
+}
@@ -1,7 +1,6 @@
 package maxreqsize
 
 import (
-	"context"
 	"net/http"
 
 	"github.com/atdiar/xhttp"
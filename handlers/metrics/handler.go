@@ -0,0 +1,229 @@
+// Package metrics defines a request handler which exports http request
+// metrics to Prometheus.
+//
+// Requests are labelled by the pattern of the route that serviced them (as
+// returned by xhttp.Pattern) rather than by their raw path, so that the number
+// of time series stays bounded.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Unmatched is the pattern label value used for requests that were not
+// dispatched by a xhttp.ServeMux.
+const Unmatched = "unmatched"
+
+// Handler is a request handler which records, for every request, its count,
+// its duration and the number of requests being serviced concurrently.
+type Handler struct {
+	namespace string
+	buckets   []float64
+	registry  *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inflight prometheus.Gauge
+
+	next xhttp.Handler
+}
+
+// New returns a request handler recording http metrics.
+// The collectors are registered on the default Prometheus registry unless the
+// Registry option is used. Creating several handlers for the same registry and
+// namespace is allowed: the collectors are then shared.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		buckets: prometheus.DefBuckets,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+
+	h.requests = register(h.registerer(), prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: h.namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of http requests serviced, by route pattern, method and status code.",
+	}, []string{"pattern", "method", "code"}))
+
+	h.duration = register(h.registerer(), prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: h.namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of http requests, by route pattern and method.",
+		Buckets:   h.buckets,
+	}, []string{"pattern", "method"}))
+
+	h.inflight = register(h.registerer(), prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: h.namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Number of http requests currently being serviced.",
+	}))
+	return h
+}
+
+// Namespace is a configuration option which prefixes the metric names.
+func Namespace(ns string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.namespace = ns
+		return h
+	}
+}
+
+// Buckets is a configuration option which sets the buckets, in seconds, of the
+// request duration histogram.
+func Buckets(b ...float64) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.buckets = b
+		return h
+	}
+}
+
+// Registry is a configuration option which specifies the registry that the
+// collectors are registered on and that the Endpoint handler exposes.
+func Registry(r *prometheus.Registry) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.registry = r
+		return h
+	}
+}
+
+func (h Handler) registerer() prometheus.Registerer {
+	if h.registry != nil {
+		return h.registry
+	}
+	return prometheus.DefaultRegisterer
+}
+
+func (h Handler) gatherer() prometheus.Gatherer {
+	if h.registry != nil {
+		return h.registry
+	}
+	return prometheus.DefaultGatherer
+}
+
+// register registers a collector, returning the one that may have already
+// been registered instead.
+func register[C prometheus.Collector](r prometheus.Registerer, c C) C {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+// Endpoint returns the request handler exposing the metrics, typically
+// registered at /metrics.
+func (h Handler) Endpoint() xhttp.Handler {
+	return promhttp.HandlerFor(h.gatherer(), promhttp.HandlerOpts{})
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.next == nil {
+		return
+	}
+	if h.requests == nil {
+		panic("metrics: handler not created with New")
+	}
+	h.inflight.Inc()
+	defer h.inflight.Dec()
+
+	start := time.Now()
+	rw := &recorder{ResponseWriter: w}
+	h.next.ServeHTTP(rw, r)
+
+	pattern := xhttp.Pattern(r)
+	if pattern == "" {
+		pattern = Unmatched
+	}
+	method := methodLabel(r.Method)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	h.requests.WithLabelValues(pattern, method, strconv.Itoa(rw.status)).Inc()
+	h.duration.WithLabelValues(pattern, method).Observe(time.Since(start).Seconds())
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// methodLabel bounds the cardinality of the method label: non-standard
+// methods are all reported as "OTHER".
+func methodLabel(m string) string {
+	m = strings.ToUpper(m)
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return m
+	}
+	return "OTHER"
+}
+
+// recorder wraps a http.ResponseWriter in order to record the status code of
+// the response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rw *recorder) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recorder) ReadFrom(r io.Reader) (int64, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return xhttp.ReadFrom(rw.ResponseWriter, r)
+}
+
+func (rw *recorder) FlushError() error {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return xhttp.Flush(rw.ResponseWriter)
+}
+
+func (rw *recorder) Flush() { rw.FlushError() }
+
+func (rw *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return xhttp.Hijack(rw.ResponseWriter)
+}
+
+func (rw *recorder) Wrappee() http.ResponseWriter { return rw.ResponseWriter }
+
+func (rw *recorder) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := New(Registry(reg), Namespace("test"))
+
+	mux := xhttp.NewServeMux()
+	mux.USE(m)
+	mux.GET("/track/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, p := range []string{"/track/1", "/track/2"} {
+		req, err := http.NewRequest("GET", "http://example.com"+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	m.Endpoint().ServeHTTP(w, req)
+	body := w.Body.String()
+
+	if !strings.Contains(body, `test_http_requests_total{code="204",method="GET",pattern="/track/"} 2`) {
+		t.Fatalf("Expected requests to be counted by pattern. Got:\n%s", body)
+	}
+	if !strings.Contains(body, `test_http_request_duration_seconds_count{method="GET",pattern="/track/"} 2`) {
+		t.Fatalf("Expected request durations to be observed. Got:\n%s", body)
+	}
+	if !strings.Contains(body, `test_http_requests_in_flight 0`) {
+		t.Fatalf("Expected the in-flight gauge to be exported. Got:\n%s", body)
+	}
+
+	// Creating a second handler on the same registry must not panic.
+	New(Registry(reg), Namespace("test"))
+}
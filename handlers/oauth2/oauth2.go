@@ -6,7 +6,6 @@ import (
 	"encoding/base64"
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/atdiar/xhttp"
 	"github.com/atdiar/xhttp/handlers/session"
@@ -26,6 +25,10 @@ type Authentifier struct {
 	*oauth2.Config
 	Options []oauth2.AuthCodeOption
 	Log     *log.Logger
+
+	mode stateMode
+	key  []byte
+	pkce bool
 }
 
 // CallbackHandler defines a http request handler that will deal with the
@@ -39,8 +42,19 @@ type CallbackHandler struct {
 
 // NewRequest returns a new user Authentifier object that handles a http request
 // for user authentication.
-func NewRequest(s session.Handler, c *oauth2.Config) (Authentifier, CallbackHandler) {
-	auth := Authentifier{s, c, nil, nil}
+// By default, the state of the authorization flow is kept in the session.
+// The StatelessCookie and StatelessParam options allow to do without it.
+// It panics if the PKCE and StatelessParam options are combined.
+func NewRequest(s session.Handler, c *oauth2.Config, options ...func(Authentifier) Authentifier) (Authentifier, CallbackHandler) {
+	auth := Authentifier{Session: s, Config: c}
+	for _, opt := range options {
+		if opt != nil {
+			auth = opt(auth)
+		}
+	}
+	if auth.pkce && auth.mode == paramState {
+		panic("xoauth2: the PKCE verifier cannot be carried by the state parameter")
+	}
 	return auth, CallbackHandler{&auth, nil}
 }
 
@@ -63,42 +77,50 @@ func (l Authentifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = l.Session.Put(r.Context(), "oauthstate", ([]byte)(state), 10*time.Minute)
+	var verifier string
+	options := l.Options
+	if l.pkce {
+		verifier = oauth2.GenerateVerifier()
+		options = append(options[:len(options):len(options)], oauth2.S256ChallengeOption(verifier))
+	}
+	state, err = l.saveState(w, r, state, verifier)
 	if err != nil {
 		if l.Log != nil {
-			l.Log.Printf("Error saving oauth state variable into session: %v", err)
+			l.Log.Printf("Error saving oauth state variable: %v", err)
 		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	url := l.Config.AuthCodeURL(state, l.Options...)
+	url := l.Config.AuthCodeURL(state, options...)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 // ServeHTTP handles the request.
 func (c CallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx:= r.Context()
-	rawstate, err := c.authentifier.Session.Get(ctx,"oauthstate")
-	if err != nil {
+	ctx := r.Context()
+	verifier, err := c.authentifier.checkState(w, r)
+	if err == errNoState {
 		if c.authentifier.Log != nil {
 			c.authentifier.Log.Printf("Error recovering oauth state variable: %v", err)
 		}
 		http.Error(w, "XOAUTH2:unable to recover authentication state", http.StatusInternalServerError)
 		return
 	}
-	c.authentifier.Session.Delete(ctx, "oauthstate")
-	state := string(rawstate)
-	if r.FormValue("state") != state {
+	if err != nil {
 		if c.authentifier.Log != nil {
-			c.authentifier.Log.Print("Error : state variables are not equal")
+			c.authentifier.Log.Printf("Error : state variables are not equal: %v", err)
 		}
 		http.Error(w, "XOAUTH2:bad state", http.StatusInternalServerError)
 		return
 	}
 
+	var options []oauth2.AuthCodeOption
+	if verifier != "" {
+		options = append(options, oauth2.VerifierOption(verifier))
+	}
 	code := r.FormValue("code")
-	tok, err := c.authentifier.Config.Exchange(ctx, code)
+	tok, err := c.authentifier.Config.Exchange(ctx, code, options...)
 	if err != nil {
 		if c.authentifier.Log != nil {
 			c.authentifier.Log.Printf("Error while retrieving token: %v", err)
@@ -109,7 +131,7 @@ func (c CallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Put token and http.Client into context object
 	ctx = context.WithValue(ctx, TokenKey, tok)
 	ctx = context.WithValue(ctx, oauth2.HTTPClient, c.authentifier.Config.Client(ctx, tok))
-	r=r.WithContext(ctx)
+	r = r.WithContext(ctx)
 
 	if c.next != nil {
 		c.next.ServeHTTP(w, r)
@@ -0,0 +1,100 @@
+package xoauth2
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/session"
+	"golang.org/x/oauth2"
+)
+
+// provider returns a fake token endpoint which checks the PKCE verifier
+// against the expected challenge, if any.
+func provider(t *testing.T, challenge *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if *challenge != "" && base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tok","token_type":"bearer"}`))
+	}))
+}
+
+func TestStatelessState(t *testing.T) {
+	var challenge string
+	srv := provider(t, &challenge)
+	defer srv.Close()
+
+	config := &oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{AuthURL: "https://provider.example/auth", TokenURL: srv.URL},
+	}
+	s := session.New("SID", "secret")
+
+	for name, mode := range map[string]func(Authentifier) Authentifier{
+		"cookie": StatelessCookie([]byte("oauthsecret")),
+		"param":  StatelessParam([]byte("oauthsecret")),
+	} {
+		pkce := name != "param"
+		var pkceOpt func(Authentifier) Authentifier
+		if pkce {
+			pkceOpt = PKCE()
+		}
+		auth, callback := NewRequest(s, config, mode, pkceOpt)
+		var token *oauth2.Token
+		h := callback.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, _ = r.Context().Value(TokenKey).(*oauth2.Token)
+		}))
+
+		w := httptest.NewRecorder()
+		auth.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := loc.Query().Get("state")
+		challenge = loc.Query().Get("code_challenge")
+		if state == "" || pkce && challenge == "" {
+			t.Fatalf("%s: expected a state and a code challenge, got %q", name, loc)
+		}
+
+		cookies := w.Result().Cookies()
+		req := httptest.NewRequest("GET", "/callback?code=c&state="+url.QueryEscape(state), nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if token == nil || token.AccessToken != "tok" {
+			t.Fatalf("%s: expected the flow to complete, got status %d: %s", name, w.Code, w.Body.String())
+		}
+
+		// A tampered state is rejected.
+		token = nil
+		req = httptest.NewRequest("GET", "/callback?code=c&state="+url.QueryEscape(state+"x"), nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if token != nil || w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: expected a tampered state to be rejected, got status %d", name, w.Code)
+		}
+	}
+}
+
+func TestStatelessParamPKCE(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected PKCE with StatelessParam to panic")
+		}
+	}()
+	NewRequest(session.New("SID", "secret"), &oauth2.Config{}, PKCE(), StatelessParam([]byte("oauthsecret")))
+}
@@ -0,0 +1,223 @@
+package xoauth2
+
+// This file defines how the state of an authorization flow (the state
+// parameter and the PKCE verifier) is kept between the authorization request
+// and the callback.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// StateCookieName is the name of the cookie holding the sealed state of an
+// authorization flow when the StatelessCookie option is used.
+const StateCookieName = "oauthstate"
+
+// stateMaxAge is the validity duration of the state of an authorization flow.
+const stateMaxAge = 10 * time.Minute
+
+var (
+	errNoState  = errors.New("xoauth2: authorization state not found")
+	errBadState = errors.New("xoauth2: invalid authorization state")
+)
+
+// stateMode defines where the state of an authorization flow is kept.
+type stateMode int
+
+const (
+	sessionState stateMode = iota
+	cookieState
+	paramState
+)
+
+// StatelessCookie is a configuration option which keeps the state of the
+// authorization flow in a short-lived encrypted and authenticated cookie
+// instead of the session, so that no shared session store is required.
+// The state parameter sent to the provider is a random value bound to the
+// cookie.
+func StatelessCookie(secret []byte) func(Authentifier) Authentifier {
+	return stateless(secret, cookieState)
+}
+
+// StatelessParam is a configuration option which carries the state of the
+// authorization flow in the state parameter itself, encrypted and
+// authenticated. Nothing is stored on the client nor the server.
+//
+// As the state is not bound to the user agent, it does not protect against
+// login CSRF. StatelessCookie should be preferred whenever cookies can be
+// used.
+//
+// The state parameter is returned along with the authorization code, so it
+// cannot carry the PKCE verifier: StatelessParam cannot be used with PKCE.
+func StatelessParam(secret []byte) func(Authentifier) Authentifier {
+	return stateless(secret, paramState)
+}
+
+func stateless(secret []byte, mode stateMode) func(Authentifier) Authentifier {
+	if len(secret) == 0 {
+		panic("xoauth2: stateless authorization state requires a secret")
+	}
+	key := sha256.Sum256(secret)
+	return func(l Authentifier) Authentifier {
+		l.mode = mode
+		l.key = key[:]
+		return l
+	}
+}
+
+// PKCE is a configuration option which enables Proof Key for Code Exchange
+// (RFC 7636) with the S256 challenge method. The verifier is kept along with
+// the state of the authorization flow, in the session or in the state cookie.
+// It cannot be combined with StatelessParam.
+func PKCE() func(Authentifier) Authentifier {
+	return func(l Authentifier) Authentifier {
+		l.pkce = true
+		return l
+	}
+}
+
+// flowState is the sealed state of an authorization flow.
+type flowState struct {
+	State    string `json:"s"`
+	Verifier string `json:"v,omitempty"`
+	Expires  int64  `json:"x"`
+}
+
+// saveState keeps the state and the PKCE verifier of a new authorization flow
+// and returns the value of the state parameter to send to the provider.
+func (l Authentifier) saveState(w http.ResponseWriter, r *http.Request, state, verifier string) (string, error) {
+	switch l.mode {
+	case paramState:
+		return seal(l.key, flowState{State: state, Expires: time.Now().Add(stateMaxAge).Unix()})
+	case cookieState:
+		sealed, err := seal(l.key, flowState{state, verifier, time.Now().Add(stateMaxAge).Unix()})
+		if err != nil {
+			return "", err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     StateCookieName,
+			Value:    sealed,
+			Path:     "/",
+			MaxAge:   int(stateMaxAge / time.Second),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return state, nil
+	}
+	ctx := r.Context()
+	if err := l.Session.Put(ctx, "oauthstate", []byte(state), stateMaxAge); err != nil {
+		return "", err
+	}
+	if verifier != "" {
+		if err := l.Session.Put(ctx, "oauthverifier", []byte(verifier), stateMaxAge); err != nil {
+			return "", err
+		}
+	}
+	return state, nil
+}
+
+// checkState verifies the state parameter of an authorization callback and
+// returns the PKCE verifier of the flow, if any. The state cannot be used
+// twice, except when carried by the state parameter.
+func (l Authentifier) checkState(w http.ResponseWriter, r *http.Request) (string, error) {
+	param := r.FormValue("state")
+	switch l.mode {
+	case paramState:
+		_, err := open(l.key, param)
+		return "", err
+	case cookieState:
+		c, err := r.Cookie(StateCookieName)
+		if err != nil {
+			return "", errNoState
+		}
+		http.SetCookie(w, &http.Cookie{Name: StateCookieName, Path: "/", MaxAge: -1, Secure: true, HttpOnly: true})
+		fs, err := open(l.key, c.Value)
+		if err != nil {
+			return "", err
+		}
+		if subtle.ConstantTimeCompare([]byte(param), []byte(fs.State)) != 1 {
+			return "", errBadState
+		}
+		return fs.Verifier, nil
+	}
+	ctx := r.Context()
+	rawstate, err := l.Session.Get(ctx, "oauthstate")
+	if err != nil {
+		return "", errNoState
+	}
+	l.Session.Delete(ctx, "oauthstate")
+	if param != string(rawstate) {
+		return "", errBadState
+	}
+	if !l.pkce {
+		return "", nil
+	}
+	verifier, err := l.Session.Get(ctx, "oauthverifier")
+	if err != nil {
+		return "", errNoState
+	}
+	l.Session.Delete(ctx, "oauthverifier")
+	return string(verifier), nil
+}
+
+// seal encrypts and authenticates the state of an authorization flow with
+// AES-GCM.
+func seal(key []byte, fs flowState) (string, error) {
+	b, err := json.Marshal(fs)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, b, nil)), nil
+}
+
+// open decrypts a sealed authorization flow state and checks its expiry.
+func open(key []byte, sealed string) (flowState, error) {
+	var fs flowState
+	b, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return fs, errBadState
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return fs, err
+	}
+	if len(b) < aead.NonceSize() {
+		return fs, errBadState
+	}
+	b, err = aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return fs, errBadState
+	}
+	if err := json.Unmarshal(b, &fs); err != nil {
+		return fs, errBadState
+	}
+	if time.Now().Unix() > fs.Expires {
+		return fs, errBadState
+	}
+	return fs, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
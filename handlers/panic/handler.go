@@ -1,41 +1,234 @@
-// Package panic defiens a panic handler that deals with panics occuring during
-// the handling of a http request. It is in general route-agnostic.
+// Package panic defines a panic handler that deals with panics occuring during
+// the handling of a http request. It is in general route-agnostic, although
+// the handling of the panics can be scoped to groups of routes.
 package panic
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/requestid"
 )
 
+// Panic holds the information gathered when a panic is recovered during the
+// handling of a http request.
+type Panic struct {
+	Value      interface{}
+	Stack      []byte
+	Time       time.Time
+	Method     string
+	URL        string
+	RemoteAddr string
+	UserAgent  string
+	RequestID  string
+	// Pattern is the pattern of the route that matched the request, as
+	// returned by xhttp.Pattern.
+	Pattern string
+}
+
+// newPanic captures the stack of the panicking goroutine as well as some
+// request metadata. It must be called from the deferred recovery function.
+func newPanic(v interface{}, r *http.Request) Panic {
+	return Panic{
+		Value:      v,
+		Stack:      debug.Stack(),
+		Time:       time.Now().UTC(),
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		RequestID:  requestid.FromContext(r.Context()),
+		Pattern:    xhttp.Pattern(r),
+	}
+}
+
+// MarshalJSON returns the JSON encoding of a recovered panic. The panic value
+// is rendered as a string.
+func (p Panic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value      string    `json:"panic"`
+		Stack      string    `json:"stack"`
+		Time       time.Time `json:"time"`
+		Method     string    `json:"method"`
+		URL        string    `json:"url"`
+		RemoteAddr string    `json:"remoteaddr"`
+		UserAgent  string    `json:"useragent"`
+		RequestID  string    `json:"requestid,omitempty"`
+		Pattern    string    `json:"pattern,omitempty"`
+	}{fmt.Sprint(p.Value), string(p.Stack), p.Time, p.Method, p.URL, p.RemoteAddr, p.UserAgent, p.RequestID, p.Pattern})
+}
+
 // Handler allows for the registration of a panic handling function.
 type Handler struct {
-	Handle func(msg interface{}, w http.ResponseWriter, r *http.Request)
-	next   xhttp.Handler
+	Handle func(p Panic, w http.ResponseWriter, r *http.Request)
+
+	reporters []Reporter
+	tags      map[string]string
+	identify  func(r *http.Request) (userID string, sessionID string)
+	scopes    []scope
+
+	reportTimeout time.Duration
+
+	next xhttp.Handler
 }
 
 // NewHandler return an object used to take care of panics stemming from the
 // request handling process accomodated by a downstrean chain of registered
 // request handlers.
-func NewHandler(handler func(msg interface{}, w http.ResponseWriter, r *http.Request)) Handler {
+func NewHandler(handler func(p Panic, w http.ResponseWriter, r *http.Request)) Handler {
 	return Handler{
 		Handle: handler,
 		next:   nil,
 	}
 }
 
+// Default returns a panic Handler which logs recovered panics as JSON using
+// the provided logger (or the standard logger if nil) and responds with a
+// bare 500 Internal Server Error, without leaking any detail to the client.
+func Default(l *log.Logger) Handler {
+	return NewHandler(LogJSON(l))
+}
+
+// LogJSON returns a panic handling function which logs the recovered panic
+// as JSON and responds with a 500 Internal Server Error.
+func LogJSON(l *log.Logger) func(p Panic, w http.ResponseWriter, r *http.Request) {
+	mapper := ToError(xhttp.DefaultErrorMapper)
+	return func(p Panic, w http.ResponseWriter, r *http.Request) {
+		b, err := json.Marshal(p)
+		if err != nil {
+			b = []byte(fmt.Sprint(p.Value))
+		}
+		if l != nil {
+			l.Print(string(b))
+		} else {
+			log.Print(string(b))
+		}
+		mapper(p, w, r)
+	}
+}
+
+// Error is the error corresponding to a recovered panic. It is the error
+// that is fed to an xhttp.ErrorMapper by the handling functions returned by
+// ToError.
+type Error struct {
+	Panic
+}
+
+func (e Error) Error() string {
+	return "panic: " + fmt.Sprint(e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e Error) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// ToError returns a panic handling function which lets recovered panics flow
+// into the provided error mapper as 500 Internal Server Error errors.
+func ToError(m xhttp.ErrorMapper) func(p Panic, w http.ResponseWriter, r *http.Request) {
+	return func(p Panic, w http.ResponseWriter, r *http.Request) {
+		m(w, r, xhttp.NewError(http.StatusInternalServerError, Error{p}))
+	}
+}
+
+// ToTypedError returns a panic handling function which converts recovered
+// panics into typed errors fed to the provided error mapper, so that panics
+// signalling a client error are not turned into blanket 500 errors.
+//
+// If the panic value is an error and status returns a 4xx code for it, the
+// error is passed on as is, wrapped in an xhttp.Error, which preserves any
+// error code it carries. status defaults to xhttp.StatusCode, which
+// recognizes xhttp.Error and xhttp.Problem values. Other panics are fed to
+// the mapper like with ToError.
+func ToTypedError(m xhttp.ErrorMapper, status func(err error) int) func(p Panic, w http.ResponseWriter, r *http.Request) {
+	if status == nil {
+		status = xhttp.StatusCode
+	}
+	internal := ToError(m)
+	return func(p Panic, w http.ResponseWriter, r *http.Request) {
+		err, ok := p.Value.(error)
+		if !ok {
+			internal(p, w, r)
+			return
+		}
+		if code := status(err); code >= 400 && code < 500 {
+			m(w, r, xhttp.NewError(code, err))
+			return
+		}
+		internal(p, w, r)
+	}
+}
+
+// scope is a panic handling function dedicated to a group of routes.
+type scope struct {
+	prefix string
+	handle func(p Panic, w http.ResponseWriter, r *http.Request)
+}
+
+// Scope returns a copy of the handler which handles the panics occurring on
+// the routes whose pattern is prefix or lies under it, with the provided
+// function instead of the Handle function, e.g. to render an error page
+// specific to a subsystem. The prefix matches whole path segments: "/admin"
+// and "/admin/" both scope "/admin" and "/admin/users" but not "/administer".
+// When several scopes match, the longest prefix wins.
+// Reporters are notified regardless of the scope.
+func (h Handler) Scope(prefix string, handle func(p Panic, w http.ResponseWriter, r *http.Request)) Handler {
+	h.scopes = append(h.scopes[:len(h.scopes):len(h.scopes)], scope{prefix, handle})
+	return h
+}
+
+// handler returns the panic handling function for a route pattern.
+func (h Handler) handler(pattern string) func(p Panic, w http.ResponseWriter, r *http.Request) {
+	handle := h.Handle
+	longest := -1
+	for _, s := range h.scopes {
+		if s.matches(pattern) && len(s.prefix) > longest {
+			handle = s.handle
+			longest = len(s.prefix)
+		}
+	}
+	return handle
+}
+
+// matches reports whether a route pattern is the prefix of the scope or lies
+// under it.
+func (s scope) matches(pattern string) bool {
+	prefix := strings.TrimSuffix(s.prefix, "/")
+	return pattern == prefix || strings.HasPrefix(pattern, prefix+"/")
+}
+
 // ServeHTTP handles the servicing of incoming http requests.
+// If no request handler has been linked, it does nothing.
+//
+// Panics with the http.ErrAbortHandler value are not handled: they are
+// re-raised so that the server aborts the response silently, as is expected
+// when a client disconnects.
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if errmsg := recover(); errmsg != nil {
-			h.Handle(errmsg, w, r)
+			if errmsg == http.ErrAbortHandler {
+				panic(errmsg)
+			}
+			p := newPanic(errmsg, r)
+			h.report(p, r)
+			handle := h.handler(p.Pattern)
+			if handle == nil {
+				ToError(xhttp.DefaultErrorMapper)(p, w, r)
+				return
+			}
+			handle(p, w, r)
 		}
 	}()
 	if h.next != nil {
 		h.next.ServeHTTP(w, r)
-		return
 	}
-	panic("Panic Handler was ill-registered")
 }
 
 // Link enables the linking of a xhttp.Handler. The linked object holds the
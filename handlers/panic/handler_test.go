@@ -1,21 +1,30 @@
 package panic
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/requestid"
 )
 
 var Payload = "Panicked"
 
 func TestHandler(t *testing.T) {
+	var stack []byte
 	mux := xhttp.NewServeMux()
-	mux.USE(NewHandler(func(msg interface{}, w http.ResponseWriter, r *http.Request) {
+	mux.USE(NewHandler(func(p Panic, w http.ResponseWriter, r *http.Request) {
 		// do something simple
-		_, _ = fmt.Fprint(w, msg)
+		stack = p.Stack
+		_, _ = fmt.Fprint(w, p.Value)
 	}))
 
 	mux.GET("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
@@ -37,4 +46,265 @@ func TestHandler(t *testing.T) {
 	if response := w.Body.String(); response != Payload {
 		t.Fatalf("Expected: %v but got: %v \n", Payload, response)
 	}
+	if !bytes.Contains(stack, []byte("runtime/debug.Stack")) {
+		t.Fatalf("Expected the goroutine stack to be captured. Got: %s", stack)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	var buf bytes.Buffer
+	mux := xhttp.NewServeMux()
+	mux.USE(Default(log.New(&buf, "", 0)))
+	mux.GET("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("secret internal detail")
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500 but got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Fatal("The panic value leaked into the response.")
+	}
+	if !strings.Contains(buf.String(), `"panic":"secret internal detail"`) {
+		t.Fatalf("Expected a JSON log entry but got: %s", buf.String())
+	}
+}
+
+func TestAbortHandler(t *testing.T) {
+	called := false
+	h := NewHandler(func(p Panic, w http.ResponseWriter, r *http.Request) {
+		called = true
+	}).Link(xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("Expected http.ErrAbortHandler to be re-raised. Got %v", v)
+		}
+		if called {
+			t.Fatal("Client disconnections should not be reported.")
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestReporter(t *testing.T) {
+	var events []Event
+	h := NewHandler(func(p Panic, w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}).WithReporter(ReporterFunc(func(ctx context.Context, e Event) error {
+		events = append(events, e)
+		return nil
+	})).WithTags(map[string]string{"service": "api"}).WithIdentity(func(r *http.Request) (string, string) {
+		return "user42", "session42"
+	})
+
+	mux := xhttp.NewServeMux()
+	mux.USE(h)
+	mux.GET("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(Payload)
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(events) != 1 {
+		t.Fatalf("Expected one reported event but got %d", len(events))
+	}
+	e := events[0]
+	if e.Value != Payload || e.UserID != "user42" || e.SessionID != "session42" || e.Tags["service"] != "api" {
+		t.Fatalf("Unexpected event %+v", e)
+	}
+}
+
+func TestReportTimeout(t *testing.T) {
+	var expired bool
+	h := NewHandler(func(p Panic, w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}).WithReportTimeout(10 * time.Millisecond).WithReporter(ReporterFunc(func(ctx context.Context, e Event) error {
+		// An unresponsive collector.
+		<-ctx.Done()
+		expired = ctx.Err() == context.DeadlineExceeded
+		return ctx.Err()
+	}))
+
+	mux := xhttp.NewServeMux()
+	mux.USE(h)
+	mux.GET("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(Payload)
+	}))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	if !expired || w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected the report to time out and the panic to be handled, got %d", w.Code)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	var mapped error
+	h := NewHandler(ToError(func(w http.ResponseWriter, r *http.Request, err error) {
+		mapped = err
+		xhttp.DefaultErrorMapper(w, r, err)
+	}))
+
+	mux := xhttp.NewServeMux()
+	mux.USE(h)
+	mux.GET("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(Payload)
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500 but got %d", w.Code)
+	}
+	var perr Error
+	if !errors.As(mapped, &perr) || perr.Value != Payload {
+		t.Fatalf("Expected the recovered panic to be mapped. Got %v", mapped)
+	}
+	if xhttp.StatusCode(mapped) != http.StatusInternalServerError {
+		t.Fatalf("Expected a 500-class error. Got %d", xhttp.StatusCode(mapped))
+	}
+}
+
+func TestNoNext(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Default(nil).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected a no-op but got status %d", w.Code)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var id string
+	mux := xhttp.NewServeMux()
+	mux.USE(requestid.New(), NewHandler(func(p Panic, w http.ResponseWriter, r *http.Request) {
+		id = p.RequestID
+	}))
+	mux.GET("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(Payload)
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestid.DefaultHeader, "req-42")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if id != "req-42" {
+		t.Fatalf("Expected the request id to be recorded but got %q", id)
+	}
+}
+
+func TestScope(t *testing.T) {
+	var pattern string
+	h := NewHandler(func(p Panic, w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "global", http.StatusInternalServerError)
+	}).Scope("/uploads/", func(p Panic, w http.ResponseWriter, r *http.Request) {
+		pattern = p.Pattern
+		http.Error(w, "upload failed", http.StatusServiceUnavailable)
+	})
+
+	mux := xhttp.NewServeMux()
+	mux.USE(h)
+	mux.GET("/uploads/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(Payload)
+	}))
+	mux.GET("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(Payload)
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/uploads/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || pattern != "/uploads/" {
+		t.Fatalf("Expected the scoped handler to recover the panic but got status %d and pattern %q", w.Code, pattern)
+	}
+
+	req, err = http.NewRequest("GET", "http://example.com/profile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected the global handler to recover the panic but got status %d", w.Code)
+	}
+
+	// Prefixes match whole path segments.
+	admin := scope{prefix: "/admin"}
+	for pattern, want := range map[string]bool{"/admin": true, "/admin/users": true, "/administer": false} {
+		if admin.matches(pattern) != want {
+			t.Fatalf("Expected the scope /admin to match %s: %v", pattern, want)
+		}
+	}
+}
+
+func TestToTypedError(t *testing.T) {
+	errInvalid := errors.New("invalid email")
+	var mapped error
+	mapper := func(w http.ResponseWriter, r *http.Request, err error) {
+		mapped = err
+		xhttp.DefaultErrorMapper(w, r, err)
+	}
+	byCode := func(err error) int {
+		if errors.Is(err, errInvalid) {
+			return http.StatusUnprocessableEntity
+		}
+		return xhttp.StatusCode(err)
+	}
+
+	tcs := []struct {
+		value  interface{}
+		status func(error) int
+		code   int
+	}{
+		{xhttp.NewError(http.StatusBadRequest, errors.New("missing field")), nil, http.StatusBadRequest},
+		{xhttp.NewProblem(http.StatusConflict, "already registered"), nil, http.StatusConflict},
+		{fmt.Errorf("register: %w", errInvalid), byCode, http.StatusUnprocessableEntity},
+		{errInvalid, nil, http.StatusInternalServerError},
+		{Payload, nil, http.StatusInternalServerError},
+	}
+	for _, tc := range tcs {
+		h := NewHandler(ToTypedError(mapper, tc.status)).Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(tc.value)
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/register", nil))
+		if w.Code != tc.code {
+			t.Errorf("%v: expected status %d but got %d", tc.value, tc.code, w.Code)
+		}
+		if err, ok := tc.value.(error); ok && (mapped == nil || !strings.Contains(mapped.Error(), err.Error())) {
+			t.Errorf("%v: expected the panic error to be preserved, got %v", tc.value, mapped)
+		}
+	}
 }
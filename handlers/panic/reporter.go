@@ -0,0 +1,154 @@
+package panic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultReportTimeout is the time allotted to the Reporters to send an Event,
+// unless set by WithReportTimeout.
+const DefaultReportTimeout = 5 * time.Second
+
+// defaultClient is the client used by the HTTPReporters without Client, so
+// that an unresponsive collector does not block them forever.
+var defaultClient = &http.Client{Timeout: DefaultReportTimeout}
+
+// Event describes a recovered panic as sent to a crash reporting service.
+type Event struct {
+	Panic
+	UserID    string            `json:"userid,omitempty"`
+	SessionID string            `json:"sessionid,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// MarshalJSON returns the JSON encoding of an Event.
+func (e Event) MarshalJSON() ([]byte, error) {
+	p, err := json.Marshal(e.Panic)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Panic     json.RawMessage   `json:"exception"`
+		UserID    string            `json:"userid,omitempty"`
+		SessionID string            `json:"sessionid,omitempty"`
+		Tags      map[string]string `json:"tags,omitempty"`
+	}{p, e.UserID, e.SessionID, e.Tags})
+}
+
+// Reporter is the interface implemented by crash reporting services
+// (Sentry, Rollbar, in-house collectors...) to which recovered panics
+// are forwarded.
+// The context passed to CaptureException is derived from the request context.
+// It is not canceled when the client disconnects but expires after the report
+// timeout, as the Reporters are called before the response is written.
+type Reporter interface {
+	CaptureException(ctx context.Context, e Event) error
+}
+
+// ReporterFunc allows the use of ordinary functions as panic Reporters.
+type ReporterFunc func(ctx context.Context, e Event) error
+
+// CaptureException calls f(ctx, e).
+func (f ReporterFunc) CaptureException(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// WithReporter registers a Reporter to which recovered panics are sent
+// before the Handle function is called.
+func (h Handler) WithReporter(r Reporter) Handler {
+	h.reporters = append(h.reporters[:len(h.reporters):len(h.reporters)], r)
+	return h
+}
+
+// WithTags adds tags that are attached to every reported Event.
+func (h Handler) WithTags(tags map[string]string) Handler {
+	t := make(map[string]string, len(h.tags)+len(tags))
+	for k, v := range h.tags {
+		t[k] = v
+	}
+	for k, v := range tags {
+		t[k] = v
+	}
+	h.tags = t
+	return h
+}
+
+// WithIdentity registers the function used to retrieve the user and session
+// ids that are attached to the reported Events.
+func (h Handler) WithIdentity(identify func(r *http.Request) (userID string, sessionID string)) Handler {
+	h.identify = identify
+	return h
+}
+
+// WithReportTimeout sets the time allotted to the Reporters to send an Event.
+// DefaultReportTimeout is used by default.
+func (h Handler) WithReportTimeout(d time.Duration) Handler {
+	h.reportTimeout = d
+	return h
+}
+
+func (h Handler) report(p Panic, r *http.Request) {
+	if len(h.reporters) == 0 {
+		return
+	}
+	e := Event{Panic: p, Tags: h.tags}
+	if h.identify != nil {
+		e.UserID, e.SessionID = h.identify(r)
+	}
+	timeout := h.reportTimeout
+	if timeout <= 0 {
+		timeout = DefaultReportTimeout
+	}
+	// Reporting is not aborted when the client disconnects, but a slow
+	// collector cannot hold the request indefinitely.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), timeout)
+	defer cancel()
+	for _, rep := range h.reporters {
+		if rep != nil {
+			_ = rep.CaptureException(ctx, e)
+		}
+	}
+}
+
+// HTTPReporter is an example of Reporter implementation which posts the JSON
+// encoding of the Event to a collector endpoint. Without Client, a client
+// timing out after DefaultReportTimeout is used.
+type HTTPReporter struct {
+	URL    string
+	Header http.Header // additional headers, typically for authentication
+	Client *http.Client
+}
+
+// CaptureException sends the Event to the collector.
+func (h HTTPReporter) CaptureException(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c := h.Client
+	if c == nil {
+		c = defaultClient
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New("panic: reporter endpoint responded with status " + strconv.Itoa(res.StatusCode))
+	}
+	return nil
+}
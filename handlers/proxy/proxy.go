@@ -0,0 +1,374 @@
+// Package proxy defines a reverse proxy request handler which balances the
+// load between several upstream servers.
+//
+// Upstreams are selected in turn (RoundRobin) or by number of requests in
+// flight (LeastConnections). An upstream that cannot be connected to is
+// ejected for a while and the request is retried on another one. Optional
+// active health checks eject upstreams until they recover.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+// ErrNoUpstream is returned when no healthy upstream is available.
+var ErrNoUpstream = errors.New("proxy: no healthy upstream")
+
+// Handler is the load balancing reverse proxy request handler.
+type Handler struct {
+	strategy       Strategy
+	retries        int
+	ejectFor       time.Duration
+	healthPath     string
+	healthInterval time.Duration
+	preserveHost   bool
+	rewrite        []func(r *http.Request)
+	modify         []func(res *http.Response) error
+	transport      http.RoundTripper
+	log            *log.Logger
+
+	*balancer
+	next xhttp.Handler
+}
+
+// balancer holds the state shared by the copies of a Handler.
+type balancer struct {
+	upstreams []*Upstream
+	counter   atomic.Uint64
+	rp        *httputil.ReverseProxy
+	stop      context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New returns a reverse proxy forwarding requests to the upstream servers.
+// It panics if an upstream url is invalid.
+func New(upstreams []string, options ...func(Handler) Handler) Handler {
+	if len(upstreams) == 0 {
+		panic("proxy: no upstream")
+	}
+	p := Handler{
+		retries:   len(upstreams) - 1,
+		ejectFor:  10 * time.Second,
+		transport: http.DefaultTransport,
+		balancer:  &balancer{},
+		next:      nil,
+	}
+	for _, s := range upstreams {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			panic("proxy: invalid upstream url " + s)
+		}
+		p.upstreams = append(p.upstreams, &Upstream{URL: u})
+	}
+	for _, opt := range options {
+		if opt != nil {
+			p = opt(p)
+		}
+	}
+
+	p.rp = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetXForwarded()
+			if !p.preserveHost {
+				pr.Out.Host = ""
+			}
+			for _, fn := range p.rewrite {
+				fn(pr.Out)
+			}
+		},
+		Transport: transport{p},
+		ModifyResponse: func(res *http.Response) error {
+			for _, fn := range p.modify {
+				if err := fn(res); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if p.log != nil {
+				p.log.Print(err)
+			}
+			if errors.Is(err, ErrNoUpstream) {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+		ErrorLog: p.log,
+	}
+
+	if p.healthInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.stop = cancel
+		p.wg.Add(1)
+		go p.healthChecks(ctx)
+	}
+	return p
+}
+
+// WithStrategy is a configuration option which sets the upstream selection
+// strategy. The default is RoundRobin.
+func WithStrategy(s Strategy) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.strategy = s
+		return p
+	}
+}
+
+// Retries is a configuration option which sets the number of other upstreams
+// tried when an upstream cannot be connected to. By default, every upstream
+// is tried once. Requests are only retried if they could not be sent at all.
+func Retries(n int) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.retries = n
+		return p
+	}
+}
+
+// EjectFor is a configuration option which sets the duration during which an
+// upstream that could not be connected to does not receive requests.
+func EjectFor(d time.Duration) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.ejectFor = d
+		return p
+	}
+}
+
+// HealthCheck is a configuration option which enables active health checks:
+// path is requested on every upstream at the given interval and upstreams not
+// answering with a 2xx status do not receive requests until they recover.
+func HealthCheck(path string, interval time.Duration) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.healthPath = path
+		p.healthInterval = interval
+		return p
+	}
+}
+
+// PreserveHost is a configuration option which forwards the Host header of the
+// incoming request instead of the host of the upstream.
+func PreserveHost() func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.preserveHost = true
+		return p
+	}
+}
+
+// RewriteRequest is a configuration option which registers a function
+// modifying the outgoing requests, e.g. to add or remove headers.
+func RewriteRequest(fn func(r *http.Request)) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.rewrite = append(p.rewrite, fn)
+		return p
+	}
+}
+
+// RewriteResponse is a configuration option which registers a function
+// modifying the upstream responses. If it returns an error, the client
+// receives a 502 Bad Gateway response.
+func RewriteResponse(fn func(res *http.Response) error) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.modify = append(p.modify, fn)
+		return p
+	}
+}
+
+// RequestHeader is a configuration option which sets a header of the outgoing
+// requests. An empty value removes the header.
+func RequestHeader(key string, value string) func(Handler) Handler {
+	return RewriteRequest(func(r *http.Request) {
+		if value == "" {
+			r.Header.Del(key)
+			return
+		}
+		r.Header.Set(key, value)
+	})
+}
+
+// ResponseHeader is a configuration option which sets a header of the
+// upstream responses. An empty value removes the header.
+func ResponseHeader(key string, value string) func(Handler) Handler {
+	return RewriteResponse(func(res *http.Response) error {
+		if value == "" {
+			res.Header.Del(key)
+			return nil
+		}
+		res.Header.Set(key, value)
+		return nil
+	})
+}
+
+// Transport is a configuration option which sets the transport used to
+// forward requests.
+func Transport(t http.RoundTripper) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.transport = t
+		return p
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// forwarding errors.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(p Handler) Handler {
+		p.log = l
+		return p
+	}
+}
+
+// Upstreams returns the upstream servers.
+func (p Handler) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// Close stops the active health checks.
+func (p Handler) Close() error {
+	if p.stop != nil {
+		p.stop()
+		p.wg.Wait()
+	}
+	return nil
+}
+
+func (p Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.rp.ServeHTTP(w, r)
+	if p.next != nil {
+		p.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (p Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	p.next = nh
+	return p
+}
+
+// pick selects a healthy upstream that has not been tried yet.
+func (p Handler) pick(tried map[*Upstream]bool) *Upstream {
+	n := uint64(len(p.upstreams))
+	switch p.strategy {
+	case LeastConnections:
+		var best *Upstream
+		start := p.counter.Add(1) // breaks ties in turn
+		for i := uint64(0); i < n; i++ {
+			u := p.upstreams[(start+i)%n]
+			if tried[u] || !u.Healthy() {
+				continue
+			}
+			if best == nil || u.Active() < best.Active() {
+				best = u
+			}
+		}
+		return best
+	default:
+		start := p.counter.Add(1) - 1
+		for i := uint64(0); i < n; i++ {
+			u := p.upstreams[(start+i)%n]
+			if !tried[u] && u.Healthy() {
+				return u
+			}
+		}
+		return nil
+	}
+}
+
+func (p Handler) healthChecks(ctx context.Context) {
+	defer p.wg.Done()
+	client := &http.Client{Transport: p.transport, Timeout: p.healthInterval}
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, u := range p.upstreams {
+			wg.Add(1)
+			go func(u *Upstream) {
+				defer wg.Done()
+				u.check(ctx, client, p.healthPath)
+			}(u)
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// transport forwards the requests to the selected upstream, retrying on
+// another one when the connection cannot be established.
+type transport struct {
+	p Handler
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.p
+	in := req.URL
+	host := req.Host
+	tried := make(map[*Upstream]bool)
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		u := p.pick(tried)
+		if u == nil {
+			if err == nil {
+				err = ErrNoUpstream
+			}
+			return nil, err
+		}
+		tried[u] = true
+
+		out := req.Clone(req.Context())
+		out.URL = u.target(in)
+		out.Host = host
+		u.active.Add(1)
+		var res *http.Response
+		res, err = p.transport.RoundTrip(out)
+		if err == nil {
+			res.Body = &trackedBody{ReadCloser: res.Body, u: u}
+			return res, nil
+		}
+		u.active.Add(-1)
+		if !connectError(err) {
+			return nil, err
+		}
+		u.eject(p.ejectFor)
+		if p.log != nil {
+			p.log.Print(err)
+		}
+	}
+	return nil, err
+}
+
+// connectError reports whether the error occurred while connecting to the
+// upstream, in which case the request has not been sent and can be retried.
+func connectError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
+
+// trackedBody decrements the number of requests in flight of an upstream when
+// the response body is closed.
+type trackedBody struct {
+	io.ReadCloser
+	u    *Upstream
+	once sync.Once
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(func() { b.u.active.Add(-1) })
+	return b.ReadCloser.Close()
+}
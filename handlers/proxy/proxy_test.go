@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func backend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			if name == "sick" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		w.Header().Set("X-Backend", name)
+		w.Header().Set("X-Internal", "secret")
+		_, _ = io.WriteString(w, r.Header.Get("X-Gateway")+" "+r.URL.Path)
+	}))
+}
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// closedAddr returns the url of a server that refuses connections.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return "http://" + addr
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b := backend("a"), backend("b")
+	defer a.Close()
+	defer b.Close()
+
+	h := New([]string{a.URL + "/api", b.URL + "/api"}, RequestHeader("X-Gateway", "gw"), ResponseHeader("X-Internal", ""))
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		w := get(t, h, "/users")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 but got %d", w.Code)
+		}
+		if body := w.Body.String(); body != "gw /api/users" {
+			t.Fatalf("Unexpected upstream request: %q", body)
+		}
+		if w.Header().Get("X-Internal") != "" {
+			t.Fatal("Expected the response header to be removed.")
+		}
+		seen[w.Header().Get("X-Backend")]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Fatalf("Expected requests to alternate between upstreams. Got %v", seen)
+	}
+}
+
+func TestRetryOnConnectFailure(t *testing.T) {
+	a := backend("a")
+	defer a.Close()
+
+	h := New([]string{closedAddr(t), a.URL})
+	for i := 0; i < 3; i++ {
+		w := get(t, h, "/")
+		if w.Code != http.StatusOK || w.Header().Get("X-Backend") != "a" {
+			t.Fatalf("Expected the request to be retried on the healthy upstream. Got %d", w.Code)
+		}
+	}
+	if h.Upstreams()[0].Healthy() {
+		t.Fatal("Expected the unreachable upstream to be ejected.")
+	}
+
+	h = New([]string{closedAddr(t)})
+	if w := get(t, h, "/"); w.Code != http.StatusBadGateway {
+		t.Fatalf("Expected status 502 but got %d", w.Code)
+	}
+	if w := get(t, h, "/"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 once every upstream is ejected but got %d", w.Code)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	a, b := backend("a"), backend("b")
+	defer a.Close()
+	defer b.Close()
+
+	h := New([]string{a.URL, b.URL}, WithStrategy(LeastConnections))
+	h.Upstreams()[0].active.Add(5)
+	for i := 0; i < 3; i++ {
+		if w := get(t, h, "/"); w.Header().Get("X-Backend") != "b" {
+			t.Fatalf("Expected the least busy upstream to be selected. Got %q", w.Header().Get("X-Backend"))
+		}
+	}
+	if n := h.Upstreams()[1].Active(); n != 0 {
+		t.Fatalf("Expected no request in flight but got %d", n)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	a, sick := backend("a"), backend("sick")
+	defer a.Close()
+	defer sick.Close()
+
+	h := New([]string{sick.URL, a.URL}, HealthCheck("/health", 10*time.Millisecond))
+	defer h.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for h.Upstreams()[0].Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the failing upstream to be ejected.")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		if w := get(t, h, "/"); w.Header().Get("X-Backend") != "a" {
+			t.Fatalf("Expected the healthy upstream to be selected. Got %q", w.Header().Get("X-Backend"))
+		}
+	}
+}
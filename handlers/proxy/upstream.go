@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream is a server requests can be forwarded to.
+type Upstream struct {
+	URL *url.URL
+
+	active       atomic.Int64 // number of requests in flight
+	down         atomic.Bool  // set by active health checks
+	ejectedUntil atomic.Int64 // set on connection failures, in unix nanoseconds
+}
+
+// Healthy reports whether the upstream may receive requests.
+func (u *Upstream) Healthy() bool {
+	return !u.down.Load() && time.Now().UnixNano() >= u.ejectedUntil.Load()
+}
+
+// Active returns the number of requests being forwarded to the upstream.
+func (u *Upstream) Active() int64 {
+	return u.active.Load()
+}
+
+func (u *Upstream) eject(d time.Duration) {
+	u.ejectedUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+// target returns the url a request should be forwarded to: the upstream path
+// is prepended to the request path.
+func (u *Upstream) target(in *url.URL) *url.URL {
+	out := *u.URL
+	switch {
+	case strings.HasSuffix(out.Path, "/") && strings.HasPrefix(in.Path, "/"):
+		out.Path = out.Path + in.Path[1:]
+	case !strings.HasSuffix(out.Path, "/") && !strings.HasPrefix(in.Path, "/") && out.Path != "":
+		out.Path = out.Path + "/" + in.Path
+	default:
+		out.Path = out.Path + in.Path
+	}
+	out.RawPath = ""
+	switch {
+	case out.RawQuery == "":
+		out.RawQuery = in.RawQuery
+	case in.RawQuery != "":
+		out.RawQuery = out.RawQuery + "&" + in.RawQuery
+	}
+	return &out
+}
+
+// check performs an active health check.
+func (u *Upstream) check(ctx context.Context, client *http.Client, path string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.target(&url.URL{Path: path}).String(), nil)
+	if err != nil {
+		u.down.Store(true)
+		return
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		u.down.Store(true)
+		return
+	}
+	res.Body.Close()
+	u.down.Store(res.StatusCode < 200 || res.StatusCode > 299)
+}
+
+// Strategy defines how an upstream is selected among the healthy ones.
+type Strategy int
+
+const (
+	// RoundRobin selects the upstreams in turn.
+	RoundRobin Strategy = iota
+	// LeastConnections selects the upstream with the fewest requests in flight.
+	LeastConnections
+)
@@ -0,0 +1,190 @@
+// Package ratelimit defines a request handler which limits the rate at which
+// clients can issue requests.
+//
+// Clients are identified by a key (client IP, session ID or any custom key)
+// and the decision is delegated to a Limiter. Two limiters are provided: an
+// in-memory token bucket and a sliding window whose counters are kept in a
+// session.Cache, which allows limits to be shared across server instances.
+//
+// The standard RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers are set on every response. Requests exceeding the limit are rejected
+// with a 429 Too Many Requests status and a Retry-After header.
+package ratelimit
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// ErrLimited is the error fed to the ErrorMapper when a request is rejected
+// because the client exceeded its limit.
+var ErrLimited = errors.New("ratelimit: too many requests")
+
+// KeyFunc returns the key identifying the client issuing a request. If it
+// returns false, the request is not rate limited.
+type KeyFunc func(r *http.Request) (key string, ok bool)
+
+// ByIP identifies clients by their IP address.
+func ByIP(r *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr, r.RemoteAddr != ""
+	}
+	return host, true
+}
+
+// BySession identifies clients by their session ID. Requests without a
+// session are not limited by this KeyFunc: it is typically combined with ByIP
+// using FirstOf.
+// The session handler should be registered ahead of the rate limiter.
+func BySession(s session.Handler) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		id, err := s.ID(r.Context())
+		if err != nil {
+			return "", false
+		}
+		return s.Name + ":" + id, true
+	}
+}
+
+// FirstOf returns a KeyFunc returning the first key found by the provided
+// KeyFuncs.
+func FirstOf(kfs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, kf := range kfs {
+			if k, ok := kf(r); ok {
+				return k, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Handler is the rate limiting request handler.
+type Handler struct {
+	Limiter    Limiter
+	Key        KeyFunc
+	FailClosed bool
+	Log        *log.Logger
+
+	// ErrorMapper, if set, writes the error responses instead of http.Error.
+	ErrorMapper xhttp.ErrorMapper
+
+	next xhttp.Handler
+}
+
+// New returns a rate limiting request handler. Clients are identified by IP
+// unless specified otherwise with the WithKey option.
+func New(l Limiter, options ...func(Handler) Handler) Handler {
+	if l == nil {
+		panic("ratelimit: nil Limiter")
+	}
+	h := Handler{
+		Limiter: l,
+		Key:     ByIP,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// WithKey is a configuration option which sets the function used to identify
+// clients.
+func WithKey(kf KeyFunc) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Key = kf
+		return h
+	}
+}
+
+// WithFailClosed is a configuration option which makes the handler reject
+// requests with a 503 Service Unavailable status when the Limiter fails.
+// By default, requests are let through.
+func WithFailClosed() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.FailClosed = true
+		return h
+	}
+}
+
+// WithErrorMapper is a configuration option which sets the ErrorMapper writing
+// the error responses, e.g. xhttp.ProblemErrorMapper. Rejected requests are
+// mapped from an xhttp.Error with a 429 status wrapping ErrLimited.
+func WithErrorMapper(m xhttp.ErrorMapper) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.ErrorMapper = m
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// Limiter failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Log = l
+		return h
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.Key(r)
+	if ok {
+		res, err := h.Limiter.Allow(r.Context(), key)
+		if err != nil {
+			if h.Log != nil {
+				h.Log.Print(err)
+			}
+			if h.FailClosed {
+				h.fail(w, r, xhttp.NewError(http.StatusServiceUnavailable, err))
+				return
+			}
+		} else {
+			hdr := w.Header()
+			hdr.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			hdr.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			hdr.Set("RateLimit-Reset", seconds(res.Reset))
+			if !res.Allowed {
+				hdr.Set("Retry-After", seconds(res.RetryAfter))
+				h.fail(w, r, xhttp.NewError(http.StatusTooManyRequests, ErrLimited))
+				return
+			}
+		}
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+func (h Handler) fail(w http.ResponseWriter, r *http.Request, err xhttp.Error) {
+	if h.ErrorMapper != nil {
+		h.ErrorMapper(w, r, err)
+		return
+	}
+	http.Error(w, http.StatusText(err.Status), err.Status)
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// seconds rounds a duration up to the second.
+func seconds(d time.Duration) string {
+	s := int64(d / time.Second)
+	if d%time.Second > 0 {
+		s++
+	}
+	return strconv.FormatInt(s, 10)
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+type mapCache struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (c *mapCache) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[id+"/"+hkey]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return v, nil
+}
+
+func (c *mapCache) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[id+"/"+hkey] = content
+	return nil
+}
+
+func (c *mapCache) Delete(ctx context.Context, id string, hkey string) error { return nil }
+func (c *mapCache) Clear() error                                             { return nil }
+func (c *mapCache) ClearAfter(t time.Duration) error                         { return nil }
+
+func serve(t *testing.T, mux xhttp.ServeMux, ip string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tb := NewTokenBucket(1, time.Second, 2)
+	tb.now = func() time.Time { return now }
+
+	mux := xhttp.NewServeMux()
+	mux.USE(New(tb))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 2; i++ {
+		if w := serve(t, mux, "192.0.2.1"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d should have been allowed. Got %d", i, w.Code)
+		}
+	}
+	w := serve(t, mux, "192.0.2.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 but got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("Unexpected headers %v", w.Header())
+	}
+	if w := serve(t, mux, "192.0.2.2"); w.Code != http.StatusOK {
+		t.Fatalf("Clients should be limited independently. Got %d", w.Code)
+	}
+
+	now = now.Add(time.Second)
+	if w := serve(t, mux, "192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("The bucket should have been refilled. Got %d", w.Code)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	sw := NewSlidingWindow(&mapCache{m: make(map[string][]byte)}, "ratelimit", 3, time.Minute)
+	sw.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		res, err := sw.Allow(ctx, "k")
+		if err != nil || !res.Allowed {
+			t.Fatalf("Request %d should have been allowed: %+v %v", i, res, err)
+		}
+		if res.Remaining != 2-i {
+			t.Fatalf("Expected %d remaining requests, got %d", 2-i, res.Remaining)
+		}
+	}
+	res, _ := sw.Allow(ctx, "k")
+	if res.Allowed || res.RetryAfter <= 0 {
+		t.Fatalf("Expected the request to be denied: %+v", res)
+	}
+
+	// Halfway through the next window, the previous window still weighs 1.5
+	// requests, so one request is allowed.
+	now = now.Add(time.Minute + time.Minute/2 - time.Duration(now.UnixNano()%int64(time.Minute)))
+	if res, _ := sw.Allow(ctx, "k"); !res.Allowed {
+		t.Fatalf("Expected the request to be allowed: %+v", res)
+	}
+	if res, _ := sw.Allow(ctx, "k"); res.Allowed {
+		t.Fatalf("Expected the request to be denied: %+v", res)
+	}
+}
@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// Result describes the outcome of a rate limiting decision.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // time until the quota is fully replenished
+	RetryAfter time.Duration // time until a denied request may be retried
+}
+
+// Limiter is the interface implemented by rate limiting algorithms.
+// It should be made safe for concurrent use.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// TokenBucket is an in-memory token bucket Limiter. Every key is assigned a
+// bucket holding up to burst tokens, refilled at a constant rate. A request
+// consumes one token.
+//
+// Since buckets are held in process memory, the limits are enforced per
+// server instance.
+type TokenBucket struct {
+	rate  float64 // tokens per second
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns an in-memory Limiter allowing n requests per period
+// on average, with bursts of up to burst requests.
+func NewTokenBucket(n int, per time.Duration, burst int) *TokenBucket {
+	if n <= 0 || per <= 0 || burst <= 0 {
+		panic("ratelimit: token bucket rate and burst must be positive")
+	}
+	return &TokenBucket{
+		rate:    float64(n) / per.Seconds(),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow consumes a token from the bucket assigned to key, if any is left.
+func (tb *TokenBucket) Allow(ctx context.Context, key string) (Result, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	tb.sweep(now)
+
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(tb.burst), last: now}
+		tb.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(tb.burst), b.tokens+now.Sub(b.last).Seconds()*tb.rate)
+	b.last = now
+
+	res := Result{Limit: tb.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = tb.duration(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = tb.duration(float64(tb.burst) - b.tokens)
+	return res, nil
+}
+
+func (tb *TokenBucket) duration(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / tb.rate * float64(time.Second)))
+}
+
+// sweep discards the buckets that have been refilled completely, as they are
+// indistinguishable from new ones. It runs at most once per refill period.
+func (tb *TokenBucket) sweep(now time.Time) {
+	full := tb.duration(float64(tb.burst))
+	if now.Sub(tb.lastSweep) < full {
+		return
+	}
+	for k, b := range tb.buckets {
+		if now.Sub(b.last) >= full {
+			delete(tb.buckets, k)
+		}
+	}
+	tb.lastSweep = now
+}
+
+// SlidingWindow is a Limiter which stores request counters in a session.Cache
+// so that limits can be shared by several server instances, for instance by
+// using a Redis backed cache.
+//
+// It implements the sliding window counter algorithm: the number of requests
+// in the last window is estimated from the counts of the current and previous
+// fixed windows, weighted by their overlap.
+//
+// As the Cache interface does not provide atomic increments, concurrent
+// requests for the same key may occasionally be under-counted.
+type SlidingWindow struct {
+	cache     session.Cache
+	namespace string
+	limit     int
+	window    time.Duration
+	now       func() time.Time
+}
+
+// NewSlidingWindow returns a Limiter allowing up to limit requests per window.
+// Counters are stored in the cache under the namespace id.
+func NewSlidingWindow(c session.Cache, namespace string, limit int, window time.Duration) SlidingWindow {
+	if c == nil {
+		panic("ratelimit: nil cache")
+	}
+	if limit <= 0 || window <= 0 {
+		panic("ratelimit: limit and window must be positive")
+	}
+	return SlidingWindow{
+		cache:     c,
+		namespace: namespace,
+		limit:     limit,
+		window:    window,
+		now:       time.Now,
+	}
+}
+
+// Allow increments the request counter of key unless the limit is reached.
+// Counters that cannot be retrieved from the cache are considered null.
+func (sw SlidingWindow) Allow(ctx context.Context, key string) (Result, error) {
+	now := sw.now()
+	idx := now.UnixNano() / int64(sw.window)
+	elapsed := time.Duration(now.UnixNano() - idx*int64(sw.window))
+
+	curr := sw.count(ctx, key, idx)
+	prev := sw.count(ctx, key, idx-1)
+	weight := 1 - float64(elapsed)/float64(sw.window)
+	estimate := float64(prev)*weight + float64(curr)
+
+	res := Result{
+		Limit: sw.limit,
+		Reset: sw.window - elapsed,
+	}
+	if estimate+1 > float64(sw.limit) {
+		res.RetryAfter = sw.window - elapsed
+		if curr < sw.limit && prev > 0 {
+			// The previous window weight decreases over time: compute when the
+			// estimate drops enough for a request to be accepted.
+			w := (float64(sw.limit-curr) - 1) / float64(prev)
+			if w > 0 {
+				res.RetryAfter = time.Duration((weight - w) * float64(sw.window))
+			}
+		}
+		return res, nil
+	}
+
+	err := sw.cache.Put(ctx, sw.namespace, sw.hkey(key, idx), []byte(strconv.Itoa(curr+1)), 2*sw.window)
+	if err != nil {
+		return res, err
+	}
+	res.Allowed = true
+	res.Remaining = sw.limit - int(math.Ceil(estimate+1))
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return res, nil
+}
+
+func (sw SlidingWindow) hkey(key string, idx int64) string {
+	return key + "/" + strconv.FormatInt(idx, 10)
+}
+
+func (sw SlidingWindow) count(ctx context.Context, key string, idx int64) int {
+	b, err := sw.cache.Get(ctx, sw.namespace, sw.hkey(key, idx))
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0
+	}
+	return n
+}
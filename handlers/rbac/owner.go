@@ -0,0 +1,136 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/atdiar/xhttp"
+)
+
+// ErrNoUser is returned by user identification functions when the request is
+// not authenticated.
+var ErrNoUser = errors.New("rbac: no authenticated user")
+
+type grantedKey struct{}
+
+// granted records the names of the roles granted in a request context.
+func granted(ctx context.Context, roles ...Role) context.Context {
+	prev, _ := ctx.Value(grantedKey{}).(map[string]bool)
+	m := make(map[string]bool, len(prev)+len(roles))
+	for k := range prev {
+		m[k] = true
+	}
+	for _, r := range roles {
+		m[r.Name] = true
+	}
+	return context.WithValue(ctx, grantedKey{}, m)
+}
+
+// HasRole reports whether a role of the given name has been assigned or
+// checked by a RoleList or an Enforcer earlier in the handling of the request.
+func HasRole(r *http.Request, name string) bool {
+	m, _ := r.Context().Value(grantedKey{}).(map[string]bool)
+	return m[name]
+}
+
+// PathParam returns an owner ID extractor which retrieves the URL parameter
+// of the given name, the URL matching pattern as defined by xhttp.PathMatch.
+func PathParam(pattern string, name string) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		vars := make(map[string]string)
+		if !xhttp.PathMatch(r, pattern, vars) || vars[name] == "" {
+			return "", xhttp.NewError(http.StatusNotFound, errors.New("rbac: no "+name+" parameter in path"))
+		}
+		return vars[name], nil
+	}
+}
+
+// OwnerEnforcer is a xhttp handler that grants access to a resource to its
+// owner or to the holders of some roles.
+type OwnerEnforcer struct {
+	Roles []string
+
+	// Owner returns the ID of the owner of the requested resource, e.g. from
+	// a path parameter or a database lookup. If the returned error carries a
+	// status code, e.g. a 404 via xhttp.NewError, it is used for the response.
+	Owner func(r *http.Request) (string, error)
+
+	// User returns the ID of the user making the request.
+	User func(r *http.Request) (string, error)
+
+	// CheckRole reports whether the user making the request holds the named
+	// role. HasRole is used by default.
+	CheckRole func(r *http.Request, role string) bool
+
+	next xhttp.Handler
+}
+
+// RequireOwnerOr returns a handler which permits the request when the user
+// is the owner of the requested resource or holds one of the listed roles.
+// The owner and user identification functions must be provided with
+// OwnedBy and Identify.
+func RequireOwnerOr(roles ...string) OwnerEnforcer {
+	return OwnerEnforcer{Roles: roles, CheckRole: HasRole}
+}
+
+// OwnedBy sets the function which extracts the owner ID of a resource.
+func (o OwnerEnforcer) OwnedBy(owner func(r *http.Request) (string, error)) OwnerEnforcer {
+	o.Owner = owner
+	return o
+}
+
+// Identify sets the function which returns the ID of the user making the
+// request. It should return ErrNoUser for unauthenticated requests.
+func (o OwnerEnforcer) Identify(user func(r *http.Request) (string, error)) OwnerEnforcer {
+	o.User = user
+	return o
+}
+
+// WithRoleChecker sets the function used to check the roles of the user.
+func (o OwnerEnforcer) WithRoleChecker(check func(r *http.Request, role string) bool) OwnerEnforcer {
+	o.CheckRole = check
+	return o
+}
+
+func (o OwnerEnforcer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if o.Owner == nil || o.User == nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if o.allowed(w, r) && o.next != nil {
+		o.next.ServeHTTP(w, r)
+	}
+}
+
+// allowed checks the roles first so that privileged users do not incur an
+// owner lookup. It writes the error response when access is denied.
+func (o OwnerEnforcer) allowed(w http.ResponseWriter, r *http.Request) bool {
+	if o.CheckRole != nil {
+		for _, role := range o.Roles {
+			if o.CheckRole(r, role) {
+				return true
+			}
+		}
+	}
+	user, err := o.User(r)
+	if err != nil || user == "" {
+		http.Error(w, "Access Denied, authentication required.", http.StatusUnauthorized)
+		return false
+	}
+	owner, err := o.Owner(r)
+	if err != nil {
+		xhttp.DefaultErrorMapper(w, r, err)
+		return false
+	}
+	if owner != user {
+		http.Error(w, "Access Denied, not the owner of the resource.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (o OwnerEnforcer) Link(hn xhttp.Handler) xhttp.HandlerLinker {
+	o.next = hn
+	return o
+}
@@ -106,6 +106,7 @@ func (rl RoleList) ServeHTTP( w http.ResponseWriter, req *http.Request) {
 			return
 		}
 		ctx = context.WithValue(ctx, r.ContextKey, r)
+		ctx = granted(ctx, r)
 	}
 	req = req.WithContext(ctx)
 	if rl.next != nil {
@@ -144,6 +145,7 @@ func (e Enforcer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		ctx = context.WithValue(ctx, role.ContextKey, role)
+		ctx = granted(ctx, role)
 	}
 	r = r.WithContext(ctx)
 	if e.next != nil {
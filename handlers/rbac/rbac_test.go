@@ -67,6 +67,7 @@ func AssignRoleToUserFn(s session.Handler) func(http.ResponseWriter, *http.Reque
 		if err != nil {
 			return err
 		}
+		req = s.Attach(req)
 		err = s.Load(w, req)
 		if err != nil {
 			return err
@@ -107,6 +108,7 @@ func AssertUserHasRoleFn(s session.Handler) func(http.ResponseWriter, *http.Requ
 	return func(w http.ResponseWriter, req *http.Request, r Role) error {
 
 		// first, we try to retrieve the session
+		req = s.Attach(req)
 		err := s.Load(w, req)
 		if err != nil {
 			return errors.New("unable to retrieve session in order to check user roles.").Wraps(err)
@@ -151,12 +153,13 @@ func Multiplexer(t *testing.T) (xhttp.ServeMux, session.Handler) {
 	// this route is used to set user roles which should be persisted somewhere
 	// for check on the role protected routes.
 	mux.GET("/setroles", roles123.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = s.Attach(r)
 		err := s.Load(w, r)
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-		id, _ := s.Cookie.ID()
+		id, _ := s.ID(r.Context())
 		if id != sessionid {
 			http.Error(w, "ID MISMATCH", http.StatusInternalServerError)
 			return
@@ -238,3 +241,55 @@ func TestRBAC(t *testing.T) {
 
 // NOTE this example implemenetation uses session storage as a backend for simplicity's sake.
 // Ideally, we should have the Roles and the roles assignments persisted in the database.
+
+func TestRequireOwnerOr(t *testing.T) {
+	user := func(r *http.Request) (string, error) {
+		if u := r.Header.Get("X-User"); u != "" {
+			return u, nil
+		}
+		return "", ErrNoUser
+	}
+	admin := NewRole("admin", "admin", 0)
+	isAdmin := func(w http.ResponseWriter, r *http.Request, role Role) error {
+		if r.Header.Get("X-User") != "root" {
+			return errors.New("not an admin")
+		}
+		return nil
+	}
+	h := RequireOwnerOr("admin").OwnedBy(PathParam("/docs/:owner", "owner")).Identify(user).Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("allowed"))
+	}))
+	h = Enforce(NewRoleList(nil), isAdmin).Link(h)
+
+	tcs := []struct {
+		user   string
+		path   string
+		status int
+	}{
+		{"alice", "/docs/alice", http.StatusOK},
+		{"bob", "/docs/alice", http.StatusForbidden},
+		{"", "/docs/alice", http.StatusUnauthorized},
+		{"alice", "/docs/", http.StatusNotFound},
+	}
+	for _, tc := range tcs {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s on %s: expected status %d but got %d", tc.user, tc.path, tc.status, w.Code)
+		}
+	}
+
+	// A user holding one of the roles is allowed whoever the owner is.
+	h = Enforce(NewRoleList(nil, admin), isAdmin).Link(h)
+	req := httptest.NewRequest("GET", "/docs/alice", nil)
+	req.Header.Set("X-User", "root")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the admin to be allowed but got status %d", w.Code)
+	}
+}
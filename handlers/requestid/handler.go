@@ -0,0 +1,146 @@
+// Package requestid defines a request handler which assigns an identifier to
+// every http request so that log entries, error reports and session data
+// pertaining to the same request can be correlated, even across services.
+//
+// The identifier is read from the incoming request header if a valid one is
+// present, generated otherwise. It is stored in the request context and echoed
+// in the response header.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/atdiar/xhttp"
+)
+
+// DefaultHeader is the name of the header carrying the request identifier.
+const DefaultHeader = "X-Request-ID"
+
+// MaxLength is the maximum length of an incoming request identifier.
+// Longer identifiers are replaced by a newly generated one.
+const MaxLength = 128
+
+type contextKey struct{}
+
+// FromContext returns the request identifier stored in the context, if any.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// NewContext returns a copy of the parent context which holds the request
+// identifier.
+func NewContext(parent context.Context, id string) context.Context {
+	return context.WithValue(parent, contextKey{}, id)
+}
+
+// Handler is the request identifier handler.
+type Handler struct {
+	Header    string
+	Generate  func() string
+	Untrusted bool
+
+	next xhttp.Handler
+}
+
+// New returns a request handler which reads or generates a request identifier.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		Header:   DefaultHeader,
+		Generate: Random,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// WithHeader is a configuration option which changes the name of the header
+// carrying the request identifier.
+func WithHeader(name string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Header = name
+		return h
+	}
+}
+
+// WithGenerator is a configuration option which changes the function used to
+// generate new request identifiers.
+func WithGenerator(fn func() string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Generate = fn
+		return h
+	}
+}
+
+// IgnoreIncoming is a configuration option which makes the handler always
+// generate a new identifier. It should be used when the server is directly
+// exposed to clients that cannot be trusted to provide one.
+func IgnoreIncoming() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Untrusted = true
+		return h
+	}
+}
+
+// Random returns a random 128 bit identifier, hex-encoded.
+func Random() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := h.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+	var id string
+	if !h.Untrusted {
+		id = r.Header.Get(header)
+		if !valid(id) {
+			id = ""
+		}
+	}
+	if id == "" {
+		if h.Generate != nil {
+			id = h.Generate()
+		} else {
+			id = Random()
+		}
+	}
+	w.Header().Set(header, id)
+	r = r.WithContext(NewContext(r.Context(), id))
+
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// valid reports whether an incoming identifier is acceptable: it must be
+// made of printable ASCII characters only, so that it can safely be logged.
+func valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
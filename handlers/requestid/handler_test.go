@@ -0,0 +1,49 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	mux := xhttp.NewServeMux()
+	mux.USE(New())
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+
+	tcs := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{"generated", "", false},
+		{"propagated", "upstream-1234", true},
+		{"invalid", "bad id\n", false},
+		{"too long", strings.Repeat("a", MaxLength+1), false},
+	}
+	for _, tc := range tcs {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.incoming != "" {
+			req.Header.Set(DefaultHeader, tc.incoming)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		echoed := w.Header().Get(DefaultHeader)
+		if echoed == "" || echoed != seen {
+			t.Fatalf("%s: expected the request id %q to be echoed but got %q", tc.name, seen, echoed)
+		}
+		if (echoed == tc.incoming) != tc.reused {
+			t.Fatalf("%s: unexpected request id %q", tc.name, echoed)
+		}
+	}
+}
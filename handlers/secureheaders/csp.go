@@ -0,0 +1,142 @@
+package secureheaders
+
+import (
+	"strings"
+)
+
+// NonceSource is a placeholder source expression which is replaced, for each
+// request, by the 'nonce-<value>' source expression corresponding to the
+// nonce generated for the request.
+const NonceSource = "'nonce'"
+
+// Common source expressions.
+const (
+	Self          = "'self'"
+	None          = "'none'"
+	UnsafeInline  = "'unsafe-inline'"
+	UnsafeEval    = "'unsafe-eval'"
+	StrictDynamic = "'strict-dynamic'"
+)
+
+type directive struct {
+	name    string
+	sources []string
+}
+
+// Policy is used to build the value of a Content-Security-Policy header.
+// A Policy is immutable: every method returns a modified copy so that a base
+// policy can be shared and refined for different groups of routes.
+type Policy struct {
+	directives []directive
+}
+
+// NewPolicy returns an empty Content-Security-Policy.
+func NewPolicy() Policy {
+	return Policy{}
+}
+
+// Add sets the list of sources for the given directive, replacing any sources
+// previously set for it.
+func (p Policy) Add(name string, sources ...string) Policy {
+	d := make([]directive, 0, len(p.directives)+1)
+	replaced := false
+	for _, v := range p.directives {
+		if v.name == name {
+			v = directive{name, sources}
+			replaced = true
+		}
+		d = append(d, v)
+	}
+	if !replaced {
+		d = append(d, directive{name, sources})
+	}
+	p.directives = d
+	return p
+}
+
+// Has reports whether the policy holds the given directive.
+func (p Policy) Has(name string) bool {
+	for _, v := range p.directives {
+		if v.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSrc sets the default-src directive.
+func (p Policy) DefaultSrc(sources ...string) Policy { return p.Add("default-src", sources...) }
+
+// ScriptSrc sets the script-src directive.
+func (p Policy) ScriptSrc(sources ...string) Policy { return p.Add("script-src", sources...) }
+
+// StyleSrc sets the style-src directive.
+func (p Policy) StyleSrc(sources ...string) Policy { return p.Add("style-src", sources...) }
+
+// ImgSrc sets the img-src directive.
+func (p Policy) ImgSrc(sources ...string) Policy { return p.Add("img-src", sources...) }
+
+// ConnectSrc sets the connect-src directive.
+func (p Policy) ConnectSrc(sources ...string) Policy { return p.Add("connect-src", sources...) }
+
+// FontSrc sets the font-src directive.
+func (p Policy) FontSrc(sources ...string) Policy { return p.Add("font-src", sources...) }
+
+// MediaSrc sets the media-src directive.
+func (p Policy) MediaSrc(sources ...string) Policy { return p.Add("media-src", sources...) }
+
+// ObjectSrc sets the object-src directive.
+func (p Policy) ObjectSrc(sources ...string) Policy { return p.Add("object-src", sources...) }
+
+// FrameSrc sets the frame-src directive.
+func (p Policy) FrameSrc(sources ...string) Policy { return p.Add("frame-src", sources...) }
+
+// FrameAncestors sets the frame-ancestors directive.
+func (p Policy) FrameAncestors(sources ...string) Policy { return p.Add("frame-ancestors", sources...) }
+
+// BaseURI sets the base-uri directive.
+func (p Policy) BaseURI(sources ...string) Policy { return p.Add("base-uri", sources...) }
+
+// FormAction sets the form-action directive.
+func (p Policy) FormAction(sources ...string) Policy { return p.Add("form-action", sources...) }
+
+// ReportURI sets the report-uri directive.
+func (p Policy) ReportURI(uri string) Policy { return p.Add("report-uri", uri) }
+
+// UpgradeInsecureRequests adds the upgrade-insecure-requests directive.
+func (p Policy) UpgradeInsecureRequests() Policy { return p.Add("upgrade-insecure-requests") }
+
+// usesNonce reports whether a nonce needs to be generated per request.
+func (p Policy) usesNonce() bool {
+	for _, d := range p.directives {
+		for _, s := range d.sources {
+			if s == NonceSource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String returns the header value of the policy, substituting the NonceSource
+// placeholder with the provided nonce.
+func (p Policy) String(nonce string) string {
+	var b strings.Builder
+	for i, d := range p.directives {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(d.name)
+		for _, s := range d.sources {
+			if s == NonceSource {
+				if nonce == "" {
+					continue
+				}
+				s = "'nonce-" + nonce + "'"
+			}
+			b.WriteString(" ")
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
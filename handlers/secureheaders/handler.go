@@ -0,0 +1,189 @@
+// Package secureheaders defines a request handler which sets the response
+// headers commonly used to harden web applications:
+// Content-Security-Policy, X-Content-Type-Options, X-Frame-Options,
+// Referrer-Policy and Permissions-Policy.
+//
+// Strict-Transport-Security is handled separately by the hsts package.
+package secureheaders
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/atdiar/xhttp"
+)
+
+type contextKey struct{}
+
+var nonceKey contextKey
+
+// Handler sets security related headers on every response.
+// A Handler can be derived into differently configured copies via Configure,
+// which allows for one configuration per group of routes.
+type Handler struct {
+	csp               *Policy
+	cspReportOnly     bool
+	noSniff           bool
+	frameOptions      string
+	referrerPolicy    string
+	permissionsPolicy string
+	next              xhttp.Handler
+}
+
+// New returns a request handler setting security headers.
+// By default, the following headers are set:
+// * X-Content-Type-Options: nosniff
+// * X-Frame-Options: DENY
+// * Referrer-Policy: strict-origin-when-cross-origin
+//
+// No Content-Security-Policy is set unless one is provided via the CSP option.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		noSniff:        true,
+		frameOptions:   "DENY",
+		referrerPolicy: "strict-origin-when-cross-origin",
+	}
+	return h.Configure(options...)
+}
+
+// Configure returns a copy of the handler with additional options applied.
+func (h Handler) Configure(options ...func(Handler) Handler) Handler {
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// CSP sets the Content-Security-Policy of the responses.
+func CSP(p Policy) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.csp = &p
+		h.cspReportOnly = false
+		return h
+	}
+}
+
+// CSPReportOnly sets a Content-Security-Policy-Report-Only header instead of
+// an enforced policy.
+func CSPReportOnly(p Policy) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.csp = &p
+		h.cspReportOnly = true
+		return h
+	}
+}
+
+// NoSniff toggles the X-Content-Type-Options: nosniff header.
+func NoSniff(b bool) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.noSniff = b
+		return h
+	}
+}
+
+// FrameOptions sets the X-Frame-Options header. Valid values are "DENY",
+// "SAMEORIGIN" and "" (header omitted).
+// When a Content-Security-Policy without frame-ancestors directive is in use,
+// the equivalent frame-ancestors directive is added to it.
+func FrameOptions(v string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		v = strings.ToUpper(v)
+		if v != "" && v != "DENY" && v != "SAMEORIGIN" {
+			panic("secureheaders: invalid X-Frame-Options value " + v)
+		}
+		h.frameOptions = v
+		return h
+	}
+}
+
+// ReferrerPolicy sets the Referrer-Policy header. An empty string omits the
+// header.
+func ReferrerPolicy(v string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.referrerPolicy = v
+		return h
+	}
+}
+
+// PermissionsPolicy sets the Permissions-Policy header from a list of policy
+// directives such as `geolocation=()` or `camera=(self)`.
+func PermissionsPolicy(directives ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.permissionsPolicy = strings.Join(directives, ", ")
+		return h
+	}
+}
+
+// Nonce returns the Content-Security-Policy nonce generated for the request,
+// if any. It is typically used in templates to mark inline scripts and styles.
+func Nonce(r *http.Request) string {
+	n, _ := r.Context().Value(nonceKey).(string)
+	return n
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	if h.noSniff {
+		header.Set("X-Content-Type-Options", "nosniff")
+	}
+	if h.frameOptions != "" {
+		header.Set("X-Frame-Options", h.frameOptions)
+	}
+	if h.referrerPolicy != "" {
+		header.Set("Referrer-Policy", h.referrerPolicy)
+	}
+	if h.permissionsPolicy != "" {
+		header.Set("Permissions-Policy", h.permissionsPolicy)
+	}
+	if h.csp != nil {
+		p := *h.csp
+		if h.frameOptions != "" && !p.Has("frame-ancestors") {
+			if h.frameOptions == "DENY" {
+				p = p.FrameAncestors(None)
+			} else {
+				p = p.FrameAncestors(Self)
+			}
+		}
+		var nonce string
+		if p.usesNonce() {
+			n, err := generateNonce(16)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			nonce = n
+			r = r.WithContext(context.WithValue(r.Context(), nonceKey, nonce))
+		}
+		name := "Content-Security-Policy"
+		if h.cspReportOnly {
+			name = "Content-Security-Policy-Report-Only"
+		}
+		header.Set(name, p.String(nonce))
+	}
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// generateNonce returns a base64 encoded cryptographically secure random
+// value of the given length in bytes.
+func generateNonce(length int) (string, error) {
+	b := make([]byte, length)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
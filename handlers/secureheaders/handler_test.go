@@ -0,0 +1,63 @@
+package secureheaders
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestHeaders(t *testing.T) {
+	var nonce string
+	h := New(
+		CSP(NewPolicy().DefaultSrc(Self).ScriptSrc(Self, NonceSource)),
+		PermissionsPolicy("geolocation=()", "camera=()"),
+	)
+
+	mux := xhttp.NewServeMux()
+	mux.USE(h)
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = Nonce(r)
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if nonce == "" {
+		t.Fatal("Expected a nonce to be available to downstream handlers.")
+	}
+	csp := w.Header().Get("Content-Security-Policy")
+	want := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'; frame-ancestors 'none'"
+	if csp != want {
+		t.Fatalf("Expected: %v but got: %v \n", want, csp)
+	}
+	if v := w.Header().Get("X-Content-Type-Options"); v != "nosniff" {
+		t.Errorf("Expected nosniff but got %v", v)
+	}
+	if v := w.Header().Get("X-Frame-Options"); v != "DENY" {
+		t.Errorf("Expected DENY but got %v", v)
+	}
+	if v := w.Header().Get("Permissions-Policy"); v != "geolocation=(), camera=()" {
+		t.Errorf("Unexpected Permissions-Policy %v", v)
+	}
+
+	// A derived configuration must not alter the original one.
+	public := h.Configure(FrameOptions("SAMEORIGIN"), ReferrerPolicy("no-referrer"))
+	w = httptest.NewRecorder()
+	public.ServeHTTP(w, req)
+	if v := w.Header().Get("Referrer-Policy"); v != "no-referrer" {
+		t.Errorf("Expected no-referrer but got %v", v)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.HasSuffix(csp, "frame-ancestors 'self'") {
+		t.Errorf("Unexpected policy %v", csp)
+	}
+	if h.frameOptions != "DENY" {
+		t.Error("Configure modified the original handler.")
+	}
+}
@@ -0,0 +1,229 @@
+// Package servertiming defines a request handler emitting a Server-Timing
+// response header, which browsers display in their developer tools.
+//
+// Downstream handlers contribute named timings (session loading, store calls,
+// template rendering...) via the request context:
+//
+//	defer servertiming.Start(r.Context(), "db", "orders query")()
+package servertiming
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+// Metric is a named timing of a request.
+type Metric struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// String returns the Server-Timing header representation of the metric.
+func (m Metric) String() string {
+	var b strings.Builder
+	b.WriteString(token(m.Name))
+	if m.Duration > 0 {
+		b.WriteString(";dur=")
+		b.WriteString(strconv.FormatFloat(float64(m.Duration)/float64(time.Millisecond), 'f', -1, 64))
+	}
+	if m.Description != "" {
+		b.WriteString(";desc=")
+		b.WriteString(strconv.Quote(m.Description))
+	}
+	return b.String()
+}
+
+// token replaces the characters that are not allowed in a metric name.
+func token(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 0x20 && r < 0x7f && !strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Timings collects the metrics of a request. It is safe for concurrent use.
+type Timings struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+// Add records a metric.
+func (t *Timings) Add(name string, d time.Duration, description string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.metrics = append(t.metrics, Metric{name, d, description})
+	t.mu.Unlock()
+}
+
+// Metrics returns the metrics recorded so far.
+func (t *Timings) Metrics() []Metric {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Metric(nil), t.metrics...)
+}
+
+type contextKey struct{}
+
+// FromContext returns the Timings of a request, or nil if the request is not
+// instrumented. A nil *Timings discards the metrics.
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(contextKey{}).(*Timings)
+	return t
+}
+
+// Add records a metric for the request the context belongs to.
+func Add(ctx context.Context, name string, d time.Duration, description string) {
+	FromContext(ctx).Add(name, d, description)
+}
+
+// Start starts timing an operation. The returned function records the metric
+// when called; it is typically deferred.
+func Start(ctx context.Context, name string, description string) (stop func()) {
+	t := FromContext(ctx)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() { t.Add(name, time.Since(start), description) })
+	}
+}
+
+// Handler emits the Server-Timing header.
+type Handler struct {
+	enabled func(r *http.Request) bool
+	total   bool
+	next    xhttp.Handler
+}
+
+// New returns a request handler which collects the timings contributed by the
+// downstream handlers and emits them in a Server-Timing header.
+func New(options ...func(Handler) Handler) Handler {
+	h := Handler{
+		total: true,
+		next:  nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	return h
+}
+
+// Enabled is a configuration option which restricts the emission of the header
+// to some requests, e.g. from administrators, since timings may disclose
+// information about the internals of the application.
+// The context helpers are no-ops for the other requests.
+func Enabled(fn func(r *http.Request) bool) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.enabled = fn
+		return h
+	}
+}
+
+// NoTotal is a configuration option which removes the "total" metric,
+// measuring the time elapsed until the response header is written.
+func NoTotal() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.total = false
+		return h
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.next == nil {
+		return
+	}
+	if h.enabled != nil && !h.enabled(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	t := &Timings{}
+	tw := &timingWriter{ResponseWriter: w, timings: t, start: time.Now(), total: h.total}
+	h.next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), contextKey{}, t)))
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+// timingWriter sets the Server-Timing header right before the response header
+// is written, as it cannot be modified afterwards.
+type timingWriter struct {
+	http.ResponseWriter
+	timings     *Timings
+	start       time.Time
+	total       bool
+	wroteHeader bool
+}
+
+func (tw *timingWriter) writeTimings() {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	metrics := tw.timings.Metrics()
+	if tw.total {
+		metrics = append(metrics, Metric{Name: "total", Duration: time.Since(tw.start)})
+	}
+	if len(metrics) == 0 {
+		return
+	}
+	values := make([]string, len(metrics))
+	for i, m := range metrics {
+		values[i] = m.String()
+	}
+	tw.Header().Add("Server-Timing", strings.Join(values, ", "))
+}
+
+func (tw *timingWriter) WriteHeader(code int) {
+	tw.writeTimings()
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	tw.writeTimings()
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timingWriter) ReadFrom(r io.Reader) (int64, error) {
+	tw.writeTimings()
+	return xhttp.ReadFrom(tw.ResponseWriter, r)
+}
+
+func (tw *timingWriter) FlushError() error {
+	tw.writeTimings()
+	return xhttp.Flush(tw.ResponseWriter)
+}
+
+func (tw *timingWriter) Flush() { tw.FlushError() }
+
+func (tw *timingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return xhttp.Hijack(tw.ResponseWriter)
+}
+
+func (tw *timingWriter) Wrappee() http.ResponseWriter { return tw.ResponseWriter }
+
+func (tw *timingWriter) Unwrap() http.ResponseWriter { return tw.ResponseWriter }
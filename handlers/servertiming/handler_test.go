@@ -0,0 +1,41 @@
+package servertiming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestHandler(t *testing.T) {
+	mux := xhttp.NewServeMux()
+	mux.USE(New(Enabled(func(r *http.Request) bool { return r.Header.Get("X-Debug") != "" })))
+	mux.GET("/", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stop := Start(r.Context(), "db", "orders query")
+		time.Sleep(2 * time.Millisecond)
+		stop()
+		Add(r.Context(), "cache", 0, "hit")
+		w.Write([]byte("ok"))
+	}))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if v := w.Header().Get("Server-Timing"); v != "" {
+		t.Fatalf("Expected no header for regular requests but got %q", v)
+	}
+
+	req.Header.Set("X-Debug", "1")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	v := w.Header().Get("Server-Timing")
+	if !regexp.MustCompile(`^db;dur=[0-9.]+;desc="orders query", cache;desc="hit", total;dur=[0-9.]+$`).MatchString(v) {
+		t.Fatalf("Unexpected Server-Timing header %q", v)
+	}
+}
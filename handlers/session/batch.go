@@ -0,0 +1,177 @@
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// Change is a buffered modification of a session value.
+type Change struct {
+	Value   []byte
+	MaxAge  time.Duration
+	Deleted bool
+}
+
+// BatchStore is implemented by the Stores which can read and write all the
+// values of a session in a single round-trip. It is required by batched
+// sessions.
+//
+// GetAll returns the values of a session, indexed by their hkey. Apply writes
+// a set of changes, indexed by hkey, at once, e.g. in a pipelined write.
+type BatchStore interface {
+	Store
+	GetAll(ctx context.Context, id string) (map[string][]byte, error)
+	Apply(ctx context.Context, id string, changes map[string]Change) error
+}
+
+// Batched is a configuration option which limits the store round-trips of a
+// server-side session to one read and one write per request: all the values
+// of the session are fetched the first time one is needed, the modifications
+// are buffered in memory and Save writes them at once, along with the renewal
+// of the session.
+// The session Store must implement BatchStore. The Cache is not used.
+func Batched() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Batch = true
+		return h
+	}
+}
+
+func (h Handler) batched() bool {
+	return h.Batch && h.Store != nil
+}
+
+func (h Handler) batchStore() BatchStore {
+	return h.Store.(BatchStore)
+}
+
+// resetValues discards the values held by a batched session, e.g. when its id
+// changes. A new session has no values to fetch.
+func (s *Session) resetValues(fresh bool) {
+	s.values, s.changes = nil, nil
+	if fresh {
+		s.values = make(map[string][]byte)
+	}
+}
+
+// fetch reads all the values of the session from the store, once.
+func (s *Session) fetch(ctx context.Context) error {
+	if s.values != nil {
+		return nil
+	}
+	h := s.h
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	get := func(ctx context.Context) (map[string][]byte, error) {
+		return h.batchStore().GetAll(ctx, h.nsID(id))
+	}
+	var values map[string][]byte
+	if h.StoreTimeout > 0 {
+		values, err = withTimeout(ctx, h.StoreTimeout, get)
+	} else {
+		values, err = get(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if values == nil {
+		values = make(map[string][]byte)
+	}
+	s.values = values
+	return nil
+}
+
+func (s *Session) batchGet(ctx context.Context, key string) ([]byte, error) {
+	err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hkey := s.h.Name + "/" + key
+	if c, ok := s.changes[hkey]; ok {
+		if c.Deleted {
+			return nil, ErrKeyNotFound
+		}
+		return c.Value, nil
+	}
+	v, ok := s.values[hkey]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (s *Session) batchPut(ctx context.Context, key string, value []byte, maxage time.Duration) error {
+	if key != sessionValidityKey {
+		_, err := s.batchGet(ctx, sessionValidityKey)
+		if err != nil {
+			return ErrBadSession.Wraps(err)
+		}
+	}
+	s.change(key, Change{Value: value, MaxAge: maxage})
+	return nil
+}
+
+func (s *Session) batchDelete(ctx context.Context, key string) error {
+	_, err := s.batchGet(ctx, sessionValidityKey)
+	if err != nil {
+		return nil // the session is invalid anyway.
+	}
+	s.change(key, Change{Deleted: true})
+	return nil
+}
+
+func (s *Session) change(key string, c Change) {
+	if s.changes == nil {
+		s.changes = make(map[string]Change)
+	}
+	s.changes[s.h.Name+"/"+key] = c
+}
+
+// flush writes the buffered modifications of a batched session, renewing the
+// session unless it has been revoked.
+func (s *Session) flush(ctx context.Context) error {
+	h := s.h
+	if !h.batched() || !s.loaded || s.stale {
+		return nil
+	}
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	vkey := h.Name + "/" + sessionValidityKey
+	if c, ok := s.changes[vkey]; !ok || !c.Deleted {
+		if d := s.validity(); d > 0 || ok {
+			s.change(sessionValidityKey, Change{Value: []byte("true"), MaxAge: d})
+		}
+	}
+	if len(s.changes) == 0 {
+		return nil
+	}
+
+	apply := func(ctx context.Context) error {
+		return h.batchStore().Apply(ctx, h.nsID(id), s.changes)
+	}
+	if h.StoreTimeout > 0 {
+		_, err = withTimeout(ctx, h.StoreTimeout, noValue(apply))
+	} else {
+		err = apply(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.values == nil {
+		s.values = make(map[string][]byte)
+	}
+	for k, c := range s.changes {
+		if c.Deleted {
+			delete(s.values, k)
+			continue
+		}
+		s.values[k] = c.Value
+	}
+	s.changes = nil
+	return nil
+}
@@ -0,0 +1,164 @@
+package session
+
+// This file defines the size budget of the session cookie. Browsers reject
+// cookies larger than about 4kB: the values which would make the session
+// cookie exceed its budget can be moved to a server-side store.
+
+import (
+	"context"
+	"time"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// DefaultMaxCookieSize is the default maximum size of the session cookie.
+const DefaultMaxCookieSize = 4096
+
+// ErrTooLarge is returned when the session data does not fit in the session
+// cookie and cannot be moved to a SpillStore.
+var ErrTooLarge = errors.New("Session data too large for the session cookie.").Code(errcode.BadCookie)
+
+// spillMin is the size under which moving a value to the SpillStore would not
+// make the cookie smaller.
+const spillMin = 16
+
+// CookieBudget is a configuration option which limits the size of the session
+// cookie to max bytes. When a value put in a client-side session would make
+// the cookie exceed it, the largest values are moved to the spill Store, the
+// cookie only keeping track of them. If spill is nil, Put fails with
+// ErrTooLarge instead.
+//
+// The values moved to the spill Store expire with their maxage. Those without
+// maxage are kept until the session is revoked.
+func CookieBudget(max int, spill Store) func(Handler) Handler {
+	return func(h Handler) Handler {
+		if max <= 0 {
+			panic("session: the session cookie budget must be positive")
+		}
+		h.Cookie.MaxSize = max
+		h.SpillStore = spill
+		return h
+	}
+}
+
+func (c Cookie) maxSize() int {
+	if c.MaxSize <= 0 {
+		return DefaultMaxCookieSize
+	}
+	return c.MaxSize
+}
+
+// largest returns the key of the largest value held by the cookie which can
+// be moved to a SpillStore.
+func (c Cookie) largest() (string, bool) {
+	var key string
+	n := spillMin
+	for k, v := range c.Data {
+		if k == "id" || k == sessionValidityKey || v.Spilled || len(v.Value) <= n {
+			continue
+		}
+		key, n = k, len(v.Value)
+	}
+	return key, key != ""
+}
+
+// fit keeps the session cookie within its budget by moving its largest values
+// to the SpillStore. It fails with ErrTooLarge if there is no SpillStore or no
+// value left to move.
+func (s *Session) fit(ctx context.Context) error {
+	for {
+		_, err := s.Cookie.encode()
+		if err != ErrTooLarge || s.h.SpillStore == nil {
+			return err
+		}
+		key, ok := s.Cookie.largest()
+		if !ok {
+			return err
+		}
+		if err = s.spill(ctx, key); err != nil {
+			return err
+		}
+	}
+}
+
+// spill moves the value of key from the session cookie to the SpillStore.
+func (s *Session) spill(ctx context.Context, key string) error {
+	h := s.h
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	v := s.Cookie.Data[key]
+	var maxage time.Duration
+	if v.Expiry != nil {
+		maxage = v.Expiry.Sub(s.Cookie.now())
+		if maxage <= 0 {
+			s.Cookie.Delete(key)
+			return nil
+		}
+	}
+	err = h.spillStore().Put(ctx, id, h.Name+"/"+key, []byte(v.Value), maxage)
+	if err != nil {
+		return err
+	}
+	s.Cookie.Data[key] = CookieValue{Expiry: v.Expiry, Spilled: true}
+	s.Cookie.ApplyMods.Set(true)
+	return nil
+}
+
+// spilled returns the value of key moved to the SpillStore.
+func (s *Session) spilled(ctx context.Context, id string, key string) ([]byte, error) {
+	v, err := s.h.spillStore().Get(ctx, id, s.h.Name+"/"+key)
+	if err != nil {
+		return nil, ErrKeyNotFound.Wraps(err)
+	}
+	return v, nil
+}
+
+// unspill deletes the values of the session moved to the SpillStore, when the
+// session is revoked.
+func (s *Session) unspill(ctx context.Context, id string) {
+	h := s.h
+	for k, v := range s.Cookie.Data {
+		if !v.Spilled {
+			continue
+		}
+		err := h.spillStore().Delete(ctx, id, h.Name+"/"+k)
+		if err != nil && h.Log != nil {
+			h.Log.Print(err)
+		}
+	}
+}
+
+// respill moves the values of the session held by the SpillStore to the new
+// id of a renewed session.
+func (s *Session) respill(ctx context.Context, oldid string, newid string) error {
+	h := s.h
+	now := s.Cookie.now()
+	for k, v := range s.Cookie.Data {
+		if !v.Spilled {
+			continue
+		}
+		b, err := h.spillStore().Get(ctx, oldid, h.Name+"/"+k)
+		if err != nil {
+			delete(s.Cookie.Data, k)
+			continue
+		}
+		var maxage time.Duration
+		if v.Expiry != nil {
+			if maxage = v.Expiry.Sub(now); maxage <= 0 {
+				delete(s.Cookie.Data, k)
+				continue
+			}
+		}
+		if err = h.spillStore().Put(ctx, newid, h.Name+"/"+k, b, maxage); err != nil {
+			return err
+		}
+		err = h.spillStore().Delete(ctx, oldid, h.Name+"/"+k)
+		if err != nil && h.Log != nil {
+			h.Log.Print(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package bolt
+
+// This file defines the methods which keep track of the sessions of every
+// owner, so that they can be listed and revoked. Every owner has a bucket,
+// nested in the owners bucket, mapping the session ids to their JSON-encoded
+// metadata.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/atdiar/xhttp/handlers/session"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// Register adds a session to the index of its owner.
+func (s *Store) Register(ctx context.Context, m session.Metadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.updateTx(func(tx *bbolt.Tx) error {
+		index, err := tx.Bucket(ownersBucket).CreateBucketIfNotExists([]byte(m.Owner))
+		if err != nil {
+			return err
+		}
+		return index.Put([]byte(m.ID), b)
+	})
+}
+
+// ListByOwner returns the sessions indexed for an owner.
+func (s *Store) ListByOwner(ctx context.Context, owner string) ([]session.Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var res []session.Metadata
+	err := s.viewTx(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(ownersBucket).Bucket([]byte(owner))
+		if index == nil {
+			return nil
+		}
+		return index.ForEach(func(k, v []byte) error {
+			var m session.Metadata
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			res = append(res, m)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// DeleteByOwner removes the given sessions of an owner, or all of them if no
+// id is given, along with their values, in a single transaction.
+func (s *Store) DeleteByOwner(ctx context.Context, owner string, ids ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.updateTx(func(tx *bbolt.Tx) error {
+		owners := tx.Bucket(ownersBucket)
+		index := owners.Bucket([]byte(owner))
+		if index == nil {
+			return nil
+		}
+		if len(ids) == 0 {
+			index.ForEach(func(k, v []byte) error {
+				ids = append(ids, string(k))
+				return nil
+			})
+		}
+		values, expiry := tx.Bucket(valuesBucket), tx.Bucket(expiryBucket)
+		for _, id := range ids {
+			if index.Get([]byte(id)) == nil {
+				continue
+			}
+			if err := index.Delete([]byte(id)); err != nil {
+				return err
+			}
+			var keys [][]byte
+			prefix := key(id, "")
+			c := values.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			for _, k := range keys {
+				if err := remove(values, expiry, k); err != nil {
+					return err
+				}
+			}
+		}
+		if index.Stats().KeyN == 0 {
+			return owners.DeleteBucket([]byte(owner))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,631 @@
+// Package bolt implements a session Store persisted in a single file by
+// bbolt, an embedded key/value database. It lets single node deployments keep
+// their sessions across restarts without running an external database.
+//
+// Every value is stored along with its expiry date. The expiring values are
+// also indexed by expiry date so that the expired ones are evicted in the
+// background by a sweeper which only visits them. The space freed by the
+// evictions is reused by the database but the file does not shrink unless it
+// is compacted.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned when no value is stored for a key.
+var ErrNotFound = errors.New("bolt: no value stored for key")
+
+// ErrClosed is returned when the Store is used after being closed.
+var ErrClosed = errors.New("bolt: store closed")
+
+// DefaultSweepInterval is the default interval between two evictions of the
+// expired values.
+const DefaultSweepInterval = time.Minute
+
+var (
+	valuesBucket = []byte("values")
+	expiryBucket = []byte("expiry")
+	ownersBucket = []byte("owners")
+)
+
+// Store is a session Cache and Store persisted in a bbolt database file. It is
+// safe for concurrent use.
+//
+// bbolt locks the database file: a file can only be opened by a single Store
+// at a time.
+type Store struct {
+	path     string
+	mode     os.FileMode
+	clock    session.Clock
+	interval time.Duration
+	compact  time.Duration
+
+	mu sync.RWMutex // guards db, which is replaced by Compact
+	db *bbolt.DB
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens, creating it if needed, the database file at path and returns a
+// Store whose sweeper runs until Close is called.
+func Open(path string, options ...func(*Store)) (*Store, error) {
+	s := &Store{
+		path:     path,
+		mode:     0600,
+		clock:    session.SystemClock,
+		interval: DefaultSweepInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range options {
+		if opt != nil {
+			opt(s)
+		}
+	}
+	db, err := s.open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	go s.sweeper()
+	return s, nil
+}
+
+// SweepInterval is a configuration option which sets the interval between two
+// evictions of the expired values.
+func SweepInterval(d time.Duration) func(*Store) {
+	return func(s *Store) {
+		if d <= 0 {
+			panic("bolt: the sweep interval must be positive")
+		}
+		s.interval = d
+	}
+}
+
+// CompactInterval is a configuration option which makes the sweeper compact
+// the database file periodically, once at most every d.
+// Compaction blocks the Store while the file is rewritten.
+func CompactInterval(d time.Duration) func(*Store) {
+	return func(s *Store) {
+		if d <= 0 {
+			panic("bolt: the compaction interval must be positive")
+		}
+		s.compact = d
+	}
+}
+
+// FileMode is a configuration option which sets the permissions of the
+// database file when it is created. It defaults to 0600.
+func FileMode(mode os.FileMode) func(*Store) {
+	return func(s *Store) {
+		s.mode = mode
+	}
+}
+
+// WithClock is a configuration option which sets the Clock used to compute the
+// expiry of the values.
+func WithClock(c session.Clock) func(*Store) {
+	return func(s *Store) {
+		s.clock = c
+	}
+}
+
+func (s *Store) open(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, s.mode, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{valuesBucket, expiryBucket, ownersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close stops the sweeper and closes the database file.
+func (s *Store) Close() error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+		close(s.stop)
+	}
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db := s.db
+	s.db = nil
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+// Ping checks that the database file is open and readable.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.viewTx(func(tx *bbolt.Tx) error {
+		if tx.Bucket(valuesBucket) == nil {
+			return errors.New("bolt: missing values bucket")
+		}
+		return nil
+	})
+}
+
+// view and update run fn in a read-only or read-write transaction, with the
+// values bucket and the expiry index.
+func (s *Store) view(fn func(values, expiry *bbolt.Bucket) error) error {
+	return s.viewTx(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket(valuesBucket), tx.Bucket(expiryBucket))
+	})
+}
+
+func (s *Store) update(fn func(values, expiry *bbolt.Bucket) error) error {
+	return s.updateTx(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket(valuesBucket), tx.Bucket(expiryBucket))
+	})
+}
+
+// viewTx and updateTx run fn in a read-only or read-write transaction.
+func (s *Store) viewTx(fn func(tx *bbolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.db == nil {
+		return ErrClosed
+	}
+	return s.db.View(fn)
+}
+
+func (s *Store) updateTx(fn func(tx *bbolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.db == nil {
+		return ErrClosed
+	}
+	return s.db.Update(fn)
+}
+
+func key(id string, hkey string) []byte {
+	return []byte(id + "/" + hkey)
+}
+
+// A record is a value prefixed by its expiry date in unix nanoseconds, zero if
+// the value does not expire.
+func record(content []byte, expires time.Time) []byte {
+	r := make([]byte, 8+len(content))
+	binary.BigEndian.PutUint64(r, stamp(expires))
+	copy(r[8:], content)
+	return r
+}
+
+func parse(r []byte) (content []byte, expires time.Time) {
+	if len(r) < 8 {
+		return nil, time.Time{}
+	}
+	if n := binary.BigEndian.Uint64(r); n != 0 {
+		expires = time.Unix(0, int64(n))
+	}
+	return r[8:], expires
+}
+
+func stamp(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// indexKey is the key of a value in the expiry index, which sorts the keys by
+// expiry date.
+func indexKey(k []byte, expires time.Time) []byte {
+	ik := make([]byte, 8+len(k))
+	binary.BigEndian.PutUint64(ik, stamp(expires))
+	copy(ik[8:], k)
+	return ik
+}
+
+func expired(expires time.Time, now time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+// lookup returns the content stored for k if it has not expired. The returned
+// slice is only valid during the transaction.
+func lookup(values *bbolt.Bucket, k []byte, now time.Time) ([]byte, time.Time, bool) {
+	r := values.Get(k)
+	if r == nil {
+		return nil, time.Time{}, false
+	}
+	content, expires := parse(r)
+	if expired(expires, now) {
+		return nil, time.Time{}, false
+	}
+	return content, expires, true
+}
+
+// put writes a record and maintains the expiry index.
+func put(values, expiry *bbolt.Bucket, k []byte, content []byte, expires time.Time) error {
+	if err := remove(values, expiry, k); err != nil {
+		return err
+	}
+	if err := values.Put(k, record(content, expires)); err != nil {
+		return err
+	}
+	if expires.IsZero() {
+		return nil
+	}
+	return expiry.Put(indexKey(k, expires), nil)
+}
+
+// remove deletes a record and its entry in the expiry index.
+func remove(values, expiry *bbolt.Bucket, k []byte) error {
+	r := values.Get(k)
+	if r == nil {
+		return nil
+	}
+	if _, expires := parse(r); !expires.IsZero() {
+		if err := expiry.Delete(indexKey(k, expires)); err != nil {
+			return err
+		}
+	}
+	return values.Delete(k)
+}
+
+// Get returns the value stored for the key of the session id.
+func (s *Store) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var res []byte
+	err := s.view(func(values, expiry *bbolt.Bucket) error {
+		content, _, ok := lookup(values, key(id, hkey), s.clock.Now())
+		if !ok {
+			return ErrNotFound
+		}
+		res = append([]byte{}, content...)
+		return nil
+	})
+	return res, err
+}
+
+// Put stores a value for the key of the session id. A negative maxage deletes
+// the key and a zero maxage means that the value does not expire.
+func (s *Store) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.update(func(values, expiry *bbolt.Bucket) error {
+		return s.apply(values, expiry, key(id, hkey), session.Change{Value: content, MaxAge: maxage})
+	})
+}
+
+func (s *Store) apply(values, expiry *bbolt.Bucket, k []byte, ch session.Change) error {
+	if ch.Deleted || ch.MaxAge < 0 {
+		return remove(values, expiry, k)
+	}
+	var expires time.Time
+	if ch.MaxAge > 0 {
+		expires = s.clock.Now().Add(ch.MaxAge)
+	}
+	return put(values, expiry, k, ch.Value, expires)
+}
+
+// Delete removes the key of the session id.
+func (s *Store) Delete(ctx context.Context, id string, hkey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.update(func(values, expiry *bbolt.Bucket) error {
+		return remove(values, expiry, key(id, hkey))
+	})
+}
+
+// TimeToExpiry returns the time left before the key of the session id
+// expires, or zero if it does not expire.
+func (s *Store) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var d time.Duration
+	err := s.view(func(values, expiry *bbolt.Bucket) error {
+		now := s.clock.Now()
+		_, expires, ok := lookup(values, key(id, hkey), now)
+		if !ok {
+			return ErrNotFound
+		}
+		if !expires.IsZero() {
+			d = expires.Sub(now)
+		}
+		return nil
+	})
+	return d, err
+}
+
+// GetAll returns the values of a session, indexed by hkey, in a single
+// transaction.
+func (s *Store) GetAll(ctx context.Context, id string) (map[string][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := make(map[string][]byte)
+	prefix := key(id, "")
+	err := s.view(func(values, expiry *bbolt.Bucket) error {
+		now := s.clock.Now()
+		c := values.Cursor()
+		for k, r := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, r = c.Next() {
+			content, expires := parse(r)
+			if expired(expires, now) {
+				continue
+			}
+			res[string(k[len(prefix):])] = append([]byte{}, content...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Apply writes a set of changes to the values of a session in a single
+// transaction.
+func (s *Store) Apply(ctx context.Context, id string, changes map[string]session.Change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.update(func(values, expiry *bbolt.Bucket) error {
+		for hkey, ch := range changes {
+			if err := s.apply(values, expiry, key(id, hkey), ch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Rename moves the values of a session to a new id, keeping their expiry.
+// The values are no longer retrievable with the old id.
+func (s *Store) Rename(ctx context.Context, oldid string, newid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	prefix := key(oldid, "")
+	return s.update(func(values, expiry *bbolt.Bucket) error {
+		type entry struct {
+			hkey    string
+			content []byte
+			expires time.Time
+		}
+		var entries []entry
+		c := values.Cursor()
+		for k, r := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, r = c.Next() {
+			content, expires := parse(r)
+			entries = append(entries, entry{string(k[len(prefix):]), append([]byte{}, content...), expires})
+		}
+		now := s.clock.Now()
+		for _, e := range entries {
+			if err := remove(values, expiry, key(oldid, e.hkey)); err != nil {
+				return err
+			}
+			if expired(e.expires, now) {
+				continue
+			}
+			if err := put(values, expiry, key(newid, e.hkey), e.content, e.expires); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Clear removes every value.
+func (s *Store) Clear() error {
+	return s.updateTx(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{valuesBucket, expiryBucket, ownersBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Flush removes every value, like Clear.
+func (s *Store) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Clear()
+}
+
+// DeleteByPrefix removes the values of the sessions whose id starts with
+// prefix, along with the index entries of the owners whose name starts with
+// it, in a single transaction.
+func (s *Store) DeleteByPrefix(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p := []byte(prefix)
+	return s.updateTx(func(tx *bbolt.Tx) error {
+		values, expiry := tx.Bucket(valuesBucket), tx.Bucket(expiryBucket)
+		var keys [][]byte
+		c := values.Cursor()
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := remove(values, expiry, k); err != nil {
+				return err
+			}
+		}
+		owners := tx.Bucket(ownersBucket)
+		var names [][]byte
+		c = owners.Cursor()
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			names = append(names, append([]byte{}, k...))
+		}
+		for _, name := range names {
+			if err := owners.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClearAfter makes every value expire after t, unless it expires sooner.
+func (s *Store) ClearAfter(t time.Duration) error {
+	if t <= 0 {
+		return s.Clear()
+	}
+	return s.update(func(values, expiry *bbolt.Bucket) error {
+		deadline := s.clock.Now().Add(t)
+		type entry struct {
+			k, content []byte
+		}
+		var entries []entry
+		err := values.ForEach(func(k, r []byte) error {
+			content, expires := parse(r)
+			if expires.IsZero() || deadline.Before(expires) {
+				entries = append(entries, entry{append([]byte{}, k...), append([]byte{}, content...)})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := put(values, expiry, e.k, e.content, deadline); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Len returns the number of values stored, including the expired values that
+// have not been evicted yet.
+func (s *Store) Len() int {
+	var n int
+	s.view(func(values, expiry *bbolt.Bucket) error {
+		n = values.Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Sweep evicts the expired values. It is called periodically by the sweeper.
+func (s *Store) Sweep() error {
+	return s.update(func(values, expiry *bbolt.Bucket) error {
+		limit := stamp(s.clock.Now())
+		c := expiry.Cursor()
+		for ik, _ := c.First(); ik != nil && binary.BigEndian.Uint64(ik) <= limit; ik, _ = c.First() {
+			ik = append([]byte{}, ik...)
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			if err := values.Delete(ik[8:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Compact evicts the expired values and rewrites the database file so that
+// the space they occupied is returned to the file system. The Store is
+// blocked until the copy is complete.
+func (s *Store) Compact() error {
+	if err := s.Sweep(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return ErrClosed
+	}
+	tmp := s.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bbolt.Open(tmp, s.mode, nil)
+	if err != nil {
+		return err
+	}
+	err = bbolt.Compact(dst, s.db, 0)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	rerr := os.Rename(tmp, s.path)
+	if rerr != nil {
+		os.Remove(tmp)
+	}
+	// The database is reopened whether the compacted copy replaced it or not.
+	db, err := s.open(s.path)
+	if err != nil {
+		s.db = nil
+		return err
+	}
+	s.db = db
+	return rerr
+}
+
+func (s *Store) sweeper() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if s.compact > 0 && time.Since(last) >= s.compact {
+				s.Compact()
+				last = time.Now()
+				continue
+			}
+			s.Sweep()
+		}
+	}
+}
+
+var (
+	_ session.Cache         = (*Store)(nil)
+	_ session.Store         = (*Store)(nil)
+	_ session.BatchStore    = (*Store)(nil)
+	_ session.Renamer       = (*Store)(nil)
+	_ session.Flusher       = (*Store)(nil)
+	_ session.Index         = (*Store)(nil)
+	_ session.PrefixDeleter = (*Store)(nil)
+	_ session.Pinger        = (*Store)(nil)
+)
@@ -0,0 +1,226 @@
+package bolt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	clock := WithClock(session.ClockFunc(func() time.Time { return now }))
+	s, err := Open(path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+	ctx := context.Background()
+
+	s.Put(ctx, "id", "short", []byte("1"), time.Minute)
+	s.Put(ctx, "id", "long", []byte("2"), time.Hour)
+	s.Put(ctx, "id", "forever", []byte("3"), 0)
+
+	if v, err := s.Get(ctx, "id", "short"); err != nil || string(v) != "1" {
+		t.Fatalf("Expected the value to be stored. Got %q %v", v, err)
+	}
+	if d, err := s.TimeToExpiry(ctx, "id", "long"); err != nil || d != time.Hour {
+		t.Fatalf("Expected the value to expire in an hour. Got %v %v", d, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Get(ctx, "id", "short"); err != ErrNotFound {
+		t.Fatalf("Expected the value to have expired. Got %v", err)
+	}
+	s.Put(ctx, "id", "evicted", []byte("4"), time.Second)
+	now = now.Add(time.Second)
+	s.Sweep()
+	if s.Len() != 2 {
+		t.Fatalf("Expected the expired values to be evicted. Got %d values", s.Len())
+	}
+
+	// The values survive a restart.
+	if err := s.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if err := s.Ping(ctx); err != ErrClosed {
+		t.Fatalf("Expected a closed store to fail the ping. Got %v", err)
+	}
+	s, err = Open(path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, err := s.TimeToExpiry(ctx, "id", "long"); err != nil || d != time.Hour-2*time.Minute-time.Second {
+		t.Fatalf("Expected the value to be persisted with its expiry. Got %v %v", d, err)
+	}
+
+	// Extending the maxage of a value updates the expiry index.
+	s.Put(ctx, "id", "long", []byte("2"), 2*time.Hour)
+	s.ClearAfter(90 * time.Minute)
+	if d, _ := s.TimeToExpiry(ctx, "id", "forever"); d != 90*time.Minute {
+		t.Fatalf("Expected the value to expire with the store. Got %v", d)
+	}
+	now = now.Add(90 * time.Minute)
+	s.Sweep()
+	if s.Len() != 0 {
+		t.Fatalf("Expected the store to be cleared. Got %d values", s.Len())
+	}
+
+	s.Put(ctx, "id", "k", []byte("v"), 0)
+	s.Clear()
+	if _, err := s.Get(ctx, "id", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the store to be cleared. Got %v", err)
+	}
+}
+
+func TestBatchAndRename(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	err = s.Apply(ctx, "old", map[string]session.Change{
+		"a": {Value: []byte("1"), MaxAge: time.Hour},
+		"b": {Value: []byte("2")},
+		"c": {Deleted: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "older", "a", []byte("x"), 0)
+
+	values, err := s.GetAll(ctx, "old")
+	if err != nil || len(values) != 2 || string(values["a"]) != "1" || string(values["b"]) != "2" {
+		t.Fatalf("Expected the values of the session only. Got %q %v", values, err)
+	}
+
+	if err := s.Rename(ctx, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := s.GetAll(ctx, "old"); len(values) != 0 {
+		t.Fatalf("Expected the old id to be invalidated. Got %q", values)
+	}
+	if d, err := s.TimeToExpiry(ctx, "new", "a"); err != nil || d <= 0 || d > time.Hour {
+		t.Fatalf("Expected the expiry to be kept. Got %v %v", d, err)
+	}
+	if v, _ := s.Get(ctx, "older", "a"); string(v) != "x" {
+		t.Fatalf("Expected the other sessions to be left untouched. Got %q", v)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s, err := Open(path, WithClock(session.ClockFunc(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	value := make([]byte, 1024)
+	changes := make(map[string]session.Change)
+	for i := 0; i < 4096; i++ {
+		changes[string(rune('a'+i%26))+time.Duration(i).String()] = session.Change{Value: value, MaxAge: time.Minute}
+	}
+	s.Apply(ctx, "expiring", changes)
+	s.Put(ctx, "kept", "k", []byte("v"), 0)
+	before, _ := os.Stat(path)
+
+	now = now.Add(time.Hour)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("Expected the file to shrink. Got %d bytes, was %d", after.Size(), before.Size())
+	}
+	if v, err := s.Get(ctx, "kept", "k"); err != nil || string(v) != "v" {
+		t.Fatalf("Expected the value to survive compaction. Got %q %v", v, err)
+	}
+	if s.Len() != 1 {
+		t.Fatalf("Expected the expired values to be evicted. Got %d values", s.Len())
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Put(ctx, "a", "k", []byte("1"), time.Hour)
+	s.Put(ctx, "b", "k", []byte("2"), 0)
+	s.Put(ctx, "c", "k", []byte("3"), 0)
+	for _, id := range []string{"a", "b"} {
+		if err := s.Register(ctx, session.Metadata{ID: id, Owner: "john", UserAgent: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Register(ctx, session.Metadata{ID: "c", Owner: "jane"})
+
+	list, err := s.ListByOwner(ctx, "john")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("Expected the sessions of the owner to be listed. Got %v %v", list, err)
+	}
+	if err := s.DeleteByOwner(ctx, "john", "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the session values to be deleted. Got %v", err)
+	}
+	if _, err := s.Get(ctx, "c", "k"); err != nil {
+		t.Fatalf("Expected the sessions of other owners to be kept. Got %v", err)
+	}
+	if err := s.DeleteByOwner(ctx, "john"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.ListByOwner(ctx, "john"); len(list) != 0 {
+		t.Fatalf("Expected every session of the owner to be deleted. Got %v", list)
+	}
+	if _, err := s.Get(ctx, "b", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the session values to be deleted. Got %v", err)
+	}
+}
+
+func TestDeleteByPrefix(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Put(ctx, "a|1", "k", []byte("1"), time.Hour)
+	s.Put(ctx, "a|2", "k", []byte("2"), 0)
+	s.Put(ctx, "b|1", "k", []byte("3"), 0)
+	s.Register(ctx, session.Metadata{ID: "a|1", Owner: "a|john"})
+	s.Register(ctx, session.Metadata{ID: "b|1", Owner: "b|john"})
+
+	if err := s.DeleteByPrefix(ctx, "a|"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a|1", "a|2"} {
+		if _, err := s.Get(ctx, id, "k"); err != ErrNotFound {
+			t.Fatalf("Expected the sessions of the prefix to be deleted. Got %v", err)
+		}
+	}
+	if list, _ := s.ListByOwner(ctx, "a|john"); len(list) != 0 {
+		t.Fatalf("Expected the index entries of the prefix to be deleted. Got %v", list)
+	}
+	if _, err := s.Get(ctx, "b|1", "k"); err != nil {
+		t.Fatalf("Expected the other sessions to be kept. Got %v", err)
+	}
+	if list, _ := s.ListByOwner(ctx, "b|john"); len(list) != 1 {
+		t.Fatalf("Expected the other index entries to be kept. Got %v", list)
+	}
+}
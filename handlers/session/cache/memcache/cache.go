@@ -0,0 +1,198 @@
+// Package memcache implements a session Cache, which can also be used as a
+// session Store, backed by memcached.
+//
+// Every session value is stored under its own key along with its expiry date,
+// so that it can expire independently and report its time to expiry.
+package memcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// ErrNotFound is returned when no value is stored for a key.
+var ErrNotFound = errors.New("memcache: no value stored for key")
+
+// maxRelativeExpiration is the longest expiration that memcached accepts as
+// a number of seconds. Longer ones must be given as a unix timestamp.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// Cache is a session Cache and Store backed by memcached. It is safe for
+// concurrent use.
+//
+// The memcached client does not accept a context: the calls fail as soon as
+// the context is done but the pending network operations are bounded by the
+// client Timeout only.
+type Cache struct {
+	client *memcache.Client
+	prefix string
+}
+
+// New returns a Cache using an existing client. prefix is prepended to every
+// key.
+func New(client *memcache.Client, prefix string) Cache {
+	return Cache{client, prefix}
+}
+
+// Open returns a Cache spreading the values over the given servers, once they
+// have been checked to be reachable.
+func Open(prefix string, servers ...string) (Cache, error) {
+	if len(servers) == 0 {
+		return Cache{}, errors.New("memcache: no server")
+	}
+	client := memcache.New(servers...)
+	if err := client.Ping(); err != nil {
+		return Cache{}, err
+	}
+	return New(client, prefix), nil
+}
+
+// Client returns the underlying memcached client.
+func (c Cache) Client() *memcache.Client {
+	return c.client
+}
+
+// Ping checks that every memcached server is reachable.
+func (c Cache) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.client.Ping()
+}
+
+// key returns the memcached key of a session value. Keys which memcached
+// would not accept, because they are too long or contain spaces or control
+// characters, are hashed.
+func (c Cache) key(id string, hkey string) string {
+	k := c.prefix + id + "/" + hkey
+	if legalKey(k) {
+		return k
+	}
+	h := sha256.Sum256([]byte(k))
+	return c.prefix + "#" + hex.EncodeToString(h[:])
+}
+
+func legalKey(k string) bool {
+	if len(k) > 250 {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the value stored for the key of the session id.
+func (c Cache) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	v, _, err := c.get(id, hkey)
+	return v, err
+}
+
+func (c Cache) get(id string, hkey string) ([]byte, time.Time, error) {
+	it, err := c.client.Get(c.key(id, hkey))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(it.Value) < 8 {
+		return nil, time.Time{}, errors.New("memcache: invalid stored value")
+	}
+	var expiry time.Time
+	if n := int64(binary.BigEndian.Uint64(it.Value)); n != 0 {
+		expiry = time.Unix(0, n)
+		if !time.Now().Before(expiry) {
+			return nil, time.Time{}, ErrNotFound
+		}
+	}
+	return it.Value[8:], expiry, nil
+}
+
+// Put stores a value for the key of the session id. A negative maxage deletes
+// the key and a zero maxage means that the value does not expire.
+func (c Cache) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if maxage < 0 {
+		return c.Delete(ctx, id, hkey)
+	}
+	v := make([]byte, 8+len(content))
+	var exp int32
+	if maxage > 0 {
+		expiry := time.Now().Add(maxage)
+		binary.BigEndian.PutUint64(v, uint64(expiry.UnixNano()))
+		exp = expiration(expiry, maxage)
+	}
+	copy(v[8:], content)
+	return c.client.Set(&memcache.Item{Key: c.key(id, hkey), Value: v, Expiration: exp})
+}
+
+// expiration returns the memcached expiration of a value expiring after
+// maxage, at the date expiry.
+func expiration(expiry time.Time, maxage time.Duration) int32 {
+	if maxage > maxRelativeExpiration {
+		return int32(expiry.Unix() + 1)
+	}
+	return int32((maxage + time.Second - 1) / time.Second)
+}
+
+// Delete removes the key of the session id.
+func (c Cache) Delete(ctx context.Context, id string, hkey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := c.client.Delete(c.key(id, hkey))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// TimeToExpiry returns the time left before the key of the session id
+// expires, or zero if it does not expire.
+func (c Cache) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	_, expiry, err := c.get(id, hkey)
+	if err != nil || expiry.IsZero() {
+		return 0, err
+	}
+	return time.Until(expiry), nil
+}
+
+// Clear removes every value stored by the memcached servers, including the
+// values which do not belong to the cache: the servers should be dedicated to
+// the sessions.
+func (c Cache) Clear() error {
+	return c.client.FlushAll()
+}
+
+// ClearAfter clears the cache after t. See Clear.
+func (c Cache) ClearAfter(t time.Duration) error {
+	if t <= 0 {
+		return c.Clear()
+	}
+	time.AfterFunc(t, func() { c.Clear() })
+	return nil
+}
+
+var (
+	_ session.Cache  = Cache{}
+	_ session.Store  = Cache{}
+	_ session.Pinger = Cache{}
+)
@@ -0,0 +1,156 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server is a minimal memcached server supporting the commands used by the
+// Cache. It records the expiration of the items but does not enforce it.
+type server struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	exp   map[string]int32
+}
+
+func startServer(t *testing.T) (*server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &server{items: make(map[string][]byte), exp: make(map[string]int32)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s, l.Addr().String()
+}
+
+func (s *server) serve(c net.Conn) {
+	defer c.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		s.mu.Lock()
+		switch f[0] {
+		case "version":
+			fmt.Fprint(rw, "VERSION 1.6.0\r\n")
+		case "gets":
+			for _, k := range f[1:] {
+				if v, ok := s.items[k]; ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d 1\r\n%s\r\n", k, len(v), v)
+				}
+			}
+			fmt.Fprint(rw, "END\r\n")
+		case "set":
+			var flags, exp, n int
+			fmt.Sscanf(strings.Join(f[2:], " "), "%d %d %d", &flags, &exp, &n)
+			v := make([]byte, n+2)
+			io.ReadFull(rw, v)
+			s.items[f[1]] = v[:n]
+			s.exp[f[1]] = int32(exp)
+			fmt.Fprint(rw, "STORED\r\n")
+		case "delete":
+			if _, ok := s.items[f[1]]; ok {
+				delete(s.items, f[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+		case "flush_all":
+			s.items = make(map[string][]byte)
+			fmt.Fprint(rw, "OK\r\n")
+		}
+		s.mu.Unlock()
+		rw.Flush()
+	}
+}
+
+func (s *server) expiration(k string) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exp[k]
+}
+
+func TestCache(t *testing.T) {
+	srv, addr := startServer(t)
+	ctx := context.Background()
+	c, err := Open("sess:", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Put(ctx, "id", "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "id", "k"); err != nil || string(v) != "v" {
+		t.Fatalf("Expected the value to be stored. Got %q %v", v, err)
+	}
+	if d, err := c.TimeToExpiry(ctx, "id", "k"); err != nil || d <= 59*time.Second || d > time.Minute {
+		t.Fatalf("Expected the value to expire in a minute. Got %v %v", d, err)
+	}
+	if exp := srv.expiration("sess:id/k"); exp != 60 {
+		t.Fatalf("Expected a relative expiration of 60s. Got %v", exp)
+	}
+
+	// Long maxages are sent as unix timestamps.
+	c.Put(ctx, "id", "long", []byte("v"), 60*24*time.Hour)
+	if exp := srv.expiration("sess:id/long"); int64(exp) < time.Now().Add(59*24*time.Hour).Unix() {
+		t.Fatalf("Expected an absolute expiration. Got %v", exp)
+	}
+
+	// A zero maxage means no expiry, a negative one deletes the value.
+	c.Put(ctx, "id", "k", []byte("v"), 0)
+	if d, err := c.TimeToExpiry(ctx, "id", "k"); err != nil || d != 0 {
+		t.Fatalf("Expected the value not to expire. Got %v %v", d, err)
+	}
+	if exp := srv.expiration("sess:id/k"); exp != 0 {
+		t.Fatalf("Expected no expiration. Got %v", exp)
+	}
+	c.Put(ctx, "id", "k", []byte("v"), -1)
+	if _, err := c.Get(ctx, "id", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the value to be deleted. Got %v", err)
+	}
+	if err := c.Delete(ctx, "id", "k"); err != nil {
+		t.Fatalf("Expected deleting a missing value to succeed. Got %v", err)
+	}
+
+	// Keys memcached would reject are hashed.
+	long := strings.Repeat("x", 300)
+	if err := c.Put(ctx, "id with spaces", long, []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "id with spaces", long); err != nil || string(v) != "v" {
+		t.Fatalf("Expected the value to be stored. Got %q %v", v, err)
+	}
+
+	if err = c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "id with spaces", long); err != ErrNotFound {
+		t.Fatalf("Expected the cache to be cleared. Got %v", err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Get(cctx, "id", "k"); err != context.Canceled {
+		t.Fatalf("Expected a canceled context to fail the call. Got %v", err)
+	}
+}
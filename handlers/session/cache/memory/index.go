@@ -0,0 +1,80 @@
+package memory
+
+// This file defines the methods which keep track of the sessions of every
+// owner, so that they can be listed and revoked.
+
+import (
+	"context"
+	"strings"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// Register adds a session to the index of its owner.
+func (s *Store) Register(ctx context.Context, m session.Metadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners == nil {
+		s.owners = make(map[string]map[string]session.Metadata)
+	}
+	if s.owners[m.Owner] == nil {
+		s.owners[m.Owner] = make(map[string]session.Metadata)
+	}
+	s.owners[m.Owner][m.ID] = m
+	return nil
+}
+
+// ListByOwner returns the sessions indexed for an owner.
+func (s *Store) ListByOwner(ctx context.Context, owner string) ([]session.Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]session.Metadata, 0, len(s.owners[owner]))
+	for _, m := range s.owners[owner] {
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// DeleteByOwner removes the given sessions of an owner, or all of them if no
+// id is given, along with their values.
+func (s *Store) DeleteByOwner(ctx context.Context, owner string, ids ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.owners[owner]
+	if len(ids) == 0 {
+		for id := range index {
+			ids = append(ids, id)
+		}
+	}
+	removed := make(map[string]bool)
+	for _, id := range ids {
+		if _, ok := index[id]; ok {
+			delete(index, id)
+			removed[key(id, "")] = true
+		}
+	}
+	if len(index) == 0 {
+		delete(s.owners, owner)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	// The values are not grouped by session: every value is visited.
+	for k, it := range s.entries {
+		if i := strings.IndexByte(k, '/'); i >= 0 && removed[k[:i+1]] {
+			s.remove(it)
+		}
+	}
+	return nil
+}
+
+var _ session.Index = (*Store)(nil)
@@ -0,0 +1,355 @@
+// Package memory implements an in-memory session Cache, which can also be
+// used as a session Store during development or by single instance
+// deployments.
+//
+// Values expire individually. Expired values are never returned and are
+// evicted in the background by a sweeper which keeps them ordered by expiry
+// date in a heap, so that a sweep only visits the expired values.
+package memory
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// ErrNotFound is returned when no value is stored for a key.
+var ErrNotFound = errors.New("memory: no value stored for key")
+
+// DefaultSweepInterval is the default interval between two evictions of the
+// expired values.
+const DefaultSweepInterval = time.Minute
+
+// Store is an in-memory session Cache and Store. It is safe for concurrent
+// use.
+type Store struct {
+	clock    session.Clock
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*item
+	expiry  expiryHeap
+	evicted uint64
+	owners  map[string]map[string]session.Metadata
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type item struct {
+	key     string
+	value   []byte
+	expires time.Time // zero if the item does not expire
+	index   int       // index in the expiry heap, -1 if absent
+}
+
+// New returns an empty Store whose sweeper runs until Close is called.
+func New(options ...func(*Store)) *Store {
+	s := &Store{
+		clock:    session.SystemClock,
+		interval: DefaultSweepInterval,
+		entries:  make(map[string]*item),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range options {
+		if opt != nil {
+			opt(s)
+		}
+	}
+	go s.sweeper()
+	return s
+}
+
+// SweepInterval is a configuration option which sets the interval between two
+// evictions of the expired values.
+func SweepInterval(d time.Duration) func(*Store) {
+	return func(s *Store) {
+		if d <= 0 {
+			panic("memory: the sweep interval must be positive")
+		}
+		s.interval = d
+	}
+}
+
+// WithClock is a configuration option which sets the Clock used to compute the
+// expiry of the values.
+func WithClock(c session.Clock) func(*Store) {
+	return func(s *Store) {
+		s.clock = c
+	}
+}
+
+// Close stops the sweeper.
+func (s *Store) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+	return nil
+}
+
+func key(id string, hkey string) string {
+	return id + "/" + hkey
+}
+
+// lookup returns the item stored for k if it has not expired.
+// s.mu must be held.
+func (s *Store) lookup(k string, now time.Time) (*item, bool) {
+	it, ok := s.entries[k]
+	if !ok {
+		return nil, false
+	}
+	if !it.expires.IsZero() && !now.Before(it.expires) {
+		s.remove(it)
+		s.evicted++
+		return nil, false
+	}
+	return it, true
+}
+
+// remove deletes an item. s.mu must be held.
+func (s *Store) remove(it *item) {
+	delete(s.entries, it.key)
+	if it.index >= 0 {
+		heap.Remove(&s.expiry, it.index)
+	}
+}
+
+// setExpiry updates the expiry date of an item. s.mu must be held.
+func (s *Store) setExpiry(it *item, t time.Time) {
+	it.expires = t
+	switch {
+	case t.IsZero() && it.index >= 0:
+		heap.Remove(&s.expiry, it.index)
+	case t.IsZero():
+	case it.index >= 0:
+		heap.Fix(&s.expiry, it.index)
+	default:
+		heap.Push(&s.expiry, it)
+	}
+}
+
+// Get returns the value stored for the key of the session id.
+func (s *Store) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.lookup(key(id, hkey), s.clock.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), it.value...), nil
+}
+
+// Put stores a value for the key of the session id. A negative maxage deletes
+// the key and a zero maxage means that the value does not expire.
+func (s *Store) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(id, hkey)
+	it, ok := s.entries[k]
+	if maxage < 0 {
+		if ok {
+			s.remove(it)
+		}
+		return nil
+	}
+	if !ok {
+		it = &item{key: k, index: -1}
+		s.entries[k] = it
+	}
+	it.value = append([]byte(nil), content...)
+	var expires time.Time
+	if maxage > 0 {
+		expires = s.clock.Now().Add(maxage)
+	}
+	s.setExpiry(it, expires)
+	return nil
+}
+
+// Delete removes the key of the session id.
+func (s *Store) Delete(ctx context.Context, id string, hkey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if it, ok := s.entries[key(id, hkey)]; ok {
+		s.remove(it)
+	}
+	return nil
+}
+
+// TimeToExpiry returns the time left before the key of the session id
+// expires, or zero if it does not expire.
+func (s *Store) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	it, ok := s.lookup(key(id, hkey), now)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if it.expires.IsZero() {
+		return 0, nil
+	}
+	return it.expires.Sub(now), nil
+}
+
+// Clear removes every value.
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make(map[string]*item)
+	s.expiry = nil
+	s.owners = nil
+	return nil
+}
+
+// Flush removes every value, like Clear.
+func (s *Store) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Clear()
+}
+
+// DeleteByPrefix removes the values of the sessions whose id starts with
+// prefix, along with the index entries of the owners whose name starts with
+// it.
+func (s *Store) DeleteByPrefix(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, it := range s.entries {
+		if strings.HasPrefix(k, prefix) {
+			s.remove(it)
+		}
+	}
+	for owner := range s.owners {
+		if strings.HasPrefix(owner, prefix) {
+			delete(s.owners, owner)
+		}
+	}
+	return nil
+}
+
+// ClearAfter makes every value expire after t, unless it expires sooner.
+func (s *Store) ClearAfter(t time.Duration) error {
+	if t <= 0 {
+		return s.Clear()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deadline := s.clock.Now().Add(t)
+	for _, it := range s.entries {
+		if it.expires.IsZero() || deadline.Before(it.expires) {
+			s.setExpiry(it, deadline)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of values stored, including the expired values that
+// have not been evicted yet.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Stats holds counters describing the content of a Store, e.g. for monitoring.
+type Stats struct {
+	Entries  int    // values stored, including the expired ones not evicted yet
+	Expiring int    // values which have an expiry date
+	Evicted  uint64 // values evicted upon expiry since the Store was created
+}
+
+// Stats returns the current counters of the Store.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{
+		Entries:  len(s.entries),
+		Expiring: len(s.expiry),
+		Evicted:  s.evicted,
+	}
+}
+
+// Sweep evicts the expired values. It is called periodically by the sweeper.
+func (s *Store) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for len(s.expiry) > 0 && !now.Before(s.expiry[0].expires) {
+		s.remove(s.expiry[0])
+		s.evicted++
+	}
+}
+
+func (s *Store) sweeper() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Sweep()
+		}
+	}
+}
+
+// expiryHeap orders the expiring items by expiry date.
+type expiryHeap []*item
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	it := x.(*item)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	it.index = -1
+	*h = old[:n-1]
+	return it
+}
+
+var (
+	_ session.Cache         = (*Store)(nil)
+	_ session.Store         = (*Store)(nil)
+	_ session.Flusher       = (*Store)(nil)
+	_ session.PrefixDeleter = (*Store)(nil)
+)
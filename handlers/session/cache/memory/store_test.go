@@ -0,0 +1,172 @@
+package memory
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New(WithClock(session.ClockFunc(func() time.Time { return now })))
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Put(ctx, "id", "short", []byte("1"), time.Minute)
+	s.Put(ctx, "id", "long", []byte("2"), time.Hour)
+	s.Put(ctx, "id", "forever", []byte("3"), 0)
+
+	if v, err := s.Get(ctx, "id", "short"); err != nil || string(v) != "1" {
+		t.Fatalf("Expected the value to be stored. Got %q %v", v, err)
+	}
+	if d, err := s.TimeToExpiry(ctx, "id", "long"); err != nil || d != time.Hour {
+		t.Fatalf("Expected the value to expire in an hour. Got %v %v", d, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Get(ctx, "id", "short"); err != ErrNotFound {
+		t.Fatalf("Expected the value to have expired. Got %v", err)
+	}
+	s.Put(ctx, "id", "evicted", []byte("4"), time.Second)
+	now = now.Add(time.Second)
+	s.Sweep()
+	if s.Len() != 2 {
+		t.Fatalf("Expected the expired values to be evicted. Got %d values", s.Len())
+	}
+
+	// Extending the maxage of a value reorders the expiry heap.
+	s.Put(ctx, "id", "long", []byte("2"), 2*time.Hour)
+	s.ClearAfter(90 * time.Minute)
+	if d, _ := s.TimeToExpiry(ctx, "id", "forever"); d != 90*time.Minute {
+		t.Fatalf("Expected the value to expire with the cache. Got %v", d)
+	}
+	now = now.Add(90 * time.Minute)
+	s.Sweep()
+	if s.Len() != 0 {
+		t.Fatalf("Expected the cache to be cleared. Got %d values", s.Len())
+	}
+
+	s.Put(ctx, "id", "k", []byte("v"), 0)
+	s.Clear()
+	if _, err := s.Get(ctx, "id", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the cache to be cleared. Got %v", err)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	s := New(SweepInterval(time.Millisecond))
+	defer s.Close()
+	s.Put(context.Background(), "id", "k", []byte("v"), time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for s.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the sweeper to evict the expired value.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if st := s.Stats(); st.Entries != 0 || st.Expiring != 0 || st.Evicted != 1 {
+		t.Fatalf("Expected the eviction to be counted. Got %+v", st)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s := New()
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Put(ctx, "a", "k", []byte("1"), time.Hour)
+	s.Put(ctx, "b", "k", []byte("2"), 0)
+	s.Put(ctx, "c", "k", []byte("3"), 0)
+	for _, id := range []string{"a", "b"} {
+		if err := s.Register(ctx, session.Metadata{ID: id, Owner: "john", UserAgent: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Register(ctx, session.Metadata{ID: "c", Owner: "jane"})
+
+	list, err := s.ListByOwner(ctx, "john")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("Expected the sessions of the owner to be listed. Got %v %v", list, err)
+	}
+	if err := s.DeleteByOwner(ctx, "john", "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the session values to be deleted. Got %v", err)
+	}
+	if _, err := s.Get(ctx, "c", "k"); err != nil {
+		t.Fatalf("Expected the sessions of other owners to be kept. Got %v", err)
+	}
+	if err := s.DeleteByOwner(ctx, "john"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.ListByOwner(ctx, "john"); len(list) != 0 {
+		t.Fatalf("Expected every session of the owner to be deleted. Got %v", list)
+	}
+	if _, err := s.Get(ctx, "b", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the session values to be deleted. Got %v", err)
+	}
+}
+
+func TestDeleteByPrefix(t *testing.T) {
+	s := New()
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Put(ctx, "a|1", "k", []byte("1"), time.Hour)
+	s.Put(ctx, "a|2", "k", []byte("2"), 0)
+	s.Put(ctx, "b|1", "k", []byte("3"), 0)
+	s.Register(ctx, session.Metadata{ID: "a|1", Owner: "a|john"})
+	s.Register(ctx, session.Metadata{ID: "b|1", Owner: "b|john"})
+
+	if err := s.DeleteByPrefix(ctx, "a|"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a|1", "a|2"} {
+		if _, err := s.Get(ctx, id, "k"); err != ErrNotFound {
+			t.Fatalf("Expected the sessions of the prefix to be deleted. Got %v", err)
+		}
+	}
+	if list, _ := s.ListByOwner(ctx, "a|john"); len(list) != 0 {
+		t.Fatalf("Expected the index entries of the prefix to be deleted. Got %v", list)
+	}
+	if _, err := s.Get(ctx, "b|1", "k"); err != nil {
+		t.Fatalf("Expected the other sessions to be kept. Got %v", err)
+	}
+	if list, _ := s.ListByOwner(ctx, "b|john"); len(list) != 1 {
+		t.Fatalf("Expected the other index entries to be kept. Got %v", list)
+	}
+}
+
+func TestSessionMaxAge(t *testing.T) {
+	store := New()
+	defer store.Close()
+	s := session.New("SID", "secret", session.SetStore(store), session.SetMaxage(3600))
+
+	w := httptest.NewRecorder()
+	r := s.Attach(httptest.NewRequest("GET", "http://example.com/", nil))
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.ID(r.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The validity of the session is stored for MaxAge seconds.
+	time.Sleep(10 * time.Millisecond)
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	r = s.Attach(r)
+	if err := s.Load(httptest.NewRecorder(), r); err != nil {
+		t.Fatalf("Expected the session to be loaded. Got %v", err)
+	}
+	if lid, _ := s.ID(r.Context()); lid != id {
+		t.Fatalf("Expected the session %q to be loaded. Got %q", id, lid)
+	}
+}
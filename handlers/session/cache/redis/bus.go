@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/atdiar/xhttp/handlers/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// Bus is a session.InvalidationBus backed by a Redis pub/sub channel. It is
+// safe for concurrent use.
+type Bus struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewBus returns a Bus publishing the invalidations on a channel.
+func NewBus(client redis.UniversalClient, channel string) Bus {
+	return Bus{client, channel}
+}
+
+// Bus returns a Bus publishing the invalidations on a channel, using the
+// client of the Cache.
+func (c Cache) Bus(channel string) Bus {
+	return NewBus(c.client, channel)
+}
+
+// Publish broadcasts an invalidation to the subscribers of the channel.
+func (b Bus) Publish(ctx context.Context, inv session.Invalidation) error {
+	msg, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, msg).Err()
+}
+
+// Subscribe calls fn for every invalidation published on the channel until
+// ctx is done. The malformed messages are ignored.
+func (b Bus) Subscribe(ctx context.Context, fn func(session.Invalidation)) error {
+	ps := b.client.Subscribe(ctx, b.channel)
+	defer ps.Close()
+	// The subscription is confirmed before any message is received, so that
+	// connection failures are reported.
+	if _, err := ps.Receive(ctx); err != nil {
+		return err
+	}
+	ch := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var inv session.Invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				continue
+			}
+			fn(inv)
+		}
+	}
+}
+
+var _ session.InvalidationBus = Bus{}
@@ -0,0 +1,274 @@
+// Package redis implements a session Cache, which can also be used as a
+// session Store, backed by Redis.
+//
+// A single server, a Redis Cluster or a set of Sentinel-monitored servers can
+// be used. Every session value is stored under its own key so that it can
+// expire independently.
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotFound is returned when no value is stored for a key.
+var ErrNotFound = errors.New("redis: no value stored for key")
+
+// Mode is the connection mode to the Redis deployment.
+type Mode int
+
+// Connection modes.
+const (
+	Single Mode = iota
+	Cluster
+	Sentinel
+)
+
+// Options configures the connection to Redis.
+type Options struct {
+	Mode Mode
+	// Addrs lists the server addresses: the server for the Single mode, seed
+	// nodes for the Cluster mode and the sentinels for the Sentinel mode.
+	Addrs []string
+	// MasterName is the name of the master monitored by the sentinels.
+	MasterName string
+
+	Username string
+	Password string
+	// SentinelUsername and SentinelPassword authenticate the connections to
+	// the sentinels.
+	SentinelUsername string
+	SentinelPassword string
+
+	// DB is the database number. It is ignored in Cluster mode.
+	DB int
+	// TLS enables TLS connections if not nil.
+	TLS *tls.Config
+
+	// Prefix is prepended to every key.
+	Prefix string
+}
+
+// Cache is a session Cache and Store backed by Redis. It is safe for
+// concurrent use.
+type Cache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// New returns a Cache using an existing client. prefix is prepended to every
+// key.
+func New(client redis.UniversalClient, prefix string) Cache {
+	return Cache{client, prefix}
+}
+
+// Open connects to Redis and returns a Cache once the connection has been
+// checked.
+func Open(ctx context.Context, o Options) (Cache, error) {
+	if len(o.Addrs) == 0 {
+		return Cache{}, errors.New("redis: no address")
+	}
+	var client redis.UniversalClient
+	switch o.Mode {
+	case Single:
+		client = redis.NewClient(&redis.Options{
+			Addr:      o.Addrs[0],
+			Username:  o.Username,
+			Password:  o.Password,
+			DB:        o.DB,
+			TLSConfig: o.TLS,
+		})
+	case Cluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     o.Addrs,
+			Username:  o.Username,
+			Password:  o.Password,
+			TLSConfig: o.TLS,
+		})
+	case Sentinel:
+		if o.MasterName == "" {
+			return Cache{}, errors.New("redis: sentinel mode requires a master name")
+		}
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       o.MasterName,
+			SentinelAddrs:    o.Addrs,
+			SentinelUsername: o.SentinelUsername,
+			SentinelPassword: o.SentinelPassword,
+			Username:         o.Username,
+			Password:         o.Password,
+			DB:               o.DB,
+			TLSConfig:        o.TLS,
+		})
+	default:
+		return Cache{}, errors.New("redis: unknown connection mode")
+	}
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return Cache{}, err
+	}
+	return New(client, o.Prefix), nil
+}
+
+// Client returns the underlying Redis client.
+func (c Cache) Client() redis.UniversalClient {
+	return c.client
+}
+
+// Close closes the connections to Redis.
+func (c Cache) Close() error {
+	return c.client.Close()
+}
+
+// Ping checks that Redis is reachable.
+func (c Cache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c Cache) key(id string, hkey string) string {
+	return c.prefix + id + "/" + hkey
+}
+
+// Get returns the value stored for the key of the session id.
+func (c Cache) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	res, err := c.client.Get(ctx, c.key(id, hkey)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return res, err
+}
+
+// Put stores a value for the key of the session id. A negative maxage deletes
+// the key and a zero maxage means that the value does not expire.
+func (c Cache) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	if maxage < 0 {
+		return c.Delete(ctx, id, hkey)
+	}
+	return c.client.Set(ctx, c.key(id, hkey), content, maxage).Err()
+}
+
+// Delete removes the key of the session id.
+func (c Cache) Delete(ctx context.Context, id string, hkey string) error {
+	return c.client.Del(ctx, c.key(id, hkey)).Err()
+}
+
+// TimeToExpiry returns the time left before the key of the session id
+// expires, or zero if it does not expire.
+func (c Cache) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	d, err := c.client.PTTL(ctx, c.key(id, hkey)).Result()
+	if err != nil {
+		return 0, err
+	}
+	switch d {
+	case -2 * time.Millisecond, -2:
+		return 0, ErrNotFound
+	case -1 * time.Millisecond, -1:
+		return 0, nil
+	}
+	return d, nil
+}
+
+// Clear removes every key of the cache, i.e. every key starting with the
+// prefix. Without prefix, the whole database is scanned.
+func (c Cache) Clear() error {
+	return c.Flush(context.Background())
+}
+
+// Flush is like Clear but stops scanning the keys once ctx is done.
+func (c Cache) Flush(ctx context.Context) error {
+	return c.each(ctx, func(ctx context.Context, client redis.Cmdable, keys []string) error {
+		return client.Unlink(ctx, keys...).Err()
+	})
+}
+
+// DeleteByPrefix removes the values of the sessions whose id starts with
+// prefix, along with the index entries of the owners whose name starts with
+// it. The keys are scanned like by Flush.
+func (c Cache) DeleteByPrefix(ctx context.Context, prefix string) error {
+	unlink := func(ctx context.Context, client redis.Cmdable, keys []string) error {
+		return client.Unlink(ctx, keys...).Err()
+	}
+	if err := c.scan(ctx, escapeGlob(c.prefix+prefix)+"*", unlink); err != nil {
+		return err
+	}
+	return c.scan(ctx, escapeGlob(c.ownerKey(prefix))+"*", unlink)
+}
+
+// ClearAfter makes every key of the cache expire after t, unless it expires
+// sooner.
+func (c Cache) ClearAfter(t time.Duration) error {
+	if t <= 0 {
+		return c.Clear()
+	}
+	return c.each(context.Background(), func(ctx context.Context, client redis.Cmdable, keys []string) error {
+		_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, k := range keys {
+				p.Do(ctx, "PEXPIRE", k, t.Milliseconds(), "LT")
+				p.Do(ctx, "PEXPIRE", k, t.Milliseconds(), "NX")
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// each calls fn with batches of the keys of the cache.
+func (c Cache) each(ctx context.Context, fn func(ctx context.Context, client redis.Cmdable, keys []string) error) error {
+	return c.scan(ctx, escapeGlob(c.prefix)+"*", fn)
+}
+
+// scan calls fn with batches of the keys matching a pattern. In Cluster mode,
+// every master node is scanned.
+func (c Cache) scan(ctx context.Context, match string, fn func(ctx context.Context, client redis.Cmdable, keys []string) error) error {
+	scan := func(ctx context.Context, client *redis.Client) error {
+		iter := client.Scan(ctx, 0, match, 500).Iterator()
+		batch := make([]string, 0, 500)
+		for iter.Next(ctx) {
+			batch = append(batch, iter.Val())
+			if len(batch) == cap(batch) {
+				if err := fn(ctx, client, batch); err != nil {
+					return err
+				}
+				batch = batch[:0]
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			return fn(ctx, client, batch)
+		}
+		return nil
+	}
+	switch client := c.client.(type) {
+	case *redis.ClusterClient:
+		return client.ForEachMaster(ctx, scan)
+	case *redis.Client:
+		return scan(ctx, client)
+	default:
+		return errors.New("redis: unsupported client type")
+	}
+}
+
+// escapeGlob escapes the characters of s which are special in a SCAN pattern.
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+var (
+	_ session.Cache         = Cache{}
+	_ session.Store         = Cache{}
+	_ session.BatchStore    = Cache{}
+	_ session.Renamer       = Cache{}
+	_ session.Flusher       = Cache{}
+	_ session.Index         = Cache{}
+	_ session.PrefixDeleter = Cache{}
+	_ session.Pinger        = Cache{}
+)
@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+func TestCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	c, err := Open(ctx, Options{Addrs: []string{srv.Addr()}, Prefix: "sess:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.Put(ctx, "id", "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "id", "k"); err != nil || string(v) != "v" {
+		t.Fatalf("Expected the value to be stored. Got %q %v", v, err)
+	}
+	if d, err := c.TimeToExpiry(ctx, "id", "k"); err != nil || d != time.Minute {
+		t.Fatalf("Expected the value to expire in a minute. Got %v %v", d, err)
+	}
+	srv.FastForward(time.Minute)
+	if _, err := c.Get(ctx, "id", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the value to have expired. Got %v", err)
+	}
+
+	c.Put(ctx, "id", "a", []byte("1"), 0)
+	c.Put(ctx, "id", "b", []byte("2"), 0)
+	srv.Set("other", "untouched")
+	if d, err := c.TimeToExpiry(ctx, "id", "a"); err != nil || d != 0 {
+		t.Fatalf("Expected the value not to expire. Got %v %v", d, err)
+	}
+	if err = c.ClearAfter(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := c.TimeToExpiry(ctx, "id", "a"); d != time.Second {
+		t.Fatalf("Expected the value to expire in a second. Got %v", d)
+	}
+	if err = c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "id", "b"); err != ErrNotFound {
+		t.Fatalf("Expected the cache to be cleared. Got %v", err)
+	}
+	if !srv.Exists("other") {
+		t.Fatal("Expected the keys outside the prefix to be preserved.")
+	}
+}
+
+func TestStore(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	c, err := Open(ctx, Options{Addrs: []string{srv.Addr()}, Prefix: "sess:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err = c.Apply(ctx, "id*", map[string]session.Change{
+		"a": {Value: []byte("1"), MaxAge: time.Minute},
+		"b": {Value: []byte("2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put(ctx, "id-other", "c", []byte("3"), 0)
+	if err = c.Apply(ctx, "id*", map[string]session.Change{"b": {Deleted: true}}); err != nil {
+		t.Fatal(err)
+	}
+	all, err := c.GetAll(ctx, "id*")
+	if err != nil || len(all) != 1 || string(all["a"]) != "1" {
+		t.Fatalf("Expected the values of the session only. Got %v %v", all, err)
+	}
+
+	if err = c.Rename(ctx, "id*", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "id*", "a"); err != ErrNotFound {
+		t.Fatalf("Expected the old session to be gone. Got %v", err)
+	}
+	if v, err := c.Get(ctx, "new", "a"); err != nil || string(v) != "1" {
+		t.Fatalf("Expected the value to be moved. Got %q %v", v, err)
+	}
+	if d, err := c.TimeToExpiry(ctx, "new", "a"); err != nil || d != time.Minute {
+		t.Fatalf("Expected the expiry to be kept. Got %v %v", d, err)
+	}
+	if v, err := c.Get(ctx, "id-other", "c"); err != nil || string(v) != "3" {
+		t.Fatalf("Expected the other session to be untouched. Got %q %v", v, err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	srv := miniredis.RunT(t)
+	s, err := Open(context.Background(), Options{Addrs: []string{srv.Addr()}, Prefix: "sess:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Put(ctx, "a", "k", []byte("1"), time.Hour)
+	s.Put(ctx, "b", "k", []byte("2"), 0)
+	s.Put(ctx, "c", "k", []byte("3"), 0)
+	for _, id := range []string{"a", "b"} {
+		if err := s.Register(ctx, session.Metadata{ID: id, Owner: "john", UserAgent: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Register(ctx, session.Metadata{ID: "c", Owner: "jane"})
+
+	list, err := s.ListByOwner(ctx, "john")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("Expected the sessions of the owner to be listed. Got %v %v", list, err)
+	}
+	if err := s.DeleteByOwner(ctx, "john", "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the session values to be deleted. Got %v", err)
+	}
+	if _, err := s.Get(ctx, "c", "k"); err != nil {
+		t.Fatalf("Expected the sessions of other owners to be kept. Got %v", err)
+	}
+	if err := s.DeleteByOwner(ctx, "john"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.ListByOwner(ctx, "john"); len(list) != 0 {
+		t.Fatalf("Expected every session of the owner to be deleted. Got %v", list)
+	}
+	if _, err := s.Get(ctx, "b", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the session values to be deleted. Got %v", err)
+	}
+}
+
+func TestDeleteByPrefix(t *testing.T) {
+	srv := miniredis.RunT(t)
+	s, err := Open(context.Background(), Options{Addrs: []string{srv.Addr()}, Prefix: "sess:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Put(ctx, "a|1", "k", []byte("1"), time.Hour)
+	s.Put(ctx, "a|2", "k", []byte("2"), 0)
+	s.Put(ctx, "b|1", "k", []byte("3"), 0)
+	s.Register(ctx, session.Metadata{ID: "a|1", Owner: "a|john"})
+	s.Register(ctx, session.Metadata{ID: "b|1", Owner: "b|john"})
+
+	if err := s.DeleteByPrefix(ctx, "a|"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a|1", "a|2"} {
+		if _, err := s.Get(ctx, id, "k"); err != ErrNotFound {
+			t.Fatalf("Expected the sessions of the prefix to be deleted. Got %v", err)
+		}
+	}
+	if list, _ := s.ListByOwner(ctx, "a|john"); len(list) != 0 {
+		t.Fatalf("Expected the index entries of the prefix to be deleted. Got %v", list)
+	}
+	if _, err := s.Get(ctx, "b|1", "k"); err != nil {
+		t.Fatalf("Expected the other sessions to be kept. Got %v", err)
+	}
+	if list, _ := s.ListByOwner(ctx, "b|john"); len(list) != 1 {
+		t.Fatalf("Expected the other index entries to be kept. Got %v", list)
+	}
+}
+
+func TestBus(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := Open(ctx, Options{Addrs: []string{srv.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	b := c.Bus("invalidations")
+
+	received := make(chan session.Invalidation, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Subscribe(ctx, func(inv session.Invalidation) { received <- inv })
+	}()
+	for srv.PubSubNumSub("invalidations")["invalidations"] == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	want := session.Invalidation{Origin: "node", ID: "id", Key: "SID/k"}
+	if err = b.Publish(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != want {
+			t.Fatalf("Expected %v to be received. Got %v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the invalidation to be received")
+	}
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Fatalf("Expected the subscription to end with the context. Got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package redis
+
+// This file defines the methods which keep track of the sessions of every
+// owner, so that they can be listed and revoked. The sessions of an owner are
+// indexed in a hash, mapping their id to their JSON-encoded metadata.
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/atdiar/xhttp/handlers/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// ownerKey returns the key of the index of an owner. Session ids do not
+// contain colons so that it cannot clash with the keys of session values.
+func (c Cache) ownerKey(owner string) string {
+	return c.prefix + "owner:" + owner
+}
+
+// Register adds a session to the index of its owner.
+func (c Cache) Register(ctx context.Context, m session.Metadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.client.HSet(ctx, c.ownerKey(m.Owner), m.ID, b).Err()
+}
+
+// ListByOwner returns the sessions indexed for an owner.
+func (c Cache) ListByOwner(ctx context.Context, owner string) ([]session.Metadata, error) {
+	index, err := c.client.HGetAll(ctx, c.ownerKey(owner)).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]session.Metadata, 0, len(index))
+	for _, v := range index {
+		var m session.Metadata
+		if err := json.Unmarshal([]byte(v), &m); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// DeleteByOwner removes the given sessions of an owner, or all of them if no
+// id is given, along with their values.
+func (c Cache) DeleteByOwner(ctx context.Context, owner string, ids ...string) error {
+	k := c.ownerKey(owner)
+	indexed, err := c.client.HKeys(ctx, k).Result()
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		wanted := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			wanted[id] = true
+		}
+		ids = ids[:0:0]
+		for _, id := range indexed {
+			if wanted[id] {
+				ids = append(ids, id)
+			}
+		}
+	} else {
+		ids = indexed
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var keys []string
+	for _, id := range ids {
+		sk, err := c.sessionKeys(ctx, id)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, sk...)
+	}
+	_, err = c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, sk := range keys {
+			p.Unlink(ctx, sk) // one key per command for Cluster mode
+		}
+		p.HDel(ctx, k, ids...)
+		return nil
+	})
+	return err
+}
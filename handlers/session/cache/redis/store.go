@@ -0,0 +1,106 @@
+package redis
+
+// This file defines the methods which make a Cache a full-fledged session
+// Store: the values of a session can be read and written in batches and moved
+// to a new session id.
+
+import (
+	"context"
+	"errors"
+
+	"github.com/atdiar/xhttp/handlers/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionKeys returns the keys of the values of a session.
+func (c Cache) sessionKeys(ctx context.Context, id string) ([]string, error) {
+	var keys []string
+	err := c.scan(ctx, escapeGlob(c.key(id, ""))+"*", func(ctx context.Context, client redis.Cmdable, batch []string) error {
+		keys = append(keys, batch...)
+		return nil
+	})
+	return keys, err
+}
+
+// GetAll returns the values of a session, indexed by hkey. The values are
+// read in a single pipeline once the keys of the session have been scanned.
+func (c Cache) GetAll(ctx context.Context, id string) (map[string][]byte, error) {
+	keys, err := c.sessionKeys(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err = c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = p.Get(ctx, k)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	n := len(c.key(id, ""))
+	res := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		v, err := cmd.Bytes()
+		if err != nil {
+			continue // expired since the scan
+		}
+		res[keys[i][n:]] = v
+	}
+	return res, nil
+}
+
+// Apply writes a set of changes to the values of a session in a single
+// pipeline.
+func (c Cache) Apply(ctx context.Context, id string, changes map[string]session.Change) error {
+	_, err := c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for hkey, ch := range changes {
+			if ch.Deleted || ch.MaxAge < 0 {
+				p.Del(ctx, c.key(id, hkey))
+				continue
+			}
+			p.Set(ctx, c.key(id, hkey), ch.Value, ch.MaxAge)
+		}
+		return nil
+	})
+	return err
+}
+
+// Rename moves the values of a session to a new id, keeping their expiry.
+// The values are no longer retrievable with the old id.
+func (c Cache) Rename(ctx context.Context, oldid string, newid string) error {
+	keys, err := c.sessionKeys(ctx, oldid)
+	if err != nil {
+		return err
+	}
+	values := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err = c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			values[i] = p.Get(ctx, k)
+			ttls[i] = p.PTTL(ctx, k)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	n := len(c.key(oldid, ""))
+	_, err = c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			v, err := values[i].Bytes()
+			if err != nil {
+				continue // expired since the scan
+			}
+			ttl := ttls[i].Val()
+			if ttl < 0 {
+				ttl = 0
+			}
+			p.Set(ctx, c.key(newid, k[n:]), v, ttl)
+			p.Del(ctx, k)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,20 @@
+package session
+
+import "time"
+
+// Clock is the source of the current time used to compute and check the
+// expiry of session values. It can be replaced in tests to control expiry
+// without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is an adapter allowing the use of a function as a Clock.
+type ClockFunc func() time.Time
+
+// Now returns the current time.
+func (f ClockFunc) Now() time.Time { return f() }
+
+// SystemClock is the Clock returning the actual current time. It is the
+// default Clock of sessions and session cookies.
+var SystemClock Clock = ClockFunc(time.Now)
@@ -0,0 +1,81 @@
+package session
+
+// This file defines helpers which store typed values in a session.
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// Codec defines how typed values are serialized to be stored in a session.
+// Other formats, such as msgpack, can be used by implementing it.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON is the Codec encoding values in JSON.
+	JSON Codec = jsonCodec{}
+	// Gob is the Codec encoding values with encoding/gob.
+	Gob Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Values is implemented by the session Handler and Session. It is the
+// interface used by the typed value helpers.
+type Values interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte, maxage time.Duration) error
+}
+
+// GetValue retrieves the value stored in a session under key and decodes it
+// with the given Codec.
+func GetValue[T any](ctx context.Context, s Values, c Codec, key string) (T, error) {
+	var v T
+	b, err := s.Get(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	err = c.Unmarshal(b, &v)
+	return v, err
+}
+
+// PutValue encodes a value with the given Codec and stores it in a session
+// under key.
+func PutValue[T any](ctx context.Context, s Values, c Codec, key string, v T, maxage time.Duration) error {
+	b, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, b, maxage)
+}
+
+// GetJSON retrieves the JSON encoded value stored in a session under key.
+func GetJSON[T any](ctx context.Context, s Values, key string) (T, error) {
+	return GetValue[T](ctx, s, JSON, key)
+}
+
+// PutJSON stores a value in a session under key, encoded in JSON.
+func PutJSON[T any](ctx context.Context, s Values, key string, v T, maxage time.Duration) error {
+	return PutValue(ctx, s, JSON, key, v, maxage)
+}
@@ -0,0 +1,86 @@
+package session
+
+// This file defines the compression of the session cookie data, so that more
+// data fits in the size limit of the cookie.
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+
+	"github.com/atdiar/errors"
+)
+
+// compressedFlag starts the compressed session data. It cannot start a JSON
+// document, so that uncompressed data is still recognized.
+const compressedFlag = 0
+
+// maxInflated bounds the size of the decompressed session data.
+const maxInflated = 1 << 18
+
+// Compressed is a configuration option for session cookies which compresses
+// the session data with DEFLATE before it is signed or encrypted. The data is
+// left uncompressed when it would not get smaller.
+// Uncompressed cookies remain decodable, which allows to turn compression on
+// without invalidating the existing sessions.
+// JWT cookies are not compressed.
+//
+// Compression leaks information through the length of the cookie, encrypted or
+// not: an attacker able to get values of their choice stored in the session
+// and to observe the size of the cookie can guess the secrets it holds, byte
+// after byte, as in the CRIME attack. It is not recommended for sessions which
+// hold secrets, e.g. tokens, alongside data controlled by the users.
+func Compressed() func(Cookie) Cookie {
+	return func(c Cookie) Cookie {
+		c.Compress = true
+		return c
+	}
+}
+
+// CompressCookie is a configuration option which compresses the session
+// cookie. See Compressed, notably for the length leak compression induces.
+func CompressCookie() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Cookie.Compress = true
+		return h
+	}
+}
+
+// compress returns the compressed session data, or data itself if it would
+// not get smaller.
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte(compressedFlag)
+	w, err := flate.NewWriter(&b, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if b.Len() >= len(data) {
+		return data, nil
+	}
+	return b.Bytes(), nil
+}
+
+// decompress returns the decompressed session data, or data itself if it is
+// not compressed.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != compressedFlag {
+		return data, nil
+	}
+	r := flate.NewReader(bytes.NewReader(data[1:]))
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, maxInflated+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxInflated {
+		return nil, errors.New("Decompressed session data too large.")
+	}
+	return b, nil
+}
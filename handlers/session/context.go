@@ -0,0 +1,46 @@
+package session
+
+import (
+	"context"
+)
+
+// nameKey is the context key under which a Session can be retrieved by the
+// name of its handler.
+type nameKey string
+
+// withSession returns a copy of ctx holding the Session s of the handler.
+func (h Handler) withSession(ctx context.Context, s *Session) context.Context {
+	ctx = context.WithValue(ctx, h.ContextKey, s)
+	return context.WithValue(ctx, nameKey(h.Name), s)
+}
+
+// FromContext returns the loaded session of the given name held by a request
+// context, so that downstream handlers do not need the session Handler.
+// It returns false if no such session has been loaded or generated.
+func FromContext(ctx context.Context, name string) (*Session, bool) {
+	s, ok := ctx.Value(nameKey(name)).(*Session)
+	if !ok || !s.loaded {
+		return nil, false
+	}
+	return s, true
+}
+
+// MustFromContext is like FromContext but panics if the session has not been
+// loaded.
+func MustFromContext(ctx context.Context, name string) *Session {
+	s, ok := FromContext(ctx, name)
+	if !ok {
+		panic("session: no " + name + " session loaded in the context")
+	}
+	return s
+}
+
+// Name returns the name of the session, i.e. the name of its handler.
+func (s *Session) Name() string {
+	return s.h.Name
+}
+
+// Loaded reports whether the session has been loaded or generated.
+func (s *Session) Loaded() bool {
+	return s.loaded
+}
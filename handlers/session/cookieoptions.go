@@ -0,0 +1,164 @@
+package session
+
+// This file defines the configuration options for the attributes of the
+// session cookie.
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/atdiar/errors"
+)
+
+// Cookie name prefixes which restrict the attributes of a cookie.
+// See https://developer.mozilla.org/en-US/docs/Web/HTTP/Cookies#cookie_prefixes
+const (
+	hostPrefix   = "__Host-"
+	securePrefix = "__Secure-"
+)
+
+// attribute returns a configuration option for session cookies which modifies
+// a copy of their underlying http.Cookie, so that cookies obtained from the
+// same configuration do not share their attributes.
+func attribute(modify func(c *http.Cookie)) func(Cookie) Cookie {
+	return func(sc Cookie) Cookie {
+		c := *sc.HttpCookie
+		modify(&c)
+		sc.HttpCookie = &c
+		return sc
+	}
+}
+
+// cookieOption returns a configuration option for session handlers which
+// applies a configuration option for session cookies to the handler's cookie.
+func cookieOption(opt func(Cookie) Cookie) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Cookie = opt(h.Cookie)
+		return h
+	}
+}
+
+// WithDomain is a configuration option for NewCookie which sets the Domain
+// attribute of the session cookie.
+func WithDomain(domain string) func(Cookie) Cookie {
+	return attribute(func(c *http.Cookie) { c.Domain = domain })
+}
+
+// WithPath is a configuration option for NewCookie which sets the Path
+// attribute of the session cookie. The path must start with a slash.
+func WithPath(path string) func(Cookie) Cookie {
+	return attribute(func(c *http.Cookie) { c.Path = path })
+}
+
+// WithSecure is a configuration option for NewCookie which sets the Secure
+// attribute of the session cookie. It is set by default.
+func WithSecure(secure bool) func(Cookie) Cookie {
+	return attribute(func(c *http.Cookie) { c.Secure = secure })
+}
+
+// WithHttpOnly is a configuration option for NewCookie which sets the
+// HttpOnly attribute of the session cookie. It is set by default.
+func WithHttpOnly(httponly bool) func(Cookie) Cookie {
+	return attribute(func(c *http.Cookie) { c.HttpOnly = httponly })
+}
+
+// WithSameSite is a configuration option for NewCookie which sets the
+// SameSite attribute of the session cookie. http.SameSiteNoneMode requires
+// the cookie to be Secure.
+func WithSameSite(mode http.SameSite) func(Cookie) Cookie {
+	return attribute(func(c *http.Cookie) { c.SameSite = mode })
+}
+
+// WithPartitioned is a configuration option for NewCookie which sets the
+// Partitioned attribute of the session cookie (CHIPS), for sessions used in
+// third-party contexts. A partitioned cookie must be Secure.
+func WithPartitioned(partitioned bool) func(Cookie) Cookie {
+	return attribute(func(c *http.Cookie) { c.Partitioned = partitioned })
+}
+
+// SetDomain is the configuration option for session handlers equivalent to
+// WithDomain.
+func SetDomain(domain string) func(Handler) Handler {
+	return cookieOption(WithDomain(domain))
+}
+
+// SetPath is the configuration option for session handlers equivalent to
+// WithPath.
+func SetPath(path string) func(Handler) Handler {
+	return cookieOption(WithPath(path))
+}
+
+// SetSecure is the configuration option for session handlers equivalent to
+// WithSecure.
+func SetSecure(secure bool) func(Handler) Handler {
+	return cookieOption(WithSecure(secure))
+}
+
+// SetHttpOnly is the configuration option for session handlers equivalent to
+// WithHttpOnly.
+func SetHttpOnly(httponly bool) func(Handler) Handler {
+	return cookieOption(WithHttpOnly(httponly))
+}
+
+// SetSameSite is the configuration option for session handlers equivalent to
+// WithSameSite.
+func SetSameSite(mode http.SameSite) func(Handler) Handler {
+	return cookieOption(WithSameSite(mode))
+}
+
+// SetPartitioned is the configuration option for session handlers equivalent
+// to WithPartitioned.
+func SetPartitioned(partitioned bool) func(Handler) Handler {
+	return cookieOption(WithPartitioned(partitioned))
+}
+
+// HostPrefixed is a configuration option which prefixes the name of the
+// session cookie with __Host-, so that the browser only accepts it if it is
+// Secure, has no Domain and a Path of /, i.e. if it is bound to the host which
+// set it. These attributes are set accordingly.
+func HostPrefixed() func(Handler) Handler {
+	return cookieOption(attribute(func(c *http.Cookie) {
+		c.Name = hostPrefix + unprefixed(c.Name)
+		c.Secure = true
+		c.Domain = ""
+		c.Path = "/"
+	}))
+}
+
+// SecurePrefixed is a configuration option which prefixes the name of the
+// session cookie with __Secure-, so that the browser only accepts it if it is
+// Secure. The Secure attribute is set accordingly.
+func SecurePrefixed() func(Handler) Handler {
+	return cookieOption(attribute(func(c *http.Cookie) {
+		c.Name = securePrefix + unprefixed(c.Name)
+		c.Secure = true
+	}))
+}
+
+func unprefixed(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, hostPrefix), securePrefix)
+}
+
+// validateCookie checks that the attributes of a session cookie are
+// consistent with each other and with the cookie name prefix, if any.
+func validateCookie(c *http.Cookie) error {
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return errors.New("session: cookie path must start with a slash")
+	}
+	if strings.ContainsAny(c.Domain, " ;,/") {
+		return errors.New("session: invalid cookie domain " + c.Domain)
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return errors.New("session: SameSite=None cookies must be Secure")
+	}
+	if c.Partitioned && !c.Secure {
+		return errors.New("session: partitioned cookies must be Secure")
+	}
+	if strings.HasPrefix(c.Name, securePrefix) && !c.Secure {
+		return errors.New("session: " + securePrefix + " prefixed cookies must be Secure")
+	}
+	if strings.HasPrefix(c.Name, hostPrefix) && (!c.Secure || c.Domain != "" || c.Path != "/") {
+		return errors.New("session: " + hostPrefix + " prefixed cookies must be Secure, have no Domain and a Path of /")
+	}
+	return nil
+}
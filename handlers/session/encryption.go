@@ -0,0 +1,84 @@
+package session
+
+// This file defines the authenticated encryption of the session cookie data.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/atdiar/errors"
+)
+
+// encryptedFormat prefixes the value of the encrypted session cookies. It
+// identifies the version of the encryption scheme.
+const encryptedFormat = "e1"
+
+// Encrypted is a configuration option for session cookies which encrypts the
+// session data with AES-GCM, using a key derived from the cookie secret, so
+// that the client cannot read it.
+// Cookies that were only signed remain decodable, which allows to turn
+// encryption on without invalidating the existing sessions.
+func Encrypted() func(Cookie) Cookie {
+	return func(c Cookie) Cookie {
+		c.Encrypt = true
+		return c
+	}
+}
+
+// EncryptCookie is a configuration option which encrypts the session cookie.
+// See Encrypted.
+func EncryptCookie() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Cookie.Encrypt = true
+		return h
+	}
+}
+
+// newAEAD returns the AES-256-GCM cipher whose key is derived from secret.
+func newAEAD(secret string) (cipher.AEAD, error) {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte("session cookie encryption"))
+	block, err := aes.NewCipher(m.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts and authenticates the session data. The cookie name is
+// authenticated as well so that the value of a cookie cannot be reused for
+// another one.
+func seal(secret string, name string, data []byte) (string, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	b := aead.Seal(nonce, nonce, data, []byte(name))
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// unseal decrypts session data encrypted by seal.
+func unseal(secret string, name string, v string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("Encrypted session cookie too short")
+	}
+	nonce, ciphertext := b[:aead.NonceSize()], b[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(name))
+}
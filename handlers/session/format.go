@@ -0,0 +1,104 @@
+package session
+
+// This file defines the versioning of the layout of the session cookie, so
+// that its signing scheme, compression or encryption can evolve without
+// invalidating the sessions of the clients holding a former cookie.
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/atdiar/errors"
+)
+
+// CookieVersion is the version of the session cookie layout written by Encode.
+// Cookies written in a former layout are still decoded.
+//
+// Version 1 is the layout of the unversioned cookies written by former
+// releases. Version 2 prefixes the cookie value with its version, which is
+// authenticated along with the session data.
+//
+// JWT cookies are not versioned as they have to remain standard tokens.
+const CookieVersion byte = 2
+
+// versionMark starts the value of the versioned cookies. It cannot start an
+// unversioned one.
+const versionMark = "~"
+
+// Migration is the type of the functions which convert the data of a session
+// cookie written in a former layout, given its version.
+type Migration func(version byte, data map[string]CookieValue) (map[string]CookieValue, error)
+
+// WithMigration is a configuration option which sets the function converting
+// the data of the session cookies written in a former layout, once decoded.
+// The data it returns replaces the session data and the cookie is written in
+// the current layout when the session is saved.
+func WithMigration(m Migration) func(Cookie) Cookie {
+	return func(c Cookie) Cookie {
+		c.Migrate = m
+		return c
+	}
+}
+
+// MigrateCookie is a configuration option which sets the function converting
+// the data of the session cookies written in a former layout. See
+// WithMigration.
+func MigrateCookie(m Migration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Cookie.Migrate = m
+		return h
+	}
+}
+
+// versionPrefix returns the prefix of the cookie values written in the given
+// layout version.
+func versionPrefix(version byte) string {
+	return versionMark + hex.EncodeToString([]byte{version})
+}
+
+// cookieVersion returns the layout version of a cookie value and the value
+// stripped of its version prefix.
+func cookieVersion(v string) (byte, string, error) {
+	if !strings.HasPrefix(v, versionMark) {
+		return 1, v, nil
+	}
+	n := len(versionMark) + 2
+	if len(v) < n {
+		return 0, "", ErrBadCookie.Wraps(errors.New("Truncated session cookie version."))
+	}
+	b, err := hex.DecodeString(v[len(versionMark):n])
+	if err != nil {
+		return 0, "", ErrBadCookie.Wraps(err)
+	}
+	if b[0] < 1 || b[0] > CookieVersion {
+		return 0, "", ErrBadCookie.Wraps(errors.New("Unknown session cookie version."))
+	}
+	return b[0], v[n:], nil
+}
+
+// migrate converts the decoded data of a cookie written in a former layout
+// with the Migrate function, if any.
+func (c Cookie) migrate(version byte) error {
+	if version == CookieVersion {
+		return nil
+	}
+	c.ApplyMods.Set(true)
+	if c.Migrate == nil {
+		return nil
+	}
+	old := make(map[string]CookieValue, len(c.Data))
+	for k, v := range c.Data {
+		old[k] = v
+	}
+	data, err := c.Migrate(version, old)
+	if err != nil {
+		return ErrBadCookie.Wraps(err)
+	}
+	for k := range c.Data {
+		delete(c.Data, k)
+	}
+	for k, v := range data {
+		c.Data[k] = v
+	}
+	return nil
+}
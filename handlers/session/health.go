@@ -0,0 +1,216 @@
+package session
+
+// This file defines the detection of the unavailability of the session Store
+// and the policy applied to the requests received during an outage.
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// Pinger is implemented by the Stores and Caches which are able to check that
+// their backend is reachable. The others are checked by writing a short-lived
+// probe value and reading it back.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// UnavailableError is returned by Load and Generate when they fail because the
+// session Store is unavailable, so that an outage can be told apart from an
+// invalid session.
+type UnavailableError struct {
+	Err error
+}
+
+func (e UnavailableError) Error() string {
+	return "Session store unavailable: " + e.Err.Error()
+}
+
+func (e UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// errStale is the cause of the UnavailableError returned when a session served
+// from the Cache is modified.
+var errStale = errors.New("Session served from the cache cannot be modified.").Code(errcode.BadStorage)
+
+// DegradedMode defines how the session handler serves the requests received
+// while the session Store is unavailable.
+type DegradedMode int
+
+const (
+	// FailClosed rejects the requests with a 503 status.
+	FailClosed DegradedMode = iota
+	// CookieOnly serves the requests with client-side sessions. The clients
+	// keep their session id but the session data put during the outage is
+	// held by the session cookie only, and is lost once the Store is
+	// available again. Server-only sessions fail closed.
+	CookieOnly
+	// ServeStale serves the session values held by the Cache, the session
+	// cannot be modified. The validity of the session cannot be checked: the
+	// values missing from the Cache are reported as unavailable. Requests
+	// without a session fail closed.
+	ServeStale
+)
+
+// SetDegradedMode is a configuration option which defines how the requests
+// are served while the session Store is unavailable. FailClosed is the
+// default.
+func SetDegradedMode(m DegradedMode) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Degraded = m
+		return h
+	}
+}
+
+// Ping checks that the session Store and Cache are available, e.g. for a
+// readiness probe. It returns an UnavailableError if they are not.
+func (h Handler) Ping(ctx context.Context) error {
+	if h.Store != nil {
+		if err := h.pingStore(ctx); err != nil {
+			return UnavailableError{err}
+		}
+	}
+	if h.Cache == nil {
+		return nil
+	}
+	var err error
+	if p, ok := h.Cache.(Pinger); ok {
+		_, err = withStoreTimeout(ctx, h, noValue(p.Ping))
+	} else {
+		err = probe(ctx, h.cache())
+	}
+	if err != nil {
+		return UnavailableError{err}
+	}
+	return nil
+}
+
+func (h Handler) pingStore(ctx context.Context) error {
+	if p, ok := h.Store.(Pinger); ok {
+		_, err := withStoreTimeout(ctx, h, noValue(p.Ping))
+		return err
+	}
+	return probe(ctx, h.store())
+}
+
+// pingInterval is the duration for which the result of the check of the Store
+// availability made when a session fails to load is reused, so that the
+// clients presenting expired or revoked sessions do not each trigger one.
+const pingInterval = 5 * time.Second
+
+// storeHealth holds the result of the last check of the Store availability.
+// It is shared by the copies of a Handler.
+type storeHealth struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// storeAvailable checks that the Store is available, reusing the result of
+// a check made less than pingInterval ago.
+func (h Handler) storeAvailable(ctx context.Context) error {
+	if h.health == nil {
+		return h.pingStore(ctx)
+	}
+	h.health.mu.Lock()
+	defer h.health.mu.Unlock()
+	now := h.now()
+	if !h.health.checked.IsZero() && now.Sub(h.health.checked) < pingInterval {
+		return h.health.err
+	}
+	h.health.err = h.pingStore(ctx)
+	h.health.checked = now
+	return h.health.err
+}
+
+// probeID is the session id under which probe values are written.
+const probeID = "~probe"
+
+// probe writes a short-lived value into a Store or Cache and reads it back.
+func probe(ctx context.Context, s interface {
+	Get(ctx context.Context, id string, hkey string) ([]byte, error)
+	Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error
+}) error {
+	v := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	hkey := "probe/" + string(v)
+	if err := s.Put(ctx, probeID, hkey, v, time.Minute); err != nil {
+		return err
+	}
+	res, err := s.Get(ctx, probeID, hkey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(res, v) {
+		return errors.New("Session store probe value mismatch.")
+	}
+	return nil
+}
+
+// unavailable returns an UnavailableError if err, returned by the loading or
+// the generation of a session, is due to the unavailability of the Store.
+// The Store is only checked if the client holds a session id, at most once
+// per pingInterval.
+func (h Handler) unavailable(ctx context.Context, err error) error {
+	if err == nil || h.Store == nil {
+		return err
+	}
+	s, serr := h.From(ctx)
+	if serr != nil {
+		return err
+	}
+	if _, serr = s.ID(); serr != nil {
+		return err
+	}
+	if h.storeAvailable(ctx) == nil {
+		return err
+	}
+	return UnavailableError{err}
+}
+
+// degrade lets the Session of a request be served despite the unavailability
+// of the Store, according to the DegradedMode. It reports whether it can be.
+// fresh reports whether the session failed to be generated, in which case
+// there is no stale session to serve.
+func (h Handler) degrade(ctx context.Context, fresh bool) bool {
+	s, err := h.From(ctx)
+	if err != nil {
+		return false
+	}
+	if _, err = s.ID(); err != nil {
+		return false
+	}
+	switch h.Degraded {
+	case CookieOnly:
+		if h.ServerOnly {
+			return false
+		}
+		s.h.Store, s.h.Cache, s.h.Batch = nil, nil, false
+		s.Cookie.Touch()
+	case ServeStale:
+		if fresh || h.Cache == nil {
+			return false
+		}
+		s.stale = true
+	default:
+		return false
+	}
+	s.loaded = true
+	return true
+}
+
+// staleGet retrieves a session value from the Cache only, while the Store is
+// unavailable.
+func (s *Session) staleGet(ctx context.Context, id string, key string) ([]byte, error) {
+	v, err := s.h.cache().Get(ctx, id, s.h.Name+"/"+key)
+	if err != nil {
+		return nil, UnavailableError{err}
+	}
+	return v, nil
+}
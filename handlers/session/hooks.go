@@ -0,0 +1,73 @@
+package session
+
+import (
+	"context"
+)
+
+// Hooks holds functions called on the events of the lifecycle of the sessions,
+// so that applications can emit audit events, update last-seen timestamps or
+// cascade cleanup. Any of them may be nil.
+//
+//   - OnCreate is called when a session is generated.
+//   - OnLoad is called when a session is loaded.
+//   - OnRevoke is called when a session is revoked, before its cookie is
+//     expired.
+//   - OnExpire is called when a session is found to have outlived its
+//     lifetime while being loaded.
+//
+// The hooks are called synchronously, during the handling of the request. They
+// are given the Session concerned, whose id and values can be read.
+// The sessions revoked in bulk, e.g. by RevokeAll or RevokeSessions, do not
+// trigger OnRevoke.
+type Hooks struct {
+	OnCreate func(ctx context.Context, s *Session)
+	OnLoad   func(ctx context.Context, s *Session)
+	OnRevoke func(ctx context.Context, s *Session)
+	OnExpire func(ctx context.Context, s *Session)
+}
+
+// SetHooks is a configuration option which sets the functions called on the
+// events of the lifecycle of the sessions.
+func SetHooks(hooks Hooks) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Hooks = hooks
+		return h
+	}
+}
+
+// markCreated records the generation of a session.
+func (s *Session) markCreated(ctx context.Context) error {
+	if err := s.start(ctx); err != nil {
+		return err
+	}
+	s.loaded = true
+	if f := s.h.Hooks.OnCreate; f != nil {
+		f(ctx, s)
+	}
+	return nil
+}
+
+// markLoaded checks the lifetime of a session before recording its loading.
+func (s *Session) markLoaded(ctx context.Context) error {
+	if err := s.checkLifetime(ctx); err != nil {
+		s.expired(ctx)
+		return err
+	}
+	s.loaded = true
+	if f := s.h.Hooks.OnLoad; f != nil {
+		f(ctx, s)
+	}
+	return nil
+}
+
+func (s *Session) expired(ctx context.Context) {
+	if f := s.h.Hooks.OnExpire; f != nil {
+		f(ctx, s)
+	}
+}
+
+func (s *Session) revoked(ctx context.Context) {
+	if f := s.h.Hooks.OnRevoke; f != nil {
+		f(ctx, s)
+	}
+}
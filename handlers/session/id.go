@@ -0,0 +1,61 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// ErrIDGeneration is returned when no session id could be generated, the
+// source of randomness having failed. No session is created rather than one
+// with a predictable id.
+var ErrIDGeneration = errors.New("Unable to generate a session id.").Code(errcode.NoID)
+
+// idLength is the number of random bytes of a generated session id.
+const idLength = 32
+
+// randomSource provides the random bytes of the generated session ids.
+var randomSource io.Reader = rand.Reader
+
+// IDEncoder turns the random bytes of a generated session id into a string
+// which can be sent in a cookie.
+type IDEncoder func(b []byte) string
+
+var (
+	// Base64ID encodes session ids in unpadded URL-safe base64. It is the
+	// default IDEncoder.
+	Base64ID IDEncoder = base64.RawURLEncoding.EncodeToString
+	// HexID encodes session ids in lowercase hexadecimal.
+	HexID IDEncoder = hex.EncodeToString
+)
+
+// SetIDEncoder is a configuration option which sets the encoding of the
+// generated session ids.
+func SetIDEncoder(enc IDEncoder) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.IDEncoder = enc
+		return h
+	}
+}
+
+// newID returns a new session id, from the generator set by
+// SetUUIDgenerator if any. By default, it is made of random bytes encoded by
+// the IDEncoder.
+func (h Handler) newID() (string, error) {
+	if h.uuidgen != nil {
+		return h.uuidgen()
+	}
+	b := make([]byte, idLength)
+	if _, err := io.ReadFull(randomSource, b); err != nil {
+		return "", ErrIDGeneration.Wraps(err)
+	}
+	enc := h.IDEncoder
+	if enc == nil {
+		enc = Base64ID
+	}
+	return enc(b), nil
+}
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+const sessionOwnerKey = "sessionowner?56dfh468s4hg54gsh"
+
+// ErrIndexNotSupported is returned when the session Store does not keep track
+// of the sessions of their owners.
+var ErrIndexNotSupported = errors.New("Session store does not index sessions by owner.").Code(errcode.BadStorage)
+
+// Index is implemented by the Stores which keep track of the sessions of every
+// owner.
+//
+// Register adds a session to the index of its owner, replacing the metadata
+// indexed for the same id. ListByOwner returns the sessions indexed for an
+// owner, in no particular order. DeleteByOwner removes the given sessions of
+// an owner, or all of them if no id is given, from the index along with their
+// data; the ids of the sessions of other owners are ignored.
+type Index interface {
+	Register(ctx context.Context, m Metadata) error
+	ListByOwner(ctx context.Context, owner string) ([]Metadata, error)
+	DeleteByOwner(ctx context.Context, owner string, ids ...string) error
+}
+
+func (h Handler) index() (Index, error) {
+	idx, ok := h.Store.(Index)
+	if !ok {
+		return nil, ErrIndexNotSupported
+	}
+	if h.Namespace != "" {
+		return nsIndex{idx, h.Namespace}, nil
+	}
+	return idx, nil
+}
+
+// SetOwner records the owner of the loaded session, typically the id of the
+// user who just logged in, and indexes the session with the client IP address
+// and user agent of the request, as returned by Info.
+// The stale entries of the index of the owner are pruned.
+//
+// If the number of concurrent sessions is limited, the limit is enforced
+// first: SetOwner may fail with ErrTooManySessions or revoke the oldest
+// sessions of the owner. See MaxConcurrent.
+func (h Handler) SetOwner(r *http.Request, owner string) error {
+	ctx := r.Context()
+	if owner == "" {
+		return errors.New("Session owner cannot be empty.")
+	}
+	idx, err := h.index()
+	if err != nil {
+		return err
+	}
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	err = h.enforceLimit(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+	err = s.Put(ctx, sessionOwnerKey, []byte(owner), 0)
+	if err != nil {
+		return err
+	}
+	m := Info(r)
+	m.ID = id
+	m.Owner = owner
+	if !s.created.IsZero() {
+		m.Start = s.created
+	}
+	_, err = withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+		return idx.Register(ctx, m)
+	}))
+	if err != nil {
+		return err
+	}
+	_, err = h.Sessions(ctx, owner)
+	return err
+}
+
+// Owner returns the owner of the loaded session, as set by SetOwner.
+func (h Handler) Owner(ctx context.Context) (string, error) {
+	owner, err := h.Get(ctx, sessionOwnerKey)
+	if err != nil {
+		return "", err
+	}
+	return string(owner), nil
+}
+
+// Sessions returns the metadata of the valid sessions of an owner. The
+// sessions which have expired are removed from the index.
+func (h Handler) Sessions(ctx context.Context, owner string) ([]Metadata, error) {
+	idx, err := h.index()
+	if err != nil {
+		return nil, err
+	}
+	list, err := withStoreTimeout(ctx, h, func(ctx context.Context) ([]Metadata, error) {
+		return idx.ListByOwner(ctx, owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var valid []Metadata
+	var stale []string
+	for _, m := range list {
+		_, err := h.store().Get(ctx, m.ID, h.Name+"/"+sessionValidityKey)
+		if err != nil {
+			stale = append(stale, m.ID)
+			continue
+		}
+		valid = append(valid, m)
+	}
+	if len(stale) > 0 {
+		_, err = withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+			return idx.DeleteByOwner(ctx, owner, stale...)
+		}))
+		if err != nil && h.Log != nil {
+			h.Log.Print(err)
+		}
+	}
+	return valid, nil
+}
+
+// RevokeSessions revokes the given sessions of an owner, or all of them if no
+// id is given. The ids which do not belong to the owner are ignored.
+func (h Handler) RevokeSessions(ctx context.Context, owner string, ids ...string) error {
+	idx, err := h.index()
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 && h.Cache != nil {
+		list, err := withStoreTimeout(ctx, h, func(ctx context.Context) ([]Metadata, error) {
+			return idx.ListByOwner(ctx, owner)
+		})
+		if err != nil {
+			return err
+		}
+		for _, m := range list {
+			ids = append(ids, m.ID)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+	}
+	_, err = withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+		return idx.DeleteByOwner(ctx, owner, ids...)
+	}))
+	if err != nil {
+		return err
+	}
+	if h.Cache != nil {
+		// The revoked sessions must not be found valid from the cache.
+		for _, id := range ids {
+			err = h.cache().Delete(ctx, id, h.Name+"/"+sessionValidityKey)
+			if err != nil && h.Log != nil {
+				h.Log.Print(err)
+			}
+			h.invalidate(ctx, id, sessionValidityKey)
+		}
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of the owner of the loaded
+// session but the loaded session itself, i.e. logs the owner out of their
+// other devices.
+func (h Handler) RevokeOtherSessions(ctx context.Context) error {
+	id, err := h.ID(ctx)
+	if err != nil {
+		return err
+	}
+	owner, err := h.Owner(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := h.Sessions(ctx, owner)
+	if err != nil {
+		return err
+	}
+	var others []string
+	for _, m := range list {
+		if m.ID != id {
+			others = append(others, m.ID)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return h.RevokeSessions(ctx, owner, others...)
+}
+
+// unindex removes a session from the index of its owner, if any.
+func (s *Session) unindex(ctx context.Context, id string) {
+	h := s.h
+	idx, err := h.index()
+	if err != nil {
+		return
+	}
+	owner, err := s.Get(ctx, sessionOwnerKey)
+	if err != nil {
+		return
+	}
+	_, err = withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+		return idx.DeleteByOwner(ctx, string(owner), id)
+	}))
+	if err != nil && h.Log != nil {
+		h.Log.Print(err)
+	}
+}
+
+// reindex moves the index entry of a renewed session to its new id.
+func (s *Session) reindex(ctx context.Context, oldid string, newid string) error {
+	h := s.h
+	idx, err := h.index()
+	if err != nil {
+		return nil
+	}
+	owner, err := s.Get(ctx, sessionOwnerKey)
+	if err != nil {
+		return nil
+	}
+	list, err := withStoreTimeout(ctx, h, func(ctx context.Context) ([]Metadata, error) {
+		return idx.ListByOwner(ctx, string(owner))
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		if m.ID != oldid {
+			continue
+		}
+		m.ID = newid
+		_, err = withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+			if err := idx.Register(ctx, m); err != nil {
+				return err
+			}
+			return idx.DeleteByOwner(ctx, m.Owner, oldid)
+		}))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package session
+
+// This file defines the invalidation of the session values held by the Caches
+// of the other nodes of a cluster, when a node modifies them.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/atdiar/errors"
+)
+
+// An Invalidation identifies the session values which are stale in the Caches
+// of the other nodes.
+type Invalidation struct {
+	// Origin identifies the node which published the Invalidation.
+	Origin string `json:"origin"`
+
+	// ID and Key identify a session value, as held by the Cache.
+	ID  string `json:"id,omitempty"`
+	Key string `json:"key,omitempty"`
+
+	// Prefix, if not empty, invalidates the values of every session whose id
+	// starts with it.
+	Prefix string `json:"prefix,omitempty"`
+
+	// All invalidates every cached value.
+	All bool `json:"all,omitempty"`
+}
+
+// InvalidationBus is implemented by the messaging systems which broadcast the
+// Invalidations to every node sharing a session Store.
+type InvalidationBus interface {
+	Publish(ctx context.Context, inv Invalidation) error
+	// Subscribe calls fn for every Invalidation published until ctx is done.
+	Subscribe(ctx context.Context, fn func(Invalidation)) error
+}
+
+// SetInvalidationBus is a configuration option which makes the handler
+// publish an Invalidation on the bus whenever it modifies or deletes a
+// session value, so that the other nodes evict it from their Cache. The
+// Invalidations are received by ListenInvalidations.
+func SetInvalidationBus(b InvalidationBus) func(Handler) Handler {
+	return func(h Handler) Handler {
+		node := make([]byte, 8)
+		if _, err := rand.Read(node); err != nil {
+			panic("session: unable to identify the node for the invalidation bus")
+		}
+		h.Bus = b
+		h.node = hex.EncodeToString(node)
+		return h
+	}
+}
+
+// ListenInvalidations evicts from the Cache the session values invalidated by
+// the other nodes, until ctx is done. It is typically run in its own
+// goroutine.
+func (h Handler) ListenInvalidations(ctx context.Context) error {
+	if h.Bus == nil || h.Cache == nil {
+		return errors.New("Session handler without invalidation bus or cache.")
+	}
+	return h.Bus.Subscribe(ctx, func(inv Invalidation) {
+		if inv.Origin == h.node {
+			return
+		}
+		if err := h.evict(ctx, inv); err != nil && h.Log != nil {
+			h.Log.Print(err)
+		}
+	})
+}
+
+// evict removes the values invalidated by inv from the Cache.
+func (h Handler) evict(ctx context.Context, inv Invalidation) error {
+	_, err := withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+		switch {
+		case inv.All:
+			return h.Cache.Clear()
+		case inv.Prefix != "":
+			if d, ok := h.Cache.(PrefixDeleter); ok {
+				return d.DeleteByPrefix(ctx, inv.Prefix)
+			}
+			return h.Cache.Clear()
+		default:
+			return h.Cache.Delete(ctx, inv.ID, inv.Key)
+		}
+	}))
+	return err
+}
+
+// publish broadcasts an Invalidation to the other nodes, if the handler has
+// a Cache and an InvalidationBus. Failures are only logged: the values expire
+// from the Caches eventually.
+func (h Handler) publish(ctx context.Context, inv Invalidation) {
+	if h.Bus == nil || h.Cache == nil {
+		return
+	}
+	inv.Origin = h.node
+	_, err := withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+		return h.Bus.Publish(ctx, inv)
+	}))
+	if err != nil && h.Log != nil {
+		h.Log.Print(err)
+	}
+}
+
+// invalidate publishes the Invalidation of a session value.
+func (h Handler) invalidate(ctx context.Context, id string, key string) {
+	h.publish(ctx, Invalidation{ID: h.nsID(id), Key: h.Name + "/" + key})
+}
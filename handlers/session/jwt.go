@@ -0,0 +1,278 @@
+package session
+
+// This file defines the encoding of the session cookie as a JSON Web Token, for
+// the deployments which cannot share a server-side store but need the session
+// data to be readable by other services. The token is signed with HS256 and,
+// when the cookie is encrypted, nested in a JWE using direct AES-256-GCM
+// encryption.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// ErrBadJWT is returned when a session JWT is malformed, its signature
+// invalid or its claims not acceptable.
+var ErrBadJWT = errors.New("Invalid session token.").Code(errcode.BadCookie)
+
+// JWT configures the encoding of the session cookie as a JSON Web Token.
+type JWT struct {
+	// Issuer and Audience, if not empty, are set as the iss and aud claims and
+	// checked upon decoding.
+	Issuer   string
+	Audience string
+
+	// Claims maps session keys to top-level claim names, e.g. "user" to "sub",
+	// so that the corresponding values can be read by other services. The
+	// mapped values expire with the token. The other session values are held
+	// in the "ses" claim.
+	Claims map[string]string
+
+	// Leeway is the clock skew tolerated when checking the exp, nbf and iat
+	// claims.
+	Leeway time.Duration
+}
+
+// WithJWT is a configuration option which encodes the session cookie as a
+// JWT. The token is encrypted as well if the cookie is.
+func WithJWT(cfg JWT) func(Cookie) Cookie {
+	validateClaims(cfg)
+	return func(c Cookie) Cookie {
+		c.JWT = &cfg
+		return c
+	}
+}
+
+// JWTCookie is a configuration option which encodes the session cookie as a
+// JWT. See WithJWT.
+func JWTCookie(cfg JWT) func(Handler) Handler {
+	validateClaims(cfg)
+	return func(h Handler) Handler {
+		h.Cookie.JWT = &cfg
+		return h
+	}
+}
+
+// validateClaims panics if a session key is mapped to a registered claim.
+func validateClaims(cfg JWT) {
+	for k, name := range cfg.Claims {
+		switch name {
+		case "iss", "aud", "iat", "nbf", "exp", "ses":
+			panic("session: the session key " + k + " cannot be mapped to the registered claim " + name)
+		}
+	}
+}
+
+var (
+	jwsHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	jweHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A256GCM","cty":"JWT"}`))
+)
+
+// claims holds the registered claims of a session token.
+type claims struct {
+	Issuer    string                 `json:"iss,omitempty"`
+	Audience  string                 `json:"aud,omitempty"`
+	IssuedAt  int64                  `json:"iat"`
+	NotBefore int64                  `json:"nbf"`
+	Expiry    int64                  `json:"exp,omitempty"`
+	Session   map[string]CookieValue `json:"ses"`
+}
+
+// encodeJWT returns the session data as a signed, and possibly encrypted,
+// token.
+func (c Cookie) encodeJWT() (string, error) {
+	cfg := c.JWT
+	now := c.now()
+	cl := claims{
+		Issuer:    cfg.Issuer,
+		Audience:  cfg.Audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		Session:   make(map[string]CookieValue, len(c.Data)),
+	}
+	if c.HttpCookie.MaxAge > 0 {
+		cl.Expiry = now.Add(time.Duration(c.HttpCookie.MaxAge) * time.Second).Unix()
+	}
+	mapped := make(map[string]string)
+	for k, v := range c.Data {
+		if name, ok := cfg.Claims[k]; ok {
+			if s, ok := v.tryRetrieve(now); ok {
+				mapped[name] = s
+			}
+			continue
+		}
+		cl.Session[k] = v
+	}
+	payload, err := json.Marshal(cl)
+	if err != nil {
+		return "", err
+	}
+	if len(mapped) > 0 {
+		// The mapped claims are merged into the registered ones.
+		extra, err := json.Marshal(mapped)
+		if err != nil {
+			return "", err
+		}
+		payload = append(append(payload[:len(payload)-1], ','), extra[1:]...)
+	}
+	token := jwsHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	token += "." + signJWT(c.Secret, token)
+	if !c.Encrypt {
+		return token, nil
+	}
+	return encryptJWT(c.Secret, token)
+}
+
+// decodeJWT verifies a session token and recovers the session data it holds.
+func (c Cookie) decodeJWT(v string) error {
+	cfg := c.JWT
+	parts := strings.Split(v, ".")
+	if len(parts) == 5 {
+		var token string
+		var err error
+		for _, secret := range c.secrets() {
+			token, err = decryptJWT(secret, parts)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return ErrBadJWT.Wraps(err)
+		}
+		parts = strings.Split(token, ".")
+	} else if c.Encrypt {
+		return ErrBadJWT.Wraps(errors.New("Session token is not encrypted."))
+	}
+	if len(parts) != 3 || parts[0] != jwsHeader {
+		return ErrBadJWT
+	}
+	signed := false
+	for _, secret := range c.secrets() {
+		if hmac.Equal([]byte(signJWT(secret, parts[0]+"."+parts[1])), []byte(parts[2])) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return ErrBadJWT.Wraps(errors.New("Signature verification failure of session token"))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrBadJWT.Wraps(err)
+	}
+	var cl claims
+	if err = json.Unmarshal(payload, &cl); err != nil {
+		return ErrBadJWT.Wraps(err)
+	}
+
+	now := c.now()
+	leeway := cfg.Leeway
+	switch {
+	case cl.Expiry != 0 && now.After(time.Unix(cl.Expiry, 0).Add(leeway)):
+		return ErrExpired
+	case now.Add(leeway).Before(time.Unix(cl.NotBefore, 0)), now.Add(leeway).Before(time.Unix(cl.IssuedAt, 0)):
+		return ErrBadJWT.Wraps(errors.New("Session token used before its issuance."))
+	case cl.Issuer != cfg.Issuer, cl.Audience != cfg.Audience:
+		return ErrBadJWT.Wraps(errors.New("Session token issued for another party."))
+	}
+
+	for k := range c.Data {
+		delete(c.Data, k)
+	}
+	for k, v := range cl.Session {
+		c.Data[k] = v
+	}
+	if len(cfg.Claims) > 0 {
+		var all map[string]json.RawMessage
+		if err = json.Unmarshal(payload, &all); err != nil {
+			return ErrBadJWT.Wraps(err)
+		}
+		for k, name := range cfg.Claims {
+			var s string
+			if json.Unmarshal(all[name], &s) != nil {
+				continue
+			}
+			if cl.Expiry != 0 {
+				c.Data[k] = NewCookieValue(s, 0, AddTimeLimit(time.Unix(cl.Expiry, 0)))
+			} else {
+				c.Data[k] = NewCookieValue(s, 0)
+			}
+		}
+	}
+	return nil
+}
+
+func signJWT(secret string, signingInput string) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// jweAEAD returns the AES-256-GCM cipher whose key is derived from secret.
+func jweAEAD(secret string) (cipher.AEAD, error) {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte("session jwt encryption"))
+	block, err := aes.NewCipher(m.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptJWT nests a signed token in a JWE in compact serialization. The
+// encrypted key is empty as the key is used directly.
+func encryptJWT(secret string, token string) (string, error) {
+	aead, err := jweAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, iv, []byte(token), []byte(jweHeader))
+	n := len(sealed) - aead.Overhead()
+	enc := base64.RawURLEncoding.EncodeToString
+	return jweHeader + ".." + enc(iv) + "." + enc(sealed[:n]) + "." + enc(sealed[n:]), nil
+}
+
+func decryptJWT(secret string, parts []string) (string, error) {
+	if parts[0] != jweHeader || parts[1] != "" {
+		return "", errors.New("Unsupported session token encryption.")
+	}
+	dec := base64.RawURLEncoding.DecodeString
+	iv, err := dec(parts[2])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := dec(parts[3])
+	if err != nil {
+		return "", err
+	}
+	tag, err := dec(parts[4])
+	if err != nil {
+		return "", err
+	}
+	aead, err := jweAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return "", errors.New("Malformed session token.")
+	}
+	token, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
@@ -0,0 +1,148 @@
+package session
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+var (
+	sessionCreatedKey = "sessioncreated?56dfh468s4hg54gsh"
+	sessionAccessKey  = "sessionaccess?56dfh468s4hg54gsh"
+)
+
+// SetIdleTimeout is a configuration option which expires the sessions that
+// have not been loaded for the duration d. Every load of the session extends
+// its validity by d, without exceeding the AbsoluteTimeout if any.
+func SetIdleTimeout(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.IdleTimeout = d
+		return h
+	}
+}
+
+// SetAbsoluteTimeout is a configuration option which expires the sessions
+// once the duration d has elapsed since their generation, whatever their
+// activity.
+func SetAbsoluteTimeout(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.AbsoluteTimeout = d
+		return h
+	}
+}
+
+func (h Handler) now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+	return h.Clock.Now()
+}
+
+func (h Handler) limitedLifetime() bool {
+	return h.IdleTimeout > 0 || h.AbsoluteTimeout > 0
+}
+
+// validity returns the duration for which a session remains valid when it is
+// touched. It is bounded by the IdleTimeout and the absolute deadline of the
+// session. Zero means no expiry.
+func (s *Session) validity() time.Duration {
+	h := s.h
+	d := time.Duration(s.Cookie.HttpCookie.MaxAge) * time.Second
+	if h.IdleTimeout > 0 {
+		d = h.IdleTimeout
+	}
+	if h.AbsoluteTimeout > 0 && !s.created.IsZero() {
+		rem := s.created.Add(h.AbsoluteTimeout).Sub(h.now())
+		if rem <= 0 {
+			return -1
+		}
+		if d <= 0 || rem < d {
+			d = rem
+		}
+	}
+	return d
+}
+
+// start records the generation time of a session whose lifetime is limited.
+func (s *Session) start(ctx context.Context) error {
+	if !s.h.limitedLifetime() {
+		return nil
+	}
+	if s.created.IsZero() {
+		s.created = s.h.now()
+	}
+	err := s.Put(ctx, sessionCreatedKey, formatTime(s.created), 0)
+	if err != nil {
+		return err
+	}
+	return s.access(ctx, s.created)
+}
+
+// checkLifetime returns ErrExpired if the session has been idle for longer
+// than the IdleTimeout or has outlived the AbsoluteTimeout. Otherwise, the
+// session access is recorded.
+func (s *Session) checkLifetime(ctx context.Context) error {
+	h := s.h
+	if !h.limitedLifetime() {
+		return nil
+	}
+	now := h.now()
+	created, err := s.timestamp(ctx, sessionCreatedKey)
+	if err != nil {
+		return ErrExpired.Wraps(err)
+	}
+	if h.AbsoluteTimeout > 0 && !now.Before(created.Add(h.AbsoluteTimeout)) {
+		return ErrExpired
+	}
+	if h.IdleTimeout > 0 {
+		last, err := s.timestamp(ctx, sessionAccessKey)
+		if err != nil {
+			return ErrExpired.Wraps(err)
+		}
+		if !now.Before(last.Add(h.IdleTimeout)) {
+			return ErrExpired
+		}
+	}
+	s.created = created
+	return s.access(ctx, now)
+}
+
+// access records the time of the last access to the session and extends its
+// validity in the store accordingly.
+func (s *Session) access(ctx context.Context, now time.Time) error {
+	h := s.h
+	if h.IdleTimeout > 0 {
+		err := s.Put(ctx, sessionAccessKey, formatTime(now), 0)
+		if err != nil {
+			return err
+		}
+	}
+	if h.Store == nil {
+		return nil
+	}
+	if h.batched() {
+		// The validity is extended when the session is saved.
+		return nil
+	}
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	return h.store().Put(ctx, id, h.Name+"/"+sessionValidityKey, []byte("true"), s.validity())
+}
+
+func (s *Session) timestamp(ctx context.Context, key string) (time.Time, error) {
+	b, err := s.Get(ctx, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n), nil
+}
+
+func formatTime(t time.Time) []byte {
+	return []byte(strconv.FormatInt(t.UnixNano(), 10))
+}
@@ -0,0 +1,74 @@
+package session
+
+import (
+	"context"
+	"sort"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// ErrTooManySessions is returned by SetOwner when the owner already holds the
+// maximum number of concurrent sessions and new sessions are rejected.
+var ErrTooManySessions = errors.New("Too many concurrent sessions.").Code(errcode.BadSession)
+
+// LimitStrategy defines what happens when an owner who already holds the
+// maximum number of concurrent sessions starts a new one.
+type LimitStrategy int
+
+const (
+	// RejectNew refuses the new session.
+	RejectNew LimitStrategy = iota
+	// EvictOldest revokes the oldest sessions of the owner to make room for
+	// the new one.
+	EvictOldest
+)
+
+// MaxConcurrent is a configuration option which limits the number of
+// concurrent sessions of an owner to n. The limit is enforced by SetOwner,
+// when a session is attributed to its owner, typically on login, according
+// to the given strategy.
+// The session Store must implement Index.
+func MaxConcurrent(n int, strategy LimitStrategy) func(Handler) Handler {
+	return func(h Handler) Handler {
+		if n <= 0 {
+			panic("session: the maximum number of concurrent sessions must be positive")
+		}
+		h.MaxSessions = n
+		h.SessionLimit = strategy
+		return h
+	}
+}
+
+// enforceLimit makes room for a new session of an owner, or fails with
+// ErrTooManySessions, depending on the LimitStrategy.
+func (h Handler) enforceLimit(ctx context.Context, owner string, id string) error {
+	if h.MaxSessions <= 0 {
+		return nil
+	}
+	list, err := h.Sessions(ctx, owner)
+	if err != nil {
+		return err
+	}
+	others := list[:0]
+	for _, m := range list {
+		if m.ID != id {
+			others = append(others, m)
+		}
+	}
+	excess := len(others) - h.MaxSessions + 1
+	if excess <= 0 {
+		return nil
+	}
+	if h.SessionLimit == RejectNew {
+		return ErrTooManySessions
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Start.Before(others[j].Start)
+	})
+	ids := make([]string, 0, excess)
+	for _, m := range others[:excess] {
+		ids = append(ids, m.ID)
+	}
+	return h.RevokeSessions(ctx, owner, ids...)
+}
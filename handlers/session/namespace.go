@@ -0,0 +1,158 @@
+package session
+
+// This file defines the namespacing of the sessions in the Stores and Caches
+// shared by several applications or tenants.
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// ErrPrefixDeleteNotSupported is returned by RevokeNamespace when the sessions
+// of a namespace cannot be deleted at once.
+var ErrPrefixDeleteNotSupported = errors.New("Session store cannot delete the sessions of a namespace.").Code(errcode.BadStorage)
+
+// PrefixDeleter is implemented by the Stores and Caches which are able to
+// delete at once every session whose id starts with a prefix, along with the
+// index entries of the owners whose name starts with it.
+type PrefixDeleter interface {
+	DeleteByPrefix(ctx context.Context, prefix string) error
+}
+
+// namespaceSeparator separates the namespace from the session id in the
+// Store. It is forbidden in namespaces so that a namespaced id is unambiguous.
+const namespaceSeparator = "|"
+
+// SetNamespace is a configuration option which isolates the sessions of the
+// handler in a Store and Cache shared with other applications or tenants.
+// The session ids, and the owners of the indexed sessions, are prefixed with
+// the namespace in the Store, Cache and SpillStore. RevokeAll only revokes
+// the sessions of the namespace.
+// The namespace cannot contain slashes or vertical bars.
+func SetNamespace(ns string) func(Handler) Handler {
+	if strings.ContainsAny(ns, "/"+namespaceSeparator) {
+		panic("session: the namespace " + ns + " cannot contain '/' or '" + namespaceSeparator + "'")
+	}
+	return func(h Handler) Handler {
+		h.Namespace = ns
+		return h
+	}
+}
+
+func namespacePrefix(ns string) string {
+	return ns + namespaceSeparator
+}
+
+// nsID returns the id under which a session, or the sessions of an owner, are
+// held by the Store.
+func (h Handler) nsID(id string) string {
+	if h.Namespace == "" {
+		return id
+	}
+	return namespacePrefix(h.Namespace) + id
+}
+
+// RevokeNamespace deletes every session of a namespace, e.g. to offboard a
+// tenant, from the Store and the Cache, which must implement PrefixDeleter.
+// The handler does not need to belong to the namespace.
+func (h Handler) RevokeNamespace(ctx context.Context, namespace string) error {
+	if namespace == "" {
+		return errors.New("Session namespace cannot be empty.")
+	}
+	if h.Store == nil {
+		return ErrPrefixDeleteNotSupported
+	}
+	if err := h.deletePrefix(ctx, h.Store, namespacePrefix(namespace)); err != nil {
+		return err
+	}
+	if h.Cache == nil {
+		return nil
+	}
+	h.publish(ctx, Invalidation{Prefix: namespacePrefix(namespace)})
+	return h.deletePrefix(ctx, h.Cache, namespacePrefix(namespace))
+}
+
+// deletePrefix deletes the sessions whose id starts with prefix from a Store
+// or Cache, within the StoreTimeout if any.
+func (h Handler) deletePrefix(ctx context.Context, x interface{}, prefix string) error {
+	d, ok := x.(PrefixDeleter)
+	if !ok {
+		return ErrPrefixDeleteNotSupported
+	}
+	_, err := withStoreTimeout(ctx, h, noValue(func(ctx context.Context) error {
+		return d.DeleteByPrefix(ctx, prefix)
+	}))
+	return err
+}
+
+type nsStore struct {
+	Store
+	ns string
+}
+
+func (s nsStore) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	return s.Store.Get(ctx, namespacePrefix(s.ns)+id, hkey)
+}
+
+func (s nsStore) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	return s.Store.Put(ctx, namespacePrefix(s.ns)+id, hkey, content, maxage)
+}
+
+func (s nsStore) Delete(ctx context.Context, id string, hkey string) error {
+	return s.Store.Delete(ctx, namespacePrefix(s.ns)+id, hkey)
+}
+
+func (s nsStore) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	return s.Store.TimeToExpiry(ctx, namespacePrefix(s.ns)+id, hkey)
+}
+
+type nsCache struct {
+	Cache
+	ns string
+}
+
+func (c nsCache) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	return c.Cache.Get(ctx, namespacePrefix(c.ns)+id, hkey)
+}
+
+func (c nsCache) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	return c.Cache.Put(ctx, namespacePrefix(c.ns)+id, hkey, content, maxage)
+}
+
+func (c nsCache) Delete(ctx context.Context, id string, hkey string) error {
+	return c.Cache.Delete(ctx, namespacePrefix(c.ns)+id, hkey)
+}
+
+// nsIndex namespaces the ids and owners of the indexed sessions. The listed
+// metadata is stripped of the namespace.
+type nsIndex struct {
+	Index
+	ns string
+}
+
+func (x nsIndex) Register(ctx context.Context, m Metadata) error {
+	m.ID = namespacePrefix(x.ns) + m.ID
+	m.Owner = namespacePrefix(x.ns) + m.Owner
+	return x.Index.Register(ctx, m)
+}
+
+func (x nsIndex) ListByOwner(ctx context.Context, owner string) ([]Metadata, error) {
+	list, err := x.Index.ListByOwner(ctx, namespacePrefix(x.ns)+owner)
+	for i := range list {
+		list[i].ID = strings.TrimPrefix(list[i].ID, namespacePrefix(x.ns))
+		list[i].Owner = strings.TrimPrefix(list[i].Owner, namespacePrefix(x.ns))
+	}
+	return list, err
+}
+
+func (x nsIndex) DeleteByOwner(ctx context.Context, owner string, ids ...string) error {
+	nids := make([]string, len(ids))
+	for i, id := range ids {
+		nids[i] = namespacePrefix(x.ns) + id
+	}
+	return x.Index.DeleteByOwner(ctx, namespacePrefix(x.ns)+owner, nids...)
+}
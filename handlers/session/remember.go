@@ -0,0 +1,344 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+	"github.com/atdiar/xhttp"
+)
+
+// DefaultRememberMaxAge is the default lifetime of a remember-me token.
+const DefaultRememberMaxAge = 30 * 24 * time.Hour
+
+// DefaultRotationGrace is the default duration during which the validator
+// replaced by a rotation is still accepted, so that concurrent requests made
+// with the same token are not mistaken for a theft.
+const DefaultRotationGrace = 30 * time.Second
+
+var (
+	// ErrBadToken is returned when a remember-me token is malformed, unknown
+	// or expired.
+	ErrBadToken = errors.New("Invalid remember-me token.").Code(errcode.BadCookie)
+	// ErrTokenTheft is returned when a remember-me token is presented with a
+	// validator which does not match: the token has been used by someone
+	// else. Every session and token of its owner are revoked.
+	ErrTokenTheft = errors.New("Remember-me token reused: possible theft.").Code(errcode.BadSession)
+)
+
+// Remember is a request handler which keeps users logged in across sessions
+// ("remember me") with long-lived persistent login tokens.
+//
+// A token is made of a selector, which identifies it in the Store, and of a
+// validator, of which only a hash is stored. The validator is replaced every
+// time the token is used. A token presented with a stale validator reveals
+// that it was stolen and used by someone else: every session and token of
+// its owner are then revoked.
+//
+// It is to be linked after the session Handler. When the session has no
+// owner, i.e. the session cookie was absent or has expired, and a valid token
+// is presented, a new session is generated for the owner of the token.
+type Remember struct {
+	Session Handler
+	Store   Store
+
+	// Cookie holds the settings of the token cookie. Its name defaults to the
+	// name of the session cookie followed by "-remember".
+	Cookie http.Cookie
+
+	// MaxAge is the lifetime of a token. Grace is the duration during which
+	// the previous validator of a rotated token is still accepted.
+	MaxAge time.Duration
+	Grace  time.Duration
+
+	next xhttp.Handler
+}
+
+// token is the record of a remember-me token held by the Store.
+type token struct {
+	Owner   string    `json:"owner"`
+	Hash    string    `json:"hash"`
+	Prev    string    `json:"prev,omitempty"`
+	Rotated time.Time `json:"rotated,omitempty"`
+	Issued  time.Time `json:"issued"`
+}
+
+const (
+	rememberTokenKey   = "remember/token"
+	rememberRevokedKey = "remember/revoked"
+)
+
+// NewRemember returns a Remember handler for the sessions of s, its tokens
+// being held by the session Store.
+func NewRemember(s Handler, options ...func(Remember) Remember) Remember {
+	rm := Remember{
+		Session: s,
+		Store:   s.Store,
+		MaxAge:  DefaultRememberMaxAge,
+		Grace:   DefaultRotationGrace,
+	}
+	sc := s.Cookie.HttpCookie
+	rm.Cookie = http.Cookie{
+		Name:     sc.Name + "-remember",
+		Path:     sc.Path,
+		Domain:   sc.Domain,
+		Secure:   sc.Secure,
+		HttpOnly: true,
+		SameSite: sc.SameSite,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			rm = opt(rm)
+		}
+	}
+	if rm.Store == nil {
+		panic("session: remember-me tokens require a server-side store")
+	}
+	if rm.MaxAge <= 0 {
+		panic("session: the lifetime of remember-me tokens must be positive")
+	}
+	return rm
+}
+
+// RememberFor is a configuration option which sets the lifetime of the
+// remember-me tokens.
+func RememberFor(d time.Duration) func(Remember) Remember {
+	return func(rm Remember) Remember {
+		rm.MaxAge = d
+		return rm
+	}
+}
+
+// RotationGrace is a configuration option which sets the duration during
+// which the previous validator of a rotated token is still accepted.
+func RotationGrace(d time.Duration) func(Remember) Remember {
+	return func(rm Remember) Remember {
+		rm.Grace = d
+		return rm
+	}
+}
+
+// tokenID returns the id under which a token is stored. Session ids cannot
+// contain colons so it does not clash with them.
+func tokenID(selector string) string {
+	return "~remember:" + selector
+}
+
+func ownerID(owner string) string {
+	return "~owner:" + owner
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashValidator(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (rm Remember) setCookie(w http.ResponseWriter, selector string, validator string) {
+	c := rm.Cookie
+	c.Value = selector + "." + validator
+	c.MaxAge = int(rm.MaxAge / time.Second)
+	setCookie(w, &c)
+}
+
+func (rm Remember) expireCookie(w http.ResponseWriter) {
+	c := rm.Cookie
+	c.Value = ""
+	c.MaxAge = -1
+	setCookie(w, &c)
+}
+
+func (rm Remember) put(r *http.Request, selector string, t token) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	d := t.Issued.Add(rm.MaxAge).Sub(rm.Session.now())
+	if d <= 0 {
+		return ErrBadToken
+	}
+	return rm.store().Put(r.Context(), tokenID(selector), rememberTokenKey, b, d)
+}
+
+func (rm Remember) store() Store {
+	return rm.Session.bound(rm.Store)
+}
+
+// Issue creates a remember-me token for owner and sends it to the client. It
+// is typically called on login when the user asked to be remembered.
+func (rm Remember) Issue(w http.ResponseWriter, r *http.Request, owner string) error {
+	selector, err := randomString(12)
+	if err != nil {
+		return err
+	}
+	validator, err := randomString(32)
+	if err != nil {
+		return err
+	}
+	t := token{Owner: owner, Hash: hashValidator(validator), Issued: rm.Session.now()}
+	if err = rm.put(r, selector, t); err != nil {
+		return err
+	}
+	rm.setCookie(w, selector, validator)
+	return nil
+}
+
+// Forget deletes the remember-me token presented by the client, if any, and
+// expires its cookie. It is typically called on logout.
+func (rm Remember) Forget(w http.ResponseWriter, r *http.Request) error {
+	rm.expireCookie(w)
+	selector, _, ok := rm.token(r)
+	if !ok {
+		return nil
+	}
+	return rm.store().Delete(r.Context(), tokenID(selector), rememberTokenKey)
+}
+
+// token returns the selector and validator of the token presented by the
+// client.
+func (rm Remember) token(r *http.Request) (selector string, validator string, ok bool) {
+	c, err := r.Cookie(rm.Cookie.Name)
+	if err != nil {
+		return "", "", false
+	}
+	selector, validator, ok = strings.Cut(c.Value, ".")
+	return selector, validator, ok && selector != "" && validator != ""
+}
+
+// Authenticate checks the remember-me token presented by the client and
+// returns its owner. The validator of the token is rotated and the new token
+// sent to the client.
+// A token presented with a stale validator makes Authenticate revoke every
+// session and token of its owner and fail with ErrTokenTheft.
+func (rm Remember) Authenticate(w http.ResponseWriter, r *http.Request) (string, error) {
+	ctx := r.Context()
+	selector, validator, ok := rm.token(r)
+	if !ok {
+		return "", ErrBadToken
+	}
+	b, err := rm.store().Get(ctx, tokenID(selector), rememberTokenKey)
+	if err != nil {
+		rm.expireCookie(w)
+		return "", ErrBadToken.Wraps(err)
+	}
+	var t token
+	if err = json.Unmarshal(b, &t); err != nil {
+		return "", ErrBadToken.Wraps(err)
+	}
+	if rv, err := rm.store().Get(ctx, ownerID(t.Owner), rememberRevokedKey); err == nil {
+		var revoked time.Time
+		if revoked.UnmarshalText(rv) == nil && !t.Issued.After(revoked) {
+			rm.expireCookie(w)
+			rm.store().Delete(ctx, tokenID(selector), rememberTokenKey)
+			return "", ErrBadToken
+		}
+	}
+
+	now := rm.Session.now()
+	h := hashValidator(validator)
+	switch {
+	case subtle.ConstantTimeCompare([]byte(h), []byte(t.Hash)) == 1:
+	case t.Prev != "" && subtle.ConstantTimeCompare([]byte(h), []byte(t.Prev)) == 1 && now.Sub(t.Rotated) < rm.Grace:
+		// A concurrent request has just rotated the token: the client
+		// receives the new validator from its response.
+		return t.Owner, nil
+	default:
+		rm.expireCookie(w)
+		rm.RevokeAll(w, r, t.Owner)
+		return "", ErrTokenTheft
+	}
+
+	validator, err = randomString(32)
+	if err != nil {
+		return "", err
+	}
+	t.Prev, t.Hash, t.Rotated = t.Hash, hashValidator(validator), now
+	if err = rm.put(r, selector, t); err != nil {
+		return "", err
+	}
+	rm.setCookie(w, selector, validator)
+	return t.Owner, nil
+}
+
+// RevokeAll invalidates every remember-me token of an owner and, if the
+// session Store implements Index, every session of the owner.
+func (rm Remember) RevokeAll(w http.ResponseWriter, r *http.Request, owner string) error {
+	ctx := r.Context()
+	now, err := rm.Session.now().MarshalText()
+	if err != nil {
+		return err
+	}
+	err = rm.store().Put(ctx, ownerID(owner), rememberRevokedKey, now, rm.MaxAge)
+	if err != nil {
+		return err
+	}
+	if _, ok := rm.Session.Store.(Index); !ok {
+		return nil
+	}
+	return rm.Session.RevokeSessions(ctx, owner)
+}
+
+// restore logs the owner of the token presented by the client in. The session
+// already loaded or generated for the request, if any, is renewed rather than
+// replaced, so that it is not left orphaned in the Store. Otherwise, a new
+// session is generated.
+func (rm Remember) restore(w http.ResponseWriter, r *http.Request) error {
+	owner, err := rm.Authenticate(w, r)
+	if err != nil {
+		return err
+	}
+	h := rm.Session
+	s, err := h.From(r.Context())
+	if err == nil && s.loaded {
+		err = h.Renew(w, r)
+	} else {
+		err = h.Generate(w, r)
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := h.Store.(Index); ok {
+		err = h.SetOwner(r, owner)
+	} else {
+		err = h.Put(r.Context(), sessionOwnerKey, []byte(owner), 0)
+	}
+	if err != nil {
+		return err
+	}
+	return h.Save(w, r)
+}
+
+func (rm Remember) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := rm.Session.Owner(r.Context()); err != nil {
+		if _, _, ok := rm.token(r); ok {
+			r = rm.Session.Attach(r)
+			err = rm.restore(w, r)
+			if err != nil && rm.Session.Log != nil {
+				rm.Session.Log.Print(err)
+			}
+		}
+	}
+	if rm.next != nil {
+		rm.next.ServeHTTP(w, r)
+	}
+}
+
+// Link enables the linking of a xhttp.Handler to the Remember handler.
+func (rm Remember) Link(hn xhttp.Handler) xhttp.HandlerLinker {
+	rm.next = hn
+	return rm
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// ErrRenewNotSupported is returned by Renew when the session Store cannot move
+// the session data to a new id.
+var ErrRenewNotSupported = errors.New("Session store cannot move session data to a new id.").Code(errcode.BadStorage)
+
+// Renamer is implemented by the Stores which are able to move the data of a
+// session to a new id. It is required to Renew server-side sessions.
+// Once renamed, the data must no longer be retrievable with the old id.
+type Renamer interface {
+	Rename(ctx context.Context, oldid string, newid string) error
+}
+
+// Renew replaces the id of the loaded session by a freshly generated one,
+// keeping the session data. The old id is invalidated.
+// It should be called whenever the privileges attached to a session change,
+// typically on login, so that a session id obtained by an attacker beforehand
+// (session fixation) becomes useless.
+//
+// The sessions spawned from the renewed session still refer to the old id and
+// should be generated again.
+func (h Handler) Renew(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.loaded {
+		return ErrNoSession
+	}
+	oldid, err := s.ID()
+	if err != nil {
+		return err
+	}
+	newid, err := h.newID()
+	if err != nil {
+		return err
+	}
+
+	if h.Store != nil {
+		err = h.rename(ctx, oldid, newid)
+		if err != nil {
+			return err
+		}
+	}
+	if h.SpillStore != nil {
+		err = s.respill(ctx, oldid, newid)
+		if err != nil {
+			return err
+		}
+	}
+	if h.Cache != nil {
+		// The old id must not be found valid from the cache.
+		err = h.cache().Delete(ctx, oldid, h.Name+"/"+sessionValidityKey)
+		if err != nil && h.Log != nil {
+			h.Log.Print(err)
+		}
+		h.invalidate(ctx, oldid, sessionValidityKey)
+	}
+	s.SetID(newid)
+	err = s.reindex(ctx, oldid, newid)
+	if err != nil {
+		return err
+	}
+
+	p, err := h.Parent()
+	if err == nil {
+		err = p.Put(ctx, h.Name+"/"+newid, Info(r).ToJSON(), 0)
+		if err != nil {
+			return err
+		}
+		err = p.Delete(ctx, h.Name+"/"+oldid)
+		if err != nil && h.Log != nil {
+			h.Log.Print(err)
+		}
+	}
+
+	return h.Save(w, r)
+}
+
+// rename moves the session data held by the Store to a new id, within the
+// StoreTimeout if any.
+func (h Handler) rename(ctx context.Context, oldid string, newid string) error {
+	rn, ok := h.Store.(Renamer)
+	if !ok {
+		return ErrRenewNotSupported
+	}
+	oldid, newid = h.nsID(oldid), h.nsID(newid)
+	if h.StoreTimeout <= 0 {
+		return rn.Rename(ctx, oldid, newid)
+	}
+	_, err := withTimeout(ctx, h.StoreTimeout, noValue(func(ctx context.Context) error {
+		return rn.Rename(ctx, oldid, newid)
+	}))
+	return err
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"context"
+
+	"github.com/atdiar/errcode"
+	"github.com/atdiar/errors"
+)
+
+// ErrRevokeAllNotSupported is returned by RevokeAll when the sessions cannot
+// all be invalidated at once.
+var ErrRevokeAllNotSupported = errors.New("Session store cannot remove every session at once.").Code(errcode.BadStorage)
+
+// Flusher is implemented by the Stores and Caches which are able to remove the
+// data of every session they hold at once, e.g. every key under their prefix.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// RevokeAll invalidates every session held by the handler's Store, e.g. after
+// a credential leak. The Cache, if any, is flushed as well. Sessions from
+// other handlers sharing the same Store namespace are invalidated too.
+//
+// The Store must implement Flusher, or at least the Clear method of a Cache.
+// For namespaced handlers, only the sessions of the namespace are revoked and
+// the Store must implement PrefixDeleter. See RevokeNamespace.
+// Client-side sessions cannot be revoked this way: the Secret must be changed
+// instead, without keeping the previous one.
+func (h Handler) RevokeAll(ctx context.Context) error {
+	if h.Store == nil {
+		return ErrRevokeAllNotSupported
+	}
+	if h.Namespace != "" {
+		return h.RevokeNamespace(ctx, h.Namespace)
+	}
+	if err := h.flush(ctx, h.Store); err != nil {
+		return err
+	}
+	if h.Cache == nil {
+		return nil
+	}
+	h.publish(ctx, Invalidation{All: true})
+	return h.flush(ctx, h.Cache)
+}
+
+// flush removes every value held by a Store or Cache, within the StoreTimeout
+// if any.
+func (h Handler) flush(ctx context.Context, x interface{}) error {
+	var fn func(ctx context.Context) error
+	switch f := x.(type) {
+	case Flusher:
+		fn = f.Flush
+	case interface{ Clear() error }:
+		fn = func(context.Context) error { return f.Clear() }
+	default:
+		return ErrRevokeAllNotSupported
+	}
+	if h.StoreTimeout <= 0 {
+		return fn(ctx)
+	}
+	_, err := withTimeout(ctx, h.StoreTimeout, noValue(fn))
+	return err
+}
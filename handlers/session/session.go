@@ -5,19 +5,19 @@ package session
 import (
 	"context"
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"log"
-	random "math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/atdiar/errcode"
 
 	"github.com/atdiar/errors"
 	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/requestid"
 )
 
 var (
@@ -49,7 +49,9 @@ var (
 
 // todo deal with sessions that should not be regen on failure to load
 
-type contextKey struct{}
+type contextKey struct {
+	name string
+}
 
 // ContextKey is used to retrieve a session cookie potentially stored in a context.
 var ContextKey contextKey
@@ -82,19 +84,24 @@ type Store interface {
 // Interface defines a common interface for objects that are used for session
 // management.
 type Interface interface {
-	ID() (string, error)
-	SetID(string)
+	ID(ctx context.Context) (string, error)
+	SetID(ctx context.Context, id string) error
 	Get(context.Context, string) ([]byte, error)
 	Put(ctx context.Context, key string, value []byte, maxage time.Duration) error
 	Delete(ctx context.Context, key string) error
 	Load(res http.ResponseWriter, req *http.Request) error
 	Save(res http.ResponseWriter, req *http.Request) error
-	Generate(res http.ResponseWriter, req *http.Request)  error
+	Generate(res http.ResponseWriter, req *http.Request) error
 }
 
 // Handler defines a type for request handling objects in charge of
 // session instantiation and validation.
 //
+// A Handler only holds the configuration of a session. The session data of
+// a request is held by a Session stored in the request context, so that a
+// Handler can be shared by concurrent requests. Its data management methods
+// act on the Session found in the context they are given.
+//
 // The duration of a session server-side is not necessarily the same as the
 // duration of the session credentials stored by the client.
 // The latter is controlled by the MaxAge field of the session cookie.
@@ -103,20 +110,72 @@ type Handler struct {
 	Name   string
 	Secret string
 
-	// Cookie is the field that holds client side stored user session data
-	// via a session cookie sent with every requests.
+	// Cookie holds the settings of the session cookie sent to the client.
+	// Every Session starts with a copy of it.
 	Cookie     Cookie
 	ServerOnly bool
 
-	// Handler specific context key under which  the session cookie is saved
+	// Handler specific context key under which the Session is saved
 	ContextKey *contextKey
 
 	// Store is the interface implemented by server-side session stores.
 	Store Store
 	Cache Cache
 
+	// Namespace, if not empty, isolates the sessions of the handler in a
+	// Store and Cache shared with other applications. See SetNamespace.
+	Namespace string
+
+	// SpillStore, for client-side sessions, holds the values which do not fit
+	// in the session cookie. See CookieBudget.
+	SpillStore Store
+
+	// StoreTimeout, if positive, bounds the duration of every Store and Cache
+	// call.
+	StoreTimeout time.Duration
+
+	// Bus broadcasts the modifications of the session values to the other
+	// nodes, so that they evict them from their Cache. See
+	// SetInvalidationBus.
+	Bus  InvalidationBus
+	node string
+
+	// Degraded defines how the requests are served while the Store is
+	// unavailable. See SetDegradedMode.
+	Degraded DegradedMode
+	health   *storeHealth
+
 	uuidgen func() (string, error)
 
+	// IDEncoder encodes the random bytes of the generated session ids. See
+	// SetIDEncoder.
+	IDEncoder IDEncoder
+
+	// Clock is the source of time used to check the expiry of the session
+	// values. It is shared with the session cookie.
+	Clock Clock
+
+	// Batch makes the session read all its values from the Store at once and
+	// buffer its modifications until Save. See Batched.
+	Batch bool
+
+	// IdleTimeout, if positive, expires the sessions which have not been
+	// loaded for that long.
+	IdleTimeout time.Duration
+
+	// AbsoluteTimeout, if positive, expires the sessions which were generated
+	// that long ago.
+	AbsoluteTimeout time.Duration
+
+	// Hooks are called on the events of the lifecycle of the sessions.
+	Hooks Hooks
+
+	// MaxSessions, if positive, is the maximum number of concurrent sessions
+	// of an owner, enforced according to the SessionLimit strategy. See
+	// MaxConcurrent.
+	MaxSessions  int
+	SessionLimit LimitStrategy
+
 	Log *log.Logger
 
 	next xhttp.Handler
@@ -127,19 +186,11 @@ func New(name string, secret string, options ...func(Handler) Handler) Handler {
 	h := Handler{}
 	h.Name = name
 	h.Secret = secret
-	h.ContextKey = &contextKey{}
+	h.ContextKey = &contextKey{name}
+	h.Clock = SystemClock
+	h.health = new(storeHealth)
 
 	h.Cookie = NewCookie(name, secret, 0)
-	h.uuidgen = func() (string, error) {
-		bstr := make([]byte, 32)
-		_, err := rand.Read(bstr)
-		if err != nil {
-			random.Seed(time.Now().UnixNano())
-			_, _ = random.Read(bstr)
-		}
-		return string(bstr), nil
-	}
-
 	if options != nil {
 		for _, opt := range options {
 			if opt != nil {
@@ -150,10 +201,24 @@ func New(name string, secret string, options ...func(Handler) Handler) Handler {
 	if h.ServerOnly && h.Store == nil {
 		panic(errors.New("error: serveronly session with no server storage").Error())
 	}
+	if _, ok := h.Store.(BatchStore); h.Batch && !ok {
+		panic("session: batched session with a store which is not a BatchStore")
+	}
+	if h.SpillStore != nil && h.Store != nil {
+		panic("session: a spill store is only used by client-side sessions")
+	}
+	if _, ok := h.Store.(Index); h.MaxSessions > 0 && !ok {
+		panic("session: concurrent session limit with a store which is not an Index")
+	}
+	if err := validateCookie(h.Cookie.HttpCookie); err != nil {
+		panic(err.Error())
+	}
+	h.Cookie.Clock = h.Clock
 	return h
 }
 
 // Configure allows for further parametrization of the session handler.
+// It panics if the resulting session cookie attributes are invalid.
 func (h Handler) Configure(options ...func(Handler) Handler) Handler {
 	if options != nil {
 		for _, opt := range options {
@@ -162,6 +227,9 @@ func (h Handler) Configure(options ...func(Handler) Handler) Handler {
 			}
 		}
 	}
+	if err := validateCookie(h.Cookie.HttpCookie); err != nil {
+		panic(err.Error())
+	}
 	return h
 }
 
@@ -189,6 +257,17 @@ func SetStore(s Store) func(Handler) Handler {
 	}
 }
 
+// SetPreviousSecrets is a configuration option which sets the secrets that were
+// used before the current one. Session cookies signed or encrypted with them
+// are still accepted while new cookies use the current secret, so that the
+// secret can be rotated without invalidating every session.
+func SetPreviousSecrets(secrets ...string) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Cookie.PreviousSecrets = secrets
+		return h
+	}
+}
+
 func SetCache(c Cache) func(Handler) Handler {
 	return func(h Handler) Handler {
 		h.Cache = c
@@ -196,6 +275,16 @@ func SetCache(c Cache) func(Handler) Handler {
 	}
 }
 
+// SetClock is a configuration option which sets the Clock used to compute and
+// check the expiry of the session values, typically to control time in tests.
+// Stores keeping track of expiry themselves should be given the same Clock.
+func SetClock(c Clock) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Clock = c
+		return h
+	}
+}
+
 func SetUUIDgenerator(f func() (string, error)) func(Handler) Handler {
 	return func(h Handler) Handler {
 		h.uuidgen = f
@@ -208,7 +297,6 @@ func FixedUUID(id string) func(Handler) Handler {
 		s.uuidgen = func() (string, error) {
 			return id, nil
 		}
-		s.Cookie.SetID(id)
 		return s
 	}
 }
@@ -221,67 +309,127 @@ func ServerOnly() func(Handler) Handler {
 }
 
 // *****************************************************************************
-// Session handler UI
+// Per-request session
 // *****************************************************************************
 
-// ID will return the client session ID if it has not expired. Otherwise it return an error.
-func (h Handler) ID() (string, error) {
-	id, ok := h.Cookie.ID()
+// Session holds the data of a session for the handling of a single request.
+// It is created by its Handler when the session is loaded or generated and
+// is stored in the request context.
+// It is not safe for concurrent use by multiple goroutines.
+type Session struct {
+	h Handler
+
+	// Cookie holds the client side stored session data, sent back to the
+	// client by Save.
+	Cookie  Cookie
+	loaded  bool
+	created time.Time
+
+	// stale is set when the session is served from the Cache while the Store
+	// is unavailable. See ServeStale.
+	stale bool
+
+	// values and changes hold the stored values and their buffered
+	// modifications, for batched sessions.
+	values  map[string][]byte
+	changes map[string]Change
+}
+
+// newSession returns an empty Session, its cookie configured after the
+// Handler's.
+func (h Handler) newSession() *Session {
+	c := h.Cookie.clone()
+	c.Clock = h.Clock
+	h.next = nil
+	return &Session{h: h, Cookie: c}
+}
+
+// From returns the Session of the handler stored in a request context.
+func (h Handler) From(ctx context.Context) (*Session, error) {
+	s, ok := ctx.Value(h.ContextKey).(*Session)
 	if !ok {
+		return nil, ErrNoSession
+	}
+	return s, nil
+}
+
+// Attach returns a copy of the request whose context holds a new Session of
+// the handler, yet to be loaded or generated, or the request itself if it
+// already holds one. Load, Generate, LoadServerOnly and GenerateServerOnly
+// require the Session to be attached: the request they are given is not
+// modified. The Session being a pointer, the modifications they make are
+// visible through the request returned and any request derived from it.
+func (h Handler) Attach(req *http.Request) *http.Request {
+	if _, err := h.From(req.Context()); err == nil {
+		return req
+	}
+	return req.WithContext(h.withSession(req.Context(), h.newSession()))
+}
+
+// ID returns the session ID if it has not expired. Otherwise it returns an
+// error.
+func (s *Session) ID() (string, error) {
+	id, ok := s.Cookie.ID()
+	if !ok || id == "" {
 		return "", ErrNoID
 	}
 	return id, nil
 }
 
-// SetID will set a new id for a client navigation session.
-func (h Handler) SetID(id string) {
-	h.Cookie.SetID(id)
-	h.Cookie.ApplyMods.Set(true)
+// SetID sets a new id for a client navigation session.
+func (s *Session) SetID(id string) {
+	s.Cookie.SetID(id)
+	s.Cookie.ApplyMods.Set(true)
 }
 
-// TODOD set client and server session id in context object?
-
 // Get will retrieve the value corresponding to a given store key from
 // the session.
-func (h Handler) Get(ctx context.Context, key string) ([]byte, error) {
-	id, ok := h.Cookie.ID()
-	if !ok {
-		return nil, ErrNoID
+func (s *Session) Get(ctx context.Context, key string) ([]byte, error) {
+	h := s.h
+	id, err := s.ID()
+	if err != nil {
+		return nil, err
+	}
+	if s.stale {
+		return s.staleGet(ctx, id, key)
+	}
+	if h.batched() {
+		return s.batchGet(ctx, key)
 	}
 
 	if h.Cache != nil {
-		res, err := h.Cache.Get(ctx, id, h.Name+"/"+key)
+		res, err := h.cache().Get(ctx, id, h.Name+"/"+key)
 		if err == nil {
 			return res, err
 		}
 	}
 
 	if h.Store != nil {
-		_, err := h.Store.Get(ctx, id, h.Name+"/"+sessionValidityKey)
+		_, err := h.store().Get(ctx, id, h.Name+"/"+sessionValidityKey)
 		if err != nil {
 			return nil, ErrBadSession.Wraps(err)
 		}
 		// let's touch the session
-		err = h.Touch(ctx)
+		err = s.Touch(ctx)
 		if err != nil {
 			if h.Log != nil {
 				h.Log.Print(err)
 			}
 		}
 
-		res, err := h.Store.Get(ctx, id, h.Name+"/"+key)
+		res, err := h.store().Get(ctx, id, h.Name+"/"+key)
 		if err != nil {
 			return nil, err
 		}
 		if h.Cache != nil {
-			maxage, err := h.Store.TimeToExpiry(ctx, id, h.Name+"/"+key)
+			maxage, err := h.store().TimeToExpiry(ctx, id, h.Name+"/"+key)
 			if err != nil {
 				if h.Log != nil {
 					h.Log.Print(err)
 				}
 				return res, nil
 			}
-			err = h.Cache.Put(ctx, id, h.Name+"/"+key, res, maxage)
+			err = h.cache().Put(ctx, id, h.Name+"/"+key, res, maxage)
 			if err != nil {
 				if h.Log != nil {
 					h.Log.Print(err)
@@ -295,26 +443,32 @@ func (h Handler) Get(ctx context.Context, key string) ([]byte, error) {
 		panic(errors.New("error: serveronly session with no server storage").Error())
 	}
 
-	v, ok := h.Cookie.Get(key)
+	v, ok := s.Cookie.Get(key)
 	if !ok {
 		return nil, ErrKeyNotFound
 	}
-	err := h.Touch(ctx)
+	res := []byte(v)
+	if s.Cookie.Data[key].Spilled {
+		res, err = s.spilled(ctx, id, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = s.Touch(ctx)
 	if err != nil {
 		if h.Log != nil {
 			h.Log.Print(err)
 		}
 	}
-	res := []byte(v)
 	if h.Cache != nil {
-		maxage, err := h.Cookie.TimeToExpiry(key)
+		maxage, err := s.Cookie.TimeToExpiry(key)
 		if err != nil {
 			if h.Log != nil {
 				h.Log.Print(err)
 			}
 			return res, nil
 		}
-		err = h.Cache.Put(ctx, id, h.Name+"/"+key, res, maxage)
+		err = h.cache().Put(ctx, id, h.Name+"/"+key, res, maxage)
 		if err != nil {
 			if h.Log != nil {
 				h.Log.Print(err)
@@ -328,26 +482,37 @@ func (h Handler) Get(ctx context.Context, key string) ([]byte, error) {
 // If no store is present, cookie storage will be used.
 // if maxage < 0, the key/session should expire immediately.
 // if maxage = 0, the key/session has no set expiry.
-func (h Handler) Put(ctx context.Context, key string, value []byte, maxage time.Duration) error {
-	id, ok := h.Cookie.ID()
-	if !ok {
-		return ErrNoID
+func (s *Session) Put(ctx context.Context, key string, value []byte, maxage time.Duration) error {
+	h := s.h
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	if s.stale {
+		return UnavailableError{errStale}
+	}
+	if h.batched() {
+		return s.batchPut(ctx, key, value, maxage)
 	}
 
 	if h.Store != nil {
-		_, err := h.Store.Get(ctx, id, h.Name+"/"+sessionValidityKey)
-		if err != nil {
-			return ErrBadSession.Wraps(err)
+		// The validity key establishes the session: it is the only key which can
+		// be put before the session is valid.
+		if key != sessionValidityKey {
+			_, err := h.store().Get(ctx, id, h.Name+"/"+sessionValidityKey)
+			if err != nil {
+				return ErrBadSession.Wraps(err)
+			}
 		}
 
-		err = h.Store.Put(ctx, id, h.Name+"/"+key, value, maxage)
+		err = h.store().Put(ctx, id, h.Name+"/"+key, value, maxage)
 		if err != nil {
 			return err
 		}
 		// let's touch the session
-		h.Cookie.Touch()
-		if h.Cookie.HttpCookie.MaxAge > 0 {
-			err = h.Store.Put(ctx, id, h.Name+"/"+sessionValidityKey, []byte("true"), time.Duration(h.Cookie.HttpCookie.MaxAge))
+		s.Cookie.Touch()
+		if d := s.validity(); d > 0 {
+			err = h.store().Put(ctx, id, h.Name+"/"+sessionValidityKey, []byte("true"), d)
 			if err != nil {
 				if h.Log != nil {
 					h.Log.Print(err)
@@ -358,12 +523,17 @@ func (h Handler) Put(ctx context.Context, key string, value []byte, maxage time.
 		if h.Cache == nil {
 			return nil
 		}
-		err = h.Cache.Put(ctx, id, h.Name+"/"+key, value, maxage)
+		err = h.cache().Put(ctx, id, h.Name+"/"+key, value, maxage)
 		if err != nil {
 			if h.Log != nil {
 				h.Log.Println(err)
 			}
 		}
+		// The validity value never changes, it only has to be invalidated when
+		// deleted.
+		if key != sessionValidityKey {
+			h.invalidate(ctx, id, key)
+		}
 		return nil
 	}
 
@@ -371,62 +541,86 @@ func (h Handler) Put(ctx context.Context, key string, value []byte, maxage time.
 		panic(errors.New("error: serveronly session with no server storage").Error())
 	}
 
-	h.Cookie.Set(key, string(value), maxage)
+	prev, had := s.Cookie.Data[key]
+	s.Cookie.Set(key, string(value), maxage)
+	if err = s.fit(ctx); err != nil {
+		if had {
+			s.Cookie.Data[key] = prev
+		} else {
+			delete(s.Cookie.Data, key)
+		}
+		return err
+	}
+	if had && prev.Spilled && !s.Cookie.Data[key].Spilled {
+		err = h.spillStore().Delete(ctx, id, h.Name+"/"+key)
+		if err != nil && h.Log != nil {
+			h.Log.Print(err)
+		}
+	}
 
 	// Let's touch the session
 	if key != sessionValidityKey {
-		h.Cookie.Touch()
+		s.Cookie.Touch()
 	}
 
 	if h.Cache == nil {
 		return nil
 	}
 
-	err := h.Cache.Put(ctx, id, h.Name+"/"+key, value, maxage)
+	err = h.cache().Put(ctx, id, h.Name+"/"+key, value, maxage)
 	if err != nil {
 		if h.Log != nil {
 			h.Log.Println(err)
 		}
 	}
+	h.invalidate(ctx, id, key)
 
 	return nil
 }
 
 // Delete will erase a session store item.
-func (h Handler) Delete(ctx context.Context, key string) error {
-	id, ok := h.Cookie.ID()
-	if !ok {
-		return ErrNoID
+func (s *Session) Delete(ctx context.Context, key string) error {
+	h := s.h
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+	if s.stale {
+		return UnavailableError{errStale}
+	}
+	if h.batched() {
+		return s.batchDelete(ctx, key)
 	}
 
-	if h.Cache == nil {
-		err := h.Cache.Delete(ctx, id, h.Name+"/"+key) // Attempt to delete a value from cache MUST succeed.
+	if h.Cache != nil {
+		err := h.cache().Delete(ctx, id, h.Name+"/"+key) // Attempt to delete a value from cache MUST succeed.
 		if err != nil {
 			if h.Log != nil {
 				h.Log.Println(err)
 			}
 		}
+		h.invalidate(ctx, id, key)
 	}
 	if h.Store != nil {
-		_, err := h.Store.Get(ctx, id, h.Name+"/"+sessionValidityKey)
+		_, err := h.store().Get(ctx, id, h.Name+"/"+sessionValidityKey)
 		if err != nil {
 			return nil // the session is invalid anyway.
 		}
 
-		err = h.Store.Delete(ctx, id, h.Name+"/"+key)
+		err = h.store().Delete(ctx, id, h.Name+"/"+key)
 		if err != nil {
 			return err
 		}
 
-		err = h.Touch(ctx)
+		err = s.Touch(ctx)
 		if err != nil {
 			if h.Log != nil {
 				h.Log.Print(err)
 			}
 		}
 		// attempt to touch the session
-		if h.Cookie.HttpCookie.MaxAge > 0 {
-			err = h.Store.Put(ctx, id, h.Name+"/"+sessionValidityKey, []byte("true"), time.Duration(h.Cookie.HttpCookie.MaxAge))
+		if d := s.validity(); d > 0 {
+			err = h.store().Put(ctx, id, h.Name+"/"+sessionValidityKey, []byte("true"), d)
 			if err != nil {
 				if h.Log != nil {
 					h.Log.Print(err)
@@ -439,9 +633,15 @@ func (h Handler) Delete(ctx context.Context, key string) error {
 		panic(errors.New("error: serveronly session with no server storage").Error())
 	}
 
-	h.Cookie.Delete(key)
+	if s.Cookie.Data[key].Spilled {
+		err = h.spillStore().Delete(ctx, id, h.Name+"/"+key)
+		if err != nil {
+			return err
+		}
+	}
+	s.Cookie.Delete(key)
 
-	err := h.Touch(ctx)
+	err = s.Touch(ctx)
 	if err != nil {
 		if h.Log != nil {
 			h.Log.Print(err)
@@ -451,49 +651,176 @@ func (h Handler) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// Touch renews the session: the session cookie is sent back to the client
+// or, for server-only sessions, the session validity is extended in the
+// store.
+func (s *Session) Touch(ctx context.Context) error {
+	// sends the signal to send a session cookie back to the client to renew
+	if !s.h.ServerOnly {
+		s.Cookie.Touch()
+		return nil
+	}
+
+	if d := s.validity(); d > 0 {
+		return s.Put(ctx, sessionValidityKey, []byte("true"), d)
+	}
+	return nil
+}
+
+// Revoke revokes the session.
+func (s *Session) Revoke(ctx context.Context) error {
+	h := s.h
+	id, err := s.ID()
+	if err != nil {
+		return errors.New("Unable to revoke session. Could not retrieve session ID").Wraps(err)
+	}
+	p, perr := h.Parent()
+	var pid []byte
+	if perr == nil {
+		pid, err = s.Get(ctx, p.Name+"/id")
+		if err != nil {
+			if h.Log != nil {
+				h.Log.Print(errors.New("Unable to recover parent session id for revocation.").Wraps(err))
+			}
+			return errors.New("Unable to recover parent session id for revocation.").Wraps(err)
+		}
+	}
+	s.unindex(ctx, id)
+	err = s.Delete(ctx, sessionValidityKey)
+	if err != nil {
+		return err
+	}
+	err = s.flush(ctx)
+	if err != nil {
+		return err
+	}
+	if h.SpillStore != nil {
+		s.unspill(ctx, id)
+	}
+	s.revoked(ctx)
+	s.Cookie.Expire()
+	if perr != nil {
+		return nil
+	}
+	ps := p.newSession()
+	ps.SetID(string(pid))
+	err = ps.Delete(ctx, h.Name+"/"+id)
+	if err != nil && h.Log != nil {
+		h.Log.Print(err)
+	}
+	return nil // we could return the error but it's not mandatory... we'll cleanup the parent session later.
+}
+
+// *****************************************************************************
+// Session handler UI
+// *****************************************************************************
+
+// ID will return the client session ID if it has not expired. Otherwise it return an error.
+func (h Handler) ID(ctx context.Context) (string, error) {
+	s, err := h.From(ctx)
+	if err != nil {
+		return "", ErrNoID
+	}
+	return s.ID()
+}
+
+// SetID will set a new id for a client navigation session.
+func (h Handler) SetID(ctx context.Context, id string) error {
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	s.SetID(id)
+	return nil
+}
+
+// Get will retrieve the value corresponding to a given store key from
+// the session.
+func (h Handler) Get(ctx context.Context, key string) ([]byte, error) {
+	s, err := h.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, key)
+}
+
+// Put will save a key/value pair in the session store (preferentially).
+// If no store is present, cookie storage will be used.
+// if maxage < 0, the key/session should expire immediately.
+// if maxage = 0, the key/session has no set expiry.
+func (h Handler) Put(ctx context.Context, key string, value []byte, maxage time.Duration) error {
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, value, maxage)
+}
+
+// Delete will erase a session store item.
+func (h Handler) Delete(ctx context.Context, key string) error {
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, key)
+}
+
 func (h Handler) Loaded(ctx context.Context) bool {
-	_, ok := ctx.Value(h.ContextKey).(http.Cookie)
-	return ok
+	s, err := h.From(ctx)
+	return err == nil && s.loaded
 }
 
-// loadFromCookie recovers the session data from the session cookie sent by the client or,
-// if already called before, attempts to find the latest version of the session
-// cookie that will have been saved by using the Save method.
-func (h Handler) loadCookie(res http.ResponseWriter, req *http.Request) error {
-	ctx := req.Context()
+// loadCookie recovers the session data from the session cookie sent by the
+// client.
+func (s *Session) loadCookie(req *http.Request) error {
+	h := s.h
 	// Let's try to load a session cookie value from the request
-	reqc, err := req.Cookie(h.Name)
+	reqc, err := req.Cookie(s.Cookie.HttpCookie.Name)
 	if err != nil {
 		// at this point, should generate a new session since there is no session cookie
 		// sent by the client.
-		req = req.WithContext(context.WithValue(ctx, h.ContextKey, ErrBadSession))
 		return ErrBadSession.Wraps(err)
 	}
 
-	err = h.Cookie.Decode(*reqc)
+	err = s.Cookie.Decode(*reqc)
 	if err != nil {
+		if err == ErrExpired {
+			s.expired(req.Context())
+		}
 		if h.Log != nil {
 			h.Log.Println(errors.New("Bad cookie").Wraps(err))
 		}
-		req = req.WithContext(context.WithValue(ctx, h.ContextKey, ErrBadCookie))
 		return ErrBadCookie.Wraps(err)
 	}
-	h.Cookie.ApplyMods.Set(false)
+	s.Cookie.ApplyMods.Set(false)
 
 	if h.Store != nil {
-		_, err = h.Get(ctx, sessionValidityKey)
+		_, err = s.Get(req.Context(), sessionValidityKey)
 		if err != nil {
-			req = req.WithContext(context.WithValue(ctx, h.ContextKey, ErrBadSession))
 			return ErrBadSession.Wraps(err)
 		}
 	}
-	req = req.WithContext(context.WithValue(ctx, h.ContextKey, *(h.Cookie.HttpCookie)))
-	return  nil
+	return nil
 }
 
-func (h *Handler) Load(res http.ResponseWriter, req *http.Request) error {
-	ctx:= req.Context()
-	if h.Loaded(ctx) {
+// Load loads the session of a request. The request must hold a Session
+// attached by Attach, which is loaded: the handlers which are passed the
+// request afterwards, or any request derived from it, find the loaded session
+// in its context. ErrNoSession is returned otherwise.
+// It returns an UnavailableError if the session cannot be loaded because the
+// Store is unavailable.
+func (h Handler) Load(res http.ResponseWriter, req *http.Request) error {
+	err := h.load(res, req)
+	return h.unavailable(req.Context(), err)
+}
+
+func (h Handler) load(res http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	if s.loaded {
 		return nil
 	}
 
@@ -504,28 +831,28 @@ func (h *Handler) Load(res http.ResponseWriter, req *http.Request) error {
 			return ErrParentInvalid
 		}
 
-		pid, err := p.ID()
+		pid, err := p.ID(ctx)
 		if err != nil {
 			return ErrParentInvalid.Wraps(err)
 		}
 
 		if !h.ServerOnly {
-			err = h.loadCookie(res, req)
+			err = s.loadCookie(req)
 			if err != nil {
 				return err
 			}
 		}
 
-		id, err := h.ID()
+		id, err := s.ID()
 		if err != nil {
 			return ErrNoID
 		}
-		_, err = h.Get(ctx, sessionValidityKey)
+		_, err = s.Get(ctx, sessionValidityKey)
 		if err != nil {
 			return ErrBadSession.Wraps(err)
 		}
 
-		psid, err := h.Get(ctx, p.Name+"/id")
+		psid, err := s.Get(ctx, p.Name+"/id")
 		if err != nil {
 			return ErrBadSession.Wraps(errors.New("Could not retrieve parent session id").Wraps(err))
 		}
@@ -537,60 +864,109 @@ func (h *Handler) Load(res http.ResponseWriter, req *http.Request) error {
 			return ErrBadSession.Wraps(errors.New("The session does not appear on its parent"))
 		}
 
+		if err := s.markLoaded(ctx); err != nil {
+			return err
+		}
 		return h.Save(res, req)
 	}
 	// if session has no parent
 	if !h.ServerOnly {
-		return h.loadCookie(res, req)
+		if err := s.loadCookie(req); err != nil {
+			return err
+		}
+		if err := s.markLoaded(ctx); err != nil {
+			return err
+		}
+		return nil
 	}
-	_, err = h.ID()
+	_, err = s.ID()
 	if err != nil {
 		return ErrNoID
 	}
-	_, err = h.Get(ctx, sessionValidityKey)
+	_, err = s.Get(ctx, sessionValidityKey)
 	if err != nil {
 		return ErrBadSession.Wraps(err)
 	}
+	if err := s.markLoaded(ctx); err != nil {
+		return err
+	}
 	return h.Save(res, req)
 }
 
-// Save will modify and keep the session data in the per-request context store.
+// Save sends the session cookie to the client, replacing the one set earlier
+// during the handling of the request, if any.
 // It needs to be called to apply session data changes.
 // These changes entail a modification in the value of the session cookie.
-// The session cookie is stored in the context.Context non-encoded.
-// Not safe for concurrent use by multiple goroutines.
-func (h *Handler) Save(res http.ResponseWriter, req *http.Request) error {
-	ctx:= req.Context()
-	hc, err := h.Cookie.Encode()
+func (h Handler) Save(res http.ResponseWriter, req *http.Request) error {
+	s, err := h.From(req.Context())
+	if err != nil {
+		return err
+	}
+	err = s.flush(req.Context())
+	if err != nil {
+		return err
+	}
+	hc, err := s.Cookie.Encode()
 	if err != nil {
 		return err
 	}
 	if !h.ServerOnly {
-		http.SetCookie(res, &hc)
+		setCookie(res, &hc)
 	}
-	h.Cookie.ApplyMods.Set(false)
-	req = req.WithContext(context.WithValue(ctx, h.ContextKey, hc))
+	s.Cookie.ApplyMods.Set(false)
 	return nil
 }
 
+// setCookie sets a cookie, replacing any cookie of the same name already set
+// on the response.
+func setCookie(w http.ResponseWriter, c *http.Cookie) {
+	hdr := w.Header()
+	var kept []string
+	for _, v := range hdr.Values("Set-Cookie") {
+		if !strings.HasPrefix(v, c.Name+"=") {
+			kept = append(kept, v)
+		}
+	}
+	hdr.Del("Set-Cookie")
+	for _, v := range kept {
+		hdr.Add("Set-Cookie", v)
+	}
+	http.SetCookie(w, c)
+}
+
 // Generate creates a completely new session. with a new generated id.
-func (h *Handler) Generate(res http.ResponseWriter, req *http.Request) error {
-	ctx:=req.Context()
+// The request must hold a Session attached by Attach, which is reset with the
+// new session. ErrNoSession is returned otherwise.
+// It returns an UnavailableError if the session cannot be generated because
+// the Store is unavailable.
+func (h Handler) Generate(res http.ResponseWriter, req *http.Request) error {
+	err := h.generate(res, req)
+	return h.unavailable(req.Context(), err)
+}
+
+func (h Handler) generate(res http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
 	// 1. Create UUID
-	id, err := h.uuidgen()
+	id, err := h.newID()
 	if err != nil {
-		return  err
+		return err
 	}
 
 	// 2. Update session cookie
-	for k := range h.Cookie.Data {
-		delete(h.Cookie.Data, k)
+	for k := range s.Cookie.Data {
+		delete(s.Cookie.Data, k)
 	}
-	h.Cookie.SetID(id)
-	h.Cookie.ApplyMods.Set(true)
+	s.Cookie.SetID(id)
+	s.Cookie.ApplyMods.Set(true)
+	s.resetValues(true)
 
 	// 3.  Establish the session on the server if server storage is available
-	err = h.Put(ctx, sessionValidityKey, []byte("true"), time.Duration(h.Cookie.HttpCookie.MaxAge))
+	s.created = h.now()
+	err = s.Put(ctx, sessionValidityKey, []byte("true"), s.validity())
 	if err != nil {
 		return errors.New("Failed to generate new session.").Wraps(err)
 	}
@@ -600,37 +976,44 @@ func (h *Handler) Generate(res http.ResponseWriter, req *http.Request) error {
 		if !p.Loaded(ctx) {
 			return ErrParentInvalid
 		}
-		err = h.Put(ctx, p.Name+"/id", []byte(id), 0)
+		err = s.Put(ctx, p.Name+"/id", []byte(id), 0)
 		if err != nil {
 			return err
 		}
 		err = p.Put(ctx, h.Name+"/"+id, Info(req).ToJSON(), 0)
+		if err != nil {
+			return err
+		}
 	}
 
+	if err := s.markCreated(ctx); err != nil {
+		return err
+	}
 	return h.Save(res, req)
 }
 
-// Load is used to load a session which is only known server-side. (serve-only)
+// LoadServerOnly is used to load a session which is only known server-side.
 // In general, those kind of sessions are tied to a regular session (cookie-based).
-func LoadServerOnly(r *http.Request, id string, h *Handler) error {
-	ctx:= r.Context()
+// The request must hold a Session attached by Attach, which is loaded.
+func LoadServerOnly(r *http.Request, id string, h Handler) error {
+	ctx := r.Context()
 	if !h.ServerOnly || h.Store == nil {
 		return errors.New("Unable to load server session. Session Handler parameters are incorrect")
 	}
 
 	if h.Loaded(ctx) {
-		sid, err := h.ID()
-		if err != nil {
-			goto load
-		}
-		if sid != id {
-			goto load
+		if sid, err := h.ID(ctx); err == nil && sid == id {
+			return nil
 		}
-		return nil
 	}
 
-load:
-	h.SetID(id)
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	s.loaded = false
+	s.SetID(id)
+	s.resetValues(false)
 
 	p, err := h.Parent()
 	if err == nil {
@@ -639,17 +1022,17 @@ load:
 			return ErrParentInvalid
 		}
 
-		pid, err := p.ID()
+		pid, err := p.ID(ctx)
 		if err != nil {
 			return ErrParentInvalid.Wraps(err)
 		}
 
-		_, err = h.Get(ctx, sessionValidityKey)
+		_, err = s.Get(ctx, sessionValidityKey)
 		if err != nil {
 			return ErrBadSession.Wraps(err)
 		}
 
-		psid, err := h.Get(ctx, p.Name+"/id")
+		psid, err := s.Get(ctx, p.Name+"/id")
 		if err != nil {
 			return ErrParentInvalid.Wraps(err)
 		}
@@ -660,34 +1043,36 @@ load:
 		if err != nil {
 			return ErrBadSession.Wraps(errors.New("The session does not appear on its parent"))
 		}
-		hc, err := h.Cookie.Encode()
-		if err != nil {
+		s.Cookie.ApplyMods.Set(false)
+		if err := s.markLoaded(ctx); err != nil {
 			return err
 		}
-		h.Cookie.ApplyMods.Set(false)
-		r = r.WithContext(context.WithValue(ctx, h.ContextKey, hc))
 		return nil
 	}
 	// if session has no parent
-	_, err = h.Get(ctx, sessionValidityKey)
+	_, err = s.Get(ctx, sessionValidityKey)
 	if err != nil {
 		return ErrBadSession.Wraps(err)
 	}
-	hc, err := h.Cookie.Encode()
-	if err != nil {
+	s.Cookie.ApplyMods.Set(false)
+	if err := s.markLoaded(ctx); err != nil {
 		return err
 	}
-	h.Cookie.ApplyMods.Set(false)
-	r = r.WithContext(context.WithValue(ctx, h.ContextKey, hc))
 	return nil
 }
 
-// Generate will create and load in context.Context a new server-only session
-// for a provided id if it does not already exist.
-func GenerateServerOnly(r *http.Request, id string, h *Handler)  error {
-	ctx:= r.Context()
-	h.SetID(id)
-	_, err := h.Get(r.Context(), sessionValidityKey)
+// GenerateServerOnly will create and load in the request context a new
+// server-only session for a provided id if it does not already exist.
+// The request must hold a Session attached by Attach.
+func GenerateServerOnly(r *http.Request, id string, h Handler) error {
+	ctx := r.Context()
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
+	}
+	s.SetID(id)
+	s.resetValues(false)
+	_, err = s.Get(ctx, sessionValidityKey)
 	if err == nil {
 		err = LoadServerOnly(r, id, h)
 		if err != nil {
@@ -695,7 +1080,8 @@ func GenerateServerOnly(r *http.Request, id string, h *Handler)  error {
 		}
 		return err
 	}
-	err = h.Put(ctx, sessionValidityKey, []byte("true"), time.Duration(h.Cookie.HttpCookie.MaxAge))
+	s.created = h.now()
+	err = s.Put(ctx, sessionValidityKey, []byte("true"), s.validity())
 	if err != nil {
 		return err
 	}
@@ -705,25 +1091,26 @@ func GenerateServerOnly(r *http.Request, id string, h *Handler)  error {
 		if !p.Loaded(ctx) {
 			return ErrParentInvalid
 		}
-		err = h.Put(ctx, p.Name+"/id", []byte(id), 0)
+		err = s.Put(ctx, p.Name+"/id", []byte(id), 0)
 		if err != nil {
 			return err
 		}
 		err = p.Put(ctx, h.Name+"/"+id, Info(r).ToJSON(), 0)
+		if err != nil {
+			return err
+		}
 	}
 
-	hc, err := h.Cookie.Encode()
-	if err != nil {
+	s.Cookie.ApplyMods.Set(false)
+	if err := s.markCreated(ctx); err != nil {
 		return err
 	}
-	h.Cookie.ApplyMods.Set(false)
-	r = r.WithContext(context.WithValue(ctx, h.ContextKey, hc))
-	return nil
+	return s.flush(ctx)
 }
 
 // Spawn returns a handler for a subsession, that is, a dependent session.
 func (h Handler) Spawn(name string, options ...func(Handler) Handler) Handler {
-	sh := New(name, h.Secret, options...)
+	sh := New(name, h.Secret, append([]func(Handler) Handler{SetPreviousSecrets(h.Cookie.PreviousSecrets...)}, options...)...)
 	sh.parent = &h
 	return sh
 }
@@ -737,8 +1124,8 @@ func (h Handler) Spawned(s Handler) Handler {
 	return h
 }
 
-// Parent returns an unitialized copy of the handler of a Parent session if
-// the aforementionned exists.
+// Parent returns a copy of the handler of a Parent session if the
+// aforementionned exists.
 // To use a Parent session,the Load method should be called first.
 func (h Handler) Parent() (Handler, error) {
 	if h.parent != nil {
@@ -748,47 +1135,22 @@ func (h Handler) Parent() (Handler, error) {
 	return h, ErrParentInvalid
 }
 
-// Revoke revokes the current session.
+// Revoke revokes the session of the request context.
 func (h Handler) Revoke(ctx context.Context) error {
-	id, err := h.ID()
+	s, err := h.From(ctx)
 	if err != nil {
 		return errors.New("Unable to revoke session. Could not retrieve session ID").Wraps(err)
 	}
-	h.Cookie.Expire()
-	err = h.Delete(ctx, sessionValidityKey)
-	if err != nil {
-		return err
-	}
-	p, err := h.Parent()
-	if err != nil {
-		return nil
-	}
-	pid, err := h.Get(ctx, p.Name+"/id")
-	if err != nil {
-		if h.Log != nil {
-			h.Log.Print(errors.New("Unable to recover parent session id for revocation.").Wraps(err))
-		}
-		return errors.New("Unable to recover parent session id for revocation.").Wraps(err)
-	}
-	p.SetID(string(pid))
-	err = p.Delete(ctx, h.Name+"/"+id)
-	if h.Log != nil {
-		h.Log.Print(err)
-	}
-	return nil // we could return the error but it's not mandatory... we'll cleanup the parent session later.
+	return s.Revoke(ctx)
 }
 
+// Touch renews the session of the request context.
 func (h Handler) Touch(ctx context.Context) error {
-	// sends the signal to send a session cookie back to the client to renew
-	if !h.ServerOnly {
-		h.Cookie.Touch()
-		return nil
-	}
-
-	if h.Cookie.HttpCookie.MaxAge > 0 {
-		return h.Put(ctx, sessionValidityKey, []byte("true"), time.Duration(h.Cookie.HttpCookie.MaxAge))
+	s, err := h.From(ctx)
+	if err != nil {
+		return err
 	}
-	return nil
+	return s.Touch(ctx)
 }
 
 // ServeHTTP effectively makes the session a xhttp request handler.
@@ -797,13 +1159,29 @@ func (h Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	// cookie header. As such, we have to add a Vary header.
 	res.Header().Add("Vary", "Cookie")
 
+	// The Session is attached to a copy of the request so that the request
+	// received is left untouched.
+	req = h.Attach(req)
+
+	// The session is only generated anew if it failed to load for another
+	// reason than the unavailability of the Store. Otherwise the request is
+	// served according to the DegradedMode.
 	err := h.Load(res, req)
+	_, unavailable := err.(UnavailableError)
+	fresh := err != nil && !unavailable
+	if fresh {
+		err = h.Generate(res, req)
+		_, unavailable = err.(UnavailableError)
+	}
 	if err != nil {
-	 	err = h.Generate(res, req)
-		if err != nil {
+		if !unavailable {
 			http.Error(res, "Unable to generate session", http.StatusInternalServerError)
 			return
 		}
+		if !h.degrade(req.Context(), fresh) {
+			http.Error(res, "Session store unavailable", http.StatusServiceUnavailable)
+			return
+		}
 	}
 	err = h.Save(res, req)
 	if err != nil {
@@ -822,10 +1200,18 @@ func (h Handler) Link(hn xhttp.Handler) xhttp.HandlerLinker {
 	return h
 }
 
+// Metadata describes a session: when and from which client it was started.
+// The sessions indexed by owner also carry their id and owner.
+//
+// The ID is a session credential: it should not be disclosed to clients other
+// than the one holding the session.
 type Metadata struct {
+	ID        string    `json:"id,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
 	Start     time.Time `json:"start"`
 	UserAgent string    `json:"useragent"`
 	IPAddress string    `json:"ipaddress"`
+	RequestID string    `json:"requestid,omitempty"`
 }
 
 func (m Metadata) ToJSON() []byte {
@@ -841,27 +1227,49 @@ func Info(r *http.Request) Metadata {
 	m.Start = time.Now().UTC()
 	m.UserAgent = r.UserAgent()
 	m.IPAddress = r.RemoteAddr
+	m.RequestID = requestid.FromContext(r.Context())
 	return m
 }
 
-// Enforce return a handler whose purpose is tom make sure that the sessions are
-// present before continuing with request handling.
+// enforcer is the request handler returned by Enforcer.
+type enforcer struct {
+	sessions []Handler
+	next     xhttp.Handler
+}
+
+// Enforcer returns a handler whose purpose is to make sure that the sessions
+// are present before continuing with request handling. The loaded sessions are
+// passed down the chain in the request context. A request for which one of
+// the sessions cannot be loaded is rejected and not handled any further.
 func Enforcer(sessions ...Handler) xhttp.HandlerLinker {
-	return xhttp.LinkableHandler(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c := r.Context()
-		var err error
-		if len(sessions) != 0 {
-			for _, s := range sessions { // TODO cancel context
-				err = s.Load(w, r)
-				if err != nil {
-					http.Error(w, "Some session credentials are missing", http.StatusUnauthorized) // TODO perhaps create an enforcer that does not write the response but return a bool or something
-					return
-				}
-				continue
+	return enforcer{sessions: sessions}
+}
+
+func (e enforcer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The Sessions are attached to a copy of the request so that the request
+	// received is left untouched.
+	for _, s := range e.sessions {
+		r = s.Attach(r)
+	}
+	for _, s := range e.sessions {
+		if err := s.Load(w, r); err != nil {
+			if _, ok := err.(UnavailableError); ok {
+				http.Error(w, "Session store unavailable", http.StatusServiceUnavailable)
+				return
 			}
+			http.Error(w, "Some session credentials are missing", http.StatusUnauthorized)
+			return
 		}
-		r=r.WithContext(c)
-	}))
+	}
+	if e.next != nil {
+		e.next.ServeHTTP(w, r)
+	}
+}
+
+// Link enables the linking of a xhttp.Handler to the Enforcer.
+func (e enforcer) Link(hn xhttp.Handler) xhttp.HandlerLinker {
+	e.next = hn
+	return e
 }
 
 /*
@@ -2,10 +2,17 @@ package session
 
 import (
 	"bytes"
+	"encoding/base64"
 	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
 	//"log"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -32,15 +39,14 @@ func Multiplexer(t *testing.T) (xhttp.ServeMux, Handler) {
 
 	r.POST("/", xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		ctx:= req.Context()
-		_, ok := ctx.Value(s.ContextKey).(http.Cookie)
-		if !ok {
+		if !s.Loaded(ctx) {
 			t.Error("The session was not loaded")
 		}
 
-		id, ok := s.Cookie.ID()
-		if !ok {
+		id, err := s.ID(ctx)
+		if err != nil {
 			//http.Error(res, ErrNoID.Error(), 501)
-			t.Errorf("Expected an id of %v but it is %v as we're getting %v \n Cookie maxage is %v ", fakeSessionID, ok, id, s.Cookie.HttpCookie.MaxAge)
+			t.Errorf("Expected an id of %v but got %v as we're getting %v \n Cookie maxage is %v ", fakeSessionID, err, id, s.Cookie.HttpCookie.MaxAge)
 		}
 
 		s.Put(ctx,"test", []byte("test"), 86400*time.Minute)
@@ -112,12 +118,13 @@ func TestSession(t *testing.T) {
 		t.Fatal("Some additional cookie with a name I don't know has been set?!")
 	}
 
-	err = sess.Cookie.Decode(*scookie)
+	ss := sess.newSession()
+	err = ss.Cookie.Decode(*scookie)
 	if err != nil {
 		t.Error(err)
 	}
 
-	bstr, err := sess.Get(context.Background(),"test")
+	bstr, err := ss.Get(context.Background(), "test")
 
 	if err != nil {
 		t.Fatal("unable to retrieve new item put in the session cookie under the key <<test>>", err)
@@ -174,7 +181,1500 @@ func TestSession(t *testing.T) {
 
 }
 
+func TestConcurrentSessions(t *testing.T) {
+	s := New(GSID, "secret")
+	h := s.Link(xhttp.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		v := req.URL.Query().Get("v")
+		if err := s.Put(ctx, "v", []byte(v), 0); err != nil {
+			t.Error(err)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		got, err := s.Get(ctx, "v")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(got) != v {
+			t.Errorf("Expected the session value %q but got %q", v, got)
+		}
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "http://example.com/?v="+strconv.Itoa(i), nil)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if s.Loaded(req.Context()) {
+				t.Error("Expected the session not to be attached to the request received")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, ok := s.Cookie.Data["v"]; ok {
+		t.Errorf("Expected the handler configuration to be left untouched but got %v", s.Cookie.Data)
+	}
+}
+
 func TestSessionInterface(t *testing.T) {
 	s := New(GSID, "secret")
 	_ = Interface(&s)
 }
+
+func TestClock(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	s := New(GSID, "secret", SetClock(clock))
+
+	s.Cookie.Set("k", "v", time.Minute)
+	if v, ok := s.Cookie.Get("k"); !ok || v != "v" {
+		t.Fatalf("Expected the value to be retrievable. Got %q %v", v, ok)
+	}
+	if d, err := s.Cookie.TimeToExpiry("k"); err != nil || d != time.Minute {
+		t.Fatalf("Expected the value to expire in a minute. Got %v %v", d, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := s.Cookie.Get("k"); ok {
+		t.Fatal("Expected the value to have expired.")
+	}
+}
+
+type slowStore struct {
+	Store
+	delay time.Duration
+}
+
+func (s slowStore) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	select {
+	case <-time.After(s.delay):
+		return []byte("true"), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestStoreTimeout(t *testing.T) {
+	s := New(GSID, "secret", SetStore(slowStore{delay: time.Second}), SetStoreTimeout(10*time.Millisecond))
+	ss := s.newSession()
+	ss.SetID(fakeSessionID)
+
+	start := time.Now()
+	_, err := ss.Get(context.Background(), "key")
+	if err == nil {
+		t.Fatal("Expected the store call to time out.")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Expected the store call to be bounded. Took %v", d)
+	}
+}
+
+func TestCookieOptions(t *testing.T) {
+	s := New("__Host-SID", "secret", SetSameSite(http.SameSiteNoneMode), SetPartitioned(true))
+	c := s.Cookie.HttpCookie
+	if !c.Secure || !c.HttpOnly || c.Path != "/" || c.SameSite != http.SameSiteNoneMode || !c.Partitioned {
+		t.Fatalf("unexpected session cookie attributes: %+v", c)
+	}
+
+	// Configured handlers do not share their cookie attributes.
+	s2 := s.Configure(SetHttpOnly(false))
+	if !s.Cookie.HttpCookie.HttpOnly || s2.Cookie.HttpCookie.HttpOnly {
+		t.Fatal("expected Configure not to modify the original session cookie")
+	}
+
+	invalid := map[string]func() Handler{
+		"relative path":       func() Handler { return New("SID", "secret", SetPath("admin")) },
+		"insecure samesite":   func() Handler { return New("SID", "secret", SetSecure(false), SetSameSite(http.SameSiteNoneMode)) },
+		"insecure partition":  func() Handler { return New("SID", "secret", SetSecure(false), SetPartitioned(true)) },
+		"host prefix domain":  func() Handler { return New("__Host-SID", "secret", SetDomain("example.com")) },
+		"secure prefix":       func() Handler { return New("__Secure-SID", "secret", SetSecure(false)) },
+		"configure host path": func() Handler { return s.Configure(SetPath("/app")) },
+	}
+	for name, f := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected an invalid configuration to panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+// memStore is a minimal in-memory Store which can rename, flush and index
+// sessions.
+type memStore struct {
+	mu     sync.Mutex
+	data   map[string]map[string][]byte
+	owners map[string]map[string]Metadata
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		data:   make(map[string]map[string][]byte),
+		owners: make(map[string]map[string]Metadata),
+	}
+}
+
+func (m *memStore) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[id][hkey]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (m *memStore) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.data[id] == nil {
+		m.data[id] = make(map[string][]byte)
+	}
+	m.data[id][hkey] = content
+	return nil
+}
+
+func (m *memStore) Delete(ctx context.Context, id string, hkey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data[id], hkey)
+	return nil
+}
+
+func (m *memStore) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (m *memStore) Rename(ctx context.Context, oldid string, newid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[newid] = m.data[oldid]
+	delete(m.data, oldid)
+	return nil
+}
+
+func (m *memStore) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = make(map[string]map[string][]byte)
+	return nil
+}
+
+func (m *memStore) DeleteByPrefix(ctx context.Context, prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.data {
+		if strings.HasPrefix(id, prefix) {
+			delete(m.data, id)
+		}
+	}
+	for owner := range m.owners {
+		if strings.HasPrefix(owner, prefix) {
+			delete(m.owners, owner)
+		}
+	}
+	return nil
+}
+
+func (m *memStore) Register(ctx context.Context, md Metadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owners[md.Owner] == nil {
+		m.owners[md.Owner] = make(map[string]Metadata)
+	}
+	m.owners[md.Owner][md.ID] = md
+	return nil
+}
+
+func (m *memStore) ListByOwner(ctx context.Context, owner string) ([]Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res []Metadata
+	for _, md := range m.owners[owner] {
+		res = append(res, md)
+	}
+	return res, nil
+}
+
+func (m *memStore) DeleteByOwner(ctx context.Context, owner string, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(ids) == 0 {
+		for id := range m.owners[owner] {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		if _, ok := m.owners[owner][id]; ok {
+			delete(m.owners[owner], id)
+			delete(m.data, id)
+		}
+	}
+	return nil
+}
+
+func TestRenew(t *testing.T) {
+	ids := []string{fakeSessionID, fakeSessionID2}
+	uuid := func() (string, error) {
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+	store := newMemStore()
+	s := New(GSID, "secret", SetStore(store), SetUUIDgenerator(uuid))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	if err := s.Renew(w, r); err != ErrNoSession {
+		t.Fatalf("Expected renewing an absent session to fail with ErrNoSession but got %v", err)
+	}
+	r = s.Attach(r)
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(r.Context(), "user", []byte("john"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Renew(w, r); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.ID(r.Context())
+	if err != nil || id != fakeSessionID2 {
+		t.Fatalf("Expected the session id to be %q but got %q %v", fakeSessionID2, id, err)
+	}
+	if v, err := s.Get(r.Context(), "user"); err != nil || string(v) != "john" {
+		t.Fatalf("Expected the session data to be kept but got %q %v", v, err)
+	}
+
+	old := s.newSession()
+	old.SetID(fakeSessionID)
+	if _, err := old.Get(context.Background(), "user"); err == nil {
+		t.Fatal("Expected the old session id to be invalidated")
+	}
+
+	// Stores which cannot rename sessions cannot renew them.
+	ns := New(GSID, "secret", SetStore(struct{ Store }{newMemStore()}))
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r = ns.Attach(r)
+	if err := ns.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Renew(w, r); err != ErrRenewNotSupported {
+		t.Fatalf("Expected ErrRenewNotSupported but got %v", err)
+	}
+}
+
+func TestRevokeAll(t *testing.T) {
+	s := New(GSID, "secret", SetStore(newMemStore()))
+	w := httptest.NewRecorder()
+	if err := s.Generate(w, s.Attach(httptest.NewRequest("GET", "http://example.com/", nil))); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+
+	if err := s.RevokeAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r = s.Attach(r)
+	if err := s.Load(httptest.NewRecorder(), r); err == nil {
+		t.Fatal("Expected the session to be revoked")
+	}
+
+	if err := New(GSID, "secret").RevokeAll(context.Background()); err != ErrRevokeAllNotSupported {
+		t.Fatalf("Expected client-side sessions not to be revocable at once but got %v", err)
+	}
+	ns := New(GSID, "secret", SetStore(struct{ Store }{newMemStore()}))
+	if err := ns.RevokeAll(context.Background()); err != ErrRevokeAllNotSupported {
+		t.Fatalf("Expected ErrRevokeAllNotSupported but got %v", err)
+	}
+}
+
+func TestCookieBudget(t *testing.T) {
+	ctx := context.Background()
+	large := []byte(strings.Repeat("x", 3000))
+
+	s := New(GSID, "secret")
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r = s.Attach(r)
+	if err := s.Generate(httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(r.Context(), "large", large, 0); err != ErrTooLarge {
+		t.Fatalf("Expected ErrTooLarge but got %v", err)
+	}
+	if _, err := s.Get(r.Context(), "large"); err != ErrKeyNotFound {
+		t.Fatalf("Expected the value not to be stored but got %v", err)
+	}
+
+	spill := newMemStore()
+	s = New(GSID, "secret", FixedUUID(fakeSessionID), CookieBudget(1024, spill))
+	w := httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r = s.Attach(r)
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(r.Context(), "small", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(r.Context(), "large", large, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := spill.Get(ctx, fakeSessionID, GSID+"/large"); string(v) != string(large) {
+		t.Fatal("Expected the large value to be moved to the spill store")
+	}
+	if _, err := spill.Get(ctx, fakeSessionID, GSID+"/small"); err == nil {
+		t.Fatal("Expected the small value to be kept in the cookie")
+	}
+	c := w.Result().Cookies()[0]
+	if len(c.String()) > 1024 {
+		t.Fatalf("Expected the cookie to fit in its budget but got %d bytes", len(c.String()))
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r.AddCookie(c)
+	r = s.Attach(r)
+	if err := s.Load(httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(r.Context(), "large"); err != nil || string(v) != string(large) {
+		t.Fatalf("Expected the spilled value to be retrieved but got %v", err)
+	}
+	if err := s.Revoke(r.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spill.Get(ctx, fakeSessionID, GSID+"/large"); err == nil {
+		t.Fatal("Expected the spilled value to be deleted with the session")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	store := newMemStore()
+	a := New(GSID, "secret", FixedUUID(fakeSessionID), SetStore(store), SetNamespace("a"))
+	b := New(GSID, "secret", FixedUUID(fakeSessionID), SetStore(store), SetNamespace("b"))
+
+	ra := httptest.NewRequest("GET", "http://example.com/", nil)
+	ra = a.Attach(ra)
+	if err := a.Generate(httptest.NewRecorder(), ra); err != nil {
+		t.Fatal(err)
+	}
+	a.Put(ra.Context(), "k", []byte("a"), 0)
+	if err := a.SetOwner(ra, "john"); err != nil {
+		t.Fatal(err)
+	}
+	rb := httptest.NewRequest("GET", "http://example.com/", nil)
+	rb = b.Attach(rb)
+	if err := b.Generate(httptest.NewRecorder(), rb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(rb.Context(), "k"); err == nil {
+		t.Fatal("Expected the sessions of another namespace not to collide")
+	}
+	b.Put(rb.Context(), "k", []byte("b"), 0)
+	if err := b.SetOwner(rb, "john"); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := a.Sessions(ra.Context(), "john"); err != nil || len(list) != 1 || list[0].ID != fakeSessionID {
+		t.Fatalf("Expected the owner sessions to be listed per namespace but got %v %v", list, err)
+	}
+
+	// Offboarding a namespace leaves the others untouched.
+	if err := a.RevokeAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get(ra.Context(), "k"); err == nil {
+		t.Fatal("Expected the sessions of the namespace to be revoked")
+	}
+	if v, err := b.Get(rb.Context(), "k"); err != nil || string(v) != "b" {
+		t.Fatalf("Expected the sessions of other namespaces to be kept but got %q %v", v, err)
+	}
+	if list, _ := b.Sessions(rb.Context(), "john"); len(list) != 1 {
+		t.Fatalf("Expected the index of other namespaces to be kept but got %v", list)
+	}
+}
+
+func TestSessionsByOwner(t *testing.T) {
+	ids := []string{"id1", "id2", "id3", "id4"}
+	uuid := func() (string, error) {
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+	store := newMemStore()
+	s := New(GSID, "secret", SetStore(store), SetUUIDgenerator(uuid))
+	login := func(ua string) *http.Request {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.Header.Set("User-Agent", ua)
+		r = s.Attach(r)
+		if err := s.Generate(httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetOwner(r, "john"); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	ctx := context.Background()
+
+	phone := login("phone")
+	login("laptop")
+	tablet := login("tablet")
+	list, err := s.Sessions(ctx, "john")
+	if err != nil || len(list) != 3 {
+		t.Fatalf("Expected 3 sessions but got %v %v", list, err)
+	}
+	for _, m := range list {
+		if m.Owner != "john" || m.IPAddress != "192.0.2.1:1234" || m.UserAgent == "" || m.Start.IsZero() {
+			t.Fatalf("Expected the session metadata to be recorded but got %+v", m)
+		}
+	}
+	if owner, err := s.Owner(phone.Context()); err != nil || owner != "john" {
+		t.Fatalf("Expected the owner of the session to be john but got %q %v", owner, err)
+	}
+
+	// Renewed sessions are indexed under their new id.
+	if err := s.Renew(httptest.NewRecorder(), tablet); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = s.Sessions(ctx, "john")
+	found := false
+	for _, m := range list {
+		found = found || (m.ID == "id4" && m.UserAgent == "tablet")
+		if m.ID == "id3" {
+			t.Fatal("Expected the old id to be removed from the index")
+		}
+	}
+	if !found || len(list) != 3 {
+		t.Fatalf("Expected the renewed session to be indexed under its new id but got %v", list)
+	}
+
+	if err := s.RevokeOtherSessions(phone.Context()); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = s.Sessions(ctx, "john")
+	if len(list) != 1 || list[0].ID != "id1" {
+		t.Fatalf("Expected only the current session to remain but got %v", list)
+	}
+	if _, err := s.Get(tablet.Context(), sessionValidityKey); err == nil {
+		t.Fatal("Expected the other sessions to be revoked")
+	}
+
+	if err := s.Revoke(phone.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = s.Sessions(ctx, "john"); len(list) != 0 {
+		t.Fatalf("Expected the revoked session to be removed from the index but got %v", list)
+	}
+
+	ns := New(GSID, "secret", SetStore(struct{ Store }{newMemStore()}))
+	if _, err := ns.Sessions(ctx, "john"); err != ErrIndexNotSupported {
+		t.Fatalf("Expected ErrIndexNotSupported but got %v", err)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := 0
+	uuid := func() (string, error) {
+		n++
+		return fmt.Sprint("id", n), nil
+	}
+	for _, strategy := range []LimitStrategy{RejectNew, EvictOldest} {
+		s := New(GSID, "secret", SetStore(newMemStore()), SetUUIDgenerator(uuid), SetClock(ClockFunc(func() time.Time { return now })), MaxConcurrent(2, strategy))
+		login := func() (string, error) {
+			now = now.Add(time.Minute)
+			r := httptest.NewRequest("GET", "http://example.com/", nil)
+			r = s.Attach(r)
+			if err := s.Generate(httptest.NewRecorder(), r); err != nil {
+				t.Fatal(err)
+			}
+			id, _ := s.ID(r.Context())
+			return id, s.SetOwner(r, "john")
+		}
+		first, _ := login()
+		login()
+		third, err := login()
+
+		list, _ := s.Sessions(context.Background(), "john")
+		ids := make(map[string]bool)
+		for _, m := range list {
+			ids[m.ID] = true
+		}
+		switch strategy {
+		case RejectNew:
+			if err != ErrTooManySessions || len(list) != 2 || ids[third] {
+				t.Fatalf("Expected the new session to be rejected but got %v %v", err, list)
+			}
+		case EvictOldest:
+			if err != nil || len(list) != 2 || ids[first] || !ids[third] {
+				t.Fatalf("Expected the oldest session to be evicted but got %v %v", err, list)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic for a store which is not an Index")
+		}
+	}()
+	New(GSID, "secret", SetStore(struct{ Store }{newMemStore()}), MaxConcurrent(1, RejectNew))
+}
+
+func TestRemember(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := 0
+	uuid := func() (string, error) {
+		n++
+		return fmt.Sprint("id", n), nil
+	}
+	store := newMemStore()
+	s := New(GSID, "secret", SetStore(store), SetUUIDgenerator(uuid), SetClock(ClockFunc(func() time.Time { return now })))
+	rm := NewRemember(s)
+
+	var owner string
+	app := xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner, _ = s.Owner(r.Context())
+	})
+	h := s.Link(rm.Link(app))
+	serve := func(c *http.Cookie) *http.Cookie {
+		owner = ""
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.AddCookie(c)
+		h.ServeHTTP(w, r)
+		for _, rc := range w.Result().Cookies() {
+			if rc.Name == rm.Cookie.Name {
+				return rc
+			}
+		}
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	if err := rm.Issue(w, httptest.NewRequest("GET", "http://example.com/", nil), "john"); err != nil {
+		t.Fatal(err)
+	}
+	first := w.Result().Cookies()[0]
+
+	second := serve(first)
+	if owner != "john" || second == nil || second.Value == first.Value {
+		t.Fatalf("Expected a session to be restored and the token rotated but got %q %v", owner, second)
+	}
+	// The anonymous session generated beforehand is renewed, not orphaned.
+	store.mu.Lock()
+	_, orphaned := store.data["id1"]
+	store.mu.Unlock()
+	if orphaned || n != 2 {
+		t.Fatal("Expected the session generated for the request to be renewed")
+	}
+	// Concurrent requests made with the previous validator are accepted for
+	// a short while.
+	if serve(first); owner != "john" {
+		t.Fatal("Expected the previous validator to be accepted during the grace period")
+	}
+
+	now = now.Add(time.Minute)
+	third := serve(second)
+	if owner != "john" {
+		t.Fatal("Expected the rotated token to be valid")
+	}
+	if serve(first); owner != "" {
+		t.Fatal("Expected a stale validator to be rejected")
+	}
+	if serve(third); owner != "" {
+		t.Fatal("Expected every token of the owner to be revoked upon theft")
+	}
+}
+
+func TestJWTCookie(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := WithClock(ClockFunc(func() time.Time { return now }))
+	cfg := JWT{Issuer: "example.com", Claims: map[string]string{"user": "sub"}, Leeway: time.Minute}
+	c := NewCookie(GSID, "secret", 3600, WithJWT(cfg), clock)
+	c.SetID(fakeSessionID)
+	c.Set("user", "john", 0)
+	c.Set("theme", "dark", 0)
+	hc, err := c.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hc.Value, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a signed JWT but got %q", hc.Value)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if !strings.Contains(string(payload), `"sub":"john"`) || !strings.Contains(string(payload), `"iss":"example.com"`) {
+		t.Fatalf("Expected the mapped claims to be readable but got %s", payload)
+	}
+
+	d := NewCookie(GSID, "secret", 3600, WithJWT(cfg), clock)
+	if err := d.Decode(hc); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.Get("user"); v != "john" {
+		t.Fatalf("Expected the mapped value to be decoded but got %q", v)
+	}
+	if v, _ := d.Get("theme"); v != "dark" {
+		t.Fatalf("Expected the session value to be decoded but got %q", v)
+	}
+
+	tampered := hc
+	tampered.Value = parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(payload), "john", "jane", 1))) + "." + parts[2]
+	if err := d.Decode(tampered); err == nil {
+		t.Fatal("Expected a tampered token to be rejected")
+	}
+	other := NewCookie(GSID, "secret", 3600, WithJWT(JWT{Issuer: "other.com"}), clock)
+	if err := other.Decode(hc); err == nil {
+		t.Fatal("Expected a token from another issuer to be rejected")
+	}
+
+	// The clock skew is tolerated up to the leeway.
+	now = now.Add(time.Hour + 30*time.Second)
+	if err := d.Decode(hc); err != nil {
+		t.Fatalf("Expected the token to be accepted within the leeway but got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := d.Decode(hc); err != ErrExpired {
+		t.Fatalf("Expected the token to have expired but got %v", err)
+	}
+
+	e := NewCookie(GSID, "secret", 3600, WithJWT(cfg), Encrypted(), clock)
+	e.SetID(fakeSessionID)
+	e.Set("user", "john", 0)
+	hc, err = e.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strings.Split(hc.Value, ".")) != 5 || strings.Contains(hc.Value, parts[0]) {
+		t.Fatalf("Expected an encrypted JWT but got %q", hc.Value)
+	}
+	ed := NewCookie(GSID, "secret", 3600, WithJWT(cfg), Encrypted(), clock)
+	if err := ed.Decode(hc); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ed.Get("user"); v != "john" {
+		t.Fatalf("Expected the encrypted token to be decoded but got %q", v)
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	s := New(GSID, "secret", SetStore(newMemStore()), SetClock(clock), SetIdleTimeout(10*time.Minute), SetAbsoluteTimeout(30*time.Minute))
+
+	generate := func() *http.Cookie {
+		w := httptest.NewRecorder()
+		if err := s.Generate(w, s.Attach(httptest.NewRequest("GET", "http://example.com/", nil))); err != nil {
+			t.Fatal(err)
+		}
+		return w.Result().Cookies()[0]
+	}
+	load := func(c *http.Cookie) error {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.AddCookie(c)
+		r = s.Attach(r)
+		return s.Load(httptest.NewRecorder(), r)
+	}
+
+	c := generate()
+	for _, d := range []time.Duration{5 * time.Minute, 9 * time.Minute, 9 * time.Minute} {
+		now = now.Add(d)
+		if err := load(c); err != nil {
+			t.Fatalf("Expected the active session to be valid at %v but got %v", now, err)
+		}
+	}
+	now = now.Add(8 * time.Minute)
+	if err := load(c); err == nil {
+		t.Fatal("Expected the session to have outlived its absolute timeout")
+	}
+
+	c = generate()
+	now = now.Add(10 * time.Minute)
+	if err := load(c); err == nil {
+		t.Fatal("Expected the idle session to have expired")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []string
+	record := func(event string) func(context.Context, *Session) {
+		return func(ctx context.Context, s *Session) {
+			id, _ := s.ID()
+			events = append(events, event+" "+id)
+		}
+	}
+	s := New(GSID, "secret", SetStore(newMemStore()), FixedUUID(fakeSessionID), SetClock(ClockFunc(func() time.Time { return now })), SetIdleTimeout(time.Hour), SetHooks(Hooks{
+		OnCreate: record("create"),
+		OnLoad:   record("load"),
+		OnRevoke: record("revoke"),
+		OnExpire: record("expire"),
+	}))
+
+	w := httptest.NewRecorder()
+	if err := s.Generate(w, s.Attach(httptest.NewRequest("GET", "http://example.com/", nil))); err != nil {
+		t.Fatal(err)
+	}
+	c := w.Result().Cookies()[0]
+	load := func() (*http.Request, error) {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.AddCookie(c)
+		r = s.Attach(r)
+		return r, s.Load(httptest.NewRecorder(), r)
+	}
+	r, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(r.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Generate(httptest.NewRecorder(), s.Attach(httptest.NewRequest("GET", "http://example.com/", nil))); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := load(); err == nil {
+		t.Fatal("Expected the session to have expired")
+	}
+
+	want := []string{"create", "load", "revoke", "create", "expire"}
+	if len(events) != len(want) {
+		t.Fatalf("Expected the events %v but got %v", want, events)
+	}
+	for i, e := range events {
+		if e != want[i]+" "+fakeSessionID {
+			t.Fatalf("Expected the events %v but got %v", want, events)
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func TestIDGeneration(t *testing.T) {
+	s := New(GSID, "secret", SetIDEncoder(HexID))
+	id, err := s.newID()
+	if err != nil || len(id) != 2*idLength || strings.Trim(id, "0123456789abcdef") != "" {
+		t.Fatalf("Expected a hex encoded id but got %q %v", id, err)
+	}
+
+	randomSource = failingReader{}
+	defer func() { randomSource = rand.Reader }()
+	w := httptest.NewRecorder()
+	New(GSID, "secret").ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	if w.Code != http.StatusInternalServerError || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("Expected the session generation to fail but got %d %v", w.Code, w.Result().Cookies())
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	s := New(GSID, "secret", FixedUUID(fakeSessionID))
+	var found *Session
+	h := s.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		found, _ = FromContext(r.Context(), GSID)
+		MustFromContext(r.Context(), GSID).Put(r.Context(), "k", []byte("v"), 0)
+	}))
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	if _, ok := FromContext(r.Context(), GSID); ok {
+		t.Fatal("Expected no session to be found")
+	}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if found == nil || found.Name() != GSID || !found.Loaded() {
+		t.Fatalf("Expected the session to be found by name but got %v", found)
+	}
+	if id, _ := found.ID(); id != fakeSessionID {
+		t.Fatalf("Expected the session id to be %q but got %q", fakeSessionID, id)
+	}
+	if v, ok := found.Cookie.Get("k"); !ok || v != "v" {
+		t.Fatalf("Expected the value to be stored in the session but got %q", v)
+	}
+}
+
+func TestEnforcer(t *testing.T) {
+	s := New(GSID, "secret", FixedUUID(fakeSessionID))
+	res := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	if err := s.Generate(res, r); err != ErrNoSession {
+		t.Fatalf("Expected ErrNoSession without an attached session but got %v", err)
+	}
+	ar := s.Attach(r)
+	if s.Attach(ar) != ar {
+		t.Fatal("Expected a request holding a session to be returned as is")
+	}
+	if err := s.Generate(res, ar); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Loaded(ar.Context()) || s.Loaded(r.Context()) {
+		t.Fatal("Expected the generated session to be visible through the attached request only")
+	}
+
+	var reached bool
+	var id string
+	h := Enforcer(s).Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		id, _ = s.ID(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/", nil))
+	if reached || rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the request to be rejected but got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	for _, c := range res.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !reached || id != fakeSessionID {
+		t.Fatalf("Expected the loaded session to be passed down the chain but got %q", id)
+	}
+	if _, err := s.From(req.Context()); err == nil {
+		t.Fatal("Expected the request received to be left untouched")
+	}
+}
+
+func TestEncryptedCookie(t *testing.T) {
+	c := NewCookie(GSID, "secret", 3600, Encrypted())
+	c.SetID(fakeSessionID)
+	c.Set("card", "4242424242424242", 0)
+	hc, err := c.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hc.Value, "4242") || strings.Contains(hc.Value, base64.StdEncoding.EncodeToString([]byte(`"card"`))[:6]) {
+		t.Fatalf("Expected the session data to be encrypted but got %s", hc.Value)
+	}
+
+	d := NewCookie(GSID, "secret", 3600)
+	if err := d.Decode(hc); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := d.Get("card"); !ok || v != "4242424242424242" {
+		t.Fatalf("Expected the encrypted value to be decoded but got %q", v)
+	}
+
+	// Tampering with the encrypted value is detected.
+	tampered := hc
+	b := []byte(tampered.Value)
+	b[len(b)/2] ^= 1
+	tampered.Value = string(b)
+	if err := NewCookie(GSID, "secret", 3600).Decode(tampered); err == nil {
+		t.Fatal("Expected a tampered cookie to fail decoding")
+	}
+	// The value cannot be decoded with another secret or for another cookie.
+	if err := NewCookie(GSID, "other", 3600).Decode(hc); err == nil {
+		t.Fatal("Expected decoding with another secret to fail")
+	}
+	if err := NewCookie("OTHER", "secret", 3600).Decode(hc); err == nil {
+		t.Fatal("Expected decoding for another cookie to fail")
+	}
+}
+
+func TestCompressedCookie(t *testing.T) {
+	blob := strings.Repeat(`{"item":"book","qty":1},`, 200)
+	for _, encrypt := range []bool{false, true} {
+		c := NewCookie(GSID, "secret", 3600)
+		c.Encrypt = encrypt
+		c.SetID(fakeSessionID)
+		c.Set("cart", blob, 0)
+		if _, err := c.Encode(); err != ErrTooLarge {
+			t.Fatalf("Expected the uncompressed data not to fit in the cookie but got %v", err)
+		}
+
+		c.Compress = true
+		hc, err := c.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewCookie(GSID, "secret", 3600)
+		d.Encrypt = encrypt
+		if err := d.Decode(hc); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := d.Get("cart"); !ok || v != blob {
+			t.Fatal("Expected the compressed data to be decoded")
+		}
+	}
+
+	// Small data is left uncompressed.
+	if b, err := compress([]byte(`{"a":{"V":"b"}}`)); err != nil || string(b) != `{"a":{"V":"b"}}` {
+		t.Fatalf("Expected small data to be left uncompressed but got %q %v", b, err)
+	}
+}
+
+func TestCookieVersion(t *testing.T) {
+	// An unversioned cookie, as written by former releases.
+	jval := []byte(`{"id":{"V":"` + fakeSessionID + `"},"user":{"V":"john"}}`)
+	legacy := http.Cookie{Name: GSID, Value: ComputeHmac256(jval, []byte("secret")) + ":" + base64.StdEncoding.EncodeToString(jval)}
+
+	var migrated byte
+	c := NewCookie(GSID, "secret", 3600, WithMigration(func(version byte, data map[string]CookieValue) (map[string]CookieValue, error) {
+		migrated = version
+		data["uid"] = data["user"]
+		delete(data, "user")
+		return data, nil
+	}))
+	if err := c.Decode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 1 {
+		t.Fatalf("Expected the data of the cookie to be migrated from version 1 but got %d", migrated)
+	}
+	if v, ok := c.Get("uid"); !ok || v != "john" {
+		t.Fatalf("Expected the migrated data to replace the session data but got %q", v)
+	}
+	if id, _ := c.ID(); id != fakeSessionID {
+		t.Fatalf("Expected the session id %q but got %q", fakeSessionID, id)
+	}
+
+	for _, encrypt := range []bool{false, true} {
+		c.Encrypt = encrypt
+		hc, err := c.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hc.Value, "~02") {
+			t.Fatalf("Expected the cookie to be written in the current layout but got %q", hc.Value)
+		}
+		migrated = 0
+		if err := c.Decode(hc); err != nil || migrated != 0 {
+			t.Fatalf("Expected a current cookie to be decoded without migration but got %v", err)
+		}
+		// The version cannot be downgraded by the client.
+		hc.Value = strings.TrimPrefix(hc.Value, "~02")
+		if err := c.Decode(hc); err == nil {
+			t.Fatal("Expected a cookie stripped of its version to be rejected")
+		}
+	}
+	if err := c.Decode(http.Cookie{Name: GSID, Value: "~ff" + legacy.Value}); err == nil {
+		t.Fatal("Expected a cookie of an unknown version to be rejected")
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		old := NewCookie(GSID, "old", 3600)
+		old.Encrypt = encrypt
+		old.SetID(fakeSessionID)
+		hc, err := old.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewCookie(GSID, "new", 3600, WithPreviousSecrets("older", "old"))
+		c.Encrypt = encrypt
+		if err := c.Decode(hc); err != nil {
+			t.Fatalf("Expected a cookie encoded with a previous secret to be accepted but got %v", err)
+		}
+		if id, _ := c.ID(); id != fakeSessionID {
+			t.Fatalf("Expected the session id %q but got %q", fakeSessionID, id)
+		}
+
+		// New cookies use the current secret only.
+		nc, err := c.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewCookie(GSID, "old", 3600).Decode(nc); err == nil {
+			t.Fatal("Expected the cookie to be encoded with the current secret")
+		}
+		if err := NewCookie(GSID, "new", 3600).Decode(nc); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := NewCookie(GSID, "new", 3600).Decode(hc); err == nil {
+			t.Fatal("Expected a cookie encoded with an unknown secret to be rejected")
+		}
+	}
+}
+
+func TestNewCookieOptions(t *testing.T) {
+	c := NewCookie("SID", "secret", 0, WithDomain("example.com"), WithPath("/app"), WithSameSite(http.SameSiteStrictMode), WithHttpOnly(false))
+	hc := c.HttpCookie
+	if hc.Domain != "example.com" || hc.Path != "/app" || hc.SameSite != http.SameSiteStrictMode || hc.HttpOnly || !hc.Secure {
+		t.Fatalf("unexpected session cookie attributes: %+v", hc)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an invalid cookie configuration to panic")
+		}
+	}()
+	NewCookie("SID", "secret", 0, WithSecure(false), WithSameSite(http.SameSiteNoneMode))
+}
+
+func TestCookiePrefixes(t *testing.T) {
+	s := New("SID", "secret", SetDomain("example.com"), SetPath("/app"), HostPrefixed())
+	c := s.Cookie.HttpCookie
+	if c.Name != "__Host-SID" || !c.Secure || c.Domain != "" || c.Path != "/" {
+		t.Fatalf("unexpected __Host- cookie attributes: %+v", c)
+	}
+	if s.Name != "SID" {
+		t.Fatalf("Expected the session name to be kept but got %q", s.Name)
+	}
+
+	// The session is loaded from the prefixed cookie.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r = s.Attach(r)
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	hc := w.Result().Cookies()[0]
+	if hc.Name != "__Host-SID" {
+		t.Fatalf("Expected the __Host-SID cookie to be set but got %v", hc.Name)
+	}
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r.AddCookie(hc)
+	r = s.Attach(r)
+	if err := s.Load(httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	s = New("SID", "secret", SetSecure(false), SecurePrefixed())
+	if c := s.Cookie.HttpCookie; c.Name != "__Secure-SID" || !c.Secure {
+		t.Fatalf("unexpected __Secure- cookie attributes: %+v", c)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a misconfigured __Host- cookie to panic")
+		}
+	}()
+	New("SID", "secret", HostPrefixed(), SetPath("/app"))
+}
+
+func TestTypedValues(t *testing.T) {
+	type cart struct {
+		Items []string
+		Total int
+	}
+	s := New(GSID, "secret")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r = s.Attach(r)
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	ctx := r.Context()
+	want := cart{[]string{"book"}, 12}
+
+	if err := PutJSON(ctx, s, "cart", want, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetJSON[cart](ctx, s, "cart")
+	if err != nil || got.Total != want.Total || len(got.Items) != 1 || got.Items[0] != "book" {
+		t.Fatalf("Expected %v but got %v %v", want, got, err)
+	}
+
+	ss, _ := s.From(ctx)
+	if err := PutValue(ctx, ss, Gob, "cart", want, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, err = GetValue[cart](ctx, ss, Gob, "cart")
+	if err != nil || got.Total != want.Total {
+		t.Fatalf("Expected %v but got %v %v", want, got, err)
+	}
+	if _, err := GetJSON[cart](ctx, s, "cart"); err == nil {
+		t.Fatal("Expected a gob encoded value not to be decoded as JSON")
+	}
+}
+
+// batchStore is a memStore which counts its round-trips.
+type batchStore struct {
+	*memStore
+	calls int
+}
+
+func (b *batchStore) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	b.calls++
+	return b.memStore.Get(ctx, id, hkey)
+}
+
+func (b *batchStore) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	b.calls++
+	return b.memStore.Put(ctx, id, hkey, content, maxage)
+}
+
+func (b *batchStore) GetAll(ctx context.Context, id string) (map[string][]byte, error) {
+	b.calls++
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := make(map[string][]byte)
+	for k, v := range b.data[id] {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (b *batchStore) Apply(ctx context.Context, id string, changes map[string]Change) error {
+	b.calls++
+	for k, c := range changes {
+		if c.Deleted {
+			b.memStore.Delete(ctx, id, k)
+			continue
+		}
+		b.memStore.Put(ctx, id, k, c.Value, c.MaxAge)
+	}
+	return nil
+}
+
+func TestBatched(t *testing.T) {
+	store := &batchStore{memStore: newMemStore()}
+	s := New(GSID, "secret", SetStore(store), Batched(), SetIdleTimeout(time.Hour))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r = s.Attach(r)
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if store.calls != 1 {
+		t.Fatalf("Expected a single write to generate the session but got %d store calls", store.calls)
+	}
+	c := w.Result().Cookies()[0]
+
+	store.calls = 0
+	var got []byte
+	h := s.Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		s.Put(ctx, "a", []byte("1"), 0)
+		s.Put(ctx, "b", []byte("2"), 0)
+		s.Delete(ctx, "b")
+		got, _ = s.Get(ctx, "a")
+		if _, err := s.Get(ctx, "b"); err == nil {
+			t.Error("Expected the deleted value to be absent")
+		}
+		if err := s.Save(w, r); err != nil {
+			t.Error(err)
+		}
+	}))
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r.AddCookie(c)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if string(got) != "1" {
+		t.Fatalf("Expected the buffered value to be readable but got %q", got)
+	}
+	// One read on load, one write when saved by the session handler, one by
+	// the request handler.
+	if store.calls != 3 {
+		t.Fatalf("Expected 3 store round-trips but got %d", store.calls)
+	}
+	for sid := range store.data {
+		if v, _ := store.memStore.Get(context.Background(), sid, GSID+"/a"); string(v) != "1" {
+			t.Fatalf("Expected the value to be written to the store but got %q", v)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a batched session without BatchStore to panic")
+		}
+	}()
+	New(GSID, "secret", SetStore(newMemStore()), Batched())
+}
+
+// downStore is a memStore which can be made unreachable.
+type downStore struct {
+	*memStore
+	down bool
+}
+
+var errDown = fmt.Errorf("store unreachable")
+
+func (d *downStore) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	if d.down {
+		return nil, errDown
+	}
+	return d.memStore.Get(ctx, id, hkey)
+}
+
+func (d *downStore) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	if d.down {
+		return errDown
+	}
+	return d.memStore.Put(ctx, id, hkey, content, maxage)
+}
+
+// memCache is a memStore used as a Cache.
+type memCache struct {
+	*memStore
+}
+
+func (c memCache) Clear() error { return c.Flush(context.Background()) }
+
+func (c memCache) ClearAfter(t time.Duration) error { return c.Clear() }
+
+func TestDegradedMode(t *testing.T) {
+	store := &downStore{memStore: newMemStore()}
+	cache := memCache{newMemStore()}
+	s := New(GSID, "secret", FixedUUID(fakeSessionID), SetStore(store), SetCache(cache))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r = s.Attach(r)
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(r.Context(), "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	c := w.Result().Cookies()[0]
+	if err := s.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cached session validity has expired, the store has to be reached.
+	cache.Delete(context.Background(), fakeSessionID, GSID+"/"+sessionValidityKey)
+	store.down = true
+	if _, ok := s.Ping(context.Background()).(UnavailableError); !ok {
+		t.Fatal("Expected the store outage to be detected")
+	}
+	serve := func(h Handler, withCookie bool, next func(w http.ResponseWriter, r *http.Request)) int {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if withCookie {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.Link(xhttp.HandlerFunc(next)).ServeHTTP(w, r)
+		return w.Code
+	}
+	nop := func(w http.ResponseWriter, r *http.Request) {}
+
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r.AddCookie(c)
+	r = s.Attach(r)
+	if _, ok := s.Load(httptest.NewRecorder(), r).(UnavailableError); !ok {
+		t.Fatal("Expected Load to return an UnavailableError")
+	}
+	if code := serve(s, true, nop); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the request to fail closed but got %d", code)
+	}
+
+	cookieOnly := s.Configure(SetDegradedMode(CookieOnly))
+	code := serve(cookieOnly, true, func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if id, _ := cookieOnly.ID(ctx); id != fakeSessionID {
+			t.Errorf("Expected the session id to be kept but got %q", id)
+		}
+		if err := cookieOnly.Put(ctx, "b", []byte("2"), 0); err != nil {
+			t.Error(err)
+		}
+		if v, err := cookieOnly.Get(ctx, "b"); err != nil || string(v) != "2" {
+			t.Errorf("Expected the value to be held by the cookie but got %q %v", v, err)
+		}
+	})
+	if code != http.StatusOK {
+		t.Fatalf("Expected the request to be served from the cookie but got %d", code)
+	}
+
+	stale := s.Configure(SetDegradedMode(ServeStale))
+	code = serve(stale, true, func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if v, err := stale.Get(ctx, "a"); err != nil || string(v) != "1" {
+			t.Errorf("Expected the cached value to be served but got %q %v", v, err)
+		}
+		if _, ok := stale.Put(ctx, "a", []byte("2"), 0).(UnavailableError); !ok {
+			t.Error("Expected a stale session to be read-only")
+		}
+	})
+	if code != http.StatusOK {
+		t.Fatalf("Expected the request to be served from the cache but got %d", code)
+	}
+	if code = serve(stale, false, nop); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a request without session to fail closed but got %d", code)
+	}
+}
+
+// probeStore is a memStore counting the probe values written into it.
+type probeStore struct {
+	*memStore
+	probes int
+}
+
+func (p *probeStore) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	if id == probeID {
+		p.probes++
+	}
+	return p.memStore.Put(ctx, id, hkey, content, maxage)
+}
+
+func TestStoreProbeInterval(t *testing.T) {
+	now := time.Now()
+	store := &probeStore{memStore: newMemStore()}
+	s := New(GSID, "secret", FixedUUID(fakeSessionID), SetStore(store), SetClock(ClockFunc(func() time.Time { return now })))
+
+	w := httptest.NewRecorder()
+	r := s.Attach(httptest.NewRequest("GET", "http://example.com/", nil))
+	if err := s.Generate(w, r); err != nil {
+		t.Fatal(err)
+	}
+	c := w.Result().Cookies()[0]
+	if err := s.Revoke(r.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() error {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.AddCookie(c)
+		r = s.Attach(r)
+		return s.Load(httptest.NewRecorder(), r)
+	}
+	for i := 0; i < 3; i++ {
+		if err := load(); err == nil {
+			t.Fatal("Expected the revoked session to fail to load")
+		} else if _, ok := err.(UnavailableError); ok {
+			t.Fatal("Expected the store to be available")
+		}
+	}
+	if store.probes != 1 {
+		t.Fatalf("Expected the store to be probed once but got %d probes", store.probes)
+	}
+	now = now.Add(pingInterval)
+	load()
+	if store.probes != 2 {
+		t.Fatalf("Expected the store to be probed again after the interval but got %d probes", store.probes)
+	}
+}
+
+// localBus is an InvalidationBus delivering the invalidations synchronously.
+type localBus struct {
+	mu   sync.Mutex
+	subs []func(Invalidation)
+}
+
+func (b *localBus) Publish(ctx context.Context, inv Invalidation) error {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, fn := range subs {
+		fn(inv)
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(ctx context.Context, fn func(Invalidation)) error {
+	b.mu.Lock()
+	b.subs = append(b.subs, fn)
+	b.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestInvalidationBus(t *testing.T) {
+	store := newMemStore()
+	bus := &localBus{}
+	cacheA, cacheB := memCache{newMemStore()}, memCache{newMemStore()}
+	a := New(GSID, "secret", FixedUUID(fakeSessionID), SetStore(store), SetCache(cacheA), SetInvalidationBus(bus))
+	b := New(GSID, "secret", SetStore(store), SetCache(cacheB), SetInvalidationBus(bus))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.ListenInvalidations(ctx)
+	go b.ListenInvalidations(ctx)
+	for {
+		bus.mu.Lock()
+		n := len(bus.subs)
+		bus.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	ra := httptest.NewRequest("GET", "http://example.com/", nil)
+	ra = a.Attach(ra)
+	if err := a.Generate(w, ra); err != nil {
+		t.Fatal(err)
+	}
+	a.Put(ra.Context(), "k", []byte("1"), 0)
+
+	rb := httptest.NewRequest("GET", "http://example.com/", nil)
+	rb.AddCookie(w.Result().Cookies()[0])
+	rb = b.Attach(rb)
+	if err := b.Load(httptest.NewRecorder(), rb); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.Get(rb.Context(), "k"); string(v) != "1" {
+		t.Fatalf("Expected the value to be read but got %q", v)
+	}
+
+	a.Put(ra.Context(), "k", []byte("2"), 0)
+	if v, _ := cacheA.Get(ctx, fakeSessionID, GSID+"/k"); string(v) != "2" {
+		t.Fatalf("Expected the publishing node to keep its cached value but got %q", v)
+	}
+	if v, _ := b.Get(rb.Context(), "k"); string(v) != "2" {
+		t.Fatalf("Expected the stale cached value to be evicted but got %q", v)
+	}
+
+	if err := a.Revoke(ra.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cacheB.Get(ctx, fakeSessionID, GSID+"/"+sessionValidityKey); err == nil {
+		t.Fatal("Expected the revoked session to be evicted from the other caches")
+	}
+}
+
+func TestRevokeSessionsInvalidation(t *testing.T) {
+	store := newMemStore()
+	bus := &localBus{}
+	cacheA, cacheB := memCache{newMemStore()}, memCache{newMemStore()}
+	a := New(GSID, "secret", FixedUUID(fakeSessionID), SetStore(store), SetCache(cacheA), SetInvalidationBus(bus))
+	b := New(GSID, "secret", SetStore(store), SetCache(cacheB), SetInvalidationBus(bus))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.ListenInvalidations(ctx)
+	for {
+		bus.mu.Lock()
+		n := len(bus.subs)
+		bus.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	ra := httptest.NewRequest("GET", "http://example.com/", nil)
+	ra = a.Attach(ra)
+	if err := a.Generate(w, ra); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetOwner(ra, "john"); err != nil {
+		t.Fatal(err)
+	}
+	rb := httptest.NewRequest("GET", "http://example.com/", nil)
+	rb.AddCookie(w.Result().Cookies()[0])
+	rb = b.Attach(rb)
+	if err := b.Load(httptest.NewRecorder(), rb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cacheB.Get(ctx, fakeSessionID, GSID+"/"+sessionValidityKey); err != nil {
+		t.Fatal("Expected the session validity to be cached")
+	}
+
+	if err := a.RevokeSessions(ctx, "john"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cacheB.Get(ctx, fakeSessionID, GSID+"/"+sessionValidityKey); err == nil {
+		t.Fatal("Expected the revoked sessions to be evicted from the other caches")
+	}
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"crypto/hmac"
 	"encoding/base64"
 	"encoding/json"
 	"log"
@@ -17,17 +18,25 @@ import (
 type CookieValue struct {
 	Value  string     `json:"V"`
 	Expiry *time.Time `json:"X,omitempty"`
+	// Spilled reports that the value was moved to the SpillStore of the
+	// session, the cookie only keeping track of it.
+	Spilled bool `json:"S,omitempty"`
 }
 
 // NewCookieValue formats a new value ready for storage in the session cookie.
 func NewCookieValue(val string, maxage time.Duration, options ...func(CookieValue) CookieValue) CookieValue {
-	n := time.Now().UTC()
+	return newCookieValueAt(time.Now(), val, maxage, options...)
+}
+
+// newCookieValueAt formats a new value whose maxage runs from now.
+func newCookieValueAt(now time.Time, val string, maxage time.Duration, options ...func(CookieValue) CookieValue) CookieValue {
+	n := now.UTC()
 	var c CookieValue
 	if maxage == 0 {
-		c = CookieValue{val, nil}
+		c = CookieValue{Value: val}
 	} else {
 		n = n.Add(maxage)
-		c = CookieValue{val, &n}
+		c = CookieValue{Value: val, Expiry: &n}
 	}
 	if options != nil {
 		for _, opt := range options {
@@ -39,14 +48,19 @@ func NewCookieValue(val string, maxage time.Duration, options ...func(CookieValu
 
 // Expired returns the expiration status of  a given value.
 func (c CookieValue) Expired() bool {
+	return c.ExpiredAt(time.Now())
+}
+
+// ExpiredAt returns the expiration status of a given value at time t.
+func (c CookieValue) ExpiredAt(t time.Time) bool {
 	if c.Expiry == nil {
 		return false
 	}
-	return time.Now().After(*(c.Expiry))
+	return t.After(*(c.Expiry))
 }
 
-func (c CookieValue) tryRetrieve() (string, bool) {
-	if !c.Expired() {
+func (c CookieValue) tryRetrieve(now time.Time) (string, bool) {
+	if !c.ExpiredAt(now) {
 		return c.Value, true
 	}
 	return "", false
@@ -81,9 +95,39 @@ type Cookie struct {
 	// It can't belong to the base64 list of accepted sigils.
 	// It is used to separate the session cookie secret from the payload.
 	Delimiter string
+
+	// Clock is used to compute and check the expiry of the stored values.
+	// SystemClock is used if nil.
+	Clock Clock
+
+	// PreviousSecrets are the secrets which were used before Secret. The
+	// cookies signed or encrypted with them are still accepted, which allows
+	// to rotate secrets without invalidating the sessions. Encoding always
+	// uses Secret.
+	PreviousSecrets []string
+
+	// Encrypt enables the encryption of the session data, which is otherwise
+	// only signed and can be read by the client.
+	Encrypt bool
+
+	// Compress enables the compression of the session data before it is
+	// signed or encrypted.
+	Compress bool
+
+	// JWT, if not nil, makes the session data be encoded as a JSON Web Token.
+	JWT *JWT
+
+	// MaxSize is the maximum size of the cookie, as sent in the Set-Cookie
+	// header. DefaultMaxCookieSize is used if zero.
+	MaxSize int
+
+	// Migrate, if not nil, is called with the data of the cookies written in
+	// a former layout, along with its version, once decoded. See WithMigration.
+	Migrate Migration
 }
 
 // NewCookie creates a new cookie based session object.
+// It panics if the attributes of the resulting cookie are invalid.
 func NewCookie(name string, secret string, maxage int, options ...func(Cookie) Cookie) Cookie {
 	if name == "" {
 		panic("Session cookie name cannpt be the empty string.")
@@ -99,6 +143,7 @@ func NewCookie(name string, secret string, maxage int, options ...func(Cookie) C
 		ApplyMods:  &flag.Flag{},
 		Secret:     secret,
 		Delimiter:  ":",
+		Clock:      SystemClock,
 	}
 	s.HttpCookie.Name = name
 	s.HttpCookie.MaxAge = maxage
@@ -115,6 +160,9 @@ func NewCookie(name string, secret string, maxage int, options ...func(Cookie) C
 	if !ok {
 		panic("ERR: id is a reserved key for the storage of the session id. Do not erase it.")
 	}
+	if err := validateCookie(s.HttpCookie); err != nil {
+		panic(err.Error())
+	}
 	s.Touch()
 	return s
 }
@@ -132,6 +180,48 @@ func DefaultCookieConfig(s Cookie) Cookie {
 	return s
 }
 
+// WithPreviousSecrets is a configuration option which sets the secrets which
+// are still accepted to decode the session cookie after a secret rotation.
+func WithPreviousSecrets(secrets ...string) func(Cookie) Cookie {
+	return func(c Cookie) Cookie {
+		c.PreviousSecrets = secrets
+		return c
+	}
+}
+
+// WithClock is a configuration option which sets the Clock of a session
+// cookie.
+func WithClock(clock Clock) func(Cookie) Cookie {
+	return func(c Cookie) Cookie {
+		c.Clock = clock
+		return c
+	}
+}
+
+// clone returns a copy of the cookie which does not share its attributes,
+// data or modification flag with c.
+func (c Cookie) clone() Cookie {
+	hc := *c.HttpCookie
+	c.HttpCookie = &hc
+	data := make(map[string]CookieValue, len(c.Data))
+	for k, v := range c.Data {
+		data[k] = v
+	}
+	c.Data = data
+	f := &flag.Flag{}
+	f.Set(c.ApplyMods.Get())
+	c.ApplyMods = f
+	return c
+}
+
+// now returns the current time according to the cookie Clock.
+func (c Cookie) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock.Now()
+}
+
 // ID returns the session id if it has not expired.
 func (c Cookie) ID() (string, bool) {
 	return c.Data["id"].Value, true
@@ -150,12 +240,13 @@ func (c Cookie) Get(key string) (string, bool) {
 	if !ok {
 		return "", false
 	}
-	if cval.Expired() {
+	now := c.now()
+	if cval.ExpiredAt(now) {
 		delete(c.Data, key)
 		c.ApplyMods.Set(true)
 		return "", false
 	}
-	return c.Data[key].tryRetrieve()
+	return c.Data[key].tryRetrieve(now)
 }
 
 // Set inserts a value in the cookie session for a given key.
@@ -166,7 +257,8 @@ func (c Cookie) Set(key string, val string, maxage time.Duration) {
 	}
 	switch {
 	case maxage > 0:
-		c.Data[key] = NewCookieValue(val, time.Duration(c.HttpCookie.MaxAge), AddTimeLimit(time.Now().UTC().Add(maxage)))
+		now := c.now()
+		c.Data[key] = newCookieValueAt(now, val, time.Duration(c.HttpCookie.MaxAge)*time.Second, AddTimeLimit(now.Add(maxage)))
 		c.ApplyMods.Set(true)
 		return
 	case maxage == 0:
@@ -194,12 +286,13 @@ func (c Cookie) TimeToExpiry(key string) (time.Duration, error) {
 	if !ok {
 		return 0, errors.New("no value stored for key: " + key)
 	}
-	if val.Expired() {
+	now := c.now()
+	if val.ExpiredAt(now) {
 		delete(c.Data, key)
 		c.ApplyMods.Set(true)
 		return 0, errors.New("no value stored for key: " + key)
 	}
-	return val.Expiry.Sub(time.Now().UTC()), nil
+	return val.Expiry.Sub(now.UTC()), nil
 }
 
 // Erase deletes the session cookies sharing the session name
@@ -217,9 +310,10 @@ func (c Cookie) Erase(w http.ResponseWriter, r *http.Request) {
 // session cookie as the session is now expired.
 // At the next request, the client may be issued a new session id.
 func (c Cookie) Expire() {
-	c.Data["id"] = NewCookieValue("", time.Duration(c.HttpCookie.MaxAge), AddTimeLimit(time.Now()))
+	now := c.now()
+	c.Data["id"] = newCookieValueAt(now, "", time.Duration(c.HttpCookie.MaxAge)*time.Second, AddTimeLimit(now))
 	c.HttpCookie.MaxAge = -1
-	c.Set(sessionValidityKey, "false", time.Duration(c.HttpCookie.MaxAge))
+	c.Set(sessionValidityKey, "false", time.Duration(c.HttpCookie.MaxAge)*time.Second)
 }
 
 // Touch sets a new maxage for the session cookie and updates the expiry date of
@@ -227,24 +321,57 @@ func (c Cookie) Expire() {
 // Otherwise, it just resets the session duration using the previous session
 // cookie maxage value.
 func (c Cookie) Touch() {
-	c.Set(sessionValidityKey, "true", time.Duration(c.HttpCookie.MaxAge))
+	c.Set(sessionValidityKey, "true", time.Duration(c.HttpCookie.MaxAge)*time.Second)
 }
 
 // Encode will return a session cookie holding the json serialized session data.
+// It fails with ErrTooLarge if the cookie exceeds its maximum size.
 func (c Cookie) Encode() (http.Cookie, error) {
+	hc, err := c.encode()
+	if err != nil {
+		return http.Cookie{}, err
+	}
+	c.HttpCookie.Value = hc.Value
+	c.ApplyMods.Set(true)
+
+	return hc, nil
+}
+
+// encode returns the session cookie holding the serialized session data,
+// leaving c untouched.
+func (c Cookie) encode() (http.Cookie, error) {
 	jval, err := json.Marshal(c.Data)
 	if err != nil {
 		return http.Cookie{}, errors.New("Encoding failure for session cookie.").Wraps(err)
 	}
-	v := ComputeHmac256(jval, []byte(c.Secret)) + c.Delimiter + base64.StdEncoding.EncodeToString(jval)
-
-	c.HttpCookie.Value = v
-	if len(c.HttpCookie.String()) > 4096 {
-		return http.Cookie{}, errors.New("ERR: JSON encoded value too big for cookie. Max allowed length is 4kB i.e. 4096 bytes")
+	if c.Compress && c.JWT == nil {
+		jval, err = compress(jval)
+		if err != nil {
+			return http.Cookie{}, errors.New("Compression failure for session cookie.").Wraps(err)
+		}
+	}
+	var v string
+	if c.JWT != nil {
+		v, err = c.encodeJWT()
+		if err != nil {
+			return http.Cookie{}, errors.New("Encoding failure for session token.").Wraps(err)
+		}
+	} else if prefix := versionPrefix(CookieVersion); c.Encrypt {
+		ev, err := seal(c.Secret, c.HttpCookie.Name+prefix, jval)
+		if err != nil {
+			return http.Cookie{}, errors.New("Encryption failure for session cookie.").Wraps(err)
+		}
+		v = prefix + encryptedFormat + c.Delimiter + ev
+	} else {
+		v = prefix + sign(prefix, jval, c.Secret) + c.Delimiter + base64.StdEncoding.EncodeToString(jval)
 	}
-	c.ApplyMods.Set(true)
 
-	return *(c.HttpCookie), nil
+	hc := *c.HttpCookie
+	hc.Value = v
+	if len(hc.String()) > c.maxSize() {
+		return http.Cookie{}, ErrTooLarge
+	}
+	return hc, nil
 }
 
 // Decode is used to deserialize the session cookie in order to make the stored
@@ -252,29 +379,75 @@ func (c Cookie) Encode() (http.Cookie, error) {
 // If we detect that the client has tampered with the session cookie somehow,
 // an error is returned.
 func (c Cookie) Decode(h http.Cookie) error {
+	if c.JWT != nil {
+		return c.decodeJWT(h.Value)
+	}
+	version, value, err := cookieVersion(h.Value)
+	if err != nil {
+		return err
+	}
 	// let's split the two components on the string-marshalled metadata (raw + Encoded)
-	s := strings.Split(h.Value, c.Delimiter)
+	s := strings.Split(value, c.Delimiter)
 	if len(s) <= 1 || len(s) > 4000 {
 		return ErrBadCookie.Wraps(errors.New("Cookie seems to have been tampered with. Size too large"))
 	}
-	b64Message := s[1]
-	b64MAC := s[0]
-	ok, err := VerifySignature(b64Message, b64MAC, c.Secret)
-	if !ok {
-		e := errors.New("Signature verification failure of session cookie")
-		if err != nil {
-			return e.Wraps(err)
-		}
-		return e
+	str, err := c.verify(version, s[0], s[1])
+	if err != nil {
+		return err
 	}
-	str, err := base64.StdEncoding.DecodeString(b64Message)
+	str, err = decompress(str)
 	if err != nil {
-		log.Print("Decoding error")
-		return errors.New("Decoding failure").Wraps(err).Code(errcode.BadCookie)
+		return ErrBadCookie.Wraps(err)
 	}
 	err = json.Unmarshal(str, &(c.Data))
 	if err != nil {
 		return errors.New("Unmarshalling failure of session value").Wraps(err).Code(errcode.BadCookie)
 	}
-	return nil
+	return c.migrate(version)
+}
+
+// secrets returns the secrets accepted to decode the cookie, the current one
+// first.
+func (c Cookie) secrets() []string {
+	return append([]string{c.Secret}, c.PreviousSecrets...)
+}
+
+// verify returns the session data held by a cookie value, after checking its
+// signature or decrypting it with any of the accepted secrets.
+func (c Cookie) verify(version byte, head string, payload string) ([]byte, error) {
+	// The version of the layout is authenticated along with the data, except
+	// for the unversioned cookies.
+	var ad string
+	if version > 1 {
+		ad = versionPrefix(version)
+	}
+	if head == encryptedFormat {
+		var err error
+		for _, secret := range c.secrets() {
+			var str []byte
+			str, err = unseal(secret, c.HttpCookie.Name+ad, payload)
+			if err == nil {
+				return str, nil
+			}
+		}
+		return nil, errors.New("Decryption failure of session cookie").Wraps(err).Code(errcode.BadCookie)
+	}
+
+	str, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		log.Print("Decoding error")
+		return nil, errors.New("Decoding failure").Wraps(err).Code(errcode.BadCookie)
+	}
+	for _, secret := range c.secrets() {
+		if hmac.Equal([]byte(sign(ad, str, secret)), []byte(head)) {
+			return str, nil
+		}
+	}
+	return nil, errors.New("Signature verification failure of session cookie")
+}
+
+// sign returns the signature of the session data, authenticating the
+// additional data ad along with it.
+func sign(ad string, data []byte, secret string) string {
+	return ComputeHmac256(append([]byte(ad), data...), []byte(secret))
 }
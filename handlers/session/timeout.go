@@ -0,0 +1,153 @@
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// SetStoreTimeout is a configuration option which bounds the duration of every
+// individual Store and Cache call made by the session handler. A call that
+// does not complete in time fails with context.DeadlineExceeded, so that a
+// slow backend does not hold requests until the client gives up.
+//
+// The context passed to the Store and Cache is canceled when the timeout
+// elapses: implementations should honor it in order to release their
+// resources.
+func SetStoreTimeout(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.StoreTimeout = d
+		return h
+	}
+}
+
+// store returns the session Store, namespaced and bounded by the StoreTimeout
+// if any.
+func (h Handler) store() Store {
+	return h.bound(h.Store)
+}
+
+// bound returns a Store namespaced and bounded by the StoreTimeout of h, if
+// any.
+func (h Handler) bound(s Store) Store {
+	if h.Namespace != "" {
+		s = nsStore{s, h.Namespace}
+	}
+	if h.StoreTimeout <= 0 {
+		return s
+	}
+	return timeoutStore{s, h.StoreTimeout}
+}
+
+// spillStore returns the session SpillStore, namespaced and bounded by the
+// StoreTimeout if any.
+func (h Handler) spillStore() Store {
+	return h.bound(h.SpillStore)
+}
+
+// cache returns the session Cache, namespaced and bounded by the StoreTimeout
+// if any.
+func (h Handler) cache() Cache {
+	c := h.Cache
+	if h.Namespace != "" {
+		c = nsCache{c, h.Namespace}
+	}
+	if h.StoreTimeout <= 0 {
+		return c
+	}
+	return timeoutCache{c, h.StoreTimeout}
+}
+
+// withTimeout runs fn with a context bounded by timeout. It returns as soon as
+// the context is done, even if fn has not returned yet.
+func withTimeout[T any](ctx context.Context, timeout time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn(ctx)
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// withStoreTimeout runs fn with a context bounded by the StoreTimeout of h, if
+// any.
+func withStoreTimeout[T any](ctx context.Context, h Handler, fn func(ctx context.Context) (T, error)) (T, error) {
+	if h.StoreTimeout <= 0 {
+		return fn(ctx)
+	}
+	return withTimeout(ctx, h.StoreTimeout, fn)
+}
+
+// noValue adapts the functions that only return an error to withTimeout.
+func noValue(fn func(ctx context.Context) error) func(ctx context.Context) (struct{}, error) {
+	return func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	}
+}
+
+type timeoutStore struct {
+	Store
+	timeout time.Duration
+}
+
+func (s timeoutStore) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	return withTimeout(ctx, s.timeout, func(ctx context.Context) ([]byte, error) {
+		return s.Store.Get(ctx, id, hkey)
+	})
+}
+
+func (s timeoutStore) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	_, err := withTimeout(ctx, s.timeout, noValue(func(ctx context.Context) error {
+		return s.Store.Put(ctx, id, hkey, content, maxage)
+	}))
+	return err
+}
+
+func (s timeoutStore) Delete(ctx context.Context, id string, hkey string) error {
+	_, err := withTimeout(ctx, s.timeout, noValue(func(ctx context.Context) error {
+		return s.Store.Delete(ctx, id, hkey)
+	}))
+	return err
+}
+
+func (s timeoutStore) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	return withTimeout(ctx, s.timeout, func(ctx context.Context) (time.Duration, error) {
+		return s.Store.TimeToExpiry(ctx, id, hkey)
+	})
+}
+
+type timeoutCache struct {
+	Cache
+	timeout time.Duration
+}
+
+func (c timeoutCache) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	return withTimeout(ctx, c.timeout, func(ctx context.Context) ([]byte, error) {
+		return c.Cache.Get(ctx, id, hkey)
+	})
+}
+
+func (c timeoutCache) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	_, err := withTimeout(ctx, c.timeout, noValue(func(ctx context.Context) error {
+		return c.Cache.Put(ctx, id, hkey, content, maxage)
+	}))
+	return err
+}
+
+func (c timeoutCache) Delete(ctx context.Context, id string, hkey string) error {
+	_, err := withTimeout(ctx, c.timeout, noValue(func(ctx context.Context) error {
+		return c.Cache.Delete(ctx, id, hkey)
+	}))
+	return err
+}
@@ -0,0 +1,233 @@
+// Package shadow defines a request handler which mirrors a share of the
+// incoming traffic to a secondary upstream, typically to test a new version of
+// a service against production traffic.
+//
+// Mirrored requests are sent asynchronously, after the request has been
+// handled, and their responses are discarded: they never affect the clients.
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+// Header is set on the mirrored requests so that the secondary upstream can
+// tell them apart, e.g. to avoid side effects such as sending emails.
+const Header = "X-Shadow-Request"
+
+// hopHeaders are not forwarded to the secondary upstream.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// credentialHeaders are not forwarded to the secondary upstream unless
+// ForwardCredentials is set.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// Handler mirrors requests to a secondary upstream.
+type Handler struct {
+	upstream *url.URL
+	percent  float64
+	maxBody  int64
+	timeout  time.Duration
+	client   *http.Client
+	pending  chan struct{} // bounds the number of mirrored requests in flight
+	log      *log.Logger
+
+	forwardCredentials bool
+
+	next xhttp.Handler
+}
+
+// New returns a request handler mirroring every request to the upstream.
+// It panics if the upstream url is invalid.
+func New(upstream string, options ...func(Handler) Handler) Handler {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		panic("shadow: invalid upstream url " + upstream)
+	}
+	h := Handler{
+		upstream: u,
+		percent:  100,
+		maxBody:  1 << 20,
+		timeout:  5 * time.Second,
+		client:   http.DefaultClient,
+		next:     nil,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	if h.pending == nil {
+		h.pending = make(chan struct{}, 100)
+	}
+	return h
+}
+
+// Percent is a configuration option which sets the percentage of requests
+// that are mirrored.
+func Percent(p float64) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.percent = p
+		return h
+	}
+}
+
+// MaxBody is a configuration option which sets the maximum size of the request
+// bodies buffered for mirroring. Requests with larger bodies are not mirrored.
+// It defaults to 1MB.
+func MaxBody(n int64) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.maxBody = n
+		return h
+	}
+}
+
+// Timeout is a configuration option which sets the timeout of the mirrored
+// requests. It defaults to 5 seconds.
+func Timeout(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.timeout = d
+		return h
+	}
+}
+
+// MaxPending is a configuration option which sets the maximum number of
+// mirrored requests in flight. Requests are not mirrored beyond that, so that a
+// slow secondary upstream cannot exhaust the resources of the server.
+// It defaults to 100.
+func MaxPending(n int) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.pending = make(chan struct{}, n)
+		return h
+	}
+}
+
+// ForwardCredentials is a configuration option which forwards the Cookie and
+// Authorization headers to the secondary upstream. By default, they are
+// stripped from the mirrored requests so that the credentials of the clients
+// are not handed to a service which may be less trusted, or log them.
+func ForwardCredentials() func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.forwardCredentials = true
+		return h
+	}
+}
+
+// WithClient is a configuration option which sets the client used to send
+// the mirrored requests.
+func WithClient(c *http.Client) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.client = c
+		return h
+	}
+}
+
+// WithLogger is a configuration option which sets the logger used to report
+// mirroring failures.
+func WithLogger(l *log.Logger) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.log = l
+		return h
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.percent <= 0 || (h.percent < 100 && rand.Float64()*100 >= h.percent) {
+		h.serveNext(w, r)
+		return
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := io.ReadAll(io.LimitReader(r.Body, h.maxBody+1))
+		// The buffered part is handed back to the downstream handlers.
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+		if err != nil || int64(len(b)) > h.maxBody {
+			h.serveNext(w, r)
+			return
+		}
+		body = b
+	}
+	req := h.mirror(r, body)
+
+	h.serveNext(w, r)
+
+	select {
+	case h.pending <- struct{}{}:
+	default:
+		return // too many mirrored requests in flight
+	}
+	go func() {
+		defer func() { <-h.pending }()
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		defer cancel()
+		res, err := h.client.Do(req.WithContext(ctx))
+		if err != nil {
+			if h.log != nil {
+				h.log.Print(err)
+			}
+			return
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}()
+}
+
+// mirror returns the request sent to the secondary upstream. It is built
+// before the request is handled, as downstream handlers may modify it.
+func (h Handler) mirror(r *http.Request, body []byte) *http.Request {
+	u := *h.upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawPath = ""
+	u.RawQuery = r.URL.RawQuery
+	req := &http.Request{
+		Method:        r.Method,
+		URL:           &u,
+		Header:        r.Header.Clone(),
+		Host:          u.Host,
+		ContentLength: int64(len(body)),
+	}
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	for _, hdr := range hopHeaders {
+		req.Header.Del(hdr)
+	}
+	if !h.forwardCredentials {
+		for _, hdr := range credentialHeaders {
+			req.Header.Del(hdr)
+		}
+	}
+	req.Header.Set(Header, "1")
+	return req
+}
+
+func (h Handler) serveNext(w http.ResponseWriter, r *http.Request) {
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// Link registers a next request Handler to be called by ServeHTTP method.
+// It returns the result of the linking.
+func (h Handler) Link(nh xhttp.Handler) xhttp.HandlerLinker {
+	h.next = nh
+	return h
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
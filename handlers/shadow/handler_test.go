@@ -0,0 +1,98 @@
+package shadow
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+)
+
+func TestShadow(t *testing.T) {
+	mirrored := make(chan string, 1)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mirrored <- r.Method + " " + r.URL.RequestURI() + " " + string(b) + " " + r.Header.Get(Header)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer secondary.Close()
+
+	h := New(secondary.URL+"/v2", MaxBody(16)).Link(xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}))
+
+	req, err := http.NewRequest("POST", "http://example.com/orders?dry=1", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"id":1}` {
+		t.Fatalf("Unexpected primary response %d %q", w.Code, w.Body.String())
+	}
+	select {
+	case m := <-mirrored:
+		if m != `POST /v2/orders?dry=1 {"id":1} 1` {
+			t.Fatalf("Unexpected mirrored request %q", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request to be mirrored.")
+	}
+
+	big := strings.Repeat("x", 32)
+	req, err = http.NewRequest("POST", "http://example.com/orders", strings.NewReader(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != big {
+		t.Fatalf("Expected the full body to reach the primary handler. Got %q", w.Body.String())
+	}
+	select {
+	case m := <-mirrored:
+		t.Fatalf("Expected large bodies not to be mirrored. Got %q", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	mirrored := make(chan http.Header, 1)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- r.Header
+	}))
+	defer secondary.Close()
+
+	for _, forward := range []bool{false, true} {
+		var opt func(Handler) Handler
+		if forward {
+			opt = ForwardCredentials()
+		}
+		h := New(secondary.URL, opt)
+		req, err := http.NewRequest("GET", "http://example.com/orders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Cookie", "SID=1")
+		req.Header.Set("Accept", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		select {
+		case hdr := <-mirrored:
+			if hdr.Get("Accept") != "application/json" {
+				t.Fatal("Expected the other headers to be forwarded")
+			}
+			got := hdr.Get("Authorization") != "" || hdr.Get("Cookie") != ""
+			if got != forward {
+				t.Fatalf("Expected the credentials to be forwarded: %v. Got %v", forward, hdr)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the request to be mirrored.")
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"sync"
 
+	"github.com/atdiar/xhttp"
 	"github.com/atdiar/xhttp/handlers/session"
 )
 
@@ -21,13 +22,14 @@ func New(s session.Handler) *Handler {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = h.Session.Attach(r)
 	ctx := r.Context()
 	err := h.Session.Load(w, r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
-	id, err := h.Session.ID()
+	id, err := h.Session.ID(r.Context())
 	if err != nil {
 		http.Error(w, "Unknown user session id. Cannot start streaming.", http.StatusInternalServerError)
 	}
@@ -40,10 +42,13 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	h.mu.Unlock()
 
-	// Make sure that the writer supports flushing.
-	//
-	fw, ok := w.(http.Flusher)
-	if !ok {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Make sure that the writer supports flushing, even when wrapped by other
+	// handlers.
+	if err := xhttp.Flush(w); err != nil {
 		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
 		return
 	}
@@ -58,10 +63,6 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.mu.Unlock()
 	}()
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
 	for {
 
 		// Retrieve message
@@ -70,7 +71,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// Write to the ResponseWriter, `w`.
 			fmt.Fprintf(w, "%s", msg)
 			// Flush the response. Only possible if streaming is supported.
-			fw.Flush()
+			xhttp.Flush(w)
 		case <-ctx.Done():
 			return
 		}
@@ -0,0 +1,283 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// Message types, as defined in RFC 6455.
+const (
+	TextMessage   = ws.TextMessage
+	BinaryMessage = ws.BinaryMessage
+)
+
+// Close status codes, as defined in RFC 6455.
+const (
+	CloseNormalClosure = ws.CloseNormalClosure
+	CloseGoingAway     = ws.CloseGoingAway
+)
+
+// ErrClosed is returned when sending a message on a closed connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+type message struct {
+	typ  int
+	data []byte
+}
+
+type closeRequest struct {
+	code   int
+	reason string
+}
+
+// Conn is a websocket connection.
+//
+// Messages can be sent concurrently from any goroutine. Messages must be
+// received from a single goroutine, typically the one running the handling
+// function registered with New.
+type Conn struct {
+	// SessionID is the id of the session of the client that opened the
+	// connection.
+	SessionID string
+	// Request is the upgraded http request.
+	Request *http.Request
+
+	ws        *ws.Conn
+	send      chan message
+	closing   chan closeRequest
+	done      chan struct{}
+	closeOnce sync.Once
+	termOnce  sync.Once
+
+	pingPeriod time.Duration
+	pongWait   time.Duration
+	writeWait  time.Duration
+}
+
+func newConn(c *ws.Conn, r *http.Request, sessionID string, h Handler) *Conn {
+	conn := &Conn{
+		SessionID:  sessionID,
+		Request:    r,
+		ws:         c,
+		send:       make(chan message, h.SendBuffer),
+		closing:    make(chan closeRequest, 1),
+		done:       make(chan struct{}),
+		pingPeriod: h.PingPeriod,
+		pongWait:   h.PingPeriod * 2,
+		writeWait:  h.WriteWait,
+	}
+	c.SetReadLimit(h.ReadLimit)
+	c.SetReadDeadline(time.Now().Add(conn.pongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(conn.pongWait))
+	})
+	go conn.writeLoop()
+	return conn
+}
+
+// Context returns the context of the upgraded request. It is canceled once the
+// handling function returns.
+func (c *Conn) Context() context.Context {
+	return c.Request.Context()
+}
+
+// Done returns a channel which is closed when the connection is terminated.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Send queues a message for delivery. It blocks if the send buffer is full,
+// until the message is queued, the context is canceled or the connection is
+// terminated.
+func (c *Conn) Send(ctx context.Context, messageType int, data []byte) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	select {
+	case c.send <- message{messageType, data}:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendText queues a text message for delivery.
+func (c *Conn) SendText(s string) error {
+	return c.Send(c.Context(), TextMessage, []byte(s))
+}
+
+// SendJSON queues the JSON encoding of v as a text message.
+func (c *Conn) SendJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(c.Context(), TextMessage, b)
+}
+
+// Receive waits for the next message sent by the client. It returns an error
+// once the connection is closed, by either side, or when the client stops
+// answering pings.
+func (c *Conn) Receive() (messageType int, data []byte, err error) {
+	messageType, data, err = c.ws.ReadMessage()
+	if err != nil {
+		c.terminate()
+	}
+	return messageType, data, err
+}
+
+// ReceiveJSON waits for the next message and decodes it into v.
+func (c *Conn) ReceiveJSON(v interface{}) error {
+	_, data, err := c.Receive()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Close initiates the closing handshake with the given status code and
+// reason, after the queued messages have been sent. The underlying network
+// connection is released once the client acknowledges or after the write
+// timeout.
+func (c *Conn) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closing <- closeRequest{code, reason}
+	})
+}
+
+// terminate releases the network connection.
+func (c *Conn) terminate() {
+	c.termOnce.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+func (c *Conn) writeLoop() {
+	ticker := time.NewTicker(c.pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(c.writeWait))
+			if err := c.ws.WriteMessage(m.typ, m.data); err != nil {
+				c.terminate()
+				return
+			}
+		case <-ticker.C:
+			if err := c.ws.WriteControl(ws.PingMessage, nil, time.Now().Add(c.writeWait)); err != nil {
+				c.terminate()
+				return
+			}
+		case cr := <-c.closing:
+			c.flush()
+			c.ws.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(cr.code, cr.reason), time.Now().Add(c.writeWait))
+			select {
+			case <-c.done: // the reader received the client acknowledgement
+			case <-time.After(c.writeWait):
+			}
+			c.terminate()
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// flush writes the queued messages.
+func (c *Conn) flush() {
+	for {
+		select {
+		case m := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(c.writeWait))
+			if err := c.ws.WriteMessage(m.typ, m.data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Hub keeps track of the open connections so that they can be closed
+// gracefully when the server shuts down. Hijacked connections, such as
+// websocket connections, are not tracked by http.Server.Shutdown.
+type Hub struct {
+	mu    sync.Mutex
+	conns map[*Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewHub returns a new connection Hub.
+func NewHub() *Hub {
+	return &Hub{conns: make(map[*Conn]struct{})}
+}
+
+func (h *Hub) add(c *Conn) {
+	h.mu.Lock()
+	h.conns[c] = struct{}{}
+	h.wg.Add(1)
+	h.mu.Unlock()
+}
+
+func (h *Hub) remove(c *Conn) {
+	h.mu.Lock()
+	if _, ok := h.conns[c]; ok {
+		delete(h.conns, c)
+		h.wg.Done()
+	}
+	h.mu.Unlock()
+}
+
+// Len returns the number of open connections.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+// Broadcast queues a message for delivery to every open connection.
+func (h *Hub) Broadcast(ctx context.Context, messageType int, data []byte) {
+	h.mu.Lock()
+	conns := make([]*Conn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+	for _, c := range conns {
+		c.Send(ctx, messageType, data)
+	}
+}
+
+// Shutdown sends a close frame with the CloseGoingAway status to every open
+// connection and waits for them to be released, or for the context to be
+// done. It may be registered with http.Server.RegisterOnShutdown.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	for c := range h.conns {
+		c.Close(CloseGoingAway, "server shutdown")
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,148 @@
+// Package websocket defines a request handler which upgrades http requests to
+// the websocket protocol.
+//
+// Clients must hold a valid session: the session is loaded before the upgrade
+// and requests without one are rejected with a 401 Unauthorized status.
+// Cross-origin requests are only upgraded if their origin is allowed by the
+// CORS parameters of the application.
+package websocket
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/cors"
+	"github.com/atdiar/xhttp/handlers/session"
+	ws "github.com/gorilla/websocket"
+)
+
+// Handler is the websocket upgrading request handler.
+type Handler struct {
+	Session    session.Handler
+	Serve      func(c *Conn)
+	Hub        *Hub
+	Origins    *cors.Parameters
+	PingPeriod time.Duration
+	WriteWait  time.Duration
+	ReadLimit  int64
+	SendBuffer int
+
+	upgrader ws.Upgrader
+}
+
+// New returns a request handler which upgrades the requests of clients holding
+// a session to the websocket protocol, and calls serve with the resulting
+// connection. The connection is closed when serve returns: it must not be read
+// from afterwards.
+func New(s session.Handler, serve func(c *Conn), options ...func(Handler) Handler) Handler {
+	if serve == nil {
+		panic("websocket: nil handling function")
+	}
+	h := Handler{
+		Session:    s,
+		Serve:      serve,
+		Hub:        NewHub(),
+		PingPeriod: 30 * time.Second,
+		WriteWait:  10 * time.Second,
+		ReadLimit:  1 << 20,
+		SendBuffer: 16,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			h = opt(h)
+		}
+	}
+	h.upgrader = ws.Upgrader{
+		HandshakeTimeout: h.WriteWait,
+		CheckOrigin:      h.checkOrigin,
+	}
+	return h
+}
+
+// AllowOrigins is a configuration option which allows cross-origin requests
+// from the origins allowed by the CORS parameters, typically those of the
+// cors.Handler of the application. By default, only same-origin requests are
+// upgraded.
+func AllowOrigins(p *cors.Parameters) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Origins = p
+		return h
+	}
+}
+
+// WithHub is a configuration option which registers the connections in the
+// provided Hub, which may be shared by several handlers.
+func WithHub(hub *Hub) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.Hub = hub
+		return h
+	}
+}
+
+// PingPeriod is a configuration option which sets the interval at which pings
+// are sent. Clients that do not answer within twice that interval are
+// disconnected.
+func PingPeriod(d time.Duration) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.PingPeriod = d
+		return h
+	}
+}
+
+// ReadLimit is a configuration option which sets the maximum size in bytes of
+// a message sent by a client.
+func ReadLimit(n int64) func(Handler) Handler {
+	return func(h Handler) Handler {
+		h.ReadLimit = n
+		return h
+	}
+}
+
+func (h Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser
+	}
+	if h.Origins != nil && h.Origins.OriginAllowed(origin) {
+		return true
+	}
+	return origin == "http://"+r.Host || origin == "https://"+r.Host
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = h.Session.Attach(r)
+	if err := h.Session.Load(w, r); err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	id, err := h.Session.ID(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if !h.checkOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	c, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has already replied
+	}
+	conn := newConn(c, r, id, h)
+	if h.Hub != nil {
+		h.Hub.add(conn)
+		defer h.Hub.remove(conn)
+	}
+	h.Serve(conn)
+
+	// The handling function is done reading: the client acknowledgement of
+	// the closing handshake is awaited here.
+	conn.Close(CloseNormalClosure, "")
+	for {
+		if _, _, err := conn.Receive(); err != nil {
+			break
+		}
+	}
+	<-conn.Done()
+}
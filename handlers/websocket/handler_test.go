@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp"
+	"github.com/atdiar/xhttp/handlers/cors"
+	"github.com/atdiar/xhttp/handlers/session"
+	ws "github.com/gorilla/websocket"
+)
+
+func TestWebsocket(t *testing.T) {
+	s := session.New("SID", "secret")
+	p := cors.NewHandler().AllowOrigins("https://app.example.com", "https://*.example.net").Parameters
+
+	echo := New(s, func(c *Conn) {
+		for {
+			typ, data, err := c.Receive()
+			if err != nil {
+				return
+			}
+			if err = c.Send(c.Context(), typ, data); err != nil {
+				return
+			}
+		}
+	}, AllowOrigins(p))
+
+	mux := xhttp.NewServeMux()
+	mux.GET("/login", s)
+	mux.GET("/ws", echo)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	var cookie string
+	for _, c := range res.Cookies() {
+		if c.Name == "SID" {
+			cookie = c.Name + "=" + c.Value
+		}
+	}
+	if cookie == "" {
+		t.Fatal("No session cookie")
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	// Without session
+	_, res, err = ws.DefaultDialer.Dial(url, nil)
+	if err == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected the upgrade to be refused. Got %v", res)
+	}
+
+	// Disallowed origin
+	hdr := http.Header{"Cookie": {cookie}, "Origin": {"https://evil.example.com"}}
+	_, res, err = ws.DefaultDialer.Dial(url, hdr)
+	if err == nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the origin to be refused. Got %v", res)
+	}
+
+	// Origin allowed by a pattern
+	hdr.Set("Origin", "https://chat.example.net")
+	pc, _, err := ws.DefaultDialer.Dial(url, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.Close()
+
+	hdr.Set("Origin", "https://app.example.com")
+	c, _, err := ws.DefaultDialer.Dial(url, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = c.WriteMessage(ws.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := c.ReadMessage()
+	if err != nil || string(msg) != "hello" {
+		t.Fatalf("Expected an echo but got %q %v", msg, err)
+	}
+
+	// Graceful shutdown
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- echo.Hub.Shutdown(ctx)
+	}()
+	_, _, err = c.ReadMessage()
+	if !ws.IsCloseError(err, CloseGoingAway) {
+		t.Fatalf("Expected a going away close frame but got %v", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if echo.Hub.Len() != 0 {
+		t.Fatalf("Expected no open connection. Got %d", echo.Hub.Len())
+	}
+}
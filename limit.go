@@ -0,0 +1,77 @@
+package xhttp
+
+// This file defines a request handler capping the number of concurrent
+// executions of a handler chain.
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type inFlightLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+	next         Handler
+}
+
+// MaxInFlight returns a HandlerLinker which lets at most n requests execute the
+// downstream handlers concurrently. The excess requests wait for a slot for at
+// most queueTimeout and are answered with a 503 Service Unavailable response
+// and a Retry-After header if none frees up.
+//
+// It is meant for expensive routes, such as report generation, and should
+// be registered per route rather than with USE, as the limit is shared by
+// every request going through the returned handler.
+func MaxInFlight(n int, queueTimeout time.Duration) HandlerLinker {
+	if n <= 0 {
+		panic("xhttp: MaxInFlight requires a positive limit")
+	}
+	return inFlightLimiter{
+		slots:        make(chan struct{}, n),
+		queueTimeout: queueTimeout,
+	}
+}
+
+func (l inFlightLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		if !l.wait(r) {
+			retry := int64((l.queueTimeout + time.Second - 1) / time.Second)
+			if retry < 1 {
+				retry = 1
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	defer func() { <-l.slots }()
+	if l.next != nil {
+		l.next.ServeHTTP(w, r)
+	}
+}
+
+// wait queues the request until a slot frees up. It returns false if the queue
+// timeout elapses or the request is canceled first.
+func (l inFlightLimiter) wait(r *http.Request) bool {
+	if l.queueTimeout <= 0 {
+		return false
+	}
+	t := time.NewTimer(l.queueTimeout)
+	defer t.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l inFlightLimiter) Link(h Handler) HandlerLinker {
+	l.next = h
+	return l
+}
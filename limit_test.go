@@ -0,0 +1,56 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := MaxInFlight(1, 20*time.Millisecond).Link(HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://example.com/report", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve()
+	}()
+	<-entered
+
+	w := serve()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Expected a saturated handler to answer 503 with Retry-After. Got %d", w.Code)
+	}
+
+	wg.Add(1)
+	var queued *httptest.ResponseRecorder
+	go func() {
+		defer wg.Done()
+		queued = serve()
+	}()
+	time.Sleep(5 * time.Millisecond)
+	release <- struct{}{}
+	<-entered
+	close(release)
+	wg.Wait()
+	if queued.Code != http.StatusOK {
+		t.Fatalf("Expected the queued request to be served once a slot freed up. Got %d", queued.Code)
+	}
+}
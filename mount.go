@@ -0,0 +1,74 @@
+package xhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mount registers a net/http request handler, such as net/http/pprof, a
+// metrics handler or a third-party router, for every method and every path
+// starting with prefix. The handlers registered with USE and the group
+// handlers apply to it, like for any other route.
+//
+// If strip is true, the prefix, without its trailing slash, is removed from
+// the request path before the handler is called, as done by http.StripPrefix.
+// For instance, when mounted on /debug/, a request for /debug/pprof/ is
+// received by the handler as /pprof/.
+func (sm *ServeMux) Mount(prefix string, h http.Handler, strip bool) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	name := handlerName(h)
+	if sm.root != nil {
+		prefix = sm.prefix + prefix
+	}
+	if h != nil && strip {
+		h = mounted{strings.TrimSuffix(prefix, "/"), h}
+	}
+	if sm.root != nil {
+		h = sm.wrap(h)
+		sm = sm.root
+	}
+	if !muxCheck(sm, "MOUNT", prefix, h) {
+		return
+	}
+
+	routehandler := sm.routeHandlerMap[prefix]
+	if v := routehandler.registered(); len(v) > 0 {
+		sm.routeError("MOUNT", prefix, fmt.Errorf("%w: %s is already registered for %s", ErrRouteConflict, v[0].t.name, v[0].method))
+		return
+	}
+	for _, t := range []*transformableHandler{
+		&routehandler.get, &routehandler.post, &routehandler.put,
+		&routehandler.patch, &routehandler.delete, &routehandler.head,
+		&routehandler.options, &routehandler.connect, &routehandler.trace,
+	} {
+		*t = t.register(h, name)
+	}
+	sm.routeHandlerMap[prefix] = routehandler.prepend(sm.catchAll)
+}
+
+// mounted removes a prefix from the request path before calling the mounted
+// handler.
+type mounted struct {
+	prefix string
+	next   http.Handler
+}
+
+func (m mounted) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, m.prefix)
+	if len(p) == len(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+	rp := strings.TrimPrefix(r.URL.RawPath, m.prefix)
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = rp
+	m.next.ServeHTTP(w, r2)
+}
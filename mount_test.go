@@ -0,0 +1,63 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMount(t *testing.T) {
+	var got string
+	sub := http.NewServeMux()
+	sub.HandleFunc("/pprof/", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.Path
+	})
+	full := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.Path
+	})
+
+	var used int
+	mux := NewServeMux()
+	mux.USE(counter{&used, nil})
+	mux.Mount("/debug", sub, true)
+	mux.Group("/api").Mount("/v2/", full, false)
+
+	tcs := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/debug/pprof/heap", "GET /pprof/heap"},
+		{"POST", "/debug/pprof/", "POST /pprof/"},
+		{"DELETE", "/api/v2/users/1", "DELETE /api/v2/users/1"},
+	}
+	for _, tc := range tcs {
+		got = ""
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if got != tc.want {
+			t.Errorf("%s %s: mounted handler received %q, expected %q", tc.method, tc.path, got, tc.want)
+		}
+	}
+	if used != len(tcs) {
+		t.Errorf("catch-all handler called %d times, expected %d", used, len(tcs))
+	}
+}
+
+// counter is a linkable handler counting the requests.
+type counter struct {
+	n    *int
+	next Handler
+}
+
+func (c counter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	*c.n++
+	if c.next != nil {
+		c.next.ServeHTTP(w, r)
+	}
+}
+
+func (c counter) Link(h Handler) HandlerLinker {
+	c.next = h
+	return c
+}
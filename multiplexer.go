@@ -3,9 +3,14 @@
 package xhttp
 
 import (
+	"bufio"
+	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -13,12 +18,41 @@ import (
 // ServeMux holds the multiplexing logic of incoming http requests.
 // It wraps around a net/http multiplexer.
 // It facilitates the registration of request handlers.
+//
+// Patterns ending with a slash match any path they prefix. Segments starting
+// with a colon, as in /track/:id, match any path segment whose value can be
+// retrieved with Params.
 type ServeMux struct {
 	catchAll        HandlerLinker
+	uses            []HandlerLinker
 	Once            *sync.Once
 	routeHandlerMap map[string]httpVerbFunctions
+	routes          *routeTree
 	ServeMux        *http.ServeMux
 	initErr         []error
+
+	// root, prefix and group are set for a route group. See Group.
+	root   *ServeMux
+	prefix string
+	group  []HandlerLinker
+
+	// MethodNotAllowedHandler, if not nil, handles the requests whose path
+	// matches a registered pattern but whose method does not. The Allow header
+	// listing the registered methods is set before it is called.
+	// By default, a 405 Method Not Allowed error is sent.
+	MethodNotAllowedHandler Handler
+
+	// TrailingSlashRedirect, if not zero, is the status code of the redirect,
+	// e.g. http.StatusMovedPermanently or http.StatusPermanentRedirect, sent
+	// when the request path is not registered but would be with a trailing
+	// slash added or removed: /path is redirected to /path/ and vice versa.
+	// By default, such a request is handled by the pattern matching it, if any.
+	TrailingSlashRedirect int
+
+	// CaseInsensitive makes the static segments of the registered patterns
+	// match the request paths regardless of case. The patterns differing only
+	// by case should then be avoided.
+	CaseInsensitive bool
 }
 
 // NewServeMux creates a new multiplexer wrapper which holds the request
@@ -30,6 +64,7 @@ func NewServeMux() ServeMux {
 	sm.ServeMux = http.NewServeMux()
 	sm.Once = new(sync.Once)
 	sm.routeHandlerMap = make(map[string]httpVerbFunctions)
+	sm.routes = newRouteTree()
 	sm.initErr = nil
 	sm.catchAll = initcatchall{nil}
 	return sm
@@ -37,56 +72,40 @@ func NewServeMux() ServeMux {
 
 // ServeHTTP is the request-servicing function for an object of type ServeMux.
 func (sm ServeMux) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if sm.root != nil {
+		sm.root.ServeHTTP(w, req)
+		return
+	}
 	if sm.initErr != nil {
-		var errstr string
-		for _, s := range sm.initErr {
-			errstr = errstr + s.Error()
-		}
-		panic(errstr)
+		panic(sm.Validate().Error())
+	}
+
+	if sm.TrailingSlashRedirect != 0 && sm.redirectSlash(w, req) {
+		return
 	}
 
 	// Let's check whether a handler has been registered for the path
-	var longestpath string
-	vh, ok := sm.routeHandlerMap[req.URL.Path]
+	longestpath, params := sm.routes.lookup(req.URL.Path, sm.CaseInsensitive)
+	vh := sm.routeHandlerMap[longestpath]
 	method := strings.ToUpper(req.Method)
-	if !ok {
-		for pathname, v := range sm.routeHandlerMap {
-			if strings.HasSuffix(pathname, "/") {
-				if strings.HasPrefix(req.URL.Path, pathname) {
-					if len(pathname) > len(longestpath) {
-						longestpath = pathname
-						vh = v
-					}
-				}
+	if longestpath != "" {
+		ctx := context.WithValue(req.Context(), patternKey{}, longestpath)
+		if len(params) > 0 {
+			vars := make(map[string]string, len(params))
+			for _, p := range params {
+				vars[p.name] = p.value
 			}
+			ctx = context.WithValue(ctx, paramsKey{}, vars)
 		}
-	} else {
-		longestpath = req.URL.Path
-	}
-	if longestpath != "" {
+		req = req.WithContext(ctx)
+
 		// Let's extract the http Method and apply the handler if it exists.
-		switch method {
-		case "GET":
-			sm.catchAll.Link(vh.get).ServeHTTP(w, req)
-		case "POST":
-			sm.catchAll.Link(vh.post).ServeHTTP(w, req)
-		case "PUT":
-			sm.catchAll.Link(vh.put).ServeHTTP(w, req)
-		case "PATCH":
-			sm.catchAll.Link(vh.patch).ServeHTTP(w, req)
-		case "DELETE":
-			sm.catchAll.Link(vh.delete).ServeHTTP(w, req)
-		case "HEAD":
-			sm.catchAll.Link(vh.head).ServeHTTP(w, req)
-		case "OPTIONS":
-			sm.catchAll.Link(vh.options).ServeHTTP( w, req)
-		case "CONNECT":
-			sm.catchAll.Link(vh.connect).ServeHTTP(w, req)
-		case "TRACE":
-			sm.catchAll.Link(vh.trace).ServeHTTP(w, req)
-		default:
-			http.Error(w, http.StatusText(405), 405)
+		t, _ := vh.lookup(method)
+		if t.Handler == nil {
+			sm.methodNotAllowed(w, req, vh)
+			return
 		}
+		t.ServeHTTP(w, req)
 	}
 
 	// todo check if a handler exists that is not http.ServeMux
@@ -94,6 +113,28 @@ func (sm ServeMux) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 }
 
+// redirectSlash redirects the request when its path is not registered but
+// would be with a trailing slash added or removed. It reports whether the
+// request was redirected.
+func (sm ServeMux) redirectSlash(w http.ResponseWriter, req *http.Request) bool {
+	path := req.URL.Path
+	if path == "" || path == "/" || sm.routes.find(path, sm.CaseInsensitive) != "" {
+		return false
+	}
+	alt := path + "/"
+	if strings.HasSuffix(path, "/") {
+		alt = strings.TrimSuffix(path, "/")
+	}
+	if sm.routes.find(alt, sm.CaseInsensitive) == "" {
+		return false
+	}
+	if req.URL.RawQuery != "" {
+		alt = alt + "?" + req.URL.RawQuery
+	}
+	http.Redirect(w, req, alt, sm.TrailingSlashRedirect)
+	return true
+}
+
 // httpVerbFunctions is a structure that lists the request handlers for each http
 // verb.
 type httpVerbFunctions struct {
@@ -108,6 +149,82 @@ type httpVerbFunctions struct {
 	trace   transformableHandler
 }
 
+// lookup returns the request handler registered for a method. It reports
+// whether the method is supported.
+func (vh httpVerbFunctions) lookup(method string) (transformableHandler, bool) {
+	switch method {
+	case "GET":
+		return vh.get, true
+	case "POST":
+		return vh.post, true
+	case "PUT":
+		return vh.put, true
+	case "PATCH":
+		return vh.patch, true
+	case "DELETE":
+		return vh.delete, true
+	case "HEAD":
+		return vh.head, true
+	case "OPTIONS":
+		return vh.options, true
+	case "CONNECT":
+		return vh.connect, true
+	case "TRACE":
+		return vh.trace, true
+	}
+	return transformableHandler{}, false
+}
+
+// linkRoute links the handlers specific to a route to its handler.
+func linkRoute(h Handler, handlers []HandlerLinker) Handler {
+	if h == nil || len(handlers) == 0 {
+		return h
+	}
+	return Chain(append([]HandlerLinker(nil), handlers...)...).Link(h)
+}
+
+// verb is a request handler registered for a given method.
+type verb struct {
+	method string
+	t      transformableHandler
+}
+
+// registered returns the handlers registered for each method, in a fixed
+// order.
+func (vh httpVerbFunctions) registered() []verb {
+	var verbs []verb
+	for _, v := range []verb{
+		{"GET", vh.get}, {"HEAD", vh.head}, {"POST", vh.post}, {"PUT", vh.put},
+		{"PATCH", vh.patch}, {"DELETE", vh.delete}, {"OPTIONS", vh.options},
+		{"CONNECT", vh.connect}, {"TRACE", vh.trace},
+	} {
+		if v.t.in != nil {
+			verbs = append(verbs, v)
+		}
+	}
+	return verbs
+}
+
+// allowed returns the list of the methods for which a handler is registered.
+func (vh httpVerbFunctions) allowed() []string {
+	var methods []string
+	for _, v := range vh.registered() {
+		methods = append(methods, v.method)
+	}
+	return methods
+}
+
+// methodNotAllowed responds to a request whose method has no registered
+// handler for the matched pattern.
+func (sm ServeMux) methodNotAllowed(w http.ResponseWriter, r *http.Request, vh httpVerbFunctions) {
+	w.Header().Set("Allow", strings.Join(vh.allowed(), ", "))
+	if sm.MethodNotAllowedHandler != nil {
+		sm.MethodNotAllowedHandler.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
 func (vh httpVerbFunctions) prepend(h HandlerLinker) httpVerbFunctions {
 	vh.get = vh.get.prepend(h)
 	vh.post = vh.post.prepend(h)
@@ -125,19 +242,24 @@ func (vh httpVerbFunctions) prepend(h HandlerLinker) httpVerbFunctions {
 // This format allows for the modification of a handler. For instance, it is
 // used to prepend catchall request handlers more easily.
 // It implements the Handler interface.
+//
+// The output handler is linked once, when the route or the catchall handlers
+// are registered, so that dispatching a request does not require any linking.
 type transformableHandler struct {
-	in      http.Handler
+	in           http.Handler
 	http.Handler // output
+	name         string
 }
 
-func (t transformableHandler) register(h http.Handler) transformableHandler {
+func (t transformableHandler) register(h http.Handler, name string) transformableHandler {
 	t.in = h
 	t.Handler = h
+	t.name = name
 	return t
 }
 
 func (t transformableHandler) prepend(h HandlerLinker) transformableHandler {
-	if h != nil {
+	if h != nil && t.in != nil {
 		t.Handler = h.Link(t.in)
 	}
 	return t
@@ -145,21 +267,21 @@ func (t transformableHandler) prepend(h HandlerLinker) transformableHandler {
 
 // HANDLER REGISTRATION
 
-func muxCheck(sm *ServeMux, method string, pattern string, h Handler) {
+func muxCheck(sm *ServeMux, method string, pattern string, h Handler) bool {
 	if h == nil {
-		sm.initErr = append(sm.initErr, error(errors.New(method+" "+pattern+": request handler nil\n")))
-		return
+		sm.routeError(method, pattern, ErrNilHandler)
+		return false
 	}
 
-	if pattern == "" {
-		sm.initErr = append(sm.initErr, error(errors.New(method+" "+pattern+": request pattern invalid\n")))
-		return
+	if pattern == "" || !strings.HasPrefix(pattern, "/") {
+		sm.routeError(method, pattern, ErrInvalidPattern)
+		return false
 	}
 
 	r, err := http.NewRequest(method, pattern, nil)
 	if err != nil {
-		sm.initErr = append(sm.initErr, error(errors.New(method+" "+pattern+": request handler nil\n")))
-		return
+		sm.routeError(method, pattern, ErrInvalidPattern)
+		return false
 	}
 	rh, path := sm.ServeMux.Handler(r)
 	if path == "" || path != pattern {
@@ -170,128 +292,132 @@ func muxCheck(sm *ServeMux, method string, pattern string, h Handler) {
 		// A handler has already been registered. If it is sm, we can continue.
 		// Otherwise, we can't.
 		if han, ok := rh.(*ServeMux); !ok || (han != sm) {
-			sm.initErr = append(sm.initErr, error(errors.New(method+" "+pattern+": request handler already exists\n")))
-			return
+			sm.routeError(method, pattern, fmt.Errorf("%w: the pattern is handled by another multiplexer", ErrRouteConflict))
+			return false
 		}
 	}
+	if err := sm.routes.insert(pattern); err != nil {
+		sm.routeError(method, pattern, err)
+		return false
+	}
+	return true
 }
 
 // GET registers the request Handler for the servicing of http GET requests.
 // It also handles HEAD requests wby creating an identical
 // response to GET requests without the request body.
-func (sm *ServeMux) GET(pattern string, h Handler) {
-	muxCheck(sm, "GET", pattern, h)
-
-	routehandler, _ := sm.routeHandlerMap[pattern]
-
-	routehandler.get = routehandler.get.register(h)
-
-	routehandler.head = routehandler.head.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
-
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) GET(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("GET", pattern, h, handlers)
 }
 
 // POST registers the request Handler for the servicing of http POST requests.
-func (sm *ServeMux) POST(pattern string, h Handler) {
-
-	muxCheck(sm, "POST", pattern, h)
-
-	routehandler, _ := sm.routeHandlerMap[pattern]
-
-	routehandler.post = routehandler.post.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
-
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) POST(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("POST", pattern, h, handlers)
 }
 
 // PUT registers the request Handler for the servicing of http PUT requests.
-func (sm *ServeMux) PUT(pattern string, h Handler) {
-
-	muxCheck(sm, "PUT", pattern, h)
-
-	routehandler, _ := sm.routeHandlerMap[pattern]
-
-	routehandler.put = routehandler.put.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
-
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) PUT(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("PUT", pattern, h, handlers)
 }
 
 // PATCH registers the request Handler for the servicing of http PATCH requests.
-func (sm *ServeMux) PATCH(pattern string, h Handler) {
-
-	muxCheck(sm, "PATCH", pattern, h)
-
-	routehandler, _ := sm.routeHandlerMap[pattern]
-
-	routehandler.patch = routehandler.patch.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
-
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) PATCH(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("PATCH", pattern, h, handlers)
 }
 
 // DELETE registers the request Handler for the servicing of http DELETE requests.
-func (sm *ServeMux) DELETE(pattern string, h Handler) {
-
-	muxCheck(sm, "DELETE", pattern, h)
-
-	routehandler, _ := sm.routeHandlerMap[pattern]
-
-	routehandler.delete = routehandler.delete.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
-
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) DELETE(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("DELETE", pattern, h, handlers)
 }
 
 // OPTIONS registers the request Handler for the servicing of http OPTIONS requests.
-func (sm *ServeMux) OPTIONS(pattern string, h Handler) {
-
-	muxCheck(sm, "OPTIONS", pattern, h)
-
-	routehandler, _ := sm.routeHandlerMap[pattern]
-
-	routehandler.options = routehandler.options.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
-
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) OPTIONS(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("OPTIONS", pattern, h, handlers)
 }
 
 // CONNECT registers the request Handler for the servicing of http CONNECT requests.
-func (sm *ServeMux) CONNECT(pattern string, h Handler) {
-
-	muxCheck(sm, "CONNECT", pattern, h)
-
-	routehandler, _ := sm.routeHandlerMap[pattern]
-
-	routehandler.connect = routehandler.connect.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
-
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) CONNECT(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("CONNECT", pattern, h, handlers)
 }
 
 // TRACE registers the request Handler for the servicing of http TRACE requests.
-func (sm *ServeMux) TRACE(pattern string, h Handler) {
+// The optional handlers are linked before h and only apply to this route.
+func (sm *ServeMux) TRACE(pattern string, h Handler, handlers ...HandlerLinker) {
+	sm.handle("TRACE", pattern, h, handlers)
+}
 
-	muxCheck(sm, "TRACE", pattern, h)
+// handle registers the request Handler of a route for a given method.
+// The routes of a group are registered on the root ServeMux.
+func (sm *ServeMux) handle(method string, pattern string, h Handler, handlers []HandlerLinker) {
+	name := handlerName(h)
+	h = linkRoute(h, handlers)
+	if sm.root != nil {
+		pattern = sm.prefix + pattern
+		h = sm.wrap(h)
+		sm = sm.root
+	}
+	if !muxCheck(sm, method, pattern, h) {
+		return
+	}
 
 	routehandler, _ := sm.routeHandlerMap[pattern]
+	if t, _ := routehandler.lookup(method); t.in != nil {
+		sm.routeError(method, pattern, fmt.Errorf("%w: %s is already registered", ErrRouteConflict, t.name))
+		return
+	}
 
-	routehandler.trace = routehandler.trace.register(h)
-
-	sm.routeHandlerMap[pattern] = routehandler
+	switch method {
+	case "GET":
+		routehandler.get = routehandler.get.register(h, name)
+		routehandler.head = routehandler.head.register(headHandler{h}, name)
+	case "POST":
+		routehandler.post = routehandler.post.register(h, name)
+	case "PUT":
+		routehandler.put = routehandler.put.register(h, name)
+	case "PATCH":
+		routehandler.patch = routehandler.patch.register(h, name)
+	case "DELETE":
+		routehandler.delete = routehandler.delete.register(h, name)
+	case "OPTIONS":
+		routehandler.options = routehandler.options.register(h, name)
+	case "CONNECT":
+		routehandler.connect = routehandler.connect.register(h, name)
+	case "TRACE":
+		routehandler.trace = routehandler.trace.register(h, name)
+	}
 
+	sm.routeHandlerMap[pattern] = routehandler.prepend(sm.catchAll)
 }
 
 // USE registers linkable request Handlers (i.e. implementing HandlerLinker)
 // which shall be servicing any path, regardless of the request method.
-// This function should only be called once.
+// It can be called several times: the handlers are appended to the ones
+// already registered, in order, and apply to every route, whether it was
+// registered before or after.
 func (sm *ServeMux) USE(handlers ...HandlerLinker) {
-	linkable := Chain(handlers...)
-	if _, ok := sm.catchAll.(initcatchall); !ok {
-		sm.initErr = append(sm.initErr, error(errors.New("USE has already been called once.\n")))
-	} else {
-		sm.catchAll = linkable
+	if sm.root != nil {
+		sm.root.routeError("USE", sm.prefix, errors.New("USE cannot be called on a route group, the group handlers should be passed to Group instead"))
+		return
+	}
+	for _, h := range handlers {
+		if h != nil {
+			sm.uses = append(sm.uses, h)
+		}
+	}
+	if len(sm.uses) == 0 {
+		return
+	}
+	sm.catchAll = Chain(append([]HandlerLinker(nil), sm.uses...)...)
+	// The routes registered so far are relinked.
+	for pattern, routehandler := range sm.routeHandlerMap {
+		sm.routeHandlerMap[pattern] = routehandler.prepend(sm.catchAll)
 	}
 }
 
@@ -322,11 +448,14 @@ func (h handlerchain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h[0].ServeHTTP(w, r)
 }
 
+// Link returns a new chain linked to l. The receiver is left untouched so that
+// a chain can be linked to several handlers, e.g. one per route.
 func (h handlerchain) Link(l Handler) HandlerLinker {
 	length := len(h)
 	if length == 0 {
 		panic("Linking to nothing is impossible.")
 	}
+	h = append(handlerchain(nil), h...)
 	nh := h[length-1].Link(l)
 	h[length-1] = nh
 
@@ -345,13 +474,80 @@ func (h handlerchain) Link(l Handler) HandlerLinker {
 // a message-body in response to a http request. It is used to derive the
 // response to a HEAD request from the response that would be returned from a
 // GET request.
+//
+// The bytes written are counted so that the Content-Length of the GET
+// response can be announced. As a consequence, the header is only sent when
+// the handler returns or flushes.
 type noopBodywriter struct {
 	http.ResponseWriter
+	status  int
+	written int64
+	sent    bool
+}
+
+func (nbw *noopBodywriter) WriteHeader(code int) {
+	if nbw.sent || nbw.status != 0 {
+		return
+	}
+	if code >= 100 && code < 200 {
+		nbw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	nbw.status = code
+}
+
+func (nbw *noopBodywriter) Write(b []byte) (int, error) {
+	if nbw.status == 0 {
+		nbw.WriteHeader(http.StatusOK)
+	}
+	nbw.written += int64(len(b))
+	return len(b), nil
+}
+
+// FlushError sends the header, without Content-Length since the length of
+// the response is not known yet.
+func (nbw *noopBodywriter) FlushError() error {
+	nbw.send(false)
+	return Flush(nbw.ResponseWriter)
+}
+
+func (nbw *noopBodywriter) Flush() { nbw.FlushError() }
+
+func (nbw *noopBodywriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return Hijack(nbw.ResponseWriter)
 }
 
-func (nbw noopBodywriter) Write([]byte) (int, error) { return 200, nil }
+// send writes the header, announcing the length of the discarded body if
+// known and not already set.
+func (nbw *noopBodywriter) send(length bool) {
+	if nbw.sent {
+		return
+	}
+	nbw.sent = true
+	if nbw.status == 0 {
+		nbw.status = http.StatusOK
+	}
+	h := nbw.Header()
+	if length && h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" && nbw.status != http.StatusNoContent && nbw.status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.FormatInt(nbw.written, 10))
+	}
+	nbw.ResponseWriter.WriteHeader(nbw.status)
+}
+
+func (nbw *noopBodywriter) Wrappee() http.ResponseWriter { return nbw.ResponseWriter }
 
-func (nbw noopBodywriter) Wrappee() http.ResponseWriter { return nbw.ResponseWriter }
+func (nbw *noopBodywriter) Unwrap() http.ResponseWriter { return nbw.ResponseWriter }
+
+// headHandler serves HEAD requests with the handler of GET requests.
+type headHandler struct {
+	get Handler
+}
+
+func (h headHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nbw := &noopBodywriter{ResponseWriter: w}
+	h.get.ServeHTTP(nbw, r)
+	nbw.send(true)
+}
 
 func patternMatch(url *url.URL, pattern string, vars map[string]string) bool {
 	uri := url.RequestURI()
@@ -361,7 +557,7 @@ func patternMatch(url *url.URL, pattern string, vars map[string]string) bool {
 		return false
 	}
 	for i, str := range patternsplit {
-		if str[0:1] != ":" {
+		if !strings.HasPrefix(str, ":") {
 			if str != pathsplit[i] {
 				return false
 			}
@@ -382,10 +578,40 @@ func patternMatch(url *url.URL, pattern string, vars map[string]string) bool {
 // ("tracknumber","2589556") and ("commentnumber","1879545")
 // NB Everything remains stored as strings.
 // This function should be used on a path registered in the muxer as /track/
+// Alternatively, the pattern can be registered as is and the parameters
+// retrieved with Params.
 func PathMatch(req *http.Request, pattern string, vars map[string]string) bool {
 	return patternMatch(req.URL, pattern, vars)
 }
 
+type patternKey struct{}
+
+type paramsKey struct{}
+
+// Params returns the path parameters of a request, extracted by the ServeMux
+// from the segments of the registered pattern starting with a colon.
+// For instance, a request for /track/2589556/comments/1879545 served by the
+// handler registered for /track/:id/comments/:cid has the parameters
+// ("id","2589556") and ("cid","1879545").
+// The returned map should not be modified. It is nil if the pattern has no
+// parameter.
+func Params(r *http.Request) map[string]string {
+	vars, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return vars
+}
+
+// Pattern returns the pattern of the route that matched the request, as it
+// was registered in the ServeMux. For instance, a request for /track/2589556
+// served by the handler registered for /track/ returns "/track/".
+// It returns the empty string if the request has not been dispatched by a
+// ServeMux yet.
+// Unlike the raw path, the pattern is of bounded cardinality, which makes it
+// suitable as a label for metrics or logs.
+func Pattern(r *http.Request) string {
+	p, _ := r.Context().Value(patternKey{}).(string)
+	return p
+}
+
 type initcatchall struct {
 	next Handler
 }
@@ -0,0 +1,356 @@
+package xhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingLinker counts the number of times it is linked.
+type countingLinker struct {
+	links *int32
+	next  Handler
+}
+
+func (c countingLinker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("X-Catchall", "1")
+	if c.next != nil {
+		c.next.ServeHTTP(w, r)
+	}
+}
+
+func (c countingLinker) Link(h Handler) HandlerLinker {
+	atomic.AddInt32(c.links, 1)
+	c.next = h
+	return c
+}
+
+func TestPrecomputedChains(t *testing.T) {
+	var links int32
+	mux := NewServeMux()
+	mux.GET("/a", HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("a")) }))
+	mux.USE(countingLinker{links: &links})
+	mux.GET("/b", HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("b")) }))
+	registered := atomic.LoadInt32(&links)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := []string{"/a", "/b"}[i%2]
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Body.String() != path[1:] || w.Header().Get("X-Catchall") != "1" {
+				t.Errorf("%s: unexpected response %q %v", path, w.Body.String(), w.Header())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&links); n != registered {
+		t.Fatalf("expected the chains to be linked at registration, got %d links after serving", n-registered)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/a", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected a 405 for an unregistered method, got %d", w.Code)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	h := HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := NewServeMux()
+	mux.GET("/items", h)
+	mux.DELETE("/items", h)
+
+	for _, method := range []string{"POST", "PROPFIND"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, "/items", nil))
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD, DELETE" {
+			t.Errorf("%s: expected a 405 with an Allow header, got %d %q", method, w.Code, w.Header().Get("Allow"))
+		}
+	}
+
+	mux.MethodNotAllowedHandler = HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, http.StatusMethodNotAllowed, NewProblem(http.StatusMethodNotAllowed, "use "+w.Header().Get("Allow")))
+	})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PUT", "/items", nil))
+	if w.Code != http.StatusMethodNotAllowed || !strings.Contains(w.Body.String(), "use GET, HEAD, DELETE") {
+		t.Errorf("expected the custom handler to be called, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRouteTree(t *testing.T) {
+	tree := newRouteTree()
+	for _, p := range []string{"/", "/track", "/track/", "/track/:id", "/track/:id/comments/:cid", "/track/new", "/static/css/"} {
+		tree.insert(p)
+	}
+	tcs := []struct {
+		path    string
+		pattern string
+		params  int
+	}{
+		{"/", "/", 0},
+		{"/unknown/path", "/", 0},
+		{"/track", "/track", 0},
+		{"/track/", "/track/", 0},
+		{"/track/new", "/track/new", 0},
+		{"/track/42", "/track/:id", 1},
+		{"/track/42/comments/7", "/track/:id/comments/:cid", 2},
+		{"/track/42/comments", "/track/", 0},
+		{"/static/css/main.css", "/static/css/", 0},
+		{"/static/js/main.js", "/", 0},
+	}
+	for _, tc := range tcs {
+		pattern, params := tree.lookup(tc.path, false)
+		if pattern != tc.pattern || len(params) != tc.params {
+			t.Errorf("%s: got %q %v want %q with %d parameters", tc.path, pattern, params, tc.pattern, tc.params)
+		}
+	}
+	if _, params := tree.lookup("/track/42/comments/7", false); params[0] != (pathParam{"id", "42"}) || params[1] != (pathParam{"cid", "7"}) {
+		t.Errorf("unexpected parameters %v", params)
+	}
+}
+
+func TestParams(t *testing.T) {
+	var vars map[string]string
+	var pattern string
+	mux := NewServeMux()
+	mux.GET("/track/:id/comments/:cid", HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars = Params(r)
+		pattern = Pattern(r)
+	}))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/track/2589556/comments/1879545", nil))
+	if vars["id"] != "2589556" || vars["cid"] != "1879545" || len(vars) != 2 {
+		t.Fatalf("unexpected path parameters %v", vars)
+	}
+	if pattern != "/track/:id/comments/:cid" {
+		t.Fatalf("unexpected pattern %q", pattern)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	tag := func(name string) HandlerLinker {
+		return LinkableHandler(HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", name)
+		}))
+	}
+	ok := HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(Pattern(r))) })
+
+	mux := NewServeMux()
+	mux.USE(tag("global"))
+	mux.GET("/public", ok)
+	api := mux.Group("/api/v1/", tag("api"))
+	api.GET("/users", ok)
+	api.PUT("/users", ok, tag("route"))
+	admin := api.Group("/admin", tag("admin"))
+	admin.POST("/users/:id", ok)
+
+	tcs := []struct {
+		method, path, pattern, chain string
+	}{
+		{"GET", "/public", "/public", "global"},
+		{"GET", "/api/v1/users", "/api/v1/users", "global,api"},
+		{"PUT", "/api/v1/users", "/api/v1/users", "global,api,route"},
+		{"POST", "/api/v1/admin/users/42", "/api/v1/admin/users/:id", "global,api,admin"},
+	}
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Body.String() != tc.pattern || strings.Join(w.Header()["X-Chain"], ",") != tc.chain {
+			t.Errorf("%s %s: got %q through %v", tc.method, tc.path, w.Body.String(), w.Header()["X-Chain"])
+		}
+	}
+
+	api.USE(tag("late"))
+	if len(mux.initErr) == 0 {
+		t.Error("expected USE on a group to be reported as an error")
+	}
+}
+
+func TestUSE(t *testing.T) {
+	tag := func(name string) HandlerLinker {
+		return LinkableHandler(HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", name)
+		}))
+	}
+	mux := NewServeMux()
+	mux.USE(tag("a"))
+	mux.GET("/", HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mux.USE(tag("b"), tag("c"))
+	mux.USE(tag("d"))
+	if mux.initErr != nil {
+		t.Fatalf("unexpected registration errors %v", mux.initErr)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if chain := strings.Join(w.Header()["X-Chain"], ","); chain != "a,b,c,d" {
+		t.Fatalf("expected the handlers to be called in registration order, got %q", chain)
+	}
+}
+
+type itemsHandler struct{}
+
+func (itemsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func listItems(w http.ResponseWriter, r *http.Request) {}
+
+func TestRoutes(t *testing.T) {
+	mux := NewServeMux()
+	api := mux.Group("/api", LinkableHandler(HandlerFunc(listItems)))
+	api.GET("/items", HandlerFunc(listItems))
+	api.POST("/items", itemsHandler{}, LinkableHandler(itemsHandler{}))
+	mux.DELETE("/admin/", &itemsHandler{})
+
+	want := []Route{
+		{"DELETE", "/admin/", "*xhttp.itemsHandler"},
+		{"GET", "/api/items", "github.com/atdiar/xhttp.listItems"},
+		{"HEAD", "/api/items", "github.com/atdiar/xhttp.listItems"},
+		{"POST", "/api/items", "xhttp.itemsHandler"},
+	}
+	routes := api.Routes()
+	if len(routes) != len(want) {
+		t.Fatalf("expected %v, got %v", want, routes)
+	}
+	for i := range want {
+		if routes[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], routes[i])
+		}
+	}
+}
+
+func TestHEAD(t *testing.T) {
+	mux := NewServeMux()
+	mux.GET("/hello", HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+		w.Write([]byte(" world"))
+	}))
+	mux.GET("/created", HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+	mux.GET("/stream", HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: 2\n\n"))
+	}))
+
+	tcs := []struct {
+		path   string
+		status int
+		length string
+	}{
+		{"/hello", http.StatusOK, "11"},
+		{"/created", http.StatusCreated, "4"},
+		{"/stream", http.StatusOK, ""},
+	}
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("HEAD", tc.path, nil))
+		if w.Code != tc.status || w.Header().Get("Content-Length") != tc.length || w.Body.Len() != 0 {
+			t.Errorf("%s: got %d, Content-Length %q and body %q", tc.path, w.Code, w.Header().Get("Content-Length"), w.Body.String())
+		}
+	}
+}
+
+func TestPathNormalization(t *testing.T) {
+	ok := HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Pattern(r)))
+	})
+	mux := NewServeMux()
+	mux.GET("/", ok)
+	mux.GET("/users", ok)
+	mux.GET("/docs/", ok)
+	mux.GET("/Track/:id", ok)
+
+	tcs := []struct {
+		path     string
+		code     int
+		location string
+		body     string
+	}{
+		{"/users", 200, "", "/users"},
+		{"/users/", 308, "/users", ""},
+		{"/docs?page=2", 308, "/docs/?page=2", ""},
+		{"/docs/intro", 200, "", "/docs/"},
+		{"/unknown/", 200, "", "/"},
+		{"/track/42", 200, "", "/Track/:id"},
+		{"/track/42/", 308, "/track/42", ""},
+		{"/USERS", 200, "", "/users"},
+	}
+	mux.TrailingSlashRedirect = http.StatusPermanentRedirect
+	mux.CaseInsensitive = true
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code || w.Header().Get("Location") != tc.location || (tc.body != "" && w.Body.String() != tc.body) {
+			t.Errorf("%s: got %d %q %q, expected %d %q %q", tc.path, w.Code, w.Header().Get("Location"), w.Body.String(), tc.code, tc.location, tc.body)
+		}
+	}
+
+	// By default, the pattern matching the path is used.
+	mux.TrailingSlashRedirect = 0
+	mux.CaseInsensitive = false
+	for path, pattern := range map[string]string{"/users/": "/", "/docs": "/", "/USERS": "/"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 200 || w.Body.String() != pattern {
+			t.Errorf("%s: got %d %q, expected the %s pattern", path, w.Code, w.Body.String(), pattern)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	h := HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := NewServeMux()
+	mux.GET("/users/:id", h)
+	mux.POST("/users/:id", h)
+	if err := mux.Validate(); err != nil {
+		t.Fatalf("unexpected registration error: %v", err)
+	}
+
+	mux.GET("/users/:id", h)
+	mux.PUT("/users/:uid", h)
+	mux.DELETE("", h)
+	mux.PATCH("/users", nil)
+	mux.Group("/admin").Mount("/users/", http.NotFoundHandler(), false)
+	mux.Group("/admin").GET("/users/", h)
+
+	err := mux.Validate()
+	want := []struct {
+		method  string
+		pattern string
+		err     error
+	}{
+		{"GET", "/users/:id", ErrRouteConflict},
+		{"PUT", "/users/:uid", ErrRouteConflict},
+		{"DELETE", "", ErrInvalidPattern},
+		{"PATCH", "/users", ErrNilHandler},
+		{"GET", "/admin/users/", ErrRouteConflict},
+	}
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), err)
+	}
+	for i, w := range want {
+		var re *RouteError
+		if !errors.As(errs[i], &re) || re.Method != w.method || re.Pattern != w.pattern || !errors.Is(re, w.err) {
+			t.Errorf("error %d: got %v, expected %s %s: %v", i, errs[i], w.method, w.pattern, w.err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an invalid ServeMux to panic")
+		}
+	}()
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+}
@@ -0,0 +1,165 @@
+package xhttp
+
+// This file defines the RFC 7807 problem details format, a machine-readable
+// representation of the errors returned by http APIs.
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ProblemContentType is the media type of problem details documents.
+const ProblemContentType = "application/problem+json"
+
+// Problem holds the details of an error as defined by RFC 7807.
+// Extensions are additional members serialized alongside the standard ones.
+//
+// A Problem is also an error so that it can flow into an ErrorMapper.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+// NewProblem returns a Problem with the given status, titled after the status
+// text.
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// With returns a copy of the Problem with an additional extension member.
+func (p Problem) With(key string, value interface{}) Problem {
+	ext := make(map[string]interface{}, len(p.Extensions)+1)
+	for k, v := range p.Extensions {
+		ext[k] = v
+	}
+	ext[key] = value
+	p.Extensions = ext
+	return p
+}
+
+func (p Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	if p.Title != "" {
+		return p.Title
+	}
+	return http.StatusText(p.Status)
+}
+
+// MarshalJSON returns the JSON encoding of a Problem. Standard members that
+// are not set are omitted and extensions cannot override them.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	set := func(k string, v string) {
+		delete(m, k)
+		if v != "" {
+			m[k] = v
+		}
+	}
+	set("type", p.Type)
+	set("title", p.Title)
+	set("detail", p.Detail)
+	set("instance", p.Instance)
+	delete(m, "status")
+	if p.Status != 0 {
+		m["status"] = p.Status
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes a problem details document, typically received from
+// another service.
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*p = Problem{}
+	get := func(k string) string {
+		v, _ := m[k].(string)
+		delete(m, k)
+		return v
+	}
+	p.Type = get("type")
+	p.Title = get("title")
+	p.Detail = get("detail")
+	p.Instance = get("instance")
+	if s, ok := m["status"].(float64); ok {
+		p.Status = int(s)
+	}
+	delete(m, "status")
+	if len(m) > 0 {
+		p.Extensions = m
+	}
+	return nil
+}
+
+// WriteProblem writes a problem details response with the given status code.
+// The status member of the Problem is set to the status code if absent.
+func WriteProblem(w http.ResponseWriter, status int, p Problem) error {
+	if p.Status == 0 {
+		p.Status = status
+	}
+	if p.Title == "" && p.Type == "" {
+		p.Title = http.StatusText(status)
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(p)
+}
+
+// Problemer is implemented by the errors which provide their own problem
+// details, such as ValidationError.
+type Problemer interface {
+	Problem() Problem
+}
+
+// AsProblem returns the Problem describing an error: either the Problem the
+// error wraps or provides, or one built after its status code.
+// The detail of server errors (5xx) is not disclosed unless the error wraps a
+// Problem.
+func AsProblem(err error) Problem {
+	var p Problem
+	var pr Problemer
+	found := errors.As(err, &p)
+	if !found && errors.As(err, &pr) {
+		p, found = pr.Problem(), true
+	}
+	if found {
+		if p.Status == 0 {
+			p.Status = StatusCode(err)
+		}
+		return p
+	}
+	status := StatusCode(err)
+	if status >= 500 {
+		return NewProblem(status, "")
+	}
+	return NewProblem(status, err.Error())
+}
+
+// ProblemErrorMapper is an ErrorMapper which responds with RFC 7807 problem
+// details documents.
+func ProblemErrorMapper(w http.ResponseWriter, r *http.Request, err error) {
+	p := AsProblem(err)
+	if p.Instance == "" && r != nil && r.URL != nil {
+		p.Instance = r.URL.Path
+	}
+	_ = WriteProblem(w, p.Status, p)
+}
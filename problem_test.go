@@ -0,0 +1,33 @@
+package xhttp_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/atdiar/xhttp"
+)
+
+func ExampleProblemErrorMapper() {
+	mux := xhttp.NewServeMux()
+	mux.GET("/orders/42", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xhttp.ProblemErrorMapper(w, r, xhttp.NewError(http.StatusNotFound, errors.New("order 42 does not exist")))
+	}))
+	mux.GET("/payments", xhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := xhttp.NewProblem(http.StatusForbidden, "insufficient credit")
+		p.Type = "https://example.com/probs/out-of-credit"
+		xhttp.ProblemErrorMapper(w, r, p.With("balance", 30))
+	}))
+
+	for _, path := range []string{"/orders/42", "/payments"} {
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		fmt.Print(w.Code, " ", w.Header().Get("Content-Type"), " ", w.Body.String())
+	}
+
+	// Output:
+	// 404 application/problem+json {"detail":"order 42 does not exist","instance":"/orders/42","status":404,"title":"Not Found","type":"about:blank"}
+	// 403 application/problem+json {"balance":30,"detail":"insufficient credit","instance":"/payments","status":403,"title":"Forbidden","type":"https://example.com/probs/out-of-credit"}
+}
@@ -0,0 +1,68 @@
+package xhttp
+
+// This file defines HTTP/2 server push helpers which see through the
+// ResponseWriter wrappers installed by the request handlers.
+
+import (
+	"net/http"
+)
+
+// Pusher returns the http.Pusher underlying w, if any. The chain of wrapping
+// ResponseWriters is walked via their Wrappee (or Unwrap) method until one
+// that supports server push is found.
+func Pusher(w http.ResponseWriter) (http.Pusher, bool) {
+	return find[http.Pusher](w)
+}
+
+// Push initiates the HTTP/2 server push of the given resources. It returns
+// http.ErrNotSupported if the connection does not support server push, e.g.
+// over HTTP/1.1, and the first push error otherwise. Every resource is
+// attempted regardless of the errors.
+//
+// It must be called before the response is written.
+func Push(w http.ResponseWriter, resources ...string) error {
+	p, ok := Pusher(w)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	var err error
+	for _, res := range resources {
+		if e := p.Push(res, nil); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type pusher struct {
+	resources []string
+	next      Handler
+}
+
+// PushAssets returns a HandlerLinker which pushes the given resources before
+// calling the next handler. It is meant to be registered per route so as to
+// declare the assets each page needs:
+//
+//	mux.GET("/", xhttp.Chain(xhttp.PushAssets("/app.css", "/app.js")).Link(home))
+//
+// Only GET requests trigger a push. Clients that do not support server push
+// are served as usual.
+func PushAssets(resources ...string) HandlerLinker {
+	return pusher{resources: resources}
+}
+
+func (p pusher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// Push failures are not fatal: the client fetches the resources
+		// itself.
+		_ = Push(w, p.resources...)
+	}
+	if p.next != nil {
+		p.next.ServeHTTP(w, r)
+	}
+}
+
+func (p pusher) Link(h Handler) HandlerLinker {
+	p.next = h
+	return p
+}
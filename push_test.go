@@ -0,0 +1,46 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (p *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	return nil
+}
+
+type wrapper struct {
+	http.ResponseWriter
+}
+
+func (w wrapper) Wrappee() http.ResponseWriter { return w.ResponseWriter }
+
+func TestPush(t *testing.T) {
+	h := PushAssets("/app.css", "/app.js").Link(HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	}))
+
+	rec := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	req := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(wrapper{wrapper{rec}}, req)
+	if want := []string{"/app.css", "/app.js"}; !reflect.DeepEqual(rec.pushed, want) {
+		t.Fatalf("Expected %v to be pushed. Got %v", want, rec.pushed)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(wrapper{w}, req)
+	if w.Body.String() != "page" {
+		t.Fatalf("Expected the page to be served without push support. Got %q", w.Body.String())
+	}
+	if err := Push(w, "/app.css"); err != http.ErrNotSupported {
+		t.Fatalf("Expected ErrNotSupported. Got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package xhttp
+
+// This file defines the tree used by the ServeMux to match request paths to
+// registered patterns.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// routeTree is a tree of path segments. A pattern is registered on the node
+// reached by following its segments.
+//
+// A pattern ending with a slash matches any path having it as prefix. A
+// segment starting with a colon, e.g. /track/:id, matches any non-empty path
+// segment.
+type routeTree struct {
+	static map[string]*routeTree
+	param  *routeTree
+	name   string // parameter name, for parameter nodes
+
+	exact  string // pattern matching the path ending at this node
+	prefix string // pattern matching the paths continuing past this node
+}
+
+func newRouteTree() *routeTree {
+	return &routeTree{}
+}
+
+// segments splits a pattern or a path into its segments, reporting whether it
+// ends with a slash.
+func segments(path string) ([]string, bool) {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil, true
+	}
+	trailing := strings.HasSuffix(path, "/")
+	return strings.Split(strings.TrimSuffix(path, "/"), "/"), trailing
+}
+
+// insert registers a pattern in the tree. It is idempotent.
+// It fails if a parameter segment is unnamed or if a parameter is named
+// differently in another pattern at the same position, as both patterns would
+// match the same paths.
+func (t *routeTree) insert(pattern string) error {
+	segs, trailing := segments(pattern)
+	n := t
+	for _, seg := range segs {
+		if strings.HasPrefix(seg, ":") {
+			if len(seg) == 1 {
+				return fmt.Errorf("%w: unnamed parameter", ErrInvalidPattern)
+			}
+			if n.param == nil {
+				n.param = &routeTree{name: seg[1:]}
+			}
+			if n.param.name != seg[1:] {
+				return fmt.Errorf("%w: parameter :%s is named :%s in another pattern", ErrRouteConflict, seg[1:], n.param.name)
+			}
+			n = n.param
+			continue
+		}
+		if n.static == nil {
+			n.static = make(map[string]*routeTree)
+		}
+		c, ok := n.static[seg]
+		if !ok {
+			c = &routeTree{}
+			n.static[seg] = c
+		}
+		n = c
+	}
+	if trailing {
+		n.prefix = pattern
+		return nil
+	}
+	n.exact = pattern
+	return nil
+}
+
+// pathParam is a named path parameter extracted from a request path.
+type pathParam struct {
+	name  string
+	value string
+}
+
+// lookup returns the pattern matching a request path, along with the values
+// of its parameters. If fold is true, static segments are matched regardless
+// of case.
+// An exact match is preferred over a prefix match, static segments over
+// parameters, and the longest prefix over shorter ones.
+func (t *routeTree) lookup(path string, fold bool) (string, []pathParam) {
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if path == "" {
+		segs = nil
+	}
+	var params []pathParam
+	pattern := t.match(segs, fold, &params)
+	return pattern, params
+}
+
+func (t *routeTree) match(segs []string, fold bool, params *[]pathParam) string {
+	if len(segs) == 0 {
+		return t.exact
+	}
+	if c := t.child(segs[0], fold); c != nil {
+		if p := c.match(segs[1:], fold, params); p != "" {
+			return p
+		}
+	}
+	if t.param != nil && segs[0] != "" {
+		l := len(*params)
+		*params = append(*params, pathParam{t.param.name, segs[0]})
+		if p := t.param.match(segs[1:], fold, params); p != "" {
+			return p
+		}
+		*params = (*params)[:l]
+	}
+	return t.prefix
+}
+
+// child returns the static child node for a segment. An exact match is
+// preferred over a case-insensitive one.
+func (t *routeTree) child(seg string, fold bool) *routeTree {
+	if c, ok := t.static[seg]; ok {
+		return c
+	}
+	if fold {
+		for k, c := range t.static {
+			if strings.EqualFold(k, seg) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// find returns the pattern registered for the path itself, ignoring the
+// patterns matching it as a prefix.
+func (t *routeTree) find(path string, fold bool) string {
+	segs, trailing := segments(path)
+	return t.walk(segs, trailing, fold)
+}
+
+func (t *routeTree) walk(segs []string, trailing bool, fold bool) string {
+	if len(segs) == 0 {
+		if trailing {
+			return t.prefix
+		}
+		return t.exact
+	}
+	if c := t.child(segs[0], fold); c != nil {
+		if p := c.walk(segs[1:], trailing, fold); p != "" {
+			return p
+		}
+	}
+	if t.param != nil && segs[0] != "" {
+		return t.param.walk(segs[1:], trailing, fold)
+	}
+	return ""
+}
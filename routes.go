@@ -0,0 +1,95 @@
+package xhttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+)
+
+var (
+	// ErrNilHandler is reported when a nil request handler is registered.
+	ErrNilHandler = errors.New("request handler nil")
+	// ErrInvalidPattern is reported when a pattern is malformed.
+	ErrInvalidPattern = errors.New("request pattern invalid")
+	// ErrRouteConflict is reported when a route is registered twice, or when
+	// two patterns would match the same paths.
+	ErrRouteConflict = errors.New("route conflict")
+)
+
+// RouteError describes a failed route registration.
+type RouteError struct {
+	Method  string
+	Pattern string
+	Err     error
+}
+
+func (e *RouteError) Error() string {
+	return e.Method + " " + e.Pattern + ": " + e.Err.Error()
+}
+
+func (e *RouteError) Unwrap() error { return e.Err }
+
+// routeError records a failed route registration.
+func (sm *ServeMux) routeError(method string, pattern string, err error) {
+	sm.initErr = append(sm.initErr, &RouteError{method, pattern, err})
+}
+
+// Validate returns the errors which occurred during the registration of the
+// routes, joined, or nil. Each of them is a *RouteError.
+// A ServeMux whose registration failed panics when serving a request, so
+// Validate should be called at startup or in tests to detect the errors
+// early.
+func (sm ServeMux) Validate() error {
+	if sm.root != nil {
+		return sm.root.Validate()
+	}
+	return errors.Join(sm.initErr...)
+}
+
+// Route describes a request handler registered on a ServeMux.
+type Route struct {
+	Method  string
+	Pattern string
+	// Handler is the name of the registered handler: the name of the function
+	// for a HandlerFunc, or the name of its type otherwise. The handlers
+	// linked before it are not included.
+	Handler string
+}
+
+// Routes returns the routes registered on the ServeMux, sorted by pattern.
+// HEAD routes derived from GET routes are included.
+// It can be used to document an API or to debug the routing table.
+func (sm ServeMux) Routes() []Route {
+	if sm.root != nil {
+		return sm.root.Routes()
+	}
+	patterns := make([]string, 0, len(sm.routeHandlerMap))
+	for pattern := range sm.routeHandlerMap {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	var routes []Route
+	for _, pattern := range patterns {
+		for _, v := range sm.routeHandlerMap[pattern].registered() {
+			routes = append(routes, Route{v.method, pattern, v.t.name})
+		}
+	}
+	return routes
+}
+
+// handlerName returns a name describing a request handler.
+func handlerName(h Handler) string {
+	if h == nil {
+		return ""
+	}
+	if f, ok := h.(http.HandlerFunc); ok {
+		if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+			return fn.Name()
+		}
+	}
+	return fmt.Sprintf("%T", h)
+}
@@ -0,0 +1,157 @@
+package xhttp
+
+// This file defines a http server which shuts down gracefully.
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is the default duration granted to the in-flight
+// requests to complete when a Server shuts down.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Server wraps a http.Server which serves a ServeMux. It shuts down gracefully
+// upon reception of a termination signal: it stops accepting connections,
+// calls the shutdown hooks and waits for the in-flight requests to complete,
+// for ShutdownTimeout at most, before closing the remaining connections.
+type Server struct {
+	*http.Server
+
+	// ShutdownTimeout bounds the duration of a shutdown triggered by a signal.
+	// DefaultShutdownTimeout is used if it is not positive.
+	ShutdownTimeout time.Duration
+
+	// Signals triggering the shutdown. SIGINT and SIGTERM are used if nil.
+	Signals []os.Signal
+
+	mu    sync.Mutex
+	hooks []func(ctx context.Context) error
+
+	// done is closed once Shutdown has completed. It is created lazily so
+	// that a Server literal is usable.
+	doneOnce sync.Once
+	once     sync.Once
+	done     chan struct{}
+	err      error
+
+	// notified, if not nil, is called once the termination signals are
+	// listened for.
+	notified func()
+}
+
+// NewServer returns a Server listening on addr and serving mux.
+// The underlying http.Server can be further configured, e.g. to set timeouts.
+func NewServer(addr string, mux *ServeMux) *Server {
+	return &Server{
+		Server: &http.Server{Addr: addr, Handler: mux},
+	}
+}
+
+// shutdown returns the channel closed once Shutdown has completed.
+func (s *Server) shutdown() chan struct{} {
+	s.doneOnce.Do(func() { s.done = make(chan struct{}) })
+	return s.done
+}
+
+// OnShutdown registers a function to be called when the server starts
+// shutting down, e.g. to close long-lived connections such as server-sent
+// event streams or websockets which would otherwise prevent the in-flight
+// requests from completing. The functions are called concurrently and
+// should return when the context is done.
+func (s *Server) OnShutdown(f func(ctx context.Context) error) {
+	s.mu.Lock()
+	s.hooks = append(s.hooks, f)
+	s.mu.Unlock()
+}
+
+// ListenAndServe listens on the server address and serves requests until a
+// termination signal is received or Shutdown is called. It returns nil once
+// the server has shut down gracefully.
+func (s *Server) ListenAndServe() error {
+	return s.run(s.Server.ListenAndServe)
+}
+
+// ListenAndServeTLS is like ListenAndServe for HTTPS connections.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	return s.run(func() error { return s.Server.ListenAndServeTLS(certFile, keyFile) })
+}
+
+// Serve is like ListenAndServe for the connections accepted by l.
+func (s *Server) Serve(l net.Listener) error {
+	return s.run(func() error { return s.Server.Serve(l) })
+}
+
+func (s *Server) run(serve func() error) error {
+	signals := s.Signals
+	if signals == nil {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+	if s.notified != nil {
+		s.notified()
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- serve() }()
+
+	select {
+	case err := <-errc:
+		if err != http.ErrServerClosed {
+			return err
+		}
+		// Shutdown has been called. It must be waited for.
+		<-s.shutdown()
+		return s.err
+	case <-c:
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	<-errc
+	return err
+}
+
+// Shutdown gracefully shuts the server down. The shutdown hooks are called
+// while the in-flight requests are drained. If the context is done before
+// the requests complete, the remaining connections are closed and the
+// context error is returned along with the errors of the hooks.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	hooks := append([]func(context.Context) error(nil), s.hooks...)
+	s.mu.Unlock()
+
+	errc := make(chan error, len(hooks)+1)
+	for _, h := range hooks {
+		go func(h func(context.Context) error) { errc <- h(ctx) }(h)
+	}
+	go func() { errc <- s.Server.Shutdown(ctx) }()
+
+	var err error
+	for i := 0; i < len(hooks)+1; i++ {
+		err = errors.Join(err, <-errc)
+	}
+	if err != nil {
+		s.Server.Close()
+	}
+	done := s.shutdown()
+	s.once.Do(func() {
+		s.err = err
+		close(done)
+	})
+	return err
+}
@@ -0,0 +1,150 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, s *Server) (string, chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	return "http://" + l.Addr().String(), done
+}
+
+func TestServerDrain(t *testing.T) {
+	mux := NewServeMux()
+	started := make(chan struct{})
+	mux.GET("/slow", HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	s := NewServer("", &mux)
+	hooked := make(chan struct{})
+	s.OnShutdown(func(ctx context.Context) error {
+		close(hooked)
+		return nil
+	})
+	url, done := startServer(t, s)
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		body <- string(b)
+	}()
+	<-started
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if b := <-body; b != "done" {
+		t.Errorf("in-flight request was not drained, got %q", b)
+	}
+	select {
+	case <-hooked:
+	default:
+		t.Error("shutdown hook was not called")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Serve returned %v, expected nil", err)
+	}
+	if _, err := http.Get(url + "/slow"); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+}
+
+func TestServerLiteral(t *testing.T) {
+	mux := NewServeMux()
+	s := &Server{Server: &http.Server{Handler: &mux}}
+	_, done := startServer(t, s)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v, expected nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after shutdown")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	mux := NewServeMux()
+	started := make(chan struct{})
+	mux.GET("/stream", HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	s := NewServer("", &mux)
+	url, done := startServer(t, s)
+
+	go func() {
+		res, err := http.Get(url + "/stream")
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Serve returned %v, expected a deadline error", err)
+	}
+}
+
+func TestServerSignal(t *testing.T) {
+	mux := NewServeMux()
+	s := NewServer("", &mux)
+	s.Signals = []os.Signal{syscall.SIGUSR1}
+	s.ShutdownTimeout = time.Second
+	notified := make(chan struct{})
+	s.notified = func() { close(notified) }
+	hooked := make(chan struct{})
+	s.OnShutdown(func(ctx context.Context) error {
+		close(hooked)
+		return nil
+	})
+	_, done := startServer(t, s)
+	<-notified // the signal must not be sent before Serve handles it
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v, expected nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not shut down upon signal")
+	}
+	select {
+	case <-hooked:
+	default:
+		t.Error("shutdown hook was not called")
+	}
+}
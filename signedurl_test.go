@@ -0,0 +1,63 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	secret := []byte("secret")
+	sid := "s1"
+	h := VerifySignedURL(secret, func(r *http.Request) string {
+		return r.Header.Get("X-Session")
+	}).Link(HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file"))
+	}))
+
+	get := func(u string, session string) int {
+		req, err := http.NewRequest("GET", "http://example.com"+u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Session", session)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	u, err := SignURL("/download/report.pdf?v=2", secret, time.Now().Add(time.Hour), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := get(u, ""); code != http.StatusOK {
+		t.Fatalf("Expected a valid signature. Got %d", code)
+	}
+	if code := get(strings.Replace(u, "v=2", "v=3", 1), ""); code != http.StatusForbidden {
+		t.Fatalf("Expected a tampered URL to be rejected. Got %d", code)
+	}
+	if code := get("/download/report.pdf?v=2", ""); code != http.StatusForbidden {
+		t.Fatalf("Expected an unsigned URL to be rejected. Got %d", code)
+	}
+
+	expired, _ := SignURL("/download/report.pdf", secret, time.Now().Add(-time.Minute), "")
+	if code := get(expired, ""); code != http.StatusForbidden {
+		t.Fatalf("Expected an expired URL to be rejected. Got %d", code)
+	}
+
+	bound, _ := SignURL("/unsubscribe", secret, time.Now().Add(time.Hour), sid)
+	if strings.Contains(bound, sid) {
+		t.Fatalf("Expected the session ID not to be disclosed: %s", bound)
+	}
+	if code := get(bound, sid); code != http.StatusOK {
+		t.Fatalf("Expected a URL bound to the session to be valid. Got %d", code)
+	}
+	if code := get(bound, "other"); code != http.StatusForbidden {
+		t.Fatalf("Expected a URL bound to another session to be rejected. Got %d", code)
+	}
+	if code := get(bound, ""); code != http.StatusForbidden {
+		t.Fatalf("Expected a bound URL to be rejected without session. Got %d", code)
+	}
+}
@@ -0,0 +1,251 @@
+package xhttp
+
+// This file defines a struct validation facility driven by struct tags, so
+// that the input of request handlers can be checked declaratively.
+//
+// Rules are listed in a "validate" tag, separated by commas:
+//
+//	type Signup struct {
+//		Email string   `json:"email" validate:"required,format=email"`
+//		Name  string   `json:"name" validate:"required,min=2,max=64"`
+//		Age   int      `json:"age" validate:"min=18"`
+//		Plan  string   `json:"plan" validate:"enum=free|pro|team"`
+//		Tags  []string `json:"tags" validate:"max=5"`
+//	}
+//
+// min and max bound numbers by value and strings, slices and maps by length.
+// Rules other than required are not checked for zero values, so that optional
+// fields can be left out.
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Violation describes a field which does not satisfy a validation rule.
+// Field is the path of the field as seen by the client, e.g. "items[2].sku".
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError aggregates all the violations found in a value.
+type ValidationError []Violation
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Field + ": " + v.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Problem returns the problem details of a validation error: a 400 Bad
+// Request listing the violations in an "errors" member.
+func (e ValidationError) Problem() Problem {
+	return NewProblem(http.StatusBadRequest, "The request contains invalid fields.").With("errors", []Violation(e))
+}
+
+// Validate checks a struct (or pointer to struct) against the rules of its
+// "validate" tags. It returns an Error with a 400 status wrapping a
+// ValidationError if any rule is violated.
+// It panics if a tag is malformed, as this is a programming error.
+func Validate(v interface{}) error {
+	var errs ValidationError
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) > 0 {
+		return NewError(http.StatusBadRequest, errs)
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, errs *ValidationError) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if _, ok := v.Interface().(time.Time); ok {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := fieldName(f)
+			if name == "-" {
+				continue
+			}
+			p := name
+			if f.Anonymous && f.Tag.Get("json") == "" {
+				p = path // promoted fields
+			} else if path != "" {
+				p = path + "." + name
+			}
+			fv := v.Field(i)
+			if tag, ok := f.Tag.Lookup("validate"); ok {
+				if !checkRules(fv, p, tag, errs) {
+					continue
+				}
+			}
+			validateValue(fv, p, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			validateValue(iter.Value(), path+"["+fmt.Sprint(iter.Key().Interface())+"]", errs)
+		}
+	}
+}
+
+// fieldName returns the name of a field as seen by clients, as given by its
+// json, query or form tag.
+func fieldName(f reflect.StructField) string {
+	for _, key := range []string{"json", "query", "form"} {
+		if tag, ok := f.Tag.Lookup(key); ok {
+			name, _, _ := strings.Cut(tag, ",")
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return f.Name
+}
+
+// checkRules records the violations of the rules of a field. It returns false
+// if the field is missing, in which case its content is not validated.
+func checkRules(v reflect.Value, path string, tag string, errs *ValidationError) bool {
+	rules := strings.Split(tag, ",")
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v = reflect.Value{}
+			break
+		}
+		v = v.Elem()
+	}
+	zero := !v.IsValid() || v.IsZero()
+	for _, rule := range rules {
+		if strings.TrimSpace(rule) == "required" && zero {
+			*errs = append(*errs, Violation{path, "required", "is required"})
+			return false
+		}
+	}
+	if zero {
+		return false
+	}
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		var msg string
+		switch name {
+		case "", "required":
+			continue
+		case "min", "max":
+			msg = checkBound(v, name, arg)
+		case "format":
+			msg = checkFormat(v, arg)
+		case "enum":
+			msg = checkEnum(v, arg)
+		default:
+			panic("xhttp: unknown validation rule " + strconv.Quote(name) + " for field " + path)
+		}
+		if msg != "" {
+			*errs = append(*errs, Violation{path, name, msg})
+		}
+	}
+	return true
+}
+
+func checkBound(v reflect.Value, rule string, arg string) string {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		panic("xhttp: invalid " + rule + " bound " + strconv.Quote(arg))
+	}
+	var n float64
+	var length bool
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String:
+		n, length = float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n, length = float64(v.Len()), true
+	default:
+		panic("xhttp: " + rule + " rule is not applicable to " + v.Type().String())
+	}
+	switch {
+	case rule == "min" && n < bound && length:
+		return "must have at least " + arg + " elements"
+	case rule == "min" && n < bound:
+		return "must be at least " + arg
+	case rule == "max" && n > bound && length:
+		return "must have at most " + arg + " elements"
+	case rule == "max" && n > bound:
+		return "must be at most " + arg
+	}
+	return ""
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func checkFormat(v reflect.Value, format string) string {
+	if v.Kind() != reflect.String {
+		panic("xhttp: format rule is not applicable to " + v.Type().String())
+	}
+	s := v.String()
+	var ok bool
+	switch format {
+	case "email":
+		a, err := mail.ParseAddress(s)
+		ok = err == nil && a.Address == s
+	case "url":
+		u, err := url.Parse(s)
+		ok = err == nil && u.Scheme != "" && u.Host != ""
+	case "uuid":
+		ok = uuidPattern.MatchString(s)
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		ok = err == nil
+	case "datetime":
+		_, err := time.Parse(time.RFC3339, s)
+		ok = err == nil
+	default:
+		panic("xhttp: unknown format " + strconv.Quote(format))
+	}
+	if !ok {
+		return "must be a valid " + format
+	}
+	return ""
+}
+
+func checkEnum(v reflect.Value, arg string) string {
+	values := strings.Split(arg, "|")
+	s := fmt.Sprint(v.Interface())
+	for _, a := range values {
+		if s == a {
+			return ""
+		}
+	}
+	return "must be one of " + strings.Join(values, ", ")
+}
@@ -0,0 +1,136 @@
+package xhttp
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type item struct {
+	SKU      string `json:"sku" validate:"required"`
+	Quantity int    `json:"quantity" validate:"min=1,max=10"`
+}
+
+type order struct {
+	Email string   `json:"email" validate:"required,format=email"`
+	Plan  string   `json:"plan" validate:"enum=free|pro"`
+	Note  string   `json:"note" validate:"min=3"`
+	Items []item   `json:"items" validate:"required,max=2"`
+	Tags  []string `json:"tags"`
+}
+
+func TestReadJSON(t *testing.T) {
+	tcs := []struct {
+		body   string
+		status int
+		fields []string
+	}{
+		{`{"email":"a@example.com","items":[{"sku":"x","quantity":2}]}`, 0, nil},
+		{`{"email":"nope","plan":"gold","items":[{"quantity":11}]}`, 400, []string{"email", "plan", "items[0].sku", "items[0].quantity"}},
+		{`{"email":"a@example.com","note":"hi"}`, 400, []string{"note", "items"}},
+		{`{"email":"a@example.com","extra":1}`, 400, []string{"extra"}},
+		{`{"email":3}`, 400, []string{"email"}},
+		{`{"email":`, 400, nil},
+		{``, 400, nil},
+	}
+	for _, tc := range tcs {
+		req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		var o order
+		err = ReadJSON(req, &o)
+		if tc.status == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tc.body, err)
+			}
+			continue
+		}
+		if StatusCode(err) != tc.status {
+			t.Errorf("%s: expected status %d but got %d (%v)", tc.body, tc.status, StatusCode(err), err)
+		}
+		var verr ValidationError
+		errors.As(err, &verr)
+		var fields []string
+		for _, v := range verr {
+			fields = append(fields, v.Field)
+		}
+		if !reflect.DeepEqual(fields, tc.fields) {
+			t.Errorf("%s: expected violations of %v but got %v", tc.body, tc.fields, fields)
+		}
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader(`a=b`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := ReadJSON(req, &order{}); StatusCode(err) != http.StatusUnsupportedMediaType {
+		t.Fatalf("Expected status 415 but got %d", StatusCode(err))
+	}
+}
+
+func TestBindQuery(t *testing.T) {
+	type query struct {
+		Page    int           `query:"page" validate:"min=1"`
+		Sort    string        `query:"sort" validate:"enum=asc|desc"`
+		IDs     []int64       `query:"id"`
+		Timeout time.Duration `query:"timeout"`
+		Draft   *bool         `query:"draft"`
+	}
+	req, err := http.NewRequest("GET", "http://example.com/?page=2&sort=asc&id=1&id=2&timeout=3s&draft=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var q query
+	if err := BindQuery(req, &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Page != 2 || q.Sort != "asc" || !reflect.DeepEqual(q.IDs, []int64{1, 2}) || q.Timeout != 3*time.Second || q.Draft == nil || !*q.Draft {
+		t.Fatalf("Unexpected binding %+v", q)
+	}
+
+	req, err = http.NewRequest("GET", "http://example.com/?page=x&sort=up", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = BindQuery(req, &query{})
+	p := AsProblem(err)
+	if p.Status != http.StatusBadRequest || len(p.Extensions["errors"].([]Violation)) != 1 {
+		t.Fatalf("Unexpected problem %+v", p)
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	type signup struct {
+		Name  string `form:"name" validate:"required"`
+		Email string `form:"email" validate:"required,format=email"`
+		Age   int    `form:"age"`
+		Terms bool   `form:"terms"`
+	}
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("name=Ana&email=ana%40example.com&terms=on"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var s signup
+	if err := BindForm(req, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "Ana" || s.Email != "ana@example.com" || !s.Terms {
+		t.Fatalf("Unexpected binding %+v", s)
+	}
+
+	req, err = http.NewRequest("POST", "http://example.com/", strings.NewReader("name=Ana&email=ana%40example.com&age=old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := BindForm(req, &signup{}); StatusCode(err) != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid integer to be rejected. Got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package xhttp
+
+// This file defines the helpers used by the ResponseWriter wrappers to
+// preserve the optional interfaces of the ResponseWriter they wrap.
+//
+// A ResponseWriter wrapper, such as the ones installed by the bundled request
+// handlers, should:
+//   - implement Unwrap() http.ResponseWriter, as understood by
+//     http.ResponseController, returning the wrapped ResponseWriter,
+//   - implement FlushError and Flush, writing out what it holds before calling
+//     Flush on the wrapped ResponseWriter,
+//   - implement Hijack by calling Hijack on the wrapped ResponseWriter,
+//   - implement ReadFrom by calling ReadFrom on the wrapped ResponseWriter
+//     when its Write method does not transform the data written.
+//
+// That way, the handlers relying on streaming (e.g. server-sent events) or on
+// hijacking the connection (e.g. websockets) keep working behind them.
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Unwrap returns the ResponseWriter wrapped by w, via its Unwrap or Wrappee
+// method, or nil if w does not wrap a ResponseWriter.
+func Unwrap(w http.ResponseWriter) http.ResponseWriter {
+	switch u := w.(type) {
+	case interface{ Unwrap() http.ResponseWriter }:
+		return u.Unwrap()
+	case interface{ Wrappee() http.ResponseWriter }:
+		return u.Wrappee()
+	}
+	return nil
+}
+
+// find walks the chain of wrapped ResponseWriters until one implementing T is
+// found.
+func find[T any](w http.ResponseWriter) (T, bool) {
+	for w != nil {
+		if t, ok := w.(T); ok {
+			return t, true
+		}
+		w = Unwrap(w)
+	}
+	var zero T
+	return zero, false
+}
+
+// Flush sends the buffered response data to the client. The chain of wrapped
+// ResponseWriters is walked until one supporting flushing is found.
+// It returns http.ErrNotSupported if none does.
+func Flush(w http.ResponseWriter) error {
+	for w != nil {
+		switch f := w.(type) {
+		case interface{ FlushError() error }:
+			return f.FlushError()
+		case http.Flusher:
+			f.Flush()
+			return nil
+		}
+		w = Unwrap(w)
+	}
+	return http.ErrNotSupported
+}
+
+// Hijack lets the caller take over the connection of the response. The chain
+// of wrapped ResponseWriters is walked until one supporting hijacking is
+// found. It returns http.ErrNotSupported if none does.
+func Hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := find[http.Hijacker](w)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// ReadFrom writes the data read from r to w, using the ReadFrom method of w
+// if it has one so that optimizations such as sendfile can be used.
+func ReadFrom(w http.ResponseWriter, r io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{w}, r)
+}
+
+// writerOnly hides the optional methods of a writer so that io.Copy does not
+// call ReadFrom recursively.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,59 @@
+package xhttp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// legacyWrapper only exposes the ResponseWriter it wraps via Wrappee.
+type legacyWrapper struct {
+	http.ResponseWriter
+}
+
+func (lw legacyWrapper) Wrappee() http.ResponseWriter { return lw.ResponseWriter }
+
+// hijackable is a ResponseWriter supporting hijacking.
+type hijackable struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijackable) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+func TestWriterInterfaces(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := legacyWrapper{legacyWrapper{rec}}
+	if err := Flush(w); err != nil || !rec.Flushed {
+		t.Errorf("expected the underlying writer to be flushed, got %v", err)
+	}
+	if _, _, err := Hijack(w); err != http.ErrNotSupported {
+		t.Errorf("expected hijacking to be unsupported, got %v", err)
+	}
+	if n, err := ReadFrom(w, strings.NewReader("hello")); n != 5 || err != nil || rec.Body.String() != "hello" {
+		t.Errorf("ReadFrom wrote %d bytes (%v), body %q", n, err, rec.Body.String())
+	}
+
+	h := &hijackable{ResponseRecorder: httptest.NewRecorder()}
+	if _, _, err := Hijack(legacyWrapper{h}); err != nil || !h.hijacked {
+		t.Errorf("expected the underlying writer to be hijacked, got %v", err)
+	}
+	if err := Flush(legacyWrapper{struct{ http.ResponseWriter }{rec}}); err != http.ErrNotSupported {
+		t.Errorf("expected flushing to be unsupported, got %v", err)
+	}
+
+	// HEAD responses are streamed through the writer discarding the body.
+	nbw := &noopBodywriter{ResponseWriter: legacyWrapper{h}}
+	if _, _, err := Hijack(nbw); err != nil {
+		t.Errorf("unexpected hijacking error: %v", err)
+	}
+	if err := http.NewResponseController(nbw).Flush(); err != nil || !h.Flushed {
+		t.Errorf("expected http.ResponseController to flush the underlying writer, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package xhttptest
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Cookies returns the cookies set by the Set-Cookie headers of h.
+func Cookies(h http.Header) []*http.Cookie {
+	return (&http.Response{Header: h}).Cookies()
+}
+
+// Cookie returns the last cookie named name set by the Set-Cookie headers of
+// h, or nil.
+func Cookie(h http.Header, name string) *http.Cookie {
+	var c *http.Cookie
+	for _, ck := range Cookies(h) {
+		if ck.Name == name {
+			c = ck
+		}
+	}
+	return c
+}
+
+// AssertCookie fails the test if no cookie named name is set by the
+// Set-Cookie headers of h. It returns the cookie.
+func AssertCookie(t testing.TB, h http.Header, name string) *http.Cookie {
+	t.Helper()
+	c := Cookie(h, name)
+	if c == nil {
+		t.Fatalf("Expected a %s cookie to be set. Got %v", name, h["Set-Cookie"])
+	}
+	return c
+}
+
+// AssertNoCookie fails the test if a cookie named name is set by the
+// Set-Cookie headers of h.
+func AssertNoCookie(t testing.TB, h http.Header, name string) {
+	t.Helper()
+	if c := Cookie(h, name); c != nil {
+		t.Fatalf("Expected no %s cookie to be set. Got %v", name, c)
+	}
+}
+
+// AssertHeader fails the test if the value of the header key differs from
+// want. An empty want asserts that the header is absent.
+func AssertHeader(t testing.TB, h http.Header, key string, want string) {
+	t.Helper()
+	if got := h.Get(key); got != want {
+		t.Fatalf("Expected the %s header to be %q. Got %q", key, want, got)
+	}
+}
+
+// AssertStatus fails the test if the recorded status code differs from want.
+func AssertStatus(t testing.TB, r *Recorder, want int) {
+	t.Helper()
+	if r.Code != want {
+		t.Fatalf("Expected a %d status. Got %d: %s", want, r.Code, r.Body.String())
+	}
+}
@@ -0,0 +1,89 @@
+// Package xhttptest provides utilities to test request handlers and chains of
+// HandlerLinkers: a recording ResponseWriter supporting the optional
+// interfaces handlers rely on, a scriptable in-memory session Store and Cache,
+// and assertions on the emitted headers and cookies.
+package xhttptest
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Recorder is a ResponseWriter recording the response, like
+// httptest.ResponseRecorder. It also implements http.Flusher and
+// http.Hijacker so that handlers depending on them can be exercised.
+type Recorder struct {
+	*httptest.ResponseRecorder
+
+	mu       sync.Mutex
+	flushes  int
+	hijacked bool
+	// Conn is the client end of the connection after the response has been
+	// hijacked.
+	Conn net.Conn
+}
+
+// NewRecorder returns an initialized Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{ResponseRecorder: httptest.NewRecorder()}
+}
+
+// Flush records the flush and flushes the underlying ResponseRecorder.
+func (r *Recorder) Flush() {
+	r.mu.Lock()
+	r.flushes++
+	r.mu.Unlock()
+	r.ResponseRecorder.Flush()
+}
+
+// Flushes returns the number of times the response was flushed.
+func (r *Recorder) Flushes() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.flushes
+}
+
+// Hijack returns the server end of an in-memory connection whose client end
+// is available in the Conn field.
+func (r *Recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	r.hijacked = true
+	server, client := net.Pipe()
+	r.Conn = client
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+// Hijacked reports whether the response was hijacked.
+func (r *Recorder) Hijacked() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hijacked
+}
+
+// Serve runs the handler on a new request and returns the recorded response.
+// body may be empty.
+func Serve(h http.Handler, method string, target string, body string) *Recorder {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	return ServeRequest(h, req)
+}
+
+// ServeRequest runs the handler on the request and returns the recorded
+// response.
+func ServeRequest(h http.Handler, r *http.Request) *Recorder {
+	rec := NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
@@ -0,0 +1,227 @@
+package xhttptest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+// ErrNotFound is returned by a Store for missing or expired keys.
+var ErrNotFound = errors.New("xhttptest: key not found")
+
+// Op identifies a Store operation.
+type Op string
+
+// Store operations whose failure can be scripted.
+const (
+	OpGet          Op = "Get"
+	OpPut          Op = "Put"
+	OpDelete       Op = "Delete"
+	OpTimeToExpiry Op = "TimeToExpiry"
+)
+
+// Store is an in-memory session Store and Cache for tests. Expiry is computed
+// with its Clock, which can be a fake one, and failures or latency can be
+// scripted per operation. It is safe for concurrent use.
+type Store struct {
+	clock session.Clock
+
+	mu       sync.Mutex
+	entries  map[string]entry
+	failures map[Op][]error
+	always   map[Op]error
+	delay    time.Duration
+	calls    map[Op]int
+}
+
+type entry struct {
+	value  []byte
+	expiry time.Time // zero if the entry does not expire
+}
+
+// NewStore returns an empty Store. The system clock is used if clock is nil.
+func NewStore(clock session.Clock) *Store {
+	if clock == nil {
+		clock = session.SystemClock
+	}
+	return &Store{
+		clock:    clock,
+		entries:  make(map[string]entry),
+		failures: make(map[Op][]error),
+		always:   make(map[Op]error),
+		calls:    make(map[Op]int),
+	}
+}
+
+// FailNext makes the next call of the operation fail with err. Successive
+// calls queue failures for the successive calls of the operation.
+func (s *Store) FailNext(op Op, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[op] = append(s.failures[op], err)
+}
+
+// FailAlways makes every call of the operation fail with err, until it is
+// called again with a nil error.
+func (s *Store) FailAlways(op Op, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.always, op)
+		return
+	}
+	s.always[op] = err
+}
+
+// SetDelay delays every operation by d, or until the context is done.
+func (s *Store) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Calls returns the number of calls of the operation.
+func (s *Store) Calls(op Op) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[op]
+}
+
+// Len returns the number of entries that have not expired.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	n := 0
+	for _, e := range s.entries {
+		if !e.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// begin records the call and returns the scripted error, if any, after the
+// scripted delay.
+func (s *Store) begin(ctx context.Context, op Op) error {
+	s.mu.Lock()
+	s.calls[op]++
+	delay := s.delay
+	var err error
+	if q := s.failures[op]; len(q) > 0 {
+		err = q[0]
+		s.failures[op] = q[1:]
+	} else {
+		err = s.always[op]
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expiry.IsZero() && !now.Before(e.expiry)
+}
+
+func key(id string, hkey string) string {
+	return id + "\x00" + hkey
+}
+
+// Get returns the value stored for the key of the session id.
+func (s *Store) Get(ctx context.Context, id string, hkey string) ([]byte, error) {
+	if err := s.begin(ctx, OpGet); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key(id, hkey)]
+	if !ok || e.expired(s.clock.Now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), e.value...), nil
+}
+
+// Put stores a value for the key of the session id. A negative maxage deletes
+// the key and a zero maxage means that the value does not expire.
+func (s *Store) Put(ctx context.Context, id string, hkey string, content []byte, maxage time.Duration) error {
+	if err := s.begin(ctx, OpPut); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(id, hkey)
+	if maxage < 0 {
+		delete(s.entries, k)
+		return nil
+	}
+	e := entry{value: append([]byte(nil), content...)}
+	if maxage > 0 {
+		e.expiry = s.clock.Now().Add(maxage)
+	}
+	s.entries[k] = e
+	return nil
+}
+
+// Delete removes the key of the session id.
+func (s *Store) Delete(ctx context.Context, id string, hkey string) error {
+	if err := s.begin(ctx, OpDelete); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key(id, hkey))
+	return nil
+}
+
+// TimeToExpiry returns the time left before the key of the session id
+// expires, or zero if it does not expire.
+func (s *Store) TimeToExpiry(ctx context.Context, id string, hkey string) (time.Duration, error) {
+	if err := s.begin(ctx, OpTimeToExpiry); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	e, ok := s.entries[key(id, hkey)]
+	if !ok || e.expired(now) {
+		return 0, ErrNotFound
+	}
+	if e.expiry.IsZero() {
+		return 0, nil
+	}
+	return e.expiry.Sub(now), nil
+}
+
+// Clear removes every entry.
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make(map[string]entry)
+	return nil
+}
+
+// ClearAfter removes every entry once t has elapsed.
+func (s *Store) ClearAfter(t time.Duration) error {
+	time.AfterFunc(t, func() { s.Clear() })
+	return nil
+}
+
+var (
+	_ session.Store = (*Store)(nil)
+	_ session.Cache = (*Store)(nil)
+)
@@ -0,0 +1,60 @@
+package xhttptest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atdiar/xhttp/handlers/session"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStore(session.ClockFunc(func() time.Time { return now }))
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "id", "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := s.TimeToExpiry(ctx, "id", "k"); err != nil || d != time.Minute {
+		t.Fatalf("Expected the key to expire in a minute. Got %v %v", d, err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := s.Get(ctx, "id", "k"); err != ErrNotFound {
+		t.Fatalf("Expected the key to have expired. Got %v", err)
+	}
+
+	boom := errors.New("boom")
+	s.FailNext(OpGet, boom)
+	s.Put(ctx, "id", "k", []byte("v"), 0)
+	if _, err := s.Get(ctx, "id", "k"); err != boom {
+		t.Fatalf("Expected the scripted failure. Got %v", err)
+	}
+	if v, err := s.Get(ctx, "id", "k"); err != nil || string(v) != "v" {
+		t.Fatalf("Expected the failure to happen once. Got %q %v", v, err)
+	}
+	if s.Calls(OpGet) != 3 {
+		t.Fatalf("Expected 3 calls to Get. Got %d", s.Calls(OpGet))
+	}
+
+	s.SetDelay(time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Get(ctx, "id", "k"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the delayed call to honor the context. Got %v", err)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	s := session.New("SID", "secret", session.SetMaxage(3600))
+	rec := Serve(s, "GET", "/", "")
+	AssertStatus(t, rec, http.StatusOK)
+	c := AssertCookie(t, rec.Header(), "SID")
+	if c.MaxAge != 3600 {
+		t.Fatalf("Expected the session cookie to last an hour. Got %d", c.MaxAge)
+	}
+	AssertHeader(t, rec.Header(), "Vary", "Cookie")
+	AssertNoCookie(t, rec.Header(), "other")
+}